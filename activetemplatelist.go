@@ -2,6 +2,7 @@ package ipfix
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -10,6 +11,7 @@ import (
 // These can be used in a session or when testing the marshalling/unmarshalling of the complex types
 type ActiveTemplates struct {
 	templates map[uint16]*activeTemplate //Using a map here instead of an array for memory reasons (512K per session might be excessive otherwise)
+	logger    *slog.Logger               //Set with SetLogger, may be nil
 
 	sync.Mutex
 }
@@ -17,6 +19,8 @@ type ActiveTemplates struct {
 //activeTemplate is the structure that holds the data for a template record
 type activeTemplate struct {
 	Record *TemplateRecord
+	plan   *decodePlan     //Compiled when the template is set
+	layout *TemplateLayout //Computed when first asked for
 
 	Added        time.Time //So we do not remove it if it just has been very recently added
 	LastAccessed time.Time //To implement clean-up routine
@@ -28,29 +32,37 @@ func NewActiveTemplateList() *ActiveTemplates {
 	return &ActiveTemplates{templates: make(map[uint16]*activeTemplate)}
 }
 
+//SetLogger sets the logger that template replacements are logged to, nil logs nothing. A Session sets its Logger before it decodes a message.
+func (at *ActiveTemplates) SetLogger(logger *slog.Logger) {
+	at.Lock()
+	defer at.Unlock()
+	at.logger = logger
+}
+
 //Set adds or replaces a templates in the list
 func (at *ActiveTemplates) Set(id uint16, tpl *TemplateRecord) error {
+	return at.setLimited(id, tpl, 0)
+}
+
+//setLimited adds or replaces a template in the list, but does not add it if the list already holds maxtemplates templates (0 means no limit)
+func (at *ActiveTemplates) setLimited(id uint16, tpl *TemplateRecord, maxtemplates int) error {
 	if id < 256 {
-		return NewError(fmt.Sprintf("Invalid templates id. Must be >=256 but got %d", id), ErrCritical)
+		return NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Invalid templates id. Must be >=256 but got %d", id), ErrCritical).InTemplate(id)
 	}
 	if tpl == nil {
-		return NewError("Got nil pointer to templates", ErrCritical)
+		return NewCategoryError(ErrInvalidValue, "Got nil pointer to templates", ErrCritical).InTemplate(id)
 	}
 	at.Lock()
 	defer at.Unlock()
 
 	if tmpl, found := at.templates[id]; found {
-		isequal := true
-		for idx, fsp := range tpl.FieldSpecifiers {
-			if fsp.EnterpriseNumber != tmpl.Record.FieldSpecifiers[idx].EnterpriseNumber ||
-				fsp.InformationElementIdentifier != tmpl.Record.FieldSpecifiers[idx].InformationElementIdentifier ||
-				fsp.FieldLength != tmpl.Record.FieldSpecifiers[idx].FieldLength {
-				isequal = false
+		if !templatesEqual(tmpl.Record, tpl) {
+			if at.logger != nil {
+				at.logger.Info("IPFIX template replaced", slog.Uint64("template_id", uint64(id)), slog.String("old", tmpl.Record.String()), slog.String("new", tpl.String()))
 			}
-		}
-		if !isequal {
 			at.templates[id] = &activeTemplate{
 				Record:       tpl,
+				plan:         newDecodePlan(tpl),
 				Added:        time.Now(),
 				LastAccessed: time.Now(),
 			}
@@ -59,8 +71,12 @@ func (at *ActiveTemplates) Set(id uint16, tpl *TemplateRecord) error {
 			tmpl.LastAccessed = time.Now()
 		}
 	} else {
+		if limiterr := checkLimit("MaxTemplates", maxtemplates, len(at.templates)+1); limiterr != nil {
+			return limiterr.InTemplate(id)
+		}
 		at.templates[id] = &activeTemplate{
 			Record:       tpl,
+			plan:         newDecodePlan(tpl),
 			Added:        time.Now(),
 			LastAccessed: time.Now(),
 		}
@@ -68,10 +84,54 @@ func (at *ActiveTemplates) Set(id uint16, tpl *TemplateRecord) error {
 	return nil
 }
 
+//withdraw removes the template of the Template Withdrawal from the list, or all (Options) Templates for the Template ID 2 (3)
+func (at *ActiveTemplates) withdraw(withdrawal *TemplateRecord) error {
+	at.Lock()
+	defer at.Unlock()
+	switch id := withdrawal.TemplateID; {
+	case id == SetIDTemplate, id == SetIDOptionTemplate:
+		for tmplid, tmpl := range at.templates {
+			if (tmpl.Record.ScopeFieldSpecifiers != nil) == (id == SetIDOptionTemplate) {
+				delete(at.templates, tmplid)
+			}
+		}
+	case id < 256:
+		return NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Invalid withdrawn template id %d", id), ErrCritical).InTemplate(id)
+	default:
+		if _, found := at.templates[id]; !found {
+			return NewCategoryError(ErrTemplateNotFound, fmt.Sprintf("Withdrawn template %d is not in the list", id), ErrFailure).InTemplate(id)
+		}
+		delete(at.templates, id)
+	}
+	if at.logger != nil {
+		at.logger.Info("IPFIX template withdrawn", slog.Uint64("template_id", uint64(withdrawal.TemplateID)))
+	}
+	return nil
+}
+
+//templatesEqual returns whether both templates describe the same (scope) fields, in the same order
+func templatesEqual(tpla, tplb *TemplateRecord) bool {
+	if (tpla.ScopeFieldSpecifiers == nil) != (tplb.ScopeFieldSpecifiers == nil) {
+		return false
+	}
+	fspsa, fspsb := tpla.allFieldSpecifiers(), tplb.allFieldSpecifiers()
+	if len(fspsa) != len(fspsb) || len(tpla.ScopeFieldSpecifiers) != len(tplb.ScopeFieldSpecifiers) {
+		return false
+	}
+	for idx, fsp := range fspsa {
+		if fsp.EnterpriseNumber != fspsb[idx].EnterpriseNumber ||
+			fsp.InformationElementIdentifier != fspsb[idx].InformationElementIdentifier ||
+			fsp.FieldLength != fspsb[idx].FieldLength {
+			return false
+		}
+	}
+	return true
+}
+
 //Get returns the templates record for the id or an error if not found
 func (at *ActiveTemplates) Get(id uint16) (*TemplateRecord, error) {
 	if at == nil {
-		return nil, NewError("No active templates available", ErrCritical)
+		return nil, NewCategoryError(ErrNoTemplates, "No active templates available", ErrCritical).InTemplate(id)
 	}
 	at.Lock()
 	defer at.Unlock()
@@ -79,10 +139,55 @@ func (at *ActiveTemplates) Get(id uint16) (*TemplateRecord, error) {
 	var tmpl *activeTemplate
 	var found bool
 	if tmpl, found = at.templates[id]; !found {
-		return nil, NewError(fmt.Sprintf("No such templates (%d) in list.", id), ErrFailure) //Not necessarily a fatal error. May hold back until we get a new one
+		return nil, NewCategoryError(ErrTemplateNotFound, fmt.Sprintf("No such templates (%d) in list.", id), ErrFailure).InTemplate(id) //Not necessarily a fatal error. May hold back until we get a new one
 	}
 	tmpl.LastAccessed = time.Now()
 	tmpl.NofAccess++
 	return tmpl.Record, nil
 
 }
+
+//plan returns the decode plan of the template with the id
+func (at *ActiveTemplates) plan(id uint16) (*decodePlan, error) {
+	if at == nil {
+		return nil, NewCategoryError(ErrNoTemplates, "No active templates available", ErrCritical).InTemplate(id)
+	}
+	at.Lock()
+	defer at.Unlock()
+
+	tmpl, found := at.templates[id]
+	if !found {
+		return nil, NewCategoryError(ErrTemplateNotFound, fmt.Sprintf("No such templates (%d) in list.", id), ErrFailure).InTemplate(id) //Not necessarily a fatal error. May hold back until we get a new one
+	}
+	if tmpl.plan == nil || !tmpl.plan.compiles(tmpl.Record) { //The template was not added with Set, or specifiers were added since
+		tmpl.plan = newDecodePlan(tmpl.Record)
+	}
+	tmpl.LastAccessed = time.Now()
+	tmpl.NofAccess++
+	return tmpl.plan, nil
+}
+
+//Layout returns the layout of the records of the template with the id, for viewing them with a RecordView.
+//The layout is computed once and kept until the template is replaced or custom elements are registered.
+func (at *ActiveTemplates) Layout(id uint16) (*TemplateLayout, error) {
+	if at == nil {
+		return nil, NewCategoryError(ErrNoTemplates, "No active templates available", ErrCritical).InTemplate(id)
+	}
+	at.Lock()
+	defer at.Unlock()
+
+	tmpl, found := at.templates[id]
+	if !found {
+		return nil, NewCategoryError(ErrTemplateNotFound, fmt.Sprintf("No such templates (%d) in list.", id), ErrFailure).InTemplate(id)
+	}
+	if tmpl.layout == nil || tmpl.layout.version != customMapVersion.Load() { //Not computed yet, or custom elements were registered since
+		layout, err := NewTemplateLayout(tmpl.Record)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.layout = layout
+	}
+	tmpl.LastAccessed = time.Now()
+	tmpl.NofAccess++
+	return tmpl.layout, nil
+}
@@ -0,0 +1,622 @@
+package ipfix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+/*
+
+RFC 7015 describes the aggregation of flow records: records with the same values of the key fields are combined into a single
+aggregated record. An Aggregator groups the records by the values of its Keys, where addresses may be masked to a prefix, and by
+time interval when an Interval is set.
+
+	aggregator := NewAggregator([]AggregationKey{{ElementID: 8, PrefixLength: 24}, {ElementID: 4}}, time.Minute, 256)
+	for _, datrec := range records {
+		aggregator.AddRecord(datrec)
+	}
+	aggregated, err := aggregator.Expire(time.Now())
+
+The aggregated records use templates that the aggregator generates in its Templates:
+
+  - the key fields; an IPv4 or IPv6 source or destination address masked to a prefix is exported as the prefix element with its prefix length
+  - flowStartMilliseconds and flowEndMilliseconds of the interval, if there is an Interval; the flow times of the records are left out
+  - the other fields of the records, combined by the function of their element, see AggregationFunction; summed fields
+    get the full length of their data type, also when the records encode them with reduced size
+  - originalFlowsPresent, and originalFlowsCompleted if there is an Interval
+
+A record is counted in the interval in which its flow started, the Start Interval distribution of RFC 7015, so every flow is
+initiated in its interval and originalFlowsInitiated is not exported. Records of different templates are aggregated separately.
+The first, last, minimum and maximum values are shared with the records, not copied. An Aggregator is not safe for concurrent use.
+
+A generated template is dropped when the template of its records is garbage collected and has no pending flows, and its id is
+given out again. Flush the messages of the aggregated records before the templates of their records are dropped.
+
+*/
+
+// The Information Elements of aggregated flows
+const (
+	flowStartMillisecondsElement  = 152
+	flowEndMillisecondsElement    = 153
+	originalFlowsPresentElement   = 375
+	originalFlowsCompletedElement = 377
+)
+
+// flowStartElements and flowEndElements are the IANA elements of the start and end time of a flow, in order of preference
+var (
+	flowStartElements = []uint16{152, 150, 154, 156} //flowStartMilliseconds, flowStartSeconds, flowStartMicroseconds, flowStartNanoseconds
+	flowEndElements   = []uint16{153, 151, 155, 157} //flowEndMilliseconds, flowEndSeconds, flowEndMicroseconds, flowEndNanoseconds
+)
+
+// prefixElements maps the address elements to their prefix element and prefix length element, RFC 7015 section 5.1
+var prefixElements = map[uint16][2]uint16{
+	8:  {44, 9},   //sourceIPv4Address: sourceIPv4Prefix, sourceIPv4PrefixLength
+	12: {45, 13},  //destinationIPv4Address: destinationIPv4Prefix, destinationIPv4PrefixLength
+	27: {170, 29}, //sourceIPv6Address: sourceIPv6Prefix, sourceIPv6PrefixLength
+	28: {169, 30}, //destinationIPv6Address: destinationIPv6Prefix, destinationIPv6PrefixLength
+}
+
+// AggregationFunction tells how the values of a non-key field are combined
+type AggregationFunction uint8
+
+// The aggregation functions
+const (
+	AggregateFirst AggregationFunction = iota //The value of the first record
+	AggregateLast                             //The value of the last record
+	AggregateMin                              //The smallest value
+	AggregateMax                              //The largest value
+	AggregateSum                              //The sum of the values, saturating at the maximum of the type
+	AggregateOr                               //The bitwise or of the values, for flags
+)
+
+// String returns the name of the aggregation function
+func (function AggregationFunction) String() string {
+	switch function {
+	case AggregateFirst:
+		return "first"
+	case AggregateLast:
+		return "last"
+	case AggregateMin:
+		return "min"
+	case AggregateMax:
+		return "max"
+	case AggregateSum:
+		return "sum"
+	case AggregateOr:
+		return "or"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(function))
+}
+
+// aggregationFunctions are the functions of the IANA elements that are not aggregated as the first value, by their semantics
+var aggregationFunctions = map[uint16]AggregationFunction{
+	3: AggregateSum, 132: AggregateSum, 133: AggregateSum, 134: AggregateSum, 135: AggregateSum, //deltaFlowCount, dropped octets and packets
+	375: AggregateSum, 376: AggregateSum, 377: AggregateSum, //originalFlowsPresent, originalFlowsInitiated, originalFlowsCompleted
+	150: AggregateMin, 152: AggregateMin, 154: AggregateMin, 156: AggregateMin, 22: AggregateMin, //flowStart
+	151: AggregateMax, 153: AggregateMax, 155: AggregateMax, 157: AggregateMax, 21: AggregateMax, //flowEnd
+	25: AggregateMin, 52: AggregateMin, //minimumIpTotalLength, minimumTTL
+	26: AggregateMax, 53: AggregateMax, //maximumIpTotalLength, maximumTTL
+
+	6: AggregateOr, //tcpControlBits
+}
+
+// defaultAggregationFunction returns the function of the element by its semantics: counters are summed, flags are or-ed,
+// the start and end of a flow are the minimum and maximum, and other values are those of the first record
+func defaultAggregationFunction(enterpriseid uint32, elementid uint16) AggregationFunction {
+	switch enterpriseid {
+	case 0, ReversePEN:
+		if samplingCounterElements[elementid] {
+			return AggregateSum
+		}
+		return aggregationFunctions[elementid]
+	}
+	custfield, err := GetCustomField(enterpriseid, elementid)
+	if err != nil || custfield.Type == nil {
+		return AggregateFirst
+	}
+	switch custfield.Type.Semantics {
+	case SemanticsTotalCounter, SemanticsDeltaCounter:
+		return AggregateSum
+	case SemanticsFlags:
+		return AggregateOr
+	}
+	return AggregateFirst
+}
+
+// AggregationKey is a key field of an Aggregator
+type AggregationKey struct {
+	EnterpriseID uint32
+	ElementID    uint16
+	PrefixLength uint8 //For addresses, aggregate by the prefix of this length instead of by address. 0 aggregates by address.
+}
+
+// aggregationElement identifies an Information Element
+type aggregationElement struct {
+	enterpriseID uint32
+	elementID    uint16
+}
+
+// aggregateTemplate is a generated template of aggregated records, with the fields of the original records it holds
+type aggregateTemplate struct {
+	record    *TemplateRecord
+	keyIdx    []int   //Indexes of the key fields in the original records
+	prefixes  []uint8 //Prefix lengths of the key fields
+	valueIdx  []int   //Indexes of the other fields in the original records
+	functions []AggregationFunction
+	startIdx  int //Index of the flow start time in the original records, -1 if there is none
+	endIdx    int //Index of the flow end time in the original records, -1 if there is none
+}
+
+// aggregateKey identifies an aggregated flow by the template of its records, the encoded values of its key fields and its interval
+type aggregateKey struct {
+	template *TemplateRecord
+	key      string
+	interval int64
+}
+
+// aggregateFlow is an aggregated flow that has not been returned yet
+type aggregateFlow struct {
+	generated *aggregateTemplate
+	sequence  int //Order of the flows, to return them in the order they were created
+	start     time.Time
+	keys      []FieldValue
+	values    []FieldValue
+	present   uint64
+	completed uint64
+}
+
+// Aggregator combines records with the same key into aggregated records
+type Aggregator struct {
+	Keys      []AggregationKey
+	Interval  time.Duration    //Length of the time intervals, 0 to aggregate regardless of time
+	Templates *ActiveTemplates //Templates of the aggregated records, generated by the aggregator
+
+	functions map[aggregationElement]AggregationFunction
+	generated *generatedTemplates[*aggregateTemplate] //Until the original template is garbage collected, which the pending flows prevent
+	flows     map[aggregateKey]*aggregateFlow
+	sequence  int
+}
+
+// NewAggregator returns an aggregator of records by the keys, in intervals of the given length, the generated templates start at firsttemplateid
+func NewAggregator(keys []AggregationKey, interval time.Duration, firsttemplateid uint16) *Aggregator {
+	return &Aggregator{
+		Keys:      keys,
+		Interval:  interval,
+		Templates: NewActiveTemplateList(),
+		functions: make(map[aggregationElement]AggregationFunction),
+		generated: newGeneratedTemplates[*aggregateTemplate](firsttemplateid),
+		flows:     make(map[aggregateKey]*aggregateFlow),
+	}
+}
+
+// SetFunction sets the function that combines the values of the element, instead of the one by its semantics.
+// Set the functions before adding records, the templates of records that were added before keep their functions.
+func (agg *Aggregator) SetFunction(enterpriseid uint32, elementid uint16, function AggregationFunction) {
+	agg.functions[aggregationElement{enterpriseID: enterpriseid, elementID: elementid}] = function
+}
+
+// Pending returns the number of aggregated flows that have not been returned yet
+func (agg *Aggregator) Pending() int {
+	return len(agg.flows)
+}
+
+// AddRecord adds a record to the aggregated flow of its key and interval
+func (agg *Aggregator) AddRecord(datrec *DataRecord) error {
+	if _, err := datrec.recordFields(); err != nil {
+		return err
+	}
+	tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	generated, err := agg.template(tmplrec)
+	if err != nil {
+		return err
+	}
+
+	var start, end time.Time
+	if generated.startIdx >= 0 {
+		start, _ = datrec.FieldValues[generated.startIdx].Value().(time.Time)
+	}
+	if generated.endIdx >= 0 {
+		end, _ = datrec.FieldValues[generated.endIdx].Value().(time.Time)
+	}
+	key := aggregateKey{template: tmplrec}
+	if agg.Interval > 0 {
+		if start.IsZero() {
+			return NewCategoryError(ErrInvalidValue, "Record has no flow start time to assign it to an interval", ErrCritical).InTemplate(datrec.TemplateID)
+		}
+		start = start.Truncate(agg.Interval)
+		key.interval = start.UnixNano()
+	}
+	keys := make([]FieldValue, len(generated.keyIdx))
+	encoded := []byte{}
+	for idx, fieldidx := range generated.keyIdx {
+		if keys[idx], err = maskPrefix(datrec.FieldValues[fieldidx], generated.prefixes[idx]); err != nil {
+			if perr, ok := err.(*ProtocolError); ok {
+				perr.InTemplate(datrec.TemplateID).AtField(fieldidx)
+			}
+			return err
+		}
+		value, _ := keys[idx].MarshalBinary()
+		encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(value)))
+		encoded = append(encoded, value...)
+	}
+	key.key = string(encoded)
+
+	flow, found := agg.flows[key]
+	values := make([]FieldValue, len(generated.valueIdx)) //The flow is only changed when all values could be combined
+	if found {
+		copy(values, flow.values)
+	}
+	for idx, fieldidx := range generated.valueIdx {
+		if err := aggregateValue(values, idx, datrec.FieldValues[fieldidx], generated.functions[idx]); err != nil {
+			if perr, ok := err.(*ProtocolError); ok {
+				perr.InTemplate(datrec.TemplateID).AtField(fieldidx)
+			}
+			return err
+		}
+	}
+	if !found {
+		flow = &aggregateFlow{generated: generated, sequence: agg.sequence, start: start, keys: keys}
+		agg.flows[key] = flow
+		agg.sequence++
+	}
+	flow.values = values
+	flow.present++
+	if agg.Interval > 0 && !end.IsZero() && end.Before(start.Add(agg.Interval)) {
+		flow.completed++
+	}
+	return nil
+}
+
+// Expire returns the aggregated flows of the intervals that ended at or before now. Without an Interval nothing expires, use Flush.
+func (agg *Aggregator) Expire(now time.Time) ([]*DataRecord, error) {
+	if agg.Interval <= 0 {
+		return nil, nil
+	}
+	return agg.expire(func(flow *aggregateFlow) bool { return !flow.start.Add(agg.Interval).After(now) })
+}
+
+// Flush returns all aggregated flows
+func (agg *Aggregator) Flush() ([]*DataRecord, error) {
+	return agg.expire(func(*aggregateFlow) bool { return true })
+}
+
+// expire removes the flows for which expired returns true and returns their records, in the order in which the flows were created
+func (agg *Aggregator) expire(expired func(*aggregateFlow) bool) ([]*DataRecord, error) {
+	flows := []*aggregateFlow{}
+	for key, flow := range agg.flows {
+		if expired(flow) {
+			flows = append(flows, flow)
+			delete(agg.flows, key)
+		}
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i].sequence < flows[j].sequence })
+	records := make([]*DataRecord, 0, len(flows))
+	for _, flow := range flows {
+		datrec, _ := NewDataRecord(flow.generated.record.TemplateID, agg.Templates)
+		datrec.FieldValues = make([]FieldValue, 0, len(flow.generated.record.FieldSpecifiers))
+		for idx, keyval := range flow.keys {
+			datrec.FieldValues = append(datrec.FieldValues, keyval)
+			if flow.generated.prefixes[idx] > 0 && isPrefixElement(flow.generated.record.FieldSpecifiers[len(datrec.FieldValues)-1]) {
+				datrec.FieldValues = append(datrec.FieldValues, &FieldValueUnsigned8{value: flow.generated.prefixes[idx]})
+			}
+		}
+		if agg.Interval > 0 {
+			datrec.FieldValues = append(datrec.FieldValues, &FieldValueDateTimeMilliseconds{value: flow.start}, &FieldValueDateTimeMilliseconds{value: flow.start.Add(agg.Interval)})
+		}
+		datrec.FieldValues = append(datrec.FieldValues, flow.values...)
+		datrec.FieldValues = append(datrec.FieldValues, &FieldValueUnsigned64{value: flow.present})
+		if agg.Interval > 0 {
+			datrec.FieldValues = append(datrec.FieldValues, &FieldValueUnsigned64{value: flow.completed})
+		}
+		records = append(records, datrec)
+	}
+	return records, nil
+}
+
+// isPrefixElement returns whether the field specifier is of a prefix element of prefixElements
+func isPrefixElement(fsp *FieldSpecifier) bool {
+	if fsp.EnterpriseNumber != 0 {
+		return false
+	}
+	for _, elements := range prefixElements {
+		if elements[0] == fsp.InformationElementIdentifier {
+			return true
+		}
+	}
+	return false
+}
+
+// template returns the generated template for records of tmplrec, and generates it if needed
+func (agg *Aggregator) template(tmplrec *TemplateRecord) (*aggregateTemplate, error) {
+	genkey := newGeneratedKey("", tmplrec)
+	if generated, found := agg.generated.get(genkey); found {
+		return generated, nil
+	}
+	agg.generated.drop()
+	id, ok := agg.generated.allocate()
+	if !ok {
+		return nil, NewCategoryError(ErrInvalidTemplateID, "No template ids left for aggregated templates", ErrCritical)
+	}
+	fsps := tmplrec.allFieldSpecifiers()
+	generated := &aggregateTemplate{startIdx: -1, endIdx: -1}
+	generated.record, _ = NewTemplateRecord(id)
+	iskey := make(map[int]bool)
+	for _, key := range agg.Keys {
+		idx := findField(fsps, key.EnterpriseID, key.ElementID)
+		if idx < 0 || iskey[idx] {
+			continue //Records without the key field are aggregated by the other keys
+		}
+		iskey[idx] = true
+		generated.keyIdx = append(generated.keyIdx, idx)
+		generated.prefixes = append(generated.prefixes, key.PrefixLength)
+		if elements, found := prefixElements[key.ElementID]; found && key.EnterpriseID == 0 && key.PrefixLength > 0 {
+			generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: elements[0], FieldLength: fsps[idx].FieldLength})
+			generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: elements[1], FieldLength: 1})
+			continue
+		}
+		generated.record.AddSpecifier(copyFieldSpecifier(fsps[idx]))
+	}
+	for _, elements := range [][]uint16{flowStartElements, flowEndElements} {
+		for _, elementid := range elements {
+			if idx := findField(fsps, 0, elementid); idx >= 0 {
+				if elements[0] == flowStartElements[0] {
+					generated.startIdx = idx
+				} else {
+					generated.endIdx = idx
+				}
+				break
+			}
+		}
+	}
+	if agg.Interval > 0 {
+		generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: flowStartMillisecondsElement, FieldLength: 8})
+		generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: flowEndMillisecondsElement, FieldLength: 8})
+	}
+	for idx, fsp := range fsps {
+		if iskey[idx] {
+			continue
+		}
+		if agg.Interval > 0 && fsp.EnterpriseNumber == 0 && (containsElement(flowStartElements, fsp.InformationElementIdentifier) || containsElement(flowEndElements, fsp.InformationElementIdentifier)) {
+			continue //The interval takes the place of the flow times
+		}
+		function, found := agg.functions[aggregationElement{enterpriseID: fsp.EnterpriseNumber, elementID: fsp.InformationElementIdentifier}]
+		if !found {
+			function = defaultAggregationFunction(fsp.EnterpriseNumber, fsp.InformationElementIdentifier)
+		}
+		generated.valueIdx = append(generated.valueIdx, idx)
+		generated.functions = append(generated.functions, function)
+		if function == AggregateSum {
+			generated.record.AddSpecifier(sumFieldSpecifier(fsp))
+		} else {
+			generated.record.AddSpecifier(copyFieldSpecifier(fsp))
+		}
+	}
+	generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: originalFlowsPresentElement, FieldLength: 8})
+	if agg.Interval > 0 {
+		generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: originalFlowsCompletedElement, FieldLength: 8})
+	}
+	if err := agg.Templates.Set(id, generated.record); err != nil {
+		agg.generated.release(id)
+		return nil, err
+	}
+	agg.generated.add(genkey, []*TemplateRecord{tmplrec}, generated, id)
+	return generated, nil
+}
+
+// sumFieldSpecifier returns a copy of the field specifier of a summed field with the full length of its data type,
+// as the sum of values of reduced-size encoding may not fit the reduced length. A bitwise or always fits.
+func sumFieldSpecifier(fsp *FieldSpecifier) *FieldSpecifier {
+	sum := copyFieldSpecifier(fsp)
+	fieldval, err := NewFieldValueByID(fsp.EnterpriseNumber, fsp.InformationElementIdentifier)
+	if err != nil {
+		return sum
+	}
+	if datatype, ok := dataTypeOf(fieldval); ok && dataTypes[datatype].length != VariableLength && dataTypes[datatype].length > sum.FieldLength {
+		sum.FieldLength = dataTypes[datatype].length
+	}
+	return sum
+}
+
+// findField returns the index of the first field specifier of the element, or -1 if there is none
+func findField(fsps []*FieldSpecifier, enterpriseid uint32, elementid uint16) int {
+	for idx, fsp := range fsps {
+		if fsp.EnterpriseNumber == enterpriseid && fsp.InformationElementIdentifier == elementid {
+			return idx
+		}
+	}
+	return -1
+}
+
+// containsElement returns whether the element is in the list
+func containsElement(elements []uint16, elementid uint16) bool {
+	for _, element := range elements {
+		if element == elementid {
+			return true
+		}
+	}
+	return false
+}
+
+// maskPrefix returns a new address value masked to the prefix length, or the value itself if prefixlength is 0
+func maskPrefix(fieldval FieldValue, prefixlength uint8) (FieldValue, error) {
+	if prefixlength == 0 {
+		return fieldval, nil
+	}
+	switch fv := fieldval.(type) {
+	case *FieldValueIPv4Address:
+		if prefixlength > 32 {
+			break
+		}
+		return &FieldValueIPv4Address{value: fv.value.To4().Mask(net.CIDRMask(int(prefixlength), 32))}, nil
+	case *FieldValueIPv6Address:
+		if prefixlength > 128 {
+			break
+		}
+		return &FieldValueIPv6Address{value: fv.value.To16().Mask(net.CIDRMask(int(prefixlength), 128))}, nil
+	default:
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not mask a %T to a prefix", fieldval), ErrCritical)
+	}
+	return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Prefix length %d is too long for %T", prefixlength, fieldval), ErrCritical)
+}
+
+// aggregateValue combines the value with values[idx] by the function. Sums and ors are kept in new values, the others share the value.
+func aggregateValue(values []FieldValue, idx int, fieldval FieldValue, function AggregationFunction) error {
+	if values[idx] == nil {
+		if function != AggregateSum && function != AggregateOr {
+			values[idx] = fieldval
+			return nil
+		}
+		accumulator, err := getNewFieldValue(fieldval)
+		if err != nil {
+			return err
+		}
+		if err := accumulator.Set(fieldval.Value()); err != nil {
+			return err
+		}
+		values[idx] = accumulator
+		return nil
+	}
+	switch function {
+	case AggregateLast:
+		values[idx] = fieldval
+	case AggregateMin:
+		if compareValues(fieldval, values[idx]) < 0 {
+			values[idx] = fieldval
+		}
+	case AggregateMax:
+		if compareValues(fieldval, values[idx]) > 0 {
+			values[idx] = fieldval
+		}
+	case AggregateSum, AggregateOr:
+		accumulator, err := getNewFieldValue(values[idx])
+		if err != nil {
+			return err
+		}
+		if err := accumulator.Set(values[idx].Value()); err != nil {
+			return err
+		}
+		if err := addValue(accumulator, fieldval, function == AggregateOr); err != nil {
+			return err
+		}
+		values[idx] = accumulator
+	}
+	return nil
+}
+
+// addValue adds the value to the accumulator, or or-s it with bitwise
+func addValue(accumulator FieldValue, fieldval FieldValue, bitwise bool) error {
+	if sum, ok := unsignedValue(accumulator); ok {
+		value, ok := unsignedValue(fieldval)
+		if !ok {
+			return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not add a %T to a %T", fieldval, accumulator), ErrCritical)
+		}
+		if bitwise {
+			return setUnsigned(accumulator, sum|value)
+		}
+		if sum > math.MaxUint64-value {
+			return setUnsigned(accumulator, math.MaxUint64)
+		}
+		return setUnsigned(accumulator, sum+value)
+	}
+	if bitwise {
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not or a %T", accumulator), ErrCritical)
+	}
+	switch sum := accumulator.Value().(type) {
+	case float32:
+		if value, ok := fieldval.Value().(float32); ok {
+			return accumulator.Set(sum + value)
+		}
+	case float64:
+		if value, ok := fieldval.Value().(float64); ok {
+			return accumulator.Set(sum + value)
+		}
+	}
+	if sum, ok := signedValue(accumulator); ok {
+		if value, ok := signedValue(fieldval); ok {
+			return setSigned(accumulator, sum, value)
+		}
+	}
+	return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not add a %T to a %T", fieldval, accumulator), ErrCritical)
+}
+
+// signedValue returns the value of a signed integer Field Value of any size
+func signedValue(fieldval FieldValue) (int64, bool) {
+	switch value := fieldval.Value().(type) {
+	case int8:
+		return int64(value), true
+	case int16:
+		return int64(value), true
+	case int32:
+		return int64(value), true
+	case int64:
+		return value, true
+	}
+	return 0, false
+}
+
+// setSigned sets a signed integer Field Value of any size to the sum of a and b, saturating at the limits of its type
+func setSigned(fieldval FieldValue, a int64, b int64) error {
+	sum := a + b
+	if b > 0 && sum < a {
+		sum = math.MaxInt64
+	} else if b < 0 && sum > a {
+		sum = math.MinInt64
+	}
+	switch fieldval.(type) {
+	case *FieldValueSigned8:
+		return fieldval.Set(int8(max(min(sum, math.MaxInt8), math.MinInt8)))
+	case *FieldValueSigned16:
+		return fieldval.Set(int16(max(min(sum, math.MaxInt16), math.MinInt16)))
+	case *FieldValueSigned32:
+		return fieldval.Set(int32(max(min(sum, math.MaxInt32), math.MinInt32)))
+	}
+	return fieldval.Set(sum)
+}
+
+// compareValues returns -1, 0 or 1 if a is smaller than, equal to or larger than b. Numbers and times are compared by value,
+// other values by their encoding.
+func compareValues(a FieldValue, b FieldValue) int {
+	if ua, ok := unsignedValue(a); ok {
+		if ub, ok := unsignedValue(b); ok {
+			return compareOrdered(ua, ub)
+		}
+	}
+	if sa, ok := signedValue(a); ok {
+		if sb, ok := signedValue(b); ok {
+			return compareOrdered(sa, sb)
+		}
+	}
+	switch va := a.Value().(type) {
+	case float32:
+		if vb, ok := b.Value().(float32); ok {
+			return compareOrdered(va, vb)
+		}
+	case float64:
+		if vb, ok := b.Value().(float64); ok {
+			return compareOrdered(va, vb)
+		}
+	case time.Time:
+		if vb, ok := b.Value().(time.Time); ok {
+			return va.Compare(vb)
+		}
+	}
+	ea, _ := a.MarshalBinary()
+	eb, _ := b.MarshalBinary()
+	return bytes.Compare(ea, eb)
+}
+
+// compareOrdered returns -1, 0 or 1 if a is smaller than, equal to or larger than b
+func compareOrdered[T int64 | uint64 | float32 | float64](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
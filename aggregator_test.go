@@ -0,0 +1,258 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const (
+	aggregatorTestPrint = false
+)
+
+func TestAggregatorMarker(t *testing.T) {
+	if aggregatorTestPrint {
+		fmt.Printf(testMarkerString, "Aggregator")
+	}
+}
+
+// aggregatorTestStart is the start of the first interval of the test flows
+var aggregatorTestStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// aggregatorTestFlow returns a flow record of template 256 that starts and ends the given number of seconds after aggregatorTestStart
+func aggregatorTestFlow(templates *ActiveTemplates, source net.IP, protocol uint8, start int, end int, port uint16, octets uint64, flags uint16) *DataRecord {
+	if _, err := templates.Get(256); err != nil {
+		tmplrec, _ := NewTemplateRecord(256)
+		for _, element := range [][2]uint16{{8, 4}, {4, 1}, {152, 8}, {153, 8}, {11, 2}, {1, 8}, {6, 2}} { //sourceIPv4Address, protocolIdentifier, flowStartMilliseconds, flowEndMilliseconds, destinationTransportPort, octetDeltaCount, tcpControlBits
+			fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+			tmplrec.AddSpecifier(fsp)
+		}
+		templates.Set(256, tmplrec)
+	}
+	datrec, _ := NewDataRecord(256, templates)
+	datrec.FieldValues = []FieldValue{
+		&FieldValueIPv4Address{value: source.To4()},
+		&FieldValueUnsigned8{value: protocol},
+		&FieldValueDateTimeMilliseconds{value: aggregatorTestStart.Add(time.Duration(start) * time.Second)},
+		&FieldValueDateTimeMilliseconds{value: aggregatorTestStart.Add(time.Duration(end) * time.Second)},
+		&FieldValueUnsigned16{value: port},
+		&FieldValueUnsigned64{value: octets},
+		&FieldValueUnsigned16{value: flags},
+	}
+	return datrec
+}
+
+// aggregatorTestRecords returns 3 flows of 10.0.0.0/24 and TCP in the first minute, of which one ends in the next minute,
+// a flow of another prefix, a UDP flow and a flow that starts in the next minute
+func aggregatorTestRecords() []*DataRecord {
+	templates := NewActiveTemplateList()
+	return []*DataRecord{
+		aggregatorTestFlow(templates, net.IPv4(10, 0, 0, 1), 6, 10, 20, 80, 100, 0x02),
+		aggregatorTestFlow(templates, net.IPv4(10, 0, 1, 1), 6, 15, 20, 80, 1, 0x02),
+		aggregatorTestFlow(templates, net.IPv4(10, 0, 0, 2), 6, 30, 90, 443, 200, 0x10),
+		aggregatorTestFlow(templates, net.IPv4(10, 0, 0, 3), 17, 40, 45, 53, 50, 0),
+		aggregatorTestFlow(templates, net.IPv4(10, 0, 0, 4), 6, 65, 70, 22, 400, 0x01),
+		aggregatorTestFlow(templates, net.IPv4(10, 0, 0, 5), 6, 50, 55, 25, 300, 0x04),
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	aggregator := NewAggregator([]AggregationKey{{ElementID: 8, PrefixLength: 24}, {ElementID: 4}}, time.Minute, 300)
+	for idx, datrec := range aggregatorTestRecords() {
+		if err := aggregator.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding record %d: %v", idx, err)
+		}
+	}
+	if aggregator.Pending() != 4 {
+		t.Fatalf(errorPrefixMarker+"Expected 4 aggregated flows, but got %d", aggregator.Pending())
+	}
+	expired, err := aggregator.Expire(aggregatorTestStart.Add(90 * time.Second))
+	if err != nil || len(expired) != 3 || aggregator.Pending() != 1 {
+		t.Fatalf(errorPrefixMarker+"Expected 3 flows of the first interval to expire, but got %d (%v)", len(expired), err)
+	}
+	tmplrec, _ := aggregator.Templates.Get(300)
+	elements := []uint16{44, 9, 4, 152, 153, 11, 1, 6, 375, 377}
+	if len(tmplrec.FieldSpecifiers) != len(elements) {
+		t.Fatalf(errorPrefixMarker+"Expected %d fields in the aggregated template, but got %v", len(elements), tmplrec)
+	}
+	for idx, element := range elements {
+		if tmplrec.FieldSpecifiers[idx].InformationElementIdentifier != element {
+			t.Errorf(errorPrefixMarker+"Field %d: expected element %d, but got %d", idx, element, tmplrec.FieldSpecifiers[idx].InformationElementIdentifier)
+		}
+	}
+
+	builder := NewMessageBuilder(1, aggregator.Templates, 0)
+	for _, datrec := range expired {
+		if err := builder.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding aggregated record: %v", err)
+		}
+	}
+	decoded := messageBuilderTestDecode(t, builder.Flush(), maxMessageSize)[0]
+	datrec := (*decoded.Sets[1].Records[0]).(*DataRecord)
+	if prefix := datrec.FieldValues[0].Value().(net.IP); !prefix.Equal(net.IPv4(10, 0, 0, 0)) || datrec.FieldValues[1].Value() != uint8(24) || datrec.FieldValues[2].Value() != uint8(6) {
+		t.Errorf(errorPrefixMarker+"Wrong key of the aggregated flow %v", datrec)
+	}
+	if start := datrec.FieldValues[3].Value().(time.Time); !start.Equal(aggregatorTestStart) {
+		t.Errorf(errorPrefixMarker+"Expected the interval to start at %v, but got %v", aggregatorTestStart, start)
+	}
+	if end := datrec.FieldValues[4].Value().(time.Time); !end.Equal(aggregatorTestStart.Add(time.Minute)) {
+		t.Errorf(errorPrefixMarker+"Expected the interval to end a minute later, but got %v", end)
+	}
+	if datrec.FieldValues[5].Value() != uint16(80) || datrec.FieldValues[6].Value() != uint64(600) || datrec.FieldValues[7].Value() != uint16(0x16) {
+		t.Errorf(errorPrefixMarker+"Expected port 80, 600 octets and flags 0x16, but got %v, %v and %v", datrec.FieldValues[5].Value(), datrec.FieldValues[6].Value(), datrec.FieldValues[7].Value())
+	}
+	if datrec.FieldValues[8].Value() != uint64(3) || datrec.FieldValues[9].Value() != uint64(2) {
+		t.Errorf(errorPrefixMarker+"Expected 3 flows present of which 2 completed, but got %v and %v", datrec.FieldValues[8].Value(), datrec.FieldValues[9].Value())
+	}
+	if prefix := expired[1].FieldValues[0].Value().(net.IP); !prefix.Equal(net.IPv4(10, 0, 1, 0)) || expired[2].FieldValues[2].Value() != uint8(17) {
+		t.Errorf(errorPrefixMarker+"Expected the flows of the other prefix and protocol in order, but got %v and %v", expired[1], expired[2])
+	}
+
+	flushed, err := aggregator.Flush()
+	if err != nil || len(flushed) != 1 || aggregator.Pending() != 0 {
+		t.Fatalf(errorPrefixMarker+"Expected 1 flow of the next interval to be flushed, but got %d (%v)", len(flushed), err)
+	}
+	if start := flushed[0].FieldValues[3].Value().(time.Time); !start.Equal(aggregatorTestStart.Add(time.Minute)) || flushed[0].FieldValues[6].Value() != uint64(400) {
+		t.Errorf(errorPrefixMarker+"Wrong flow of the next interval %v", flushed[0])
+	}
+}
+
+func TestAggregatorWithoutInterval(t *testing.T) {
+	aggregator := NewAggregator([]AggregationKey{{ElementID: 4}}, 0, 0)
+	aggregator.SetFunction(0, 11, AggregateMax)
+	for idx, datrec := range aggregatorTestRecords() {
+		if err := aggregator.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding record %d: %v", idx, err)
+		}
+	}
+	if expired, _ := aggregator.Expire(aggregatorTestStart.Add(time.Hour)); len(expired) != 0 {
+		t.Errorf(errorPrefixMarker+"Flows without interval should not expire, but got %d", len(expired))
+	}
+	flushed, err := aggregator.Flush()
+	if err != nil || len(flushed) != 2 {
+		t.Fatalf(errorPrefixMarker+"Expected 2 aggregated flows, but got %d (%v)", len(flushed), err)
+	}
+	tcp := flushed[0]
+	if tmplrec, _ := aggregator.Templates.Get(tcp.TemplateID); tmplrec.TemplateID != 256 || len(tmplrec.FieldSpecifiers) != 8 {
+		t.Fatalf(errorPrefixMarker+"Expected template 256 with 8 fields, but got %v", tmplrec)
+	}
+	//protocolIdentifier, sourceIPv4Address, flowStartMilliseconds, flowEndMilliseconds, destinationTransportPort, octetDeltaCount, tcpControlBits, originalFlowsPresent
+	if start := tcp.FieldValues[2].Value().(time.Time); !start.Equal(aggregatorTestStart.Add(10 * time.Second)) {
+		t.Errorf(errorPrefixMarker+"Expected the earliest flow start, but got %v", start)
+	}
+	if end := tcp.FieldValues[3].Value().(time.Time); !end.Equal(aggregatorTestStart.Add(90 * time.Second)) {
+		t.Errorf(errorPrefixMarker+"Expected the latest flow end, but got %v", end)
+	}
+	if tcp.FieldValues[1].Value().(net.IP).String() != "10.0.0.1" || tcp.FieldValues[4].Value() != uint16(443) || tcp.FieldValues[5].Value() != uint64(1001) || tcp.FieldValues[7].Value() != uint64(5) {
+		t.Errorf(errorPrefixMarker+"Wrong aggregated TCP flow %v", tcp)
+	}
+}
+
+func TestAggregatorReducedSize(t *testing.T) {
+	templates := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(256)
+	for _, element := range [][2]uint16{{4, 1}, {1, 4}, {6, 1}} { //protocolIdentifier, octetDeltaCount and tcpControlBits of reduced size
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	templates.Set(256, tmplrec)
+	aggregator := NewAggregator([]AggregationKey{{ElementID: 4}}, 0, 300)
+	for _, flags := range []uint16{0x02, 0x10} {
+		datrec, _ := NewDataRecord(256, templates)
+		datrec.FieldValues = []FieldValue{&FieldValueUnsigned8{value: 6}, &FieldValueUnsigned64{value: 2250000000}, &FieldValueUnsigned16{value: flags}}
+		if err := aggregator.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding record: %v", err)
+		}
+	}
+	flushed, _ := aggregator.Flush()
+	builder := NewMessageBuilder(1, aggregator.Templates, 0)
+	if err := builder.AddRecord(flushed[0]); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error adding aggregated record: %v", err)
+	}
+	decoded := messageBuilderTestDecode(t, builder.Flush(), maxMessageSize)[0]
+	datrec := (*decoded.Sets[1].Records[0]).(*DataRecord)
+	if datrec.FieldValues[1].Value() != uint64(4500000000) || datrec.FieldValues[2].Value() != uint16(0x12) {
+		t.Errorf(errorPrefixMarker+"Expected 4500000000 octets and flags 0x12, but got %v and %v", datrec.FieldValues[1].Value(), datrec.FieldValues[2].Value())
+	}
+	if generated, _ := aggregator.Templates.Get(300); generated.FieldSpecifiers[1].FieldLength != 8 || generated.FieldSpecifiers[2].FieldLength != 1 {
+		t.Errorf(errorPrefixMarker+"Expected the summed counter to get its full length and the flags to keep theirs, but got %v", generated)
+	}
+}
+
+func TestAggregatorDroppedTemplates(t *testing.T) {
+	aggregator := NewAggregator([]AggregationKey{{ElementID: 4}}, 0, 65535) //Only a single template id
+	builder := NewMessageBuilder(1, aggregator.Templates, 0)
+	aggregate := func(datrec *DataRecord) error {
+		if err := aggregator.AddRecord(datrec); err != nil {
+			return err
+		}
+		flushed, _ := aggregator.Flush()
+		if err := builder.AddRecord(flushed[0]); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding aggregated record: %v", err)
+		}
+		if messages := builder.Flush(); len(messages[0].Sets) != 2 {
+			t.Errorf(errorPrefixMarker+"Expected the generated template to be exported with the record, but got %d sets", len(messages[0].Sets))
+		}
+		return nil
+	}
+	if err := aggregate(aggregatorTestFlow(NewActiveTemplateList(), net.IPv4(10, 0, 0, 1), 6, 10, 20, 80, 100, 0)); err != nil { //Sessions that come and go, all with a template 256
+		t.Fatalf(errorPrefixMarker+"Error aggregating: %v", err)
+	}
+	for range 100 {
+		runtime.GC()
+		err := aggregate(psampTestRecord(NewActiveTemplateList(), 256, psampTestField{4, &FieldValueUnsigned8{value: 6}}, psampTestField{1, &FieldValueUnsigned64{value: 100}}))
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrInvalidTemplateID) {
+			t.Fatalf(errorPrefixMarker+"Expected no template ids to be left, but got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf(errorPrefixMarker + "Expected the template id of the dropped template to be given out again")
+}
+
+func TestAggregatorErrors(t *testing.T) {
+	records := aggregatorTestRecords()
+	if err := NewAggregator([]AggregationKey{{ElementID: 4, PrefixLength: 8}}, 0, 0).AddRecord(records[0]); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a prefix of a protocol to fail, but got %v", err)
+	}
+	if err := NewAggregator([]AggregationKey{{ElementID: 8, PrefixLength: 33}}, 0, 0).AddRecord(records[0]); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a prefix that is too long to fail, but got %v", err)
+	}
+	aggregator := NewAggregator([]AggregationKey{{ElementID: 4}}, 0, 0)
+	aggregator.AddRecord(records[0])
+	wrong := aggregatorTestFlow(NewActiveTemplateList(), net.IPv4(10, 0, 0, 2), 6, 10, 20, 80, 100, 0)
+	wrong.AssociatedTemplates = records[0].AssociatedTemplates
+	wrong.FieldValues[6] = &FieldValueString{value: "SYN"} //The octets are summed before the flags fail
+	if err := aggregator.AddRecord(wrong); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected summing a string to fail, but got %v", err)
+	}
+	if flushed, _ := aggregator.Flush(); len(flushed) != 1 || flushed[0].FieldValues[5].Value() != uint64(100) || flushed[0].FieldValues[7].Value() != uint64(1) {
+		t.Errorf(errorPrefixMarker+"Expected the flow to be unchanged by the failed record, but got %v", flushed)
+	}
+
+	templates := NewActiveTemplateList()
+	notime := psampTestRecord(templates, 257, psampTestField{4, &FieldValueUnsigned8{value: 6}})
+	if err := NewAggregator([]AggregationKey{{ElementID: 4}}, time.Minute, 0).AddRecord(notime); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a record without start time to fail with an interval, but got %v", err)
+	}
+
+	accumulator := &FieldValueUnsigned8{value: 200}
+	if err := addValue(accumulator, &FieldValueUnsigned8{value: 100}, false); err != nil || accumulator.value != 255 {
+		t.Errorf(errorPrefixMarker+"Expected the sum to saturate at 255, but got %d (%v)", accumulator.value, err)
+	}
+	signed := &FieldValueSigned8{value: -100}
+	if err := addValue(signed, &FieldValueSigned8{value: -100}, false); err != nil || signed.value != -128 {
+		t.Errorf(errorPrefixMarker+"Expected the sum to saturate at -128, but got %d (%v)", signed.value, err)
+	}
+	if err := addValue(&FieldValueString{value: "a"}, &FieldValueString{value: "b"}, false); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a sum of strings to fail, but got %v", err)
+	}
+	if AggregateOr.String() != "or" || AggregationFunction(42).String() != "unknown(42)" {
+		t.Errorf(errorPrefixMarker+"Wrong function names %s and %s", AggregateOr, AggregationFunction(42))
+	}
+}
@@ -0,0 +1,413 @@
+package ipfix
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+	"weak"
+)
+
+/*
+
+RFC 6235 describes the anonymisation of flow records before they are exported, and how the exporter tells the collector which
+technique was applied to which field in anonymisation records. An Anonymiser anonymises the fields of data records in place,
+with an Anonymisation per Information Element:
+
+	anonymiser, err := NewAnonymiser(key) //The 32 octet key of Crypto-PAn
+	anonymiser.SetAnonymisation(0, 8, Anonymisation{Technique: AnonymizationStructuredPermutation}) //sourceIPv4Address with Crypto-PAn
+	anonymiser.SetAnonymisation(0, 56, Anonymisation{Technique: AnonymizationTruncation, Bits: 24})  //sourceMacAddress keeps its OUI
+	anonymiser.SetAnonymisation(0, 152, Anonymisation{Technique: AnonymizationTruncation, Precision: time.Minute})
+	anonymiser.SetAnonymisation(0, 7, Anonymisation{Technique: AnonymizationBinning}) //sourceTransportPort
+	anonymised, err := anonymiser.AnonymiseMessage(ipfixmsg)
+	records, err := anonymiser.NewAnonymizationRecords(ipfixmsg.ObservationDomainID, templates, 300)
+
+The techniques that are supported:
+
+  - AnonymizationStructuredPermutation of IPv4 and IPv6 addresses with Crypto-PAn, which preserves the length of common prefixes
+  - AnonymizationTruncation of IPv4, IPv6 and MAC addresses to their leading Bits, and of times to a multiple of their Precision
+  - AnonymizationBinning of unsigned integers to the start of their bin of BinSize, or of ports to the start of their IANA range
+  - AnonymizationNone, which leaves a field as it is but is exported in the anonymisation records
+
+The values are replaced, not changed, so values shared with other records are not anonymised through them. Anonymisation
+fails closed: when a field of a record can not be anonymised, all fields of the record that have an anonymisation other
+than AnonymizationNone are set to zeros of their length, so no original value is left. The anonymisation records are kept per
+Observation Domain, as the Template IDs they refer to are. An Anonymiser is not safe for concurrent use.
+
+*/
+
+// The Information Elements of anonymisation records, RFC 6235
+const (
+	templateIDElement              = 145
+	anonymizationFlagsElement      = 285
+	anonymizationTechniqueElement  = 286
+	informationElementIndexElement = 287
+)
+
+// AnonymizationTechnique is the value of anonymizationTechnique, RFC 6235 section 6.2.2
+type AnonymizationTechnique uint16
+
+// The values of anonymizationTechnique
+const (
+	AnonymizationUndefined             AnonymizationTechnique = iota //Undefined
+	AnonymizationNone                                                //No anonymisation
+	AnonymizationTruncation                                          //Precision degradation or truncation
+	AnonymizationBinning                                             //Binning
+	AnonymizationEnumeration                                         //Enumeration
+	AnonymizationPermutation                                         //Permutation
+	AnonymizationStructuredPermutation                               //Structured permutation, like prefix-preserving address anonymisation
+	AnonymizationReverseTruncation                                   //Reverse truncation
+	AnonymizationNoise                                               //Noise
+	AnonymizationOffset                                              //Offset
+)
+
+var anonymizationTechniqueNames = [...]string{
+	"undefined", "none", "precisionDegradation", "binning", "enumeration",
+	"permutation", "structuredPermutation", "reverseTruncation", "noise", "offset",
+}
+
+// String returns the name of the technique
+func (technique AnonymizationTechnique) String() string {
+	if technique > AnonymizationOffset {
+		return fmt.Sprintf("unassigned(%d)", uint16(technique))
+	}
+	return anonymizationTechniqueNames[technique]
+}
+
+// AnonymizationStability is the Stability Class of anonymizationFlags: how long the anonymisation of a value stays the same
+type AnonymizationStability uint16
+
+// The Stability Classes of anonymizationFlags
+const (
+	AnonymizationStabilityUndefined         AnonymizationStability = 0 //Undefined
+	AnonymizationStabilitySession           AnonymizationStability = 1 //Stable within the Transport Session
+	AnonymizationStabilityExporterCollector AnonymizationStability = 2 //Stable between this Exporter and Collector
+	AnonymizationStable                     AnonymizationStability = 3 //Stable indefinitely
+)
+
+// Anonymisation is the anonymisation of an Information Element. Only the parameters of its technique are used.
+type Anonymisation struct {
+	Technique AnonymizationTechnique
+	Bits      uint8         //Truncation of addresses: the number of leading bits that are kept, the others are zeroed
+	Precision time.Duration //Truncation of times: times are truncated to a multiple of the precision
+	BinSize   uint64        //Binning: values are replaced by the start of their bin. 0 bins ports into the well-known, registered and dynamic ranges.
+}
+
+// CryptoPAn is the prefix-preserving IP address anonymisation of Xu, Fan, Ammar and Moon: addresses that share a prefix of n bits
+// are anonymised to addresses that share a prefix of n bits as well.
+type CryptoPAn struct {
+	cipher cipher.Block
+	pad    [aes.BlockSize]byte
+}
+
+// cryptoPAnKeyLength is the length of the key of Crypto-PAn: the AES key followed by the octets of the pad
+const cryptoPAnKeyLength = 32
+
+// NewCryptoPAn returns the Crypto-PAn of the 32 octet key
+func NewCryptoPAn(key []byte) (*CryptoPAn, error) {
+	if len(key) != cryptoPAnKeyLength {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Crypto-PAn needs a key of %d octets, but got %d", cryptoPAnKeyLength, len(key)), ErrCritical)
+	}
+	block, err := aes.NewCipher(key[:aes.BlockSize])
+	if err != nil {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid Crypto-PAn key: %v", err), ErrCritical)
+	}
+	cp := &CryptoPAn{cipher: block}
+	block.Encrypt(cp.pad[:], key[aes.BlockSize:])
+	return cp, nil
+}
+
+// Anonymise returns the anonymised address. IPv4 addresses are returned as 4 octets, IPv6 addresses as 16.
+func (cp *CryptoPAn) Anonymise(ip net.IP) net.IP {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return cp.anonymise(ipv4)
+	}
+	return cp.anonymise(ip.To16())
+}
+
+// anonymise returns the anonymised address: bit n of the address is flipped by the first bit of the encryption of its first n bits,
+// padded with the pad
+func (cp *CryptoPAn) anonymise(addr []byte) net.IP {
+	result := make(net.IP, len(addr))
+	var block, output [aes.BlockSize]byte
+	for pos := 0; pos < len(addr)*8; pos++ {
+		block = cp.pad
+		copy(block[:pos/8], addr)
+		if rem := pos % 8; rem > 0 {
+			mask := byte(0xff) << (8 - rem)
+			block[pos/8] = addr[pos/8]&mask | cp.pad[pos/8]&^mask
+		}
+		cp.cipher.Encrypt(output[:], block[:])
+		result[pos/8] |= (output[0] >> 7) << (7 - pos%8)
+	}
+	for idx := range result {
+		result[idx] ^= addr[idx]
+	}
+	return result
+}
+
+// Anonymiser anonymises the fields of data records
+type Anonymiser struct {
+	Stability AnonymizationStability //Stability Class of the exported anonymisation records
+
+	cryptopan      *CryptoPAn
+	anonymisations map[aggregationElement]Anonymisation
+	templates      map[anonymisedTemplate]struct{} //Templates of the anonymised records, for the anonymisation records
+}
+
+// anonymisedTemplate is a template of anonymised records in its Observation Domain. The template is not kept from being garbage collected.
+type anonymisedTemplate struct {
+	observationDomainID uint32
+	template            weak.Pointer[TemplateRecord]
+}
+
+// NewAnonymiser returns an anonymiser that uses the key for Crypto-PAn. The key may be nil when no structured permutation is used.
+func NewAnonymiser(key []byte) (*Anonymiser, error) {
+	anon := &Anonymiser{
+		Stability:      AnonymizationStable,
+		anonymisations: make(map[aggregationElement]Anonymisation),
+		templates:      make(map[anonymisedTemplate]struct{}),
+	}
+	if key != nil {
+		cryptopan, err := NewCryptoPAn(key)
+		if err != nil {
+			return nil, err
+		}
+		anon.cryptopan = cryptopan
+	}
+	return anon, nil
+}
+
+// SetAnonymisation sets the anonymisation of the element. It returns an error for techniques that are not supported.
+func (anon *Anonymiser) SetAnonymisation(enterpriseid uint32, elementid uint16, anonymisation Anonymisation) error {
+	switch anonymisation.Technique {
+	case AnonymizationNone, AnonymizationTruncation, AnonymizationBinning:
+	case AnonymizationStructuredPermutation:
+		if anon.cryptopan == nil {
+			return NewCategoryError(ErrInvalidValue, "Structured permutation needs a Crypto-PAn key", ErrCritical)
+		}
+	default:
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Anonymisation technique %s is not supported", anonymisation.Technique), ErrCritical)
+	}
+	anon.anonymisations[aggregationElement{enterpriseID: enterpriseid, elementID: elementid}] = anonymisation
+	return nil
+}
+
+// Anonymise anonymises the fields of the record of the Observation Domain, and returns whether any field was anonymised.
+// If a field can not be anonymised the fields with an anonymisation are zeroed, and false and the error are returned.
+func (anon *Anonymiser) Anonymise(odid uint32, datrec *DataRecord) (bool, error) {
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return false, err
+	}
+	anonymised := make(map[int]FieldValue)
+	for idx, fsp := range fsps {
+		anonymisation, found := anon.anonymisations[aggregationElement{enterpriseID: fsp.EnterpriseNumber, elementID: fsp.InformationElementIdentifier}]
+		if !found || idx >= len(datrec.FieldValues) {
+			continue
+		}
+		fieldval, suberr := anon.anonymise(datrec.FieldValues[idx], anonymisation)
+		if suberr != nil {
+			if perr, ok := suberr.(*ProtocolError); ok {
+				perr.InTemplate(datrec.TemplateID).AtField(idx)
+			}
+			err = stackError(err, "Sub errors anonymising record, its anonymised fields are zeroed.", suberr, 0)
+		}
+		anonymised[idx] = fieldval
+	}
+	for idx, fieldval := range anonymised {
+		if err != nil && fieldval != datrec.FieldValues[idx] { //AnonymizationNone keeps the value itself
+			fieldval = zeroValue(datrec.FieldValues[idx])
+		}
+		datrec.FieldValues[idx] = fieldval
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(anonymised) > 0 {
+		if tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID); tmplrec != nil {
+			anon.templates[anonymisedTemplate{observationDomainID: odid, template: weak.Make(tmplrec)}] = struct{}{}
+		}
+	}
+	return len(anonymised) > 0, nil
+}
+
+// zeroValue returns a new value of the type and length of fieldval with all octets 0,
+// or an octet array of zeros if the type is not known
+func zeroValue(fieldval FieldValue) FieldValue {
+	data, _ := fieldval.MarshalBinary()
+	zeros := make([]byte, len(data))
+	zeroed, err := getNewFieldValue(fieldval)
+	if err != nil || zeroed.UnmarshalBinary(zeros) != nil {
+		return &FieldValueOctetArray{value: zeros}
+	}
+	return zeroed
+}
+
+// AnonymiseMessage anonymises the data records of the message and returns the number of records with anonymised fields
+func (anon *Anonymiser) AnonymiseMessage(ipfixmsg *Message) (int, error) {
+	var err error
+	anonymised := 0
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok {
+				continue
+			}
+			done, suberr := anon.Anonymise(ipfixmsg.ObservationDomainID, datrec)
+			if suberr != nil {
+				err = stackError(err, "Sub errors anonymising records.", suberr, 0)
+			}
+			if done {
+				anonymised++
+			}
+		}
+	}
+	return anonymised, err
+}
+
+// anonymise returns a new value of the anonymised field value
+func (anon *Anonymiser) anonymise(fieldval FieldValue, anonymisation Anonymisation) (FieldValue, error) {
+	switch anonymisation.Technique {
+	case AnonymizationNone:
+		return fieldval, nil
+	case AnonymizationStructuredPermutation:
+		switch fv := fieldval.(type) {
+		case *FieldValueIPv4Address:
+			return &FieldValueIPv4Address{value: anon.cryptopan.Anonymise(fv.value)}, nil
+		case *FieldValueIPv6Address:
+			return &FieldValueIPv6Address{value: anon.cryptopan.Anonymise(fv.value)}, nil
+		}
+	case AnonymizationTruncation:
+		switch fv := fieldval.(type) {
+		case *FieldValueIPv4Address:
+			return truncateAddress(fieldval, fv.value.To4(), anonymisation.Bits)
+		case *FieldValueIPv6Address:
+			return truncateAddress(fieldval, fv.value.To16(), anonymisation.Bits)
+		case *FieldValueMacAddress:
+			return truncateAddress(fieldval, fv.value, anonymisation.Bits)
+		}
+		if value, ok := fieldval.Value().(time.Time); ok {
+			if anonymisation.Precision <= 0 {
+				return nil, NewCategoryError(ErrInvalidValue, "Truncation of a time needs a precision", ErrCritical)
+			}
+			degraded, err := getNewFieldValue(fieldval)
+			if err != nil {
+				return nil, err
+			}
+			return degraded, degraded.Set(value.Truncate(anonymisation.Precision))
+		}
+	case AnonymizationBinning:
+		if value, ok := unsignedValue(fieldval); ok {
+			binned, err := getNewFieldValue(fieldval)
+			if err != nil {
+				return nil, err
+			}
+			return binned, setUnsigned(binned, binValue(value, anonymisation.BinSize))
+		}
+	}
+	return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not apply %s to a %T", anonymisation.Technique, fieldval), ErrCritical)
+}
+
+// truncateAddress returns a new address value of the type of fieldval with the leading bits of addr
+func truncateAddress(fieldval FieldValue, addr []byte, bits uint8) (FieldValue, error) {
+	if int(bits) > len(addr)*8 {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not keep %d bits of a %T", bits, fieldval), ErrCritical)
+	}
+	truncated := make([]byte, len(addr))
+	copy(truncated, addr[:bits/8])
+	if rem := bits % 8; rem > 0 {
+		truncated[bits/8] = addr[bits/8] & (byte(0xff) << (8 - rem))
+	}
+	switch fieldval.(type) {
+	case *FieldValueIPv4Address:
+		return &FieldValueIPv4Address{value: net.IP(truncated)}, nil
+	case *FieldValueIPv6Address:
+		return &FieldValueIPv6Address{value: net.IP(truncated)}, nil
+	}
+	return &FieldValueMacAddress{value: net.HardwareAddr(truncated)}, nil
+}
+
+// binValue returns the start of the bin of the value. Without a bin size, the bins are the well-known, registered and dynamic port ranges.
+func binValue(value uint64, binsize uint64) uint64 {
+	switch {
+	case binsize > 0:
+		return value - value%binsize
+	case value < 1024:
+		return 0
+	case value < 49152:
+		return 1024
+	}
+	return 49152
+}
+
+// NewAnonymizationTemplate returns the options template of the anonymisation records of NewAnonymizationRecords, RFC 6235 section 6.1
+func NewAnonymizationTemplate(templateid uint16) (*TemplateRecord, error) {
+	tmplrec, err := NewOptionsTemplateRecord(templateid)
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range [][2]uint16{
+		{templateIDElement, 2},
+		{informationElementIDElement, 2},
+		{privateEnterpriseNumberElement, 4},
+		{informationElementIndexElement, 2},
+	} {
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddScopeSpecifier(fsp)
+	}
+	for _, element := range [][2]uint16{
+		{anonymizationFlagsElement, 2},
+		{anonymizationTechniqueElement, 2},
+	} {
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	return tmplrec, nil
+}
+
+// NewAnonymizationRecords returns an anonymisation record of every anonymised field of the templates of the records of the Observation Domain
+// that were anonymised, of the template of NewAnonymizationTemplate in templates. The records are sorted by template id and field index.
+// Templates that are no longer used are forgotten.
+func (anon *Anonymiser) NewAnonymizationRecords(odid uint32, templates *ActiveTemplates, templateid uint16) ([]*DataRecord, error) {
+	tmplrecs := []*TemplateRecord{}
+	for key := range anon.templates {
+		tmplrec := key.template.Value()
+		if tmplrec == nil {
+			delete(anon.templates, key)
+			continue
+		}
+		if key.observationDomainID == odid {
+			tmplrecs = append(tmplrecs, tmplrec)
+		}
+	}
+	sort.SliceStable(tmplrecs, func(i, j int) bool { return tmplrecs[i].TemplateID < tmplrecs[j].TemplateID })
+	records := []*DataRecord{}
+	for _, tmplrec := range tmplrecs {
+		for idx, fsp := range tmplrec.allFieldSpecifiers() {
+			anonymisation, found := anon.anonymisations[aggregationElement{enterpriseID: fsp.EnterpriseNumber, elementID: fsp.InformationElementIdentifier}]
+			if !found {
+				continue
+			}
+			datrec, err := NewDataRecord(templateid, templates)
+			if err != nil {
+				return nil, err
+			}
+			datrec.FieldValues = []FieldValue{
+				&FieldValueUnsigned16{value: tmplrec.TemplateID},
+				&FieldValueUnsigned16{value: fsp.InformationElementIdentifier},
+				&FieldValueUnsigned32{value: fsp.EnterpriseNumber},
+				&FieldValueUnsigned16{value: uint16(idx)},
+				&FieldValueUnsigned16{value: uint16(anon.Stability & 0x3)},
+				&FieldValueUnsigned16{value: uint16(anonymisation.Technique)},
+			}
+			records = append(records, datrec)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,192 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const (
+	anonymisationTestPrint = false
+)
+
+func TestAnonymisationMarker(t *testing.T) {
+	if anonymisationTestPrint {
+		fmt.Printf(testMarkerString, "Anonymisation")
+	}
+}
+
+// anonymisationTestKey is the key of the sample of the Crypto-PAn reference implementation
+var anonymisationTestKey = []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16, 216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2}
+
+func TestCryptoPAn(t *testing.T) {
+	cryptopan, err := NewCryptoPAn(anonymisationTestKey)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating Crypto-PAn: %v", err)
+	}
+	for raw, anonymised := range map[string]string{ //From the sample trace of the reference implementation
+		"128.11.68.132":   "135.242.180.132",
+		"129.118.74.4":    "134.136.186.123",
+		"130.132.252.244": "133.68.164.234",
+		"141.223.7.43":    "141.167.8.160",
+		"141.233.145.108": "141.129.237.235",
+		"152.163.225.39":  "151.140.114.167",
+		"192.102.249.13":  "252.138.62.131",
+	} {
+		if result := cryptopan.Anonymise(net.ParseIP(raw)); result.String() != anonymised || len(result) != 4 {
+			t.Errorf(errorPrefixMarker+"Expected %s to be anonymised to %s, but got %s", raw, anonymised, result)
+		}
+	}
+
+	first := cryptopan.Anonymise(net.ParseIP("2001:db8:1:2::1"))
+	second := cryptopan.Anonymise(net.ParseIP("2001:db8:1:3::1"))
+	if len(first) != 16 || first.Equal(net.ParseIP("2001:db8:1:2::1")) {
+		t.Errorf(errorPrefixMarker+"Expected an anonymised IPv6 address, but got %s", first)
+	}
+	if !first.Mask(net.CIDRMask(63, 128)).Equal(second.Mask(net.CIDRMask(63, 128))) || first.Mask(net.CIDRMask(64, 128)).Equal(second.Mask(net.CIDRMask(64, 128))) {
+		t.Errorf(errorPrefixMarker+"Expected %s and %s to share a prefix of exactly 63 bits", first, second)
+	}
+	if _, err := NewCryptoPAn(anonymisationTestKey[:16]); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a short key to fail, but got %v", err)
+	}
+}
+
+func TestAnonymiser(t *testing.T) {
+	anonymiser, err := NewAnonymiser(anonymisationTestKey)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating anonymiser: %v", err)
+	}
+	for element, anonymisation := range map[uint16]Anonymisation{
+		8:   {Technique: AnonymizationStructuredPermutation},
+		12:  {Technique: AnonymizationTruncation, Bits: 20},
+		56:  {Technique: AnonymizationTruncation, Bits: 24},
+		152: {Technique: AnonymizationTruncation, Precision: time.Minute},
+		7:   {Technique: AnonymizationBinning},
+		11:  {Technique: AnonymizationBinning, BinSize: 100},
+		4:   {Technique: AnonymizationNone},
+	} {
+		if err := anonymiser.SetAnonymisation(0, element, anonymisation); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error setting anonymisation of %d: %v", element, err)
+		}
+	}
+
+	templates := NewActiveTemplateList()
+	start := time.Date(2024, 1, 1, 12, 34, 56, 789000000, time.UTC)
+	sourcemac, _ := net.ParseMAC("00:1b:21:3a:4b:5c")
+	datrec := samplingTestFlow(templates, 256,
+		psampTestField{8, &FieldValueIPv4Address{value: net.IPv4(128, 11, 68, 132).To4()}},
+		psampTestField{12, &FieldValueIPv4Address{value: net.IPv4(10, 1, 255, 7).To4()}},
+		psampTestField{56, &FieldValueMacAddress{value: sourcemac}},
+		psampTestField{152, &FieldValueDateTimeMilliseconds{value: start}},
+		psampTestField{7, &FieldValueUnsigned16{value: 51234}},
+		psampTestField{11, &FieldValueUnsigned16{value: 443}},
+		psampTestField{4, &FieldValueUnsigned8{value: 6}})
+	untouched := samplingTestFlow(templates, 257)
+	source := datrec.FieldValues[2]
+	ipfixmsg := samplingTestMessage(t, templates, 1, datrec, untouched)
+	datrec = (*ipfixmsg.Sets[1].Records[0]).(*DataRecord)
+
+	anonymised, err := anonymiser.AnonymiseMessage(ipfixmsg)
+	if err != nil || anonymised != 1 {
+		t.Fatalf(errorPrefixMarker+"Expected 1 anonymised record, but got %d (%v)", anonymised, err)
+	}
+	if source.Value().(net.IP).String() != "128.11.68.132" {
+		t.Errorf(errorPrefixMarker+"The original value should not change, but got %v", source.Value())
+	}
+	expected := []string{"1000", "10", "135.242.180.132", "10.1.240.0", "00:1b:21:00:00:00", start.Truncate(time.Minute).String(), "49152", "400", "6"}
+	for idx, value := range expected {
+		result := fmt.Sprint(datrec.FieldValues[idx].Value())
+		if value, ok := datrec.FieldValues[idx].Value().(time.Time); ok {
+			result = value.UTC().String()
+		}
+		if result != value {
+			t.Errorf(errorPrefixMarker+"Field %d: expected %s, but got %s", idx, value, result)
+		}
+	}
+
+	records, err := anonymiser.NewAnonymizationRecords(1, templates, 300)
+	if err != nil || len(records) != 7 {
+		t.Fatalf(errorPrefixMarker+"Expected 7 anonymisation records, but got %d (%v)", len(records), err)
+	}
+	runtime.KeepAlive(ipfixmsg)
+	anontmpl, _ := NewAnonymizationTemplate(300)
+	templates.Set(300, anontmpl)
+	decoded := samplingTestMessage(t, templates, 1, records...)
+	techniques := []AnonymizationTechnique{AnonymizationStructuredPermutation, AnonymizationTruncation, AnonymizationTruncation, AnonymizationTruncation, AnonymizationBinning, AnonymizationBinning, AnonymizationNone}
+	for idx, rec := range decoded.Sets[1].Records {
+		anonrec := (*rec).(*DataRecord)
+		if anonrec.FieldValues[0].Value() != uint16(256) || anonrec.FieldValues[3].Value() != uint16(idx+2) || anonrec.FieldValues[4].Value() != uint16(AnonymizationStable) ||
+			anonrec.FieldValues[5].Value() != uint16(techniques[idx]) {
+			t.Errorf(errorPrefixMarker+"Record %d: expected %s of field %d, but got %v", idx, techniques[idx], idx+2, anonrec)
+		}
+	}
+}
+
+func TestAnonymiserDomains(t *testing.T) {
+	anonymiser, _ := NewAnonymiser(nil)
+	anonymiser.SetAnonymisation(0, 12, Anonymisation{Technique: AnonymizationTruncation, Bits: 8})
+	anonymiser.SetAnonymisation(0, 7, Anonymisation{Technique: AnonymizationBinning})
+
+	first := NewActiveTemplateList()
+	firstmsg := samplingTestMessage(t, first, 1, samplingTestFlow(first, 256,
+		psampTestField{12, &FieldValueIPv4Address{value: net.IPv4(10, 1, 2, 3).To4()}}))
+	second := NewActiveTemplateList()
+	secondmsg := samplingTestMessage(t, second, 2, samplingTestFlow(second, 256,
+		psampTestField{4, &FieldValueUnsigned8{value: 6}},
+		psampTestField{7, &FieldValueUnsigned16{value: 51234}}))
+	for _, ipfixmsg := range []*Message{firstmsg, secondmsg} {
+		if anonymised, err := anonymiser.AnonymiseMessage(ipfixmsg); err != nil || anonymised != 1 {
+			t.Fatalf(errorPrefixMarker+"Expected 1 anonymised record in domain %d, but got %d (%v)", ipfixmsg.ObservationDomainID, anonymised, err)
+		}
+	}
+
+	for odid, expected := range map[uint32][][2]uint16{1: {{12, 2}}, 2: {{7, 3}}, 3: {}} {
+		records, err := anonymiser.NewAnonymizationRecords(odid, NewActiveTemplateList(), 300)
+		if err != nil || len(records) != len(expected) {
+			t.Fatalf(errorPrefixMarker+"Expected %d anonymisation records in domain %d, but got %d (%v)", len(expected), odid, len(records), err)
+		}
+		for idx, field := range expected {
+			if records[idx].FieldValues[0].Value() != uint16(256) || records[idx].FieldValues[1].Value() != field[0] || records[idx].FieldValues[3].Value() != field[1] {
+				t.Errorf(errorPrefixMarker+"Domain %d: expected element %d at field %d, but got %v", odid, field[0], field[1], records[idx])
+			}
+		}
+	}
+	runtime.KeepAlive(firstmsg)
+	runtime.KeepAlive(secondmsg)
+}
+
+func TestAnonymiserErrors(t *testing.T) {
+	anonymiser, _ := NewAnonymiser(nil)
+	if err := anonymiser.SetAnonymisation(0, 8, Anonymisation{Technique: AnonymizationStructuredPermutation}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected structured permutation without a key to fail, but got %v", err)
+	}
+	if err := anonymiser.SetAnonymisation(0, 8, Anonymisation{Technique: AnonymizationNoise}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected noise to fail, but got %v", err)
+	}
+	anonymiser.SetAnonymisation(0, 1, Anonymisation{Technique: AnonymizationTruncation, Bits: 8})
+	anonymiser.SetAnonymisation(0, 12, Anonymisation{Technique: AnonymizationTruncation, Bits: 8})
+	anonymiser.SetAnonymisation(0, 4, Anonymisation{Technique: AnonymizationNone})
+	datrec := samplingTestFlow(NewActiveTemplateList(), 256,
+		psampTestField{12, &FieldValueIPv4Address{value: net.IPv4(10, 1, 2, 3).To4()}},
+		psampTestField{4, &FieldValueUnsigned8{value: 6}})
+	if anonymised, err := anonymiser.Anonymise(1, datrec); anonymised || !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected truncation of a counter to fail, but got %v", err)
+	}
+	for idx, expected := range []string{"0", "10", "0.0.0.0", "6"} {
+		if result := fmt.Sprint(datrec.FieldValues[idx].Value()); result != expected {
+			t.Errorf(errorPrefixMarker+"Field %d: expected %s after the failure, but got %s", idx, expected, result)
+		}
+	}
+	if _, err := datrec.MarshalBinary(); err != nil {
+		t.Errorf(errorPrefixMarker+"Error marshalling zeroed record: %v", err)
+	}
+	if _, err := anonymiser.anonymise(&FieldValueIPv4Address{value: net.IPv4(10, 0, 0, 1).To4()}, Anonymisation{Technique: AnonymizationTruncation, Bits: 33}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected truncation to 33 bits of an IPv4 address to fail, but got %v", err)
+	}
+	if AnonymizationStructuredPermutation.String() != "structuredPermutation" || AnonymizationTechnique(42).String() != "unassigned(42)" {
+		t.Errorf(errorPrefixMarker+"Wrong technique names %s and %s", AnonymizationStructuredPermutation, AnonymizationTechnique(42))
+	}
+}
@@ -0,0 +1,192 @@
+package ipfix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	appendbinaryTestPrint = false
+)
+
+func TestAppendBinaryMarker(t *testing.T) {
+	if appendbinaryTestPrint {
+		fmt.Printf(testMarkerString, "Append Binary")
+	}
+}
+
+// appendBinaryTestMessage returns a decoded message with templates, lists and options
+func appendBinaryTestMessage(t testing.TB) *Message {
+	ipfixmsg, _ := NewMessage()
+	ipfixmsg.AssociatedTemplates = NewActiveTemplateList()
+	if err := ipfixmsg.UnmarshalBinary(sessionTestMessage(1, fuzzTestTemplateSet, fuzzTestOptionsTemplateSet, fuzzTestDataSet, fuzzTestOptionsSet)); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	return ipfixmsg
+}
+
+func TestAppendBinary(t *testing.T) {
+	ipfixmsg := appendBinaryTestMessage(t)
+	prefix := []byte{1, 2, 3}
+
+	marshalled, err := ipfixmsg.MarshalBinary()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error marshalling message: %v", err)
+	}
+	appended, err := ipfixmsg.AppendBinary(append([]byte{}, prefix...))
+	if err != nil || !bytes.Equal(appended[:len(prefix)], prefix) || !bytes.Equal(appended[len(prefix):], marshalled) {
+		t.Errorf(errorPrefixMarker+"Appending should keep the prefix and add the marshalled message.\nMarshalled %v\nappended   %v (%v)", marshalled, appended, err)
+	}
+	if int(ipfixmsg.Len()) != len(marshalled) || int(marshalled[2])<<8|int(marshalled[3]) != len(marshalled) {
+		t.Errorf(errorPrefixMarker+"Expected message length %d, but got %d octets with length %d in the header", ipfixmsg.Len(), len(marshalled), int(marshalled[2])<<8|int(marshalled[3]))
+	}
+
+	for _, set := range ipfixmsg.Sets {
+		marshalled, _ := set.MarshalBinary()
+		if appended, err := set.AppendBinary(append([]byte{}, prefix...)); err != nil || !bytes.Equal(appended[len(prefix):], marshalled) {
+			t.Errorf(errorPrefixMarker+"Set %d: expected %v, but got %v (%v)", set.SetID, marshalled, appended, err)
+		}
+		for _, rec := range set.Records {
+			marshalled, _ := (*rec).MarshalBinary()
+			if appended, err := appendBinary(append([]byte{}, prefix...), *rec); err != nil || !bytes.Equal(appended[len(prefix):], marshalled) {
+				t.Errorf(errorPrefixMarker+"Record in set %d: expected %v, but got %v (%v)", set.SetID, marshalled, appended, err)
+			}
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok {
+				continue
+			}
+			for fieldidx, fieldval := range datrec.FieldValues {
+				marshalled, _ := fieldval.MarshalBinary()
+				if appended, err := appendBinary(append([]byte{}, prefix...), fieldval); err != nil || !bytes.Equal(appended[len(prefix):], marshalled) {
+					t.Errorf(errorPrefixMarker+"Field %d of set %d: expected %v, but got %v (%v)", fieldidx, set.SetID, marshalled, appended, err)
+				}
+			}
+		}
+	}
+
+	decoded, _ := NewMessage()
+	decoded.AssociatedTemplates = NewActiveTemplateList()
+	if err := decoded.UnmarshalBinary(marshalled); err != nil {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling marshalled message: %v", err)
+	}
+}
+
+func TestAppendBinaryVariableLength(t *testing.T) {
+	templates := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(256)
+	fsp, _ := NewFieldSpecifier(0, 82, VariableLength) //interfaceName
+	tmplrec.AddSpecifier(fsp)
+	templates.Set(256, tmplrec)
+
+	for _, length := range []int{0, 10, 254, 255, 300} {
+		datrec, _ := NewDataRecord(256, templates)
+		name, _ := NewFieldValueByID(0, 82)
+		name.Set(strings.Repeat("x", length))
+		datrec.AddFieldValue(name)
+		data, err := datrec.AppendBinary([]byte{9})
+		if err != nil {
+			t.Errorf(errorPrefixMarker+"Error marshalling string of %d octets: %v", length, err)
+			continue
+		}
+		expected, _ := EncodeVariableLength(make([]byte, length), false)
+		if !bytes.Equal(data[1:1+len(expected)], expected) || len(data) != 1+len(expected)+length || data[0] != 9 {
+			t.Errorf(errorPrefixMarker+"String of %d octets: expected length encoding %v, but got %v", length, expected, data[:1+len(expected)])
+		}
+	}
+}
+
+// appendBinaryTestValue is a FieldValue from outside the package, which does not implement AppendBinary
+type appendBinaryTestValue struct {
+	value uint32
+	err   error //Returned by MarshalBinary, if set
+}
+
+func (fv *appendBinaryTestValue) MarshalBinary() ([]byte, error) {
+	if fv.err != nil {
+		return nil, fv.err
+	}
+	return binary.BigEndian.AppendUint32(nil, fv.value), nil
+}
+func (fv *appendBinaryTestValue) UnmarshalBinary(data []byte) error { return nil }
+func (fv *appendBinaryTestValue) Len() uint16                       { return 4 }
+func (fv *appendBinaryTestValue) Value() interface{}                { return fv.value }
+func (fv *appendBinaryTestValue) Set(val interface{}) error         { return nil }
+
+func TestAppendBinaryForeignValues(t *testing.T) {
+	templates := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(256)
+	fsp, _ := NewFieldSpecifier(0, 10, 4) //ingressInterface
+	tmplrec.AddSpecifier(fsp)
+	fsp, _ = NewFieldSpecifier(0, 82, VariableLength) //interfaceName
+	tmplrec.AddSpecifier(fsp)
+	templates.Set(256, tmplrec)
+
+	datrec, _ := NewDataRecord(256, templates)
+	datrec.FieldValues = []FieldValue{&appendBinaryTestValue{value: 7}, &appendBinaryTestValue{value: 8}}
+	if data, err := datrec.AppendBinary([]byte{9}); err != nil || !bytes.Equal(data, []byte{9, 0, 0, 0, 7, 4, 0, 0, 0, 8}) {
+		t.Errorf(errorPrefixMarker+"Expected a value without AppendBinary to be marshalled, but got %v (%v)", data, err)
+	}
+	foreign := errors.New("foreign failure")
+	datrec.FieldValues = []FieldValue{&appendBinaryTestValue{value: 7}, &appendBinaryTestValue{err: foreign}}
+	if _, err := datrec.MarshalBinary(); !errors.Is(err, foreign) {
+		t.Errorf(errorPrefixMarker+"Expected the error of the value, but got %v", err)
+	}
+}
+
+func TestAppendBinaryAllocations(t *testing.T) {
+	templates := decodePlanTestTemplates(t)
+	ipfixmsg, _ := NewMessage()
+	ipfixmsg.AssociatedTemplates = templates
+	set, _ := NewSet(256)
+	set.AssociateTemplates(templates)
+	set.UnmarshalBinary(decodePlanTestDataSet(256, decodePlanTestFixedRecord, 20))
+	ipfixmsg.AddSet(set)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf := GetBuffer()
+		*buf, _ = ipfixmsg.AppendBinary(*buf)
+		PutBuffer(buf)
+	})
+	if allocs != 0 {
+		t.Errorf(errorPrefixMarker+"Appending a message to a pooled buffer should not allocate, but got %.1f allocations", allocs)
+	}
+
+	buf := GetBuffer()
+	*buf, _ = ipfixmsg.AppendBinary(*buf)
+	if marshalled, _ := ipfixmsg.MarshalBinary(); !bytes.Equal(*buf, marshalled) {
+		t.Errorf(errorPrefixMarker+"Expected %v, but got %v", marshalled, *buf)
+	}
+	PutBuffer(buf)
+	if buf := GetBuffer(); len(*buf) != 0 {
+		t.Errorf(errorPrefixMarker+"Buffers from the pool should be empty, but got %d octets", len(*buf))
+	}
+}
+
+func BenchmarkMessageMarshalBinary(b *testing.B) {
+	ipfixmsg := appendBinaryTestMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for cnt := 0; cnt < b.N; cnt++ {
+		if _, err := ipfixmsg.MarshalBinary(); err != nil {
+			b.Fatalf(errorPrefixMarker+"Error marshalling message: %v", err)
+		}
+	}
+}
+
+func BenchmarkMessageAppendBinary(b *testing.B) {
+	ipfixmsg := appendBinaryTestMessage(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for cnt := 0; cnt < b.N; cnt++ {
+		buf := GetBuffer()
+		var err error
+		if *buf, err = ipfixmsg.AppendBinary(*buf); err != nil {
+			b.Fatalf(errorPrefixMarker+"Error marshalling message: %v", err)
+		}
+		PutBuffer(buf)
+	}
+}
@@ -1,6 +1,9 @@
 package ipfix
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 /*
 
@@ -22,7 +25,7 @@ func EncodeVariableLength(content []byte, rfc6313recommended bool) ([]byte, erro
 		retval = []byte{uint8(len(content))}
 	} else {
 		if len(content) > 65535 {
-			return []byte{}, NewError(fmt.Sprintf("Content too large, maximum of 65535 octets, but it is %d", len(content)), ErrCritical)
+			return []byte{}, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Content too large, maximum of 65535 octets, but it is %d", len(content)), ErrCritical)
 		}
 		lengthBytes := []byte{255}
 		lengthContentBytes, err := marshalBinarySingleValue(uint16(len(content)))
@@ -39,8 +42,11 @@ func EncodeVariableLength(content []byte, rfc6313recommended bool) ([]byte, erro
 func DecodeVariableLength(content []byte) (uint16, uint8, error) {
 	cursorshift := uint8(0)
 	retval := uint16(0)
+	if len(content) == 0 || (content[0] == 255 && len(content) < 3) {
+		return 0, 0, NewCategoryError(ErrInsufficientData, "Not enough data to decode variable length.", ErrCritical)
+	}
 	if content[0] == 0 {
-		return 0, 0, NewError("Content can not be 0 in length.", ErrCritical)
+		return 0, 0, NewCategoryError(ErrMalformedLength, "Content can not be 0 in length.", ErrCritical)
 	}
 	if content[0] < 255 {
 		retval = uint16(content[0])
@@ -51,3 +57,31 @@ func DecodeVariableLength(content []byte) (uint16, uint8, error) {
 	}
 	return retval, cursorshift, nil
 }
+
+// unsignedValue returns the value of an unsigned integer Field Value of any size
+func unsignedValue(fieldval FieldValue) (uint64, bool) {
+	switch value := fieldval.Value().(type) {
+	case uint8:
+		return uint64(value), true
+	case uint16:
+		return uint64(value), true
+	case uint32:
+		return uint64(value), true
+	case uint64:
+		return value, true
+	}
+	return 0, false
+}
+
+// setUnsigned sets an unsigned integer Field Value of any size, saturating at the maximum of its type
+func setUnsigned(fieldval FieldValue, value uint64) error {
+	switch fieldval.(type) {
+	case *FieldValueUnsigned8:
+		return fieldval.Set(uint8(min(value, math.MaxUint8)))
+	case *FieldValueUnsigned16:
+		return fieldval.Set(uint16(min(value, math.MaxUint16)))
+	case *FieldValueUnsigned32:
+		return fieldval.Set(uint32(min(value, math.MaxUint32)))
+	}
+	return fieldval.Set(value)
+}
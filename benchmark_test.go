@@ -0,0 +1,262 @@
+package ipfix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+/*
+
+The benchmarks decode and encode messages full of records of typical templates. Besides the usual per-operation numbers
+they report records/s and allocs/record, which can be compared between templates and messages of different sizes.
+Run them with, for example:
+
+	go test -run=^$ -bench=Workload -benchtime=2s
+
+*/
+
+const (
+	benchmarkTestPrint = false
+)
+
+func TestBenchmarkMarker(t *testing.T) {
+	if benchmarkTestPrint {
+		fmt.Printf(testMarkerString, "Benchmark")
+	}
+}
+
+// benchmarkWorkload is a template with a typical record, which is repeated to fill a message
+type benchmarkWorkload struct {
+	name         string
+	templateSets [][]byte
+	setID        uint16
+	record       []byte
+}
+
+var benchmarkWorkloads = []benchmarkWorkload{
+	{
+		name: "IPv4",
+		templateSets: [][]byte{{0, 2, 0, 56,
+			1, 0, 0, 12, //Template 256, 12 fields
+			0, 8, 0, 4, //sourceIPv4Address
+			0, 12, 0, 4, //destinationIPv4Address
+			0, 7, 0, 2, //sourceTransportPort
+			0, 11, 0, 2, //destinationTransportPort
+			0, 4, 0, 1, //protocolIdentifier
+			0, 6, 0, 2, //tcpControlBits
+			0, 1, 0, 8, //octetDeltaCount
+			0, 2, 0, 8, //packetDeltaCount
+			0, 152, 0, 8, //flowStartMilliseconds
+			0, 153, 0, 8, //flowEndMilliseconds
+			0, 10, 0, 4, //ingressInterface
+			0, 14, 0, 4, //egressInterface
+		}},
+		setID: 256,
+		record: []byte{
+			192, 168, 1, 10, 198, 51, 100, 7, //Addresses
+			0xc3, 0x50, 1, 187, //Ports
+			6, 0, 0x18, //TCP, PSH and ACK
+			0, 0, 0, 0, 0, 0, 0x5d, 0xc0, //Octets
+			0, 0, 0, 0, 0, 0, 0, 20, //Packets
+			0, 0, 1, 67, 162, 116, 204, 123, //Start
+			0, 0, 1, 67, 162, 117, 2, 51, //End
+			0, 0, 0, 1, 0, 0, 0, 2, //Interfaces
+		},
+	},
+	{
+		name: "IPv6",
+		templateSets: [][]byte{{0, 2, 0, 48,
+			1, 0, 0, 10, //Template 256, 10 fields
+			0, 27, 0, 16, //sourceIPv6Address
+			0, 28, 0, 16, //destinationIPv6Address
+			0, 7, 0, 2, //sourceTransportPort
+			0, 11, 0, 2, //destinationTransportPort
+			0, 4, 0, 1, //protocolIdentifier
+			0, 31, 0, 4, //flowLabelIPv6
+			0, 1, 0, 8, //octetDeltaCount
+			0, 2, 0, 8, //packetDeltaCount
+			0, 152, 0, 8, //flowStartMilliseconds
+			0, 153, 0, 8, //flowEndMilliseconds
+		}},
+		setID: 256,
+		record: []byte{
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, //Source
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, //Destination
+			0xc3, 0x50, 1, 187, //Ports
+			17,               //UDP
+			0, 1, 0xe2, 0x40, //Flow label
+			0, 0, 0, 0, 0, 0, 0x5d, 0xc0, //Octets
+			0, 0, 0, 0, 0, 0, 0, 20, //Packets
+			0, 0, 1, 67, 162, 116, 204, 123, //Start
+			0, 0, 1, 67, 162, 117, 2, 51, //End
+		},
+	},
+	{
+		name: "Strings",
+		templateSets: [][]byte{{0, 2, 0, 28,
+			1, 0, 0, 5, //Template 256, 5 fields
+			0, 8, 0, 4, //sourceIPv4Address
+			0, 12, 0, 4, //destinationIPv4Address
+			0, 82, 0xff, 0xff, //interfaceName
+			0, 96, 0xff, 0xff, //applicationName
+			0, 1, 0, 8, //octetDeltaCount
+		}},
+		setID: 256,
+		record: []byte{
+			192, 168, 1, 10, 198, 51, 100, 7, //Addresses
+			4, 'e', 't', 'h', '0', //interfaceName
+			5, 'h', 't', 't', 'p', 's', //applicationName
+			0, 0, 0, 0, 0, 0, 0x5d, 0xc0, //Octets
+		},
+	},
+	{
+		name: "Lists",
+		templateSets: [][]byte{{0, 2, 0, 32,
+			1, 0, 0, 3, //Template 256, 3 fields
+			0, 8, 0, 4, //sourceIPv4Address
+			1, 35, 0xff, 0xff, //basicList
+			1, 36, 0xff, 0xff, //subTemplateList
+			1, 1, 0, 2, //Template 257, 2 fields
+			0, 12, 0, 4, //destinationIPv4Address
+			0, 11, 0, 2, //destinationTransportPort
+		}},
+		setID: 256,
+		record: []byte{
+			192, 168, 1, 10, //sourceIPv4Address
+			11, 3, 0, 11, 0, 2, 0, 53, 0, 80, 1, 187, //basicList of destinationTransportPort
+			15, 3, 1, 1, 198, 51, 100, 7, 0, 80, 198, 51, 100, 8, 1, 187, //subTemplateList of two records of template 257
+		},
+	},
+}
+
+// benchmarkTestMessage returns a message holding one data set with as many records of the workload as fit in an Ethernet frame
+func benchmarkTestMessage(workload benchmarkWorkload) ([]byte, int) {
+	count := (1400 - 16 - 4) / len(workload.record)
+	msg := []byte{0, 10, 0, 0, 0x52, 0xdd, 0xa6, 0xec, 0, 0, 0, 1, 0, 0, 0, 1}
+	msg = append(msg, decodePlanTestDataSet(workload.setID, workload.record, count)...)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	return msg, count
+}
+
+// benchmarkTestTemplates returns the active templates of the workload
+func benchmarkTestTemplates(b *testing.B, workload benchmarkWorkload) *ActiveTemplates {
+	templates := NewActiveTemplateList()
+	for _, data := range workload.templateSets {
+		tmplset := &Set{}
+		if err := tmplset.UnmarshalBinary(data); err != nil {
+			b.Fatalf(errorPrefixMarker+"Error unmarshalling %s template set: %v", workload.name, err)
+		}
+		for _, rec := range tmplset.Records {
+			tmplrec := (*rec).(*TemplateRecord)
+			templates.Set(tmplrec.TemplateID, tmplrec)
+		}
+	}
+	return templates
+}
+
+// benchmarkRecords runs the benchmark loop of op, which handles count records, and reports the records/s and allocs/record
+func benchmarkRecords(b *testing.B, count int, op func() error) {
+	if err := op(); err != nil {
+		b.Fatalf(errorPrefixMarker+"Error in benchmark: %v", err)
+	}
+	allocs := testing.AllocsPerRun(10, func() { op() })
+	b.ReportAllocs()
+	b.ResetTimer()
+	for cnt := 0; cnt < b.N; cnt++ {
+		if err := op(); err != nil {
+			b.Fatalf(errorPrefixMarker+"Error in benchmark: %v", err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)*float64(count)/b.Elapsed().Seconds(), "records/s")
+	b.ReportMetric(allocs/float64(count), "allocs/record")
+}
+
+// TestBenchmarkWorkloads checks that the messages of the benchmarks decode and encode to themselves
+func TestBenchmarkWorkloads(t *testing.T) {
+	for _, workload := range benchmarkWorkloads {
+		data, count := benchmarkTestMessage(workload)
+		templates := NewActiveTemplateList()
+		for _, tmpldata := range workload.templateSets {
+			tmplset := &Set{}
+			if err := tmplset.UnmarshalBinary(tmpldata); err != nil {
+				t.Fatalf(errorPrefixMarker+"Error unmarshalling %s template set: %v", workload.name, err)
+			}
+			for _, rec := range tmplset.Records {
+				templates.Set((*rec).(*TemplateRecord).TemplateID, (*rec).(*TemplateRecord))
+			}
+		}
+		ipfixmsg := &Message{AssociatedTemplates: templates}
+		if err := ipfixmsg.UnmarshalBinary(data); err != nil || len(ipfixmsg.Sets) != 1 || len(ipfixmsg.Sets[0].Records) != count {
+			t.Errorf(errorPrefixMarker+"%s: expected %d records, but got %v (%v)", workload.name, count, ipfixmsg.Sets, err)
+			continue
+		}
+		if encoded, err := ipfixmsg.MarshalBinary(); err != nil || !bytes.Equal(encoded, data) {
+			t.Errorf(errorPrefixMarker+"%s: encoding differs from the decoded message (%v)\nDecoded %v\nencoded %v", workload.name, err, data, encoded)
+		}
+	}
+}
+
+func BenchmarkWorkloadDecode(b *testing.B) {
+	for _, workload := range benchmarkWorkloads {
+		b.Run(workload.name, func(b *testing.B) {
+			templates := benchmarkTestTemplates(b, workload)
+			data, count := benchmarkTestMessage(workload)
+			b.SetBytes(int64(len(data)))
+			benchmarkRecords(b, count, func() error {
+				ipfixmsg := &Message{AssociatedTemplates: templates}
+				return ipfixmsg.UnmarshalBinary(data)
+			})
+		})
+	}
+}
+
+func BenchmarkWorkloadEncode(b *testing.B) {
+	for _, workload := range benchmarkWorkloads {
+		b.Run(workload.name, func(b *testing.B) {
+			templates := benchmarkTestTemplates(b, workload)
+			data, count := benchmarkTestMessage(workload)
+			ipfixmsg := &Message{AssociatedTemplates: templates}
+			if err := ipfixmsg.UnmarshalBinary(data); err != nil {
+				b.Fatalf(errorPrefixMarker+"Error unmarshalling %s message: %v", workload.name, err)
+			}
+			b.SetBytes(int64(len(data)))
+			benchmarkRecords(b, count, func() error {
+				buf := GetBuffer()
+				defer PutBuffer(buf)
+				var err error
+				*buf, err = ipfixmsg.AppendBinary(*buf)
+				return err
+			})
+		})
+	}
+}
+
+func BenchmarkWorkloadView(b *testing.B) {
+	for _, workload := range benchmarkWorkloads {
+		b.Run(workload.name, func(b *testing.B) {
+			templates := benchmarkTestTemplates(b, workload)
+			data, count := benchmarkTestMessage(workload)
+			layout, err := templates.Layout(workload.setID)
+			if err != nil {
+				b.Fatalf(errorPrefixMarker+"Error getting %s layout: %v", workload.name, err)
+			}
+			setdata := data[16:]
+			b.SetBytes(int64(len(data)))
+			benchmarkRecords(b, count, func() error {
+				for cursor := 4; len(setdata)-cursor >= layout.MinLength(); {
+					view, err := layout.View(setdata[cursor:])
+					if err != nil {
+						return err
+					}
+					view.Uint64(1) //octetDeltaCount
+					view.Addr(8)   //sourceIPv4Address
+					cursor += view.Len()
+				}
+				return nil
+			})
+		})
+	}
+}
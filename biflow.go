@@ -0,0 +1,199 @@
+package ipfix
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+
+RFC 5103 exports a bidirectional flow in a single Data Record. The values of the reverse direction use the Reverse Information
+Elements: the IANA element with the enterprise bit set and the Reverse PEN 29305. The registry derives them from the IANA elements,
+so a reverse element decodes to the same type as its forward counterpart and is named like "reverseOctetDeltaCount".
+
+SplitBiflow splits such a record into a forward and a reverse BiflowRecord, which only use IANA elements and can be handled like
+uniflows. biflowDirection tells which endpoint initiated the flow.
+
+*/
+
+// ReversePEN is the Private Enterprise Number of the Reverse Information Elements of RFC 5103
+const ReversePEN uint32 = 29305
+
+// nonReversibleElements are the IANA elements that have no reverse counterpart, RFC 5103 section 6.1
+var nonReversibleElements = map[uint16]bool{
+	137: true, 145: true, 148: true, 149: true, //commonPropertiesId, templateId, flowId, observationDomainId
+	130: true, 131: true, 173: true, 211: true, 212: true, 213: true, 214: true, 215: true, 216: true, 217: true, //Process configuration
+	40: true, 41: true, 42: true, 163: true, 164: true, 165: true, 166: true, 167: true, 168: true, //Process statistics
+	210: true, //paddingOctets
+	239: true, //biflowDirection
+}
+
+// biflowSwappedElements maps the IANA elements of an endpoint to those of the other endpoint.
+// The forward values of these elements are swapped in the reverse direction, unless the record has reverse values for them.
+var biflowSwappedElements = map[uint16]uint16{
+	8: 12, 12: 8, //sourceIPv4Address, destinationIPv4Address
+	27: 28, 28: 27, //sourceIPv6Address, destinationIPv6Address
+	7: 11, 11: 7, //sourceTransportPort, destinationTransportPort
+	9: 13, 13: 9, //sourceIPv4PrefixLength, destinationIPv4PrefixLength
+	29: 30, 30: 29, //sourceIPv6PrefixLength, destinationIPv6PrefixLength
+	16: 17, 17: 16, //bgpSourceAsNumber, bgpDestinationAsNumber
+	10: 14, 14: 10, //ingressInterface, egressInterface
+	56: 80, 80: 56, //sourceMacAddress, destinationMacAddress
+	225: 226, 226: 225, //postNATSourceIPv4Address, postNATDestinationIPv4Address
+	227: 228, 228: 227, //postNAPTSourceTransportPort, postNAPTDestinationTransportPort
+}
+
+// IsReversible returns whether the IANA element has a Reverse Information Element
+func IsReversible(elementid uint16) bool {
+	if nonReversibleElements[elementid] {
+		return false
+	}
+	exists, _ := fieldInstanceExists(0, elementid)
+	return exists
+}
+
+// ReverseElementName returns the name of the Reverse Information Element of the forward element name
+func ReverseElementName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "reverse" + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// BiflowDirection is the value of biflowDirection, the method used to assign the Biflow Source and Destination
+type BiflowDirection uint8
+
+// The values of biflowDirection, RFC 5103 section 6.3
+const (
+	BiflowArbitrary        BiflowDirection = iota //Direction was assigned arbitrarily
+	BiflowInitiator                               //The Biflow Source is the flow initiator
+	BiflowReverseInitiator                        //The Biflow Destination is the flow initiator
+	BiflowPerimeter                               //The Biflow Source is the endpoint outside of a defined perimeter
+)
+
+// biflowDirectionElement is the IANA element id of biflowDirection
+const biflowDirectionElement = 239
+
+// String returns the name of the direction assignment method
+func (direction BiflowDirection) String() string {
+	switch direction {
+	case BiflowArbitrary:
+		return "arbitrary"
+	case BiflowInitiator:
+		return "initiator"
+	case BiflowReverseInitiator:
+		return "reverseInitiator"
+	case BiflowPerimeter:
+		return "perimeter"
+	}
+	return fmt.Sprintf("unassigned(%d)", uint8(direction))
+}
+
+// BiflowRecord is one direction of a biflow Data Record. FieldSpecifiers and FieldValues have the same order,
+// the values are shared with the Data Record, not copied.
+type BiflowRecord struct {
+	FieldSpecifiers []*FieldSpecifier
+	FieldValues     []FieldValue
+}
+
+// Field returns the value of the element, or false if the record does not have it
+func (bfrec *BiflowRecord) Field(enterpriseid uint32, elementid uint16) (FieldValue, bool) {
+	for idx, fsp := range bfrec.FieldSpecifiers {
+		if fsp.EnterpriseNumber == enterpriseid && fsp.InformationElementIdentifier == elementid {
+			return bfrec.FieldValues[idx], true
+		}
+	}
+	return nil, false
+}
+
+// add adds the value as the element, the field length of the original field specifier is kept
+func (bfrec *BiflowRecord) add(fsp *FieldSpecifier, elementid uint16, fieldval FieldValue) {
+	bfrec.FieldSpecifiers = append(bfrec.FieldSpecifiers, &FieldSpecifier{InformationElementIdentifier: elementid, FieldLength: fsp.FieldLength})
+	bfrec.FieldValues = append(bfrec.FieldValues, fieldval)
+}
+
+// recordFields returns the (scope) field specifiers of the template of the record, which must match its values
+func (datrec *DataRecord) recordFields() ([]*FieldSpecifier, error) {
+	if datrec.AssociatedTemplates == nil {
+		return nil, NewCategoryError(ErrNoTemplates, "Can not look up the fields of a record without associated templates", ErrCritical).InTemplate(datrec.TemplateID)
+	}
+	tmplrec, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	fsps := tmplrec.allFieldSpecifiers()
+	if len(fsps) != len(datrec.FieldValues) {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Record has %d field values, but its template has %d fields", len(datrec.FieldValues), len(fsps)), ErrCritical).InTemplate(datrec.TemplateID)
+	}
+	return fsps, nil
+}
+
+// IsBiflow returns whether the record holds any Reverse Information Elements
+func (datrec *DataRecord) IsBiflow() bool {
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return false
+	}
+	for _, fsp := range fsps {
+		if fsp.EnterpriseNumber == ReversePEN {
+			return true
+		}
+	}
+	return false
+}
+
+// BiflowDirection returns the biflowDirection of the record, or false if the record does not have one
+func (datrec *DataRecord) BiflowDirection() (BiflowDirection, bool) {
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return BiflowArbitrary, false
+	}
+	for idx, fsp := range fsps {
+		if fsp.EnterpriseNumber == 0 && fsp.InformationElementIdentifier == biflowDirectionElement {
+			if direction, ok := datrec.FieldValues[idx].Value().(uint8); ok {
+				return BiflowDirection(direction), true
+			}
+		}
+	}
+	return BiflowArbitrary, false
+}
+
+// SplitBiflow splits a biflow record in the direction from the Biflow Source to the Biflow Destination and the reverse direction.
+// The forward record has all fields except the Reverse Information Elements. The reverse record has the Reverse Information Elements
+// as their IANA elements, and the other fields of the forward direction that have no reverse value, with the endpoints swapped:
+// the sourceIPv4Address of the forward direction is the destinationIPv4Address of the reverse direction.
+// Non-reversible elements, such as flowId, are in both records. Enterprise-specific elements are only in the forward record.
+// With biflowDirection reverseInitiator the reverse record is the direction of the initiator.
+func (datrec *DataRecord) SplitBiflow() (forward *BiflowRecord, reverse *BiflowRecord, err error) {
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return nil, nil, err
+	}
+	forward, reverse = &BiflowRecord{}, &BiflowRecord{}
+	reversed := make(map[uint16]bool)
+	for idx, fsp := range fsps {
+		if fsp.EnterpriseNumber == ReversePEN {
+			if !IsReversible(fsp.InformationElementIdentifier) {
+				continue //RFC 5103: a Collecting Process MAY discard the reverse counterpart of a non-reversible element
+			}
+			reverse.add(fsp, fsp.InformationElementIdentifier, datrec.FieldValues[idx])
+			reversed[fsp.InformationElementIdentifier] = true
+			continue
+		}
+		forward.FieldSpecifiers = append(forward.FieldSpecifiers, fsp)
+		forward.FieldValues = append(forward.FieldValues, datrec.FieldValues[idx])
+	}
+	for idx, fsp := range forward.FieldSpecifiers {
+		if fsp.EnterpriseNumber != 0 {
+			continue
+		}
+		elementid := fsp.InformationElementIdentifier
+		if swapped, found := biflowSwappedElements[elementid]; found {
+			elementid = swapped
+		}
+		if !reversed[elementid] {
+			reverse.add(fsp, elementid, forward.FieldValues[idx])
+		}
+	}
+	return forward, reverse, nil
+}
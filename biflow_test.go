@@ -0,0 +1,161 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+const (
+	biflowTestPrint = false
+)
+
+func TestBiflowMarker(t *testing.T) {
+	if biflowTestPrint {
+		fmt.Printf(testMarkerString, "Biflow")
+	}
+}
+
+var biflowTestTemplateSet = []byte{0, 2, 0, 52,
+	1, 0, 0, 9, //Template 256, 9 fields
+	0, 8, 0, 4, //sourceIPv4Address
+	0, 12, 0, 4, //destinationIPv4Address
+	0, 7, 0, 2, //sourceTransportPort
+	0, 11, 0, 2, //destinationTransportPort
+	0, 148, 0, 8, //flowId
+	0, 239, 0, 1, //biflowDirection
+	0, 1, 0, 8, //octetDeltaCount
+	0x80, 1, 0, 8, 0, 0, 0x72, 0x79, //reverseOctetDeltaCount
+	0x80, 82, 0xff, 0xff, 0, 0, 0x72, 0x79, //reverseInterfaceName
+}
+
+var biflowTestRecord = []byte{
+	192, 168, 1, 10, 198, 51, 100, 7, //Addresses
+	0xc3, 0x50, 1, 187, //Ports
+	0, 0, 0, 0, 0, 0, 0, 42, //flowId
+	2,                            //reverseInitiator
+	0, 0, 0, 0, 0, 0, 0x5d, 0xc0, //octetDeltaCount
+	0, 0, 0, 0, 0, 0, 0x01, 0xf4, //reverseOctetDeltaCount
+	4, 'e', 't', 'h', '1', //reverseInterfaceName
+}
+
+// biflowTestDataRecord returns the decoded biflowTestRecord
+func biflowTestDataRecord(t *testing.T) *DataRecord {
+	templates := NewActiveTemplateList()
+	tmplset := &Set{}
+	if err := tmplset.UnmarshalBinary(biflowTestTemplateSet); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling template set: %v", err)
+	}
+	tmplrec := (*tmplset.Records[0]).(*TemplateRecord)
+	templates.Set(tmplrec.TemplateID, tmplrec)
+	datrec, _ := NewDataRecord(256, templates)
+	if err := datrec.UnmarshalBinary(biflowTestRecord); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling record: %v", err)
+	}
+	return datrec
+}
+
+func TestReverseElements(t *testing.T) {
+	for _, test := range []struct {
+		elementid  uint16
+		reversible bool
+		name       string
+		length     uint16
+	}{
+		{1, true, "reverseOctetDeltaCount", 8},
+		{8, true, "reverseSourceIPv4Address", 4},
+		{82, true, "reverseInterfaceName", VariableLength},
+		{148, false, "", 0}, //flowId
+		{210, false, "", 0}, //paddingOctets
+		{239, false, "", 0}, //biflowDirection
+		{32000, false, "", 0},
+	} {
+		if IsReversible(test.elementid) != test.reversible {
+			t.Errorf(errorPrefixMarker+"Element %d: expected reversible %v", test.elementid, test.reversible)
+		}
+		name, err := FieldDescriptionByID(ReversePEN, test.elementid)
+		length, _ := FieldLengthByID(ReversePEN, test.elementid)
+		exists, custom := fieldInstanceExists(ReversePEN, test.elementid)
+		if name != test.name || length != test.length || exists != test.reversible || custom {
+			t.Errorf(errorPrefixMarker+"Element %d: expected %q of length %d, but got %q of length %d (%v)", test.elementid, test.name, test.length, name, length, err)
+		}
+		if test.reversible {
+			reverseval, _ := NewFieldValueByID(ReversePEN, test.elementid)
+			forwardval, _ := NewFieldValueByID(0, test.elementid)
+			if fmt.Sprintf("%T", reverseval) != fmt.Sprintf("%T", forwardval) {
+				t.Errorf(errorPrefixMarker+"Element %d: expected a %T, but got %T", test.elementid, forwardval, reverseval)
+			}
+		} else if _, err := NewFieldValueByID(ReversePEN, test.elementid); !errors.Is(err, ErrUnknownElement) {
+			t.Errorf(errorPrefixMarker+"Element %d: expected an unknown element, but got %v", test.elementid, err)
+		}
+	}
+	if err := RegisterCustomField(ReversePEN, 1, 8, "custom", &FieldValueUnsigned64{}); err == nil {
+		t.Errorf(errorPrefixMarker + "Registering a custom reverse element should fail")
+	}
+	if BiflowReverseInitiator.String() != "reverseInitiator" || BiflowDirection(9).String() != "unassigned(9)" {
+		t.Errorf(errorPrefixMarker+"Wrong direction names %s and %s", BiflowReverseInitiator, BiflowDirection(9))
+	}
+}
+
+func TestBiflowSplit(t *testing.T) {
+	datrec := biflowTestDataRecord(t)
+	if value, ok := datrec.FieldValues[7].(*FieldValueUnsigned64); !ok || value.Value() != uint64(500) {
+		t.Errorf(errorPrefixMarker+"Expected reverseOctetDeltaCount 500, but got %#v", datrec.FieldValues[7])
+	}
+	if !datrec.IsBiflow() {
+		t.Errorf(errorPrefixMarker + "Expected a biflow")
+	}
+	if direction, found := datrec.BiflowDirection(); !found || direction != BiflowReverseInitiator {
+		t.Errorf(errorPrefixMarker+"Expected direction reverseInitiator, but got %v (%v)", direction, found)
+	}
+
+	forward, reverse, err := datrec.SplitBiflow()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error splitting biflow: %v", err)
+	}
+	if len(forward.FieldValues) != 7 || len(reverse.FieldValues) != 8 {
+		t.Errorf(errorPrefixMarker+"Expected 7 forward and 8 reverse fields, but got %d and %d", len(forward.FieldValues), len(reverse.FieldValues))
+	}
+	for _, test := range []struct {
+		record   *BiflowRecord
+		name     string
+		element  uint16
+		expected interface{}
+	}{
+		{forward, "forward", 8, net.IP{192, 168, 1, 10}.String()},
+		{forward, "forward", 7, uint16(50000)},
+		{forward, "forward", 1, uint64(24000)},
+		{reverse, "reverse", 8, net.IP{198, 51, 100, 7}.String()},
+		{reverse, "reverse", 12, net.IP{192, 168, 1, 10}.String()},
+		{reverse, "reverse", 7, uint16(443)},
+		{reverse, "reverse", 11, uint16(50000)},
+		{reverse, "reverse", 1, uint64(500)},
+		{reverse, "reverse", 82, "eth1"},
+		{reverse, "reverse", 148, uint64(42)},
+	} {
+		fieldval, found := test.record.Field(0, test.element)
+		if !found {
+			t.Errorf(errorPrefixMarker+"Element %d not in %s record", test.element, test.name)
+			continue
+		}
+		value := fieldval.Value()
+		if ip, ok := value.(net.IP); ok {
+			value = ip.String()
+		}
+		if value != test.expected {
+			t.Errorf(errorPrefixMarker+"Element %d of %s record: expected %v, but got %v", test.element, test.name, test.expected, value)
+		}
+	}
+	if _, found := forward.Field(ReversePEN, 1); found {
+		t.Errorf(errorPrefixMarker + "The forward record should not have reverse elements")
+	}
+	if _, found := forward.Field(0, 82); found {
+		t.Errorf(errorPrefixMarker + "The forward record should not have interfaceName")
+	}
+
+	datrec.AssociatedTemplates = nil
+	if _, _, err := datrec.SplitBiflow(); !errors.Is(err, ErrNoTemplates) {
+		t.Errorf(errorPrefixMarker+"Expected no templates, but got %v", err)
+	}
+}
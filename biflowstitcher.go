@@ -0,0 +1,269 @@
+package ipfix
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+
+A BiflowStitcher pairs unidirectional flow records of opposite directions into RFC 5103 biflow records. Two records are
+opposite when their 5-tuples are mirrored (the source of one is the destination of the other) and they are seen within
+the window of each other. The record seen first is the forward direction, whatever the order the records are added in:
+the Biflow Source is taken to be the initiator, as RFC 5103 section 5.2 recommends. Records seen at the same time keep
+the order they are added in.
+
+	stitcher := NewBiflowStitcher(time.Minute, 256)
+	builder := NewMessageBuilder(odid, stitcher.Templates, MaxUDPMessageSize(1500, false))
+	for _, datrec := range records {
+		biflow, err := stitcher.AddRecord(datrec, flowstart)
+		if err == nil && biflow != nil {
+			builder.AddRecord(biflow)
+		}
+	}
+	uniflows, _ := stitcher.Expire(time.Now())
+	for _, uniflow := range uniflows {
+		builder.AddRecord(uniflow)
+	}
+
+The output records use templates that the stitcher generates in its Templates: the fields of the forward record,
+biflowDirection, and the Reverse Information Elements of the fields of the reverse record that are not part of the 5-tuple.
+Records that are not matched within the window are returned by Expire on a template with only the forward fields.
+A generated template is dropped when a template it was generated for is garbage collected, and its id is given out again.
+A BiflowStitcher is not safe for concurrent use.
+
+*/
+
+// biflowKeyElements are the IANA elements of the 5-tuple, they are not repeated as Reverse Information Elements
+var biflowKeyElements = map[uint16]bool{4: true, 7: true, 8: true, 11: true, 12: true, 27: true, 28: true}
+
+// biflowKey is the 5-tuple of a unidirectional flow, the addresses are the encoded IPv4 or IPv6 addresses
+type biflowKey struct {
+	protocol        uint8
+	source          string
+	destination     string
+	sourcePort      uint16
+	destinationPort uint16
+}
+
+// reversed returns the key of the flow in the opposite direction
+func (key biflowKey) reversed() biflowKey {
+	return biflowKey{protocol: key.protocol, source: key.destination, destination: key.source, sourcePort: key.destinationPort, destinationPort: key.sourcePort}
+}
+
+// stitchEntry is a record waiting for its opposite
+type stitchEntry struct {
+	record   *DataRecord
+	template *TemplateRecord
+	seen     time.Time
+}
+
+// stitchTemplate is a generated template, with the indexes of the fields of the reverse record that it holds
+type stitchTemplate struct {
+	record     *TemplateRecord
+	reverseIdx []int
+}
+
+// BiflowStitcher pairs opposite unidirectional flow records into biflow records
+type BiflowStitcher struct {
+	Window    time.Duration    //Maximum time between the records of a biflow
+	Templates *ActiveTemplates //Templates of the biflow records, generated by the stitcher
+
+	pending   map[biflowKey][]*stitchEntry
+	generated *generatedTemplates[*stitchTemplate] //By the templates of the forward and the reverse record, nil for unmatched records
+}
+
+// NewBiflowStitcher returns a stitcher that pairs records seen within window, the generated templates start at firsttemplateid
+func NewBiflowStitcher(window time.Duration, firsttemplateid uint16) *BiflowStitcher {
+	return &BiflowStitcher{
+		Window:    window,
+		Templates: NewActiveTemplateList(),
+		pending:   make(map[biflowKey][]*stitchEntry),
+		generated: newGeneratedTemplates[*stitchTemplate](firsttemplateid),
+	}
+}
+
+// Pending returns the number of records waiting for their opposite
+func (st *BiflowStitcher) Pending() int {
+	count := 0
+	for _, entries := range st.pending {
+		count += len(entries)
+	}
+	return count
+}
+
+// AddRecord adds a unidirectional flow record seen at the given time, for example its flow start time.
+// It returns the biflow record if the record completes one, or nil if the record waits for its opposite.
+// The record seen first of the two is the forward record of the biflow.
+// The field values of the biflow record are shared with the unidirectional records, not copied.
+func (st *BiflowStitcher) AddRecord(datrec *DataRecord, seen time.Time) (*DataRecord, error) {
+	tmplrec, key, err := biflowRecordKey(datrec)
+	if err != nil {
+		return nil, err
+	}
+	opposite := key.reversed()
+	for idx, entry := range st.pending[opposite] {
+		if diff := seen.Sub(entry.seen); diff > st.Window || diff < -st.Window {
+			continue
+		}
+		var biflow *DataRecord
+		if seen.Before(entry.seen) { //The added record started the flow
+			biflow, err = st.stitch(datrec, tmplrec, entry.record, entry.template)
+		} else {
+			biflow, err = st.stitch(entry.record, entry.template, datrec, tmplrec)
+		}
+		if err != nil {
+			return nil, err
+		}
+		st.remove(opposite, idx)
+		return biflow, nil
+	}
+	st.pending[key] = append(st.pending[key], &stitchEntry{record: datrec, template: tmplrec, seen: seen})
+	return nil, nil
+}
+
+// remove removes the pending entry at idx of the key
+func (st *BiflowStitcher) remove(key biflowKey, idx int) {
+	entries := append(st.pending[key][:idx], st.pending[key][idx+1:]...)
+	if len(entries) == 0 {
+		delete(st.pending, key)
+		return
+	}
+	st.pending[key] = entries
+}
+
+// Expire returns the records that were seen more than the window before now and had no opposite, on templates without reverse fields.
+// Records that can not be converted are dropped, their errors are returned.
+func (st *BiflowStitcher) Expire(now time.Time) ([]*DataRecord, error) {
+	return st.expire(func(entry *stitchEntry) bool { return now.Sub(entry.seen) > st.Window })
+}
+
+// Flush returns all records that have no opposite yet, as Expire does
+func (st *BiflowStitcher) Flush() ([]*DataRecord, error) {
+	return st.expire(func(*stitchEntry) bool { return true })
+}
+
+// expire removes the entries for which expired returns true, and returns them as records
+func (st *BiflowStitcher) expire(expired func(*stitchEntry) bool) ([]*DataRecord, error) {
+	var records []*DataRecord
+	var errs error
+	for key, entries := range st.pending {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if !expired(entry) {
+				kept = append(kept, entry)
+				continue
+			}
+			uniflow, err := st.stitch(entry.record, entry.template, nil, nil)
+			if err != nil {
+				errs = stackError(errs, "Sub errors expiring biflow records.", err, 0)
+				continue
+			}
+			records = append(records, uniflow)
+		}
+		if len(kept) == 0 {
+			delete(st.pending, key)
+		} else {
+			st.pending[key] = kept
+		}
+	}
+	return records, errs
+}
+
+// stitch returns the biflow record of the forward and reverse record, reverse is nil for an unmatched record
+func (st *BiflowStitcher) stitch(forward *DataRecord, forwardtmpl *TemplateRecord, reverse *DataRecord, reversetmpl *TemplateRecord) (*DataRecord, error) {
+	generated, err := st.template(forwardtmpl, reversetmpl)
+	if err != nil {
+		return nil, err
+	}
+	biflow, _ := NewDataRecord(generated.record.TemplateID, st.Templates)
+	biflow.FieldValues = make([]FieldValue, 0, len(generated.record.FieldSpecifiers))
+	biflow.FieldValues = append(biflow.FieldValues, forward.FieldValues...)
+	if reverse != nil {
+		biflow.FieldValues = append(biflow.FieldValues, &FieldValueUnsigned8{value: uint8(BiflowInitiator)})
+		for _, idx := range generated.reverseIdx {
+			biflow.FieldValues = append(biflow.FieldValues, reverse.FieldValues[idx])
+		}
+	}
+	return biflow, nil
+}
+
+// template returns the generated template for records of the forward and reverse template, and generates it if needed
+func (st *BiflowStitcher) template(forwardtmpl *TemplateRecord, reversetmpl *TemplateRecord) (*stitchTemplate, error) {
+	key := newGeneratedKey("", forwardtmpl, reversetmpl)
+	if generated, found := st.generated.get(key); found {
+		return generated, nil
+	}
+	st.generated.drop()
+	id, ok := st.generated.allocate()
+	if !ok {
+		return nil, NewCategoryError(ErrInvalidTemplateID, "No template ids left for biflow templates", ErrCritical)
+	}
+	generated := &stitchTemplate{}
+	generated.record, _ = NewTemplateRecord(id)
+	for _, fsp := range forwardtmpl.allFieldSpecifiers() {
+		generated.record.AddSpecifier(&FieldSpecifier{E: fsp.E, InformationElementIdentifier: fsp.InformationElementIdentifier, FieldLength: fsp.FieldLength, EnterpriseNumber: fsp.EnterpriseNumber})
+	}
+	if reversetmpl != nil {
+		generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: biflowDirectionElement, FieldLength: 1})
+		for idx, fsp := range reversetmpl.allFieldSpecifiers() {
+			if fsp.EnterpriseNumber != 0 || biflowKeyElements[fsp.InformationElementIdentifier] || !IsReversible(fsp.InformationElementIdentifier) {
+				continue
+			}
+			generated.record.AddSpecifier(&FieldSpecifier{E: true, InformationElementIdentifier: fsp.InformationElementIdentifier, FieldLength: fsp.FieldLength, EnterpriseNumber: ReversePEN})
+			generated.reverseIdx = append(generated.reverseIdx, idx)
+		}
+	}
+	if err := st.Templates.Set(id, generated.record); err != nil {
+		st.generated.release(id)
+		return nil, err
+	}
+	st.generated.add(key, []*TemplateRecord{forwardtmpl, reversetmpl}, generated, id)
+	return generated, nil
+}
+
+// biflowRecordKey returns the template and the 5-tuple of a unidirectional flow record
+func biflowRecordKey(datrec *DataRecord) (*TemplateRecord, biflowKey, error) {
+	key := biflowKey{}
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return nil, key, err
+	}
+	tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	found := 0
+	for idx, fsp := range fsps {
+		if fsp.EnterpriseNumber != 0 || !biflowKeyElements[fsp.InformationElementIdentifier] {
+			continue
+		}
+		fieldval := datrec.FieldValues[idx]
+		switch fsp.InformationElementIdentifier {
+		case 4: //protocolIdentifier
+			protocol, ok := fieldval.Value().(uint8)
+			if !ok {
+				return nil, key, NewCategoryError(ErrInvalidValue, fmt.Sprintf("protocolIdentifier is a %T", fieldval), ErrCritical).InTemplate(datrec.TemplateID).AtField(idx)
+			}
+			key.protocol = protocol
+		case 7, 11: //sourceTransportPort, destinationTransportPort
+			port, ok := fieldval.Value().(uint16)
+			if !ok {
+				return nil, key, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Transport port is a %T", fieldval), ErrCritical).InTemplate(datrec.TemplateID).AtField(idx)
+			}
+			if fsp.InformationElementIdentifier == 7 {
+				key.sourcePort = port
+			} else {
+				key.destinationPort = port
+			}
+		case 8, 27: //sourceIPv4Address, sourceIPv6Address
+			address, _ := fieldval.MarshalBinary()
+			key.source = string(address)
+		case 12, 28: //destinationIPv4Address, destinationIPv6Address
+			address, _ := fieldval.MarshalBinary()
+			key.destination = string(address)
+		}
+		found++
+	}
+	if key.source == "" || key.destination == "" {
+		return nil, key, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Record has no source and destination address, only %d fields of the 5-tuple", found), ErrCritical).InTemplate(datrec.TemplateID)
+	}
+	return tmplrec, key, nil
+}
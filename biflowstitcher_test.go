@@ -0,0 +1,190 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const (
+	biflowstitcherTestPrint = false
+)
+
+func TestBiflowStitcherMarker(t *testing.T) {
+	if biflowstitcherTestPrint {
+		fmt.Printf(testMarkerString, "Biflow Stitcher")
+	}
+}
+
+// biflowStitcherTestTemplates returns template 256 of a uniflow: addresses, ports, protocol and octetDeltaCount
+func biflowStitcherTestTemplates() *ActiveTemplates {
+	templates := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(256)
+	for _, element := range [][2]uint16{{8, 4}, {12, 4}, {7, 2}, {11, 2}, {4, 1}, {1, 8}} {
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	templates.Set(256, tmplrec)
+	return templates
+}
+
+// biflowStitcherTestRecord returns a uniflow record of template 256
+func biflowStitcherTestRecord(templates *ActiveTemplates, source, destination string, sourceport, destinationport uint16, octets uint64) *DataRecord {
+	datrec, _ := NewDataRecord(256, templates)
+	for _, value := range []interface{}{source, destination, sourceport, destinationport, uint8(6), octets} {
+		fieldval, _ := NewFieldValueByID(0, map[int]uint16{0: 8, 1: 12, 2: 7, 3: 11, 4: 4, 5: 1}[len(datrec.FieldValues)])
+		fieldval.Set(value)
+		datrec.AddFieldValue(fieldval)
+	}
+	return datrec
+}
+
+func TestBiflowStitcher(t *testing.T) {
+	templates := biflowStitcherTestTemplates()
+	stitcher := NewBiflowStitcher(time.Minute, 1000)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, datrec := range []*DataRecord{
+		biflowStitcherTestRecord(templates, "192.168.1.10", "198.51.100.7", 50000, 443, 2000),
+		biflowStitcherTestRecord(templates, "192.168.1.10", "198.51.100.7", 50001, 443, 3000), //Other conversation
+		biflowStitcherTestRecord(templates, "198.51.100.7", "192.168.1.10", 443, 50002, 4000), //Other conversation
+	} {
+		if biflow, err := stitcher.AddRecord(datrec, start); biflow != nil || err != nil {
+			t.Errorf(errorPrefixMarker+"Expected the record to wait for its opposite, but got %v (%v)", biflow, err)
+		}
+	}
+	reverse := biflowStitcherTestRecord(templates, "198.51.100.7", "192.168.1.10", 443, 50000, 9000)
+	biflow, err := stitcher.AddRecord(reverse, start.Add(10*time.Second))
+	if err != nil || biflow == nil {
+		t.Fatalf(errorPrefixMarker+"Expected a biflow, but got %v (%v)", biflow, err)
+	}
+	if stitcher.Pending() != 2 {
+		t.Errorf(errorPrefixMarker+"Expected 2 pending records, but got %d", stitcher.Pending())
+	}
+
+	builder := NewMessageBuilder(1, stitcher.Templates, 0)
+	if err := builder.AddRecord(biflow); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error adding biflow to message: %v", err)
+	}
+	decoded := messageBuilderTestDecode(t, builder.Flush(), maxMessageSize)
+	datrec := (*decoded[0].Sets[1].Records[0]).(*DataRecord)
+	if datrec.TemplateID != 1000 || len(datrec.FieldValues) != 8 {
+		t.Fatalf(errorPrefixMarker+"Expected 8 fields of template 1000, but got %d of template %d", len(datrec.FieldValues), datrec.TemplateID)
+	}
+	if direction, _ := datrec.BiflowDirection(); direction != BiflowInitiator {
+		t.Errorf(errorPrefixMarker+"Expected direction initiator, but got %v", direction)
+	}
+	forward, backward, err := datrec.SplitBiflow()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error splitting biflow: %v", err)
+	}
+	for _, test := range []struct {
+		record   *BiflowRecord
+		element  uint16
+		expected interface{}
+	}{
+		{forward, 1, uint64(2000)},
+		{forward, 7, uint16(50000)},
+		{backward, 1, uint64(9000)},
+		{backward, 7, uint16(443)},
+		{backward, 8, "198.51.100.7"},
+	} {
+		fieldval, _ := test.record.Field(0, test.element)
+		value := fieldval.Value()
+		if ip, ok := value.(net.IP); ok {
+			value = ip.String()
+		}
+		if value != test.expected {
+			t.Errorf(errorPrefixMarker+"Element %d: expected %v, but got %v", test.element, test.expected, value)
+		}
+	}
+}
+
+func TestBiflowStitcherInitiator(t *testing.T) {
+	templates := biflowStitcherTestTemplates()
+	stitcher := NewBiflowStitcher(time.Minute, 1000)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	//The reply is exported first, but the request was seen first
+	stitcher.AddRecord(biflowStitcherTestRecord(templates, "198.51.100.7", "192.168.1.10", 443, 50000, 9000), start.Add(time.Second))
+	biflow, err := stitcher.AddRecord(biflowStitcherTestRecord(templates, "192.168.1.10", "198.51.100.7", 50000, 443, 2000), start)
+	if err != nil || biflow == nil {
+		t.Fatalf(errorPrefixMarker+"Expected a biflow, but got %v (%v)", biflow, err)
+	}
+	forward, backward, err := biflow.SplitBiflow()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error splitting biflow: %v", err)
+	}
+	forwardoctets, _ := forward.Field(0, 1)
+	backwardoctets, _ := backward.Field(0, 1)
+	if forwardoctets.Value() != uint64(2000) || backwardoctets.Value() != uint64(9000) {
+		t.Errorf(errorPrefixMarker+"Expected the record seen first to be the forward record, but got %v and %v", forwardoctets.Value(), backwardoctets.Value())
+	}
+}
+
+func TestBiflowStitcherDroppedTemplates(t *testing.T) {
+	stitcher := NewBiflowStitcher(time.Minute, 65535) //Only a single template id
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	stitch := func() error {
+		templates := biflowStitcherTestTemplates()
+		stitcher.AddRecord(biflowStitcherTestRecord(templates, "192.168.1.10", "198.51.100.7", 50000, 443, 2000), start)
+		_, err := stitcher.AddRecord(biflowStitcherTestRecord(templates, "198.51.100.7", "192.168.1.10", 443, 50000, 9000), start)
+		stitcher.Flush()
+		return err
+	}
+	if err := stitch(); err != nil { //Sessions that come and go, all with a template 256
+		t.Fatalf(errorPrefixMarker+"Error stitching records: %v", err)
+	}
+	for range 100 {
+		runtime.GC()
+		err := stitch()
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrInvalidTemplateID) {
+			t.Fatalf(errorPrefixMarker+"Expected no template ids to be left, but got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf(errorPrefixMarker + "Expected the template id of the dropped template to be given out again")
+}
+
+func TestBiflowStitcherExpire(t *testing.T) {
+	templates := biflowStitcherTestTemplates()
+	stitcher := NewBiflowStitcher(time.Minute, 0)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	stitcher.AddRecord(biflowStitcherTestRecord(templates, "192.168.1.10", "198.51.100.7", 50000, 443, 2000), start)
+	stitcher.AddRecord(biflowStitcherTestRecord(templates, "192.168.1.11", "198.51.100.7", 50000, 443, 2000), start.Add(time.Minute))
+	if biflow, _ := stitcher.AddRecord(biflowStitcherTestRecord(templates, "198.51.100.7", "192.168.1.10", 443, 50000, 9000), start.Add(2*time.Minute)); biflow != nil {
+		t.Errorf(errorPrefixMarker+"Records outside the window should not be stitched, but got %v", biflow)
+	}
+
+	expired, err := stitcher.Expire(start.Add(100 * time.Second))
+	if err != nil || len(expired) != 1 || stitcher.Pending() != 2 {
+		t.Fatalf(errorPrefixMarker+"Expected 1 expired and 2 pending records, but got %d and %d (%v)", len(expired), stitcher.Pending(), err)
+	}
+	if expired[0].TemplateID != 256 || len(expired[0].FieldValues) != 6 || expired[0].IsBiflow() {
+		t.Errorf(errorPrefixMarker+"Expected a uniflow of 6 fields on template 256, but got %v", expired[0])
+	}
+	flushed, err := stitcher.Flush()
+	if err != nil || len(flushed) != 2 || stitcher.Pending() != 0 || flushed[0].TemplateID != 256 {
+		t.Errorf(errorPrefixMarker+"Expected 2 flushed records on template 256, but got %v (%v)", flushed, err)
+	}
+
+	datrec, _ := NewDataRecord(256, nil)
+	if _, err := stitcher.AddRecord(datrec, start); !errors.Is(err, ErrNoTemplates) {
+		t.Errorf(errorPrefixMarker+"Expected no templates, but got %v", err)
+	}
+	tmplrec, _ := NewTemplateRecord(257)
+	fsp, _ := NewFieldSpecifier(0, 1, 8)
+	templates.Set(257, tmplrec.AddSpecifier(fsp))
+	datrec, _ = NewDataRecord(257, templates)
+	datrec.AddFieldValue(&FieldValueUnsigned64{})
+	if _, err := stitcher.AddRecord(datrec, start); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a record without addresses to fail, but got %v", err)
+	}
+}
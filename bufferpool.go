@@ -0,0 +1,62 @@
+package ipfix
+
+import (
+	"encoding"
+	"sync"
+)
+
+/*
+
+Marshalling with MarshalBinary allocates a new slice for every message. An exporter that sends many messages can instead
+append them to buffers from a pool, so the buffers are reused and marshalling does not allocate:
+
+	buf := GetBuffer()
+	*buf, err = ipfixmsg.AppendBinary(*buf)
+	if err == nil {
+		_, err = conn.Write(*buf)
+	}
+	PutBuffer(buf)
+
+The pool hands out pointers to slices, so putting a buffer back does not allocate either.
+
+*/
+
+const (
+	pooledBufferSize    = 1500  //Room for a message that fits in an Ethernet frame, the buffer grows if it needs more
+	maxPooledBufferSize = 65535 //The maximum length of a message, larger buffers are not kept
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, pooledBufferSize)
+		return &buf
+	},
+}
+
+// GetBuffer returns an empty buffer from the pool to append messages, sets or records to.
+// Return it with PutBuffer when its contents are no longer used.
+func GetBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// PutBuffer returns a buffer to the pool. The buffer, and anything that references it, must not be used afterwards.
+func PutBuffer(buf *[]byte) {
+	if buf == nil || cap(*buf) > maxPooledBufferSize {
+		return
+	}
+	*buf = (*buf)[:0]
+	bufferPool.Put(buf)
+}
+
+// appendBinary appends the binary representation of value to dst. Values that do not implement encoding.BinaryAppender,
+// like FieldValues and Records from outside this package, are marshalled with MarshalBinary and copied.
+func appendBinary(dst []byte, value encoding.BinaryMarshaler) ([]byte, error) {
+	if appender, ok := value.(encoding.BinaryAppender); ok {
+		return appender.AppendBinary(dst)
+	}
+	data, err := value.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
@@ -0,0 +1,559 @@
+package ipfix
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+
+RFC 5473 reduces the redundancy in flow records by exporting the values that many records share only once: in a Common Properties
+record, options data scoped on a commonPropertiesId. The data records then hold the commonPropertiesId instead of those fields.
+
+A CommonPropertiesReducer finds the common properties in a batch of records. Per template, the fields that have few distinct values
+in the batch are the properties, if their values are longer than the commonPropertiesId. Records that share the values of the properties with at least MinRecords records of the batch
+are reduced. The other records are exported as they are.
+
+	reducer := NewCommonPropertiesReducer(10, 256)
+	builder := NewMessageBuilder(odid, reducer.Templates, MaxUDPMessageSize(1500, false))
+	reduced, err := reducer.Reduce(records)
+	for _, datrec := range reduced {
+		builder.AddRecord(datrec)
+	}
+
+Reduce returns the Common Properties records that are new before the records that refer to them. A commonPropertiesId is not reused
+for other values, so over UDP the records of Properties should be sent again along with the templates.
+
+A CommonPropertiesExpander reassembles the complete records on the collector: the commonPropertiesId of a record is replaced by
+the fields of its Common Properties record, which it learns from the options data per Observation Domain.
+
+	expander := NewCommonPropertiesExpander(256)
+	records, err := expander.ExpandMessage(ipfixmsg)
+
+The expander keeps at most MaxProperties Common Properties records, and forgets those that are withdrawn by a record of a
+Common Properties template without other fields.
+
+Both generate templates in their Templates for the records they return. A generated template is dropped when a template it was
+generated for is garbage collected, and its id is given out again; the reducer then forgets the Common Properties records of
+the template as well. The field values of the returned records are shared with the records they were made of, not copied.
+Neither is safe for concurrent use.
+
+*/
+
+// commonPropertiesIDElement is the IANA element id of commonPropertiesId
+const commonPropertiesIDElement = 137
+
+// commonPropertiesIDLength is the field length of the commonPropertiesId in the generated templates. The reduced-size encoding keeps
+// the records small, so the reducer hands out no ids beyond 2^32-1.
+const commonPropertiesIDLength = 4
+
+// reduceTemplate is a generated template of reduced records, or of copies if properties is nil
+type reduceTemplate struct {
+	record      *TemplateRecord
+	properties  *TemplateRecord //The options template of the Common Properties records
+	propertyIdx []int           //Indexes of the fields of the original records that are properties
+	restIdx     []int           //Indexes of the fields of the original records that are kept in the reduced records
+}
+
+// propertiesKey identifies a Common Properties record by its options template and its encoded values
+type propertiesKey struct {
+	templateID uint16
+	values     string
+}
+
+// CommonPropertiesReducer replaces the values that records share by the commonPropertiesId of a Common Properties record
+type CommonPropertiesReducer struct {
+	MinRecords int              //Minimum number of records of a batch that must share the values of the properties
+	Templates  *ActiveTemplates //Templates of the reduced records and of the Common Properties records, generated by the reducer
+
+	nextID     uint64 //Next commonPropertiesId
+	properties map[propertiesKey]*DataRecord
+	generated  *generatedTemplates[*reduceTemplate] //By the template of the original records and the fields that are properties, one octet per field
+}
+
+// NewCommonPropertiesReducer returns a reducer that reduces records that share their properties with at least minrecords records,
+// the generated templates start at firsttemplateid
+func NewCommonPropertiesReducer(minrecords int, firsttemplateid uint16) *CommonPropertiesReducer {
+	if minrecords < 2 {
+		minrecords = 2
+	}
+	return &CommonPropertiesReducer{
+		MinRecords: minrecords,
+		Templates:  NewActiveTemplateList(),
+		nextID:     1,
+		properties: make(map[propertiesKey]*DataRecord),
+		generated:  newGeneratedTemplates[*reduceTemplate](firsttemplateid),
+	}
+}
+
+// Properties returns all Common Properties records, ordered by commonPropertiesId
+func (cpr *CommonPropertiesReducer) Properties() []*DataRecord {
+	records := make([]*DataRecord, 0, len(cpr.properties))
+	for _, datrec := range cpr.properties {
+		records = append(records, datrec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].FieldValues[0].Value().(uint64) < records[j].FieldValues[0].Value().(uint64)
+	})
+	return records
+}
+
+// reduceGroup holds the records of a batch that have the same template
+type reduceGroup struct {
+	template *TemplateRecord
+	indexes  []int      //Indexes of the records in the batch
+	encoded  [][][]byte //Encoded values per record, nil for values that can not be properties
+}
+
+// Reduce returns the records of the batch on the templates of the reducer, in the same order, reduced where they share their properties.
+// The Common Properties records that the batch needs and that were not returned before come first.
+// Records that can not be converted are dropped, their errors are returned.
+func (cpr *CommonPropertiesReducer) Reduce(records []*DataRecord) ([]*DataRecord, error) {
+	var errs error
+	groups := make(map[*TemplateRecord]*reduceGroup)
+	grouporder := []*reduceGroup{}
+	for idx, datrec := range records {
+		if _, err := datrec.recordFields(); err != nil {
+			errs = stackError(errs, "Sub errors reducing records.", err, 0)
+			continue
+		}
+		tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+		group, found := groups[tmplrec]
+		if !found {
+			group = &reduceGroup{template: tmplrec}
+			groups[tmplrec] = group
+			grouporder = append(grouporder, group)
+		}
+		group.indexes = append(group.indexes, idx)
+		group.encoded = append(group.encoded, encodeProperties(tmplrec, datrec))
+	}
+
+	reduced := make([]*DataRecord, len(records))
+	newproperties := []*DataRecord{}
+	for _, group := range grouporder {
+		properties := cpr.findProperties(group)
+		counts := make(map[string]int)
+		keys := make([]string, len(group.indexes))
+		if properties != "" {
+			for idx, encoded := range group.encoded {
+				keys[idx] = propertiesValues(properties, encoded)
+				counts[keys[idx]]++
+			}
+		}
+		for idx, recidx := range group.indexes {
+			var datrec *DataRecord
+			var propertiesrec *DataRecord
+			var err error
+			if properties != "" && counts[keys[idx]] >= cpr.MinRecords {
+				datrec, propertiesrec, err = cpr.reduce(records[recidx], group.template, properties, keys[idx])
+			} else {
+				datrec, _, err = cpr.reduce(records[recidx], group.template, "", "")
+			}
+			if err != nil {
+				errs = stackError(errs, "Sub errors reducing records.", err, 0)
+				continue
+			}
+			if propertiesrec != nil {
+				newproperties = append(newproperties, propertiesrec)
+			}
+			reduced[recidx] = datrec
+		}
+	}
+
+	result := make([]*DataRecord, 0, len(newproperties)+len(records))
+	result = append(result, newproperties...)
+	for _, datrec := range reduced {
+		if datrec != nil {
+			result = append(result, datrec)
+		}
+	}
+	return result, errs
+}
+
+// encodeProperties returns the encoded values of a record, nil for values that can not be properties
+func encodeProperties(tmplrec *TemplateRecord, datrec *DataRecord) [][]byte {
+	encoded := make([][]byte, len(datrec.FieldValues))
+	if len(tmplrec.ScopeFieldSpecifiers) > 0 {
+		return encoded //Options data is not reduced
+	}
+	for idx, fsp := range tmplrec.FieldSpecifiers {
+		if fsp.EnterpriseNumber == 0 && fsp.InformationElementIdentifier == commonPropertiesIDElement {
+			continue
+		}
+		switch datrec.FieldValues[idx].(type) {
+		case *FieldValueBasicList, *FieldValueSubTemplateList, *FieldValueSubTemplateMultiList:
+			continue //Lists would need their templates to be compared
+		}
+		if value, err := datrec.FieldValues[idx].MarshalBinary(); err == nil {
+			encoded[idx] = value
+		}
+	}
+	return encoded
+}
+
+// findProperties returns the fields of the group that are properties, one octet per field that is 1 for a property, or "" if there are none.
+// A field is a property if on average at least MinRecords records have the same value. Properties that are not longer than the
+// commonPropertiesId would not make the records smaller, so then there are none.
+func (cpr *CommonPropertiesReducer) findProperties(group *reduceGroup) string {
+	if len(group.indexes) < cpr.MinRecords {
+		return ""
+	}
+	fieldcount := len(group.encoded[0])
+	properties := make([]byte, fieldcount)
+	length := 0
+	for field := 0; field < fieldcount; field++ {
+		distinct := make(map[string]bool)
+		for _, encoded := range group.encoded {
+			if encoded[field] == nil {
+				distinct = nil
+				break
+			}
+			distinct[string(encoded[field])] = true
+		}
+		if distinct != nil && len(distinct)*cpr.MinRecords <= len(group.indexes) {
+			properties[field] = 1
+			length += len(group.encoded[0][field])
+		}
+	}
+	if length <= commonPropertiesIDLength {
+		return ""
+	}
+	return string(properties)
+}
+
+// propertiesValues returns the encoded values of the properties, each prefixed by its length
+func propertiesValues(properties string, encoded [][]byte) string {
+	values := []byte{}
+	for idx, value := range encoded {
+		if properties[idx] == 1 {
+			values = binary.BigEndian.AppendUint16(values, uint16(len(value)))
+			values = append(values, value...)
+		}
+	}
+	return string(values)
+}
+
+// reduce returns the record on a template of the reducer. With properties it is reduced, and the Common Properties record
+// of its values is returned as well if it is new.
+func (cpr *CommonPropertiesReducer) reduce(datrec *DataRecord, tmplrec *TemplateRecord, properties string, values string) (*DataRecord, *DataRecord, error) {
+	generated, err := cpr.template(tmplrec, properties)
+	if err != nil {
+		return nil, nil, err
+	}
+	reduced, _ := NewDataRecord(generated.record.TemplateID, cpr.Templates)
+	if generated.properties == nil {
+		reduced.FieldValues = append(reduced.FieldValues, datrec.FieldValues...)
+		return reduced, nil, nil
+	}
+
+	key := propertiesKey{templateID: generated.properties.TemplateID, values: values}
+	propertiesrec, found := cpr.properties[key]
+	isnew := !found
+	if !found {
+		if cpr.nextID > math.MaxUint32 {
+			return nil, nil, NewCategoryError(ErrInvalidValue, "No commonPropertiesIds left", ErrCritical)
+		}
+		propertiesrec, _ = NewDataRecord(generated.properties.TemplateID, cpr.Templates)
+		propertiesrec.FieldValues = append(propertiesrec.FieldValues, &FieldValueUnsigned64{value: cpr.nextID})
+		for _, idx := range generated.propertyIdx {
+			propertiesrec.FieldValues = append(propertiesrec.FieldValues, datrec.FieldValues[idx])
+		}
+		cpr.properties[key] = propertiesrec
+		cpr.nextID++
+	}
+	reduced.FieldValues = make([]FieldValue, 0, len(generated.restIdx)+1)
+	reduced.FieldValues = append(reduced.FieldValues, propertiesrec.FieldValues[0])
+	for _, idx := range generated.restIdx {
+		reduced.FieldValues = append(reduced.FieldValues, datrec.FieldValues[idx])
+	}
+	if isnew {
+		return reduced, propertiesrec, nil
+	}
+	return reduced, nil, nil
+}
+
+// template returns the generated template for records of tmplrec with the properties, and generates it if needed
+func (cpr *CommonPropertiesReducer) template(tmplrec *TemplateRecord, properties string) (*reduceTemplate, error) {
+	key := newGeneratedKey(properties, tmplrec)
+	if generated, found := cpr.generated.get(key); found {
+		return generated, nil
+	}
+	cpr.dropTemplates()
+	generated := &reduceTemplate{}
+	if err := cpr.generate(generated, tmplrec, properties); err != nil {
+		for _, record := range []*TemplateRecord{generated.record, generated.properties} {
+			if record != nil {
+				cpr.generated.release(record.TemplateID)
+			}
+		}
+		return nil, err
+	}
+	ids := []uint16{generated.record.TemplateID}
+	if generated.properties != nil {
+		ids = append(ids, generated.properties.TemplateID)
+	}
+	cpr.generated.add(key, []*TemplateRecord{tmplrec}, generated, ids...)
+	return generated, nil
+}
+
+// generate generates the templates for records of tmplrec with the properties
+func (cpr *CommonPropertiesReducer) generate(generated *reduceTemplate, tmplrec *TemplateRecord, properties string) error {
+	var err error
+	if properties == "" {
+		if generated.record, err = cpr.newTemplate(len(tmplrec.ScopeFieldSpecifiers) > 0); err != nil {
+			return err
+		}
+		for _, fsp := range tmplrec.ScopeFieldSpecifiers {
+			generated.record.AddScopeSpecifier(copyFieldSpecifier(fsp))
+		}
+		for _, fsp := range tmplrec.FieldSpecifiers {
+			generated.record.AddSpecifier(copyFieldSpecifier(fsp))
+		}
+	} else {
+		if generated.properties, err = cpr.newTemplate(true); err != nil {
+			return err
+		}
+		if generated.record, err = cpr.newTemplate(false); err != nil {
+			return err
+		}
+		generated.properties.AddScopeSpecifier(&FieldSpecifier{InformationElementIdentifier: commonPropertiesIDElement, FieldLength: commonPropertiesIDLength})
+		generated.record.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: commonPropertiesIDElement, FieldLength: commonPropertiesIDLength})
+		for idx, fsp := range tmplrec.FieldSpecifiers {
+			if properties[idx] == 1 {
+				generated.properties.AddSpecifier(copyFieldSpecifier(fsp))
+				generated.propertyIdx = append(generated.propertyIdx, idx)
+			} else {
+				generated.record.AddSpecifier(copyFieldSpecifier(fsp))
+				generated.restIdx = append(generated.restIdx, idx)
+			}
+		}
+		if err := cpr.Templates.Set(generated.properties.TemplateID, generated.properties); err != nil {
+			return err
+		}
+	}
+	return cpr.Templates.Set(generated.record.TemplateID, generated.record)
+}
+
+// dropTemplates drops the generated templates of garbage collected templates, with their Common Properties records
+func (cpr *CommonPropertiesReducer) dropTemplates() {
+	dropped := make(map[uint16]bool)
+	for _, generated := range cpr.generated.drop() {
+		if generated.properties != nil {
+			dropped[generated.properties.TemplateID] = true
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	for key := range cpr.properties {
+		if dropped[key.templateID] {
+			delete(cpr.properties, key)
+		}
+	}
+}
+
+// newTemplate returns an empty (options) template with a template id of the reducer that is not in use
+func (cpr *CommonPropertiesReducer) newTemplate(options bool) (*TemplateRecord, error) {
+	id, ok := cpr.generated.allocate()
+	if !ok {
+		return nil, NewCategoryError(ErrInvalidTemplateID, "No template ids left for common properties templates", ErrCritical)
+	}
+	if options {
+		return NewOptionsTemplateRecord(id)
+	}
+	return NewTemplateRecord(id)
+}
+
+// copyFieldSpecifier returns a copy of the field specifier
+func copyFieldSpecifier(fsp *FieldSpecifier) *FieldSpecifier {
+	return &FieldSpecifier{E: fsp.E, InformationElementIdentifier: fsp.InformationElementIdentifier, FieldLength: fsp.FieldLength, EnterpriseNumber: fsp.EnterpriseNumber}
+}
+
+// expandKey identifies a Common Properties record by its Observation Domain and commonPropertiesId
+type expandKey struct {
+	observationDomainID uint32
+	id                  uint64
+}
+
+// defaultMaxCommonProperties is the MaxProperties of a new CommonPropertiesExpander
+const defaultMaxCommonProperties = 65536
+
+// expandProperties is a Common Properties record that the expander learned
+type expandProperties struct {
+	key    expandKey
+	record *DataRecord
+}
+
+// CommonPropertiesExpander replaces the commonPropertiesId of records by the fields of their Common Properties record
+type CommonPropertiesExpander struct {
+	Templates     *ActiveTemplates //Templates of the expanded records, generated by the expander
+	MaxProperties int              //Maximum number of Common Properties records that are kept, the least recently learned are forgotten first. 0 means no limit.
+
+	properties map[expandKey]*list.Element          //Elements of learned
+	learned    *list.List                           //The *expandProperties, least recently learned first
+	generated  *generatedTemplates[*TemplateRecord] //By the templates of the reduced record and the Common Properties record
+}
+
+// NewCommonPropertiesExpander returns an expander that does not know any Common Properties yet, the generated templates start at firsttemplateid
+func NewCommonPropertiesExpander(firsttemplateid uint16) *CommonPropertiesExpander {
+	return &CommonPropertiesExpander{
+		Templates:     NewActiveTemplateList(),
+		MaxProperties: defaultMaxCommonProperties,
+		properties:    make(map[expandKey]*list.Element),
+		learned:       list.New(),
+		generated:     newGeneratedTemplates[*TemplateRecord](firsttemplateid),
+	}
+}
+
+// isCommonPropertiesTemplate returns whether the template is of Common Properties records, options data with commonPropertiesId as the only scope
+func isCommonPropertiesTemplate(tmplrec *TemplateRecord) bool {
+	return len(tmplrec.ScopeFieldSpecifiers) == 1 && tmplrec.ScopeFieldSpecifiers[0].EnterpriseNumber == 0 &&
+		tmplrec.ScopeFieldSpecifiers[0].InformationElementIdentifier == commonPropertiesIDElement
+}
+
+// AddRecord learns a Common Properties record of the Observation Domain, or forgets the Common Properties if the record withdraws them:
+// a record of a Common Properties template without other fields. Other records are ignored.
+func (cpe *CommonPropertiesExpander) AddRecord(observationdomainid uint32, datrec *DataRecord) error {
+	if _, err := datrec.recordFields(); err != nil {
+		return err
+	}
+	tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	if !isCommonPropertiesTemplate(tmplrec) {
+		return nil
+	}
+	id, ok := unsignedValue(datrec.FieldValues[0])
+	if !ok {
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("commonPropertiesId is a %T", datrec.FieldValues[0]), ErrCritical).InTemplate(datrec.TemplateID).AtField(0)
+	}
+	key := expandKey{observationDomainID: observationdomainid, id: id}
+	if element, found := cpe.properties[key]; found {
+		cpe.learned.Remove(element)
+		delete(cpe.properties, key)
+	}
+	if len(tmplrec.FieldSpecifiers) == 0 {
+		return nil //Withdrawn
+	}
+	cpe.properties[key] = cpe.learned.PushBack(&expandProperties{key: key, record: datrec})
+	for cpe.MaxProperties > 0 && cpe.learned.Len() > cpe.MaxProperties {
+		oldest := cpe.learned.Remove(cpe.learned.Front()).(*expandProperties)
+		delete(cpe.properties, oldest.key)
+	}
+	return nil
+}
+
+// Expand returns the record of the Observation Domain with its commonPropertiesId replaced by the fields of its Common Properties record.
+// A record without commonPropertiesId is returned as it is, as is a record of which the Common Properties are not known, with an error.
+func (cpe *CommonPropertiesExpander) Expand(observationdomainid uint32, datrec *DataRecord) (*DataRecord, error) {
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return datrec, err
+	}
+	tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	ididx := -1
+	for idx, fsp := range fsps {
+		if idx >= len(tmplrec.ScopeFieldSpecifiers) && fsp.EnterpriseNumber == 0 && fsp.InformationElementIdentifier == commonPropertiesIDElement {
+			ididx = idx
+			break
+		}
+	}
+	if ididx < 0 {
+		return datrec, nil
+	}
+	id, _ := unsignedValue(datrec.FieldValues[ididx])
+	element, found := cpe.properties[expandKey{observationDomainID: observationdomainid, id: id}]
+	if !found {
+		return datrec, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Unknown commonPropertiesId %d", id), ErrFailure).InTemplate(datrec.TemplateID).AtField(ididx)
+	}
+	propertiesrec := element.Value.(*expandProperties).record
+	propertiestmpl, err := propertiesrec.AssociatedTemplates.Get(propertiesrec.TemplateID)
+	if err != nil {
+		return datrec, err
+	}
+	generated, err := cpe.template(tmplrec, propertiestmpl, ididx)
+	if err != nil {
+		return datrec, err
+	}
+	expanded, _ := NewDataRecord(generated.TemplateID, cpe.Templates)
+	expanded.FieldValues = make([]FieldValue, 0, len(datrec.FieldValues)+len(propertiesrec.FieldValues)-2)
+	expanded.FieldValues = append(expanded.FieldValues, datrec.FieldValues[:ididx]...)
+	expanded.FieldValues = append(expanded.FieldValues, propertiesrec.FieldValues[1:]...)
+	expanded.FieldValues = append(expanded.FieldValues, datrec.FieldValues[ididx+1:]...)
+	return expanded, nil
+}
+
+// template returns the generated template for records of tmplrec expanded with records of propertiestmpl, and generates it if needed.
+// The fields of propertiestmpl take the place of the commonPropertiesId at ididx.
+func (cpe *CommonPropertiesExpander) template(tmplrec *TemplateRecord, propertiestmpl *TemplateRecord, ididx int) (*TemplateRecord, error) {
+	key := newGeneratedKey("", tmplrec, propertiestmpl)
+	if generated, found := cpe.generated.get(key); found {
+		return generated, nil
+	}
+	cpe.generated.drop()
+	id, ok := cpe.generated.allocate()
+	if !ok {
+		return nil, NewCategoryError(ErrInvalidTemplateID, "No template ids left for expanded templates", ErrCritical)
+	}
+	var generated *TemplateRecord
+	if len(tmplrec.ScopeFieldSpecifiers) > 0 {
+		generated, _ = NewOptionsTemplateRecord(id)
+		for _, fsp := range tmplrec.ScopeFieldSpecifiers {
+			generated.AddScopeSpecifier(copyFieldSpecifier(fsp))
+		}
+	} else {
+		generated, _ = NewTemplateRecord(id)
+	}
+	scopecount := len(tmplrec.ScopeFieldSpecifiers)
+	for idx, fsp := range tmplrec.FieldSpecifiers {
+		if idx+scopecount != ididx {
+			generated.AddSpecifier(copyFieldSpecifier(fsp))
+			continue
+		}
+		for _, propertyfsp := range propertiestmpl.FieldSpecifiers {
+			generated.AddSpecifier(copyFieldSpecifier(propertyfsp))
+		}
+	}
+	if err := cpe.Templates.Set(id, generated); err != nil {
+		cpe.generated.release(id)
+		return nil, err
+	}
+	cpe.generated.add(key, []*TemplateRecord{tmplrec, propertiestmpl}, generated, id)
+	return generated, nil
+}
+
+// ExpandMessage learns the Common Properties records of the message and returns its other data records, expanded.
+// Records that can not be expanded are returned as they are, their errors are returned.
+func (cpe *CommonPropertiesExpander) ExpandMessage(ipfixmsg *Message) ([]*DataRecord, error) {
+	var errs error
+	records := []*DataRecord{}
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			if datrec, ok := (*rec).(*DataRecord); ok {
+				records = append(records, datrec)
+			}
+		}
+	}
+	expanded := make([]*DataRecord, 0, len(records))
+	for _, datrec := range records { //The Common Properties first, in case they follow the records that refer to them
+		tmplrec, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+		if err != nil || !isCommonPropertiesTemplate(tmplrec) {
+			expanded = append(expanded, datrec)
+			continue
+		}
+		if err := cpe.AddRecord(ipfixmsg.ObservationDomainID, datrec); err != nil {
+			errs = stackError(errs, "Sub errors expanding records.", err, 0)
+		}
+	}
+	for idx, datrec := range expanded {
+		var err error
+		if expanded[idx], err = cpe.Expand(ipfixmsg.ObservationDomainID, datrec); err != nil {
+			errs = stackError(errs, "Sub errors expanding records.", err, 0)
+		}
+	}
+	return expanded, errs
+}
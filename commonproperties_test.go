@@ -0,0 +1,209 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const (
+	commonPropertiesTestPrint = false
+)
+
+func TestCommonPropertiesMarker(t *testing.T) {
+	if commonPropertiesTestPrint {
+		fmt.Printf(testMarkerString, "Common Properties")
+	}
+}
+
+// commonPropertiesTestRecords returns 20 flows of 2 ingress interfaces to the same egress interface and next hop, a flow of another
+// ingress interface and an options record
+func commonPropertiesTestRecords() []*DataRecord {
+	templates := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(256)
+	for _, element := range [][2]uint16{{10, 4}, {14, 4}, {15, 4}, {12, 4}, {1, 8}} { //ingressInterface, egressInterface, ipNextHopIPv4Address, destinationIPv4Address, octetDeltaCount
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	templates.Set(256, tmplrec)
+	records := []*DataRecord{}
+	for idx := 0; idx < 21; idx++ {
+		datrec, _ := NewDataRecord(256, templates)
+		ingress := uint32(idx%2 + 1)
+		if idx == 20 {
+			ingress = 3
+		}
+		datrec.FieldValues = []FieldValue{
+			&FieldValueUnsigned32{value: ingress},
+			&FieldValueUnsigned32{value: 5},
+			&FieldValueIPv4Address{value: net.IPv4(192, 168, 0, 1).To4()},
+			&FieldValueIPv4Address{value: net.IPv4(10, 0, 0, byte(idx)).To4()},
+			&FieldValueUnsigned64{value: uint64(1000 + idx)},
+		}
+		records = append(records, datrec)
+	}
+	return append(records, psampTestRecord(templates, 257, psampTestField{149, &FieldValueUnsigned32{value: 1}}, psampTestField{34, &FieldValueUnsigned32{value: 100}}))
+}
+
+func TestCommonPropertiesReducer(t *testing.T) {
+	records := commonPropertiesTestRecords()
+	reducer := NewCommonPropertiesReducer(5, 300)
+	reduced, err := reducer.Reduce(records)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error reducing records: %v", err)
+	}
+	if len(reduced) != 2+len(records) {
+		t.Fatalf(errorPrefixMarker+"Expected 2 Common Properties records and %d records, but got %d", len(records), len(reduced))
+	}
+	for idx, datrec := range reduced[:2] {
+		if datrec.FieldValues[0].Value() != uint64(idx+1) || datrec.FieldValues[1].Value() != uint32(idx+1) || datrec.FieldValues[2].Value() != uint32(5) || len(datrec.FieldValues) != 4 {
+			t.Errorf(errorPrefixMarker+"Wrong Common Properties record %v", datrec)
+		}
+	}
+	if flow := reduced[2]; len(flow.FieldValues) != 3 || flow.FieldValues[0].Value() != uint64(1) {
+		t.Errorf(errorPrefixMarker+"Expected a reduced record with commonPropertiesId 1, but got %v", flow)
+	}
+	if rare := reduced[22]; len(rare.FieldValues) != 5 || rare.FieldValues[0].Value() != uint32(3) {
+		t.Errorf(errorPrefixMarker+"A record of rare properties should not be reduced, but got %v", rare)
+	}
+	if again, _ := reducer.Reduce(records); len(again) != len(records) || len(reducer.Properties()) != 2 {
+		t.Errorf(errorPrefixMarker+"Common Properties should only be returned once, but got %d records", len(again))
+	}
+
+	size := func(messages []*Message) int {
+		total := 0
+		for _, ipfixmsg := range messages {
+			total += int(ipfixmsg.Len())
+		}
+		return total
+	}
+	builder := NewMessageBuilder(1, reducer.Templates, 0)
+	for idx, datrec := range reduced {
+		if err := builder.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding reduced record %d: %v", idx, err)
+		}
+	}
+	messages := builder.Flush()
+	unreduced := NewMessageBuilder(1, records[0].AssociatedTemplates, 0)
+	for _, datrec := range records {
+		unreduced.AddRecord(datrec)
+	}
+	if reducedsize, originalsize := size(messages), size(unreduced.Flush()); reducedsize >= originalsize {
+		t.Errorf(errorPrefixMarker+"Expected the reduced records to be smaller, but they take %d octets instead of %d", reducedsize, originalsize)
+	}
+
+	expander := NewCommonPropertiesExpander(400)
+	expanded := []*DataRecord{}
+	for _, ipfixmsg := range messageBuilderTestDecode(t, messages, maxMessageSize) {
+		msgrecords, err := expander.ExpandMessage(ipfixmsg)
+		if err != nil {
+			t.Fatalf(errorPrefixMarker+"Error expanding records: %v", err)
+		}
+		expanded = append(expanded, msgrecords...)
+	}
+	if len(expanded) != len(records) {
+		t.Fatalf(errorPrefixMarker+"Expected %d expanded records, but got %d", len(records), len(expanded))
+	}
+	for idx, datrec := range expanded {
+		original, _ := records[idx].MarshalBinary()
+		encoded, _ := datrec.MarshalBinary()
+		if string(original) != string(encoded) {
+			t.Errorf(errorPrefixMarker+"Record %d: expected %v, but got %v", idx, records[idx], datrec)
+		}
+	}
+}
+
+func TestCommonPropertiesExpanderErrors(t *testing.T) {
+	templates := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(256)
+	tmplrec.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: commonPropertiesIDElement, FieldLength: 8})
+	templates.Set(256, tmplrec)
+	datrec, _ := NewDataRecord(256, templates)
+	datrec.FieldValues = []FieldValue{&FieldValueUnsigned64{value: 99}}
+
+	expander := NewCommonPropertiesExpander(0)
+	if expanded, err := expander.Expand(1, datrec); expanded != datrec || !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected an unknown commonPropertiesId to fail, but got %v", err)
+	}
+	plain := commonPropertiesTestRecords()[0]
+	if expanded, err := expander.Expand(1, plain); expanded != plain || err != nil {
+		t.Errorf(errorPrefixMarker+"Records without commonPropertiesId should be returned as they are (%v)", err)
+	}
+}
+
+// commonPropertiesTestReduced returns a Common Properties record of the ingressInterface and a record that refers to it,
+// on templates 256 and 257. Without an ingressInterface the Common Properties record withdraws the id.
+func commonPropertiesTestReduced(templates *ActiveTemplates, id uint64, ingress FieldValue) (*DataRecord, *DataRecord) {
+	fields := []psampTestField{{commonPropertiesIDElement, &FieldValueUnsigned64{value: id}}}
+	if ingress != nil {
+		fields = append(fields, psampTestField{10, ingress})
+	}
+	properties := psampTestRecord(templates, 256, fields...)
+	tmplrec, _ := NewTemplateRecord(257)
+	tmplrec.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: commonPropertiesIDElement, FieldLength: 8})
+	tmplrec.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: 1, FieldLength: 8})
+	templates.Set(257, tmplrec)
+	datrec, _ := NewDataRecord(257, templates)
+	datrec.FieldValues = []FieldValue{&FieldValueUnsigned64{value: id}, &FieldValueUnsigned64{value: 1000}}
+	return properties, datrec
+}
+
+func TestCommonPropertiesExpanderForget(t *testing.T) {
+	templates := NewActiveTemplateList()
+	expander := NewCommonPropertiesExpander(0)
+	expander.MaxProperties = 2
+	records := []*DataRecord{}
+	for id := range uint64(3) {
+		properties, datrec := commonPropertiesTestReduced(templates, id, &FieldValueUnsigned32{value: uint32(id)})
+		if err := expander.AddRecord(1, properties); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error learning Common Properties %d: %v", id, err)
+		}
+		records = append(records, datrec)
+	}
+	if _, err := expander.Expand(1, records[0]); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected the least recently learned Common Properties to be forgotten, but got %v", err)
+	}
+	if expanded, err := expander.Expand(1, records[2]); err != nil || expanded.FieldValues[0].Value() != uint32(2) {
+		t.Errorf(errorPrefixMarker+"Expected the record to be expanded, but got %v (%v)", expanded, err)
+	}
+
+	withdrawal, _ := commonPropertiesTestReduced(NewActiveTemplateList(), 2, nil)
+	if err := expander.AddRecord(1, withdrawal); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error withdrawing Common Properties: %v", err)
+	}
+	if _, err := expander.Expand(1, records[2]); !errors.Is(err, ErrInvalidValue) || len(expander.properties) != 1 {
+		t.Errorf(errorPrefixMarker+"Expected withdrawn Common Properties to be forgotten, but got %v", err)
+	}
+}
+
+func TestCommonPropertiesDroppedTemplates(t *testing.T) {
+	reducer := NewCommonPropertiesReducer(2, 65535) //Only a single template id
+	expander := NewCommonPropertiesExpander(65535)
+	convert := func() error {
+		if _, err := reducer.Reduce(commonPropertiesTestRecords()[20:21]); err != nil {
+			return err
+		}
+		properties, datrec := commonPropertiesTestReduced(NewActiveTemplateList(), 1, &FieldValueUnsigned32{value: 1})
+		expander.AddRecord(1, properties)
+		_, err := expander.Expand(1, datrec)
+		return err
+	}
+	if err := convert(); err != nil { //Sessions that come and go, all with templates 256 and 257
+		t.Fatalf(errorPrefixMarker+"Error converting records: %v", err)
+	}
+	for range 100 {
+		runtime.GC()
+		err := convert()
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrInvalidTemplateID) {
+			t.Fatalf(errorPrefixMarker+"Expected no template ids to be left, but got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf(errorPrefixMarker + "Expected the template ids of the dropped templates to be given out again")
+}
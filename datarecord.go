@@ -23,7 +23,7 @@ type DataRecord struct {
 // NewDataRecord returns a pointer to a newly created datarecord
 func NewDataRecord(templateid uint16, associatedtemplates *ActiveTemplates) (*DataRecord, error) {
 	if associatedtemplates != nil && templateid < 256 {
-		return nil, NewError(fmt.Sprintf("Template id %d not valid. Must be > 255", templateid), ErrCritical)
+		return nil, NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Template id %d not valid. Must be > 255", templateid), ErrCritical)
 	}
 	return &DataRecord{
 		TemplateID:          templateid,
@@ -43,11 +43,11 @@ func (datrec *DataRecord) AddFieldValue(fieldvalue FieldValue) error {
 		return err
 	}
 	if len(datrec.FieldValues) >= (len(associatedTemplate.FieldSpecifiers) + len(associatedTemplate.ScopeFieldSpecifiers)) {
-		return NewError(fmt.Sprintf("Too many field values in record. Should only have %d", (len(associatedTemplate.FieldSpecifiers)+len(associatedTemplate.ScopeFieldSpecifiers))), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Too many field values in record. Should only have %d", (len(associatedTemplate.FieldSpecifiers)+len(associatedTemplate.ScopeFieldSpecifiers))), ErrCritical).InTemplate(datrec.TemplateID)
 	}
 	if associatedTemplate.FieldSpecifiers[len(datrec.FieldValues)].FieldLength != fieldvalue.Len() &&
 		associatedTemplate.FieldSpecifiers[len(datrec.FieldValues)].FieldLength != VariableLength {
-		return NewError(fmt.Sprintf("Field value has incorrect octet length. Expected %d, but got %d", associatedTemplate.FieldSpecifiers[len(datrec.FieldValues)].FieldLength, fieldvalue.Len()), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Field value has incorrect octet length. Expected %d, but got %d", associatedTemplate.FieldSpecifiers[len(datrec.FieldValues)].FieldLength, fieldvalue.Len()), ErrCritical).InTemplate(datrec.TemplateID).AtField(len(datrec.FieldValues))
 	}
 	datrec.FieldValues = append(datrec.FieldValues, fieldvalue)
 	return nil
@@ -74,11 +74,13 @@ func (datrec *DataRecord) Len() uint16 {
 			FieldSpec = curtemplate.FieldSpecifiers[fieldidx]
 		}
 		if FieldSpec.FieldLength == VariableLength {
-			if tmplen < 256 {
+			if tmplen < 255 {
 				tmplen++
 			} else {
 				tmplen += 3
 			}
+		} else {
+			tmplen = FieldSpec.FieldLength //Reduced-size encoding may make the field smaller than its type
 		}
 		reclen += tmplen
 	}
@@ -88,7 +90,7 @@ func (datrec *DataRecord) Len() uint16 {
 // AssociateTemplates sets the template to be used marshalling/unmarshalling this DataRecord
 func (datrec *DataRecord) AssociateTemplates(at *ActiveTemplates) error {
 	if at == nil {
-		return NewError("Can not use nil as Template List", ErrCritical)
+		return NewCategoryError(ErrNoTemplates, "Can not use nil as Template List", ErrCritical)
 	}
 	datrec.AssociatedTemplates = at
 	return nil
@@ -106,7 +108,7 @@ func (datrec *DataRecord) String() string {
 // SetTemplateID sets the template ID the current DataRecord adheres to.
 func (datrec *DataRecord) SetTemplateID(id uint16) error {
 	if id < 256 {
-		return NewError(fmt.Sprintf("Can not use a template id < 256. Was %d", id), ErrCritical)
+		return NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Can not use a template id < 256. Was %d", id), ErrCritical)
 	}
 	datrec.TemplateID = id
 	return nil
@@ -115,36 +117,32 @@ func (datrec *DataRecord) SetTemplateID(id uint16) error {
 // MarshalBinary satisfies the encoding/BinaryMarshaler interface
 // FieldValues have a type when added so there is implicit information on each field value to marshal it
 func (datrec *DataRecord) MarshalBinary() (data []byte, err error) {
+	return datrec.AppendBinary(nil)
+}
+
+// AppendBinary satisfies the encoding/BinaryAppender interface, it appends the record to dst
+func (datrec *DataRecord) AppendBinary(dst []byte) (data []byte, err error) {
 	if datrec.AssociatedTemplates == nil {
-		return nil, NewError("Can not marshal without associated templates", ErrCritical)
+		return nil, NewCategoryError(ErrNoTemplates, "Can not marshal without associated templates", ErrCritical)
 	}
 	if datrec.TemplateID < 256 {
-		return nil, NewError("Can not marshal without a template id", ErrCritical)
+		return nil, NewCategoryError(ErrInvalidTemplateID, "Can not marshal without a template id", ErrCritical)
 	}
 	if len(datrec.FieldValues) < 1 {
 		return nil, NewError("Can not marshal record, must have at least one Field Value", ErrCritical)
 	}
-	marshalValue := []byte{}
 	curtemplate, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
 	if err != nil {
-		return nil, NewError(fmt.Sprintf("Can not marshal record, error in retrieving template %#v", err), ErrCritical)
+		return nil, err
 	}
 	NofScopeFields := len(curtemplate.ScopeFieldSpecifiers)
 	for fieldidx, listitem := range datrec.FieldValues {
-		item := []byte{}
 		switch listitem.(type) {
 		case *FieldValueSubTemplateList:
 			listitem.(*FieldValueSubTemplateList).SetAssiocatedTemplates(datrec.AssociatedTemplates)
 		case *FieldValueSubTemplateMultiList:
 			listitem.(*FieldValueSubTemplateMultiList).SetAssiocatedTemplates(datrec.AssociatedTemplates)
 		}
-		item, suberr := listitem.MarshalBinary()
-		if suberr != nil {
-			if err == nil {
-				err = NewError("Sub errors marshalling data record.", ErrFailure)
-			}
-			err.(*ProtocolError).Stack(*suberr.(*ProtocolError))
-		}
 		FieldSpec := &FieldSpecifier{}
 		if NofScopeFields > 0 {
 			if fieldidx < NofScopeFields {
@@ -155,85 +153,76 @@ func (datrec *DataRecord) MarshalBinary() (data []byte, err error) {
 		} else {
 			FieldSpec = curtemplate.FieldSpecifiers[fieldidx]
 		}
-		if FieldSpec.FieldLength != VariableLength {
-			if len(item) != int(curtemplate.FieldSpecifiers[fieldidx].FieldLength) {
-				return nil, NewError(fmt.Sprintf("Wrong marshalled size for item %#v, expected %d, but got %d", listitem, len(item), curtemplate.FieldSpecifiers[fieldidx].FieldLength), ErrCritical)
+		start := len(dst)
+		if FieldSpec.FieldLength == VariableLength {
+			dst = reserveVariableLength(dst, false)
+		}
+		appended, suberr := appendBinary(dst, listitem)
+		if suberr != nil {
+			err = stackError(err, "Sub errors marshalling data record.", suberr, 0)
+			appended = dst //The field is left empty
+		}
+		dst = appended
+		if FieldSpec.FieldLength == VariableLength {
+			dst, suberr = putVariableLength(dst, start, false)
+			if suberr != nil {
+				return nil, suberr
 			}
-		} else {
-			var marshalLength []byte
-			marshalLength, err = EncodeVariableLength(item, false)
-			if err != nil {
-				return nil, err
+			continue
+		}
+		item := dst[start:]
+		if len(item) > int(FieldSpec.FieldLength) {
+			item, suberr = reduceSize(listitem, item, FieldSpec.FieldLength)
+			if suberr != nil {
+				return nil, suberr
 			}
-			marshalValue = append(marshalValue, marshalLength...)
+			dst = append(dst[:start], item...)
+		}
+		if len(item) != int(FieldSpec.FieldLength) {
+			return nil, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Wrong marshalled size for item %#v, expected %d, but got %d", listitem, FieldSpec.FieldLength, len(item)), ErrCritical).InTemplate(datrec.TemplateID).AtField(fieldidx)
 		}
-		marshalValue = append(marshalValue, item...)
 	}
-	return marshalValue, err
+	return dst, err
 }
 
 // UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
+// The DefaultLimits apply.
 func (datrec *DataRecord) UnmarshalBinary(data []byte) error {
+	_, err := datrec.unmarshal(data, newDecodeState(DefaultLimits))
+	return err
+}
+
+// unmarshal decodes the record at the start of data and returns the number of octets it takes on the wire.
+// That can differ from Len for variable-length fields, as the length may have been encoded in 3 octets.
+func (datrec *DataRecord) unmarshal(data []byte, state *decodeState) (int, error) {
 	if datrec.AssociatedTemplates == nil {
-		return NewError(fmt.Sprintf("Can not marshal without associated templates"), ErrCritical)
+		return 0, NewCategoryError(ErrNoTemplates, fmt.Sprintf("Can not marshal without associated templates"), ErrCritical)
 	}
 	if datrec.TemplateID < 256 {
-		return NewError(fmt.Sprintf("Can not unmarshal; incorrect template id %d", datrec.TemplateID), ErrCritical)
+		return 0, NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Can not unmarshal; incorrect template id %d", datrec.TemplateID), ErrCritical)
 	}
 	if data == nil || len(data) == 0 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+		return 0, NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
 	}
-	curtemplate, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	plan, err := state.plan(datrec.AssociatedTemplates, datrec.TemplateID)
+	if err != nil {
+		return 0, err
+	}
+	reclen, err := plan.decode(datrec, data, state)
 	if err != nil {
-		return NewError(fmt.Sprintf("Can not marshal record, error in retrieving template %#v", err), ErrCritical)
+		return 0, err
 	}
-	cursor := 0
-	cnt := 0
+	return reclen, plan.unknownElements()
+}
 
-	for _, recitem := range curtemplate.FieldSpecifiers {
-		cnt++
-		newval, suberr := NewFieldValueByID(recitem.EnterpriseNumber, recitem.InformationElementIdentifier)
-		if suberr != nil {
-			if err == nil {
-				err = NewError("Sub errors unmarshalling data record.", ErrFailure)
-			}
-			err.(*ProtocolError).Stack(*suberr.(*ProtocolError))
-		}
-		switch newval.(type) {
-		case *FieldValueSubTemplateList:
-			newval.(*FieldValueSubTemplateList).SetAssiocatedTemplates(datrec.AssociatedTemplates)
-		case *FieldValueSubTemplateMultiList:
-			newval.(*FieldValueSubTemplateMultiList).SetAssiocatedTemplates(datrec.AssociatedTemplates)
-		}
-		if recitem.FieldLength != VariableLength {
-			if cursor+int(recitem.FieldLength) > len(data) {
-				return NewError(fmt.Sprintf("Insufficient data to decode. Needed %d, but have %d", recitem.FieldLength, len(data[cursor:])), ErrCritical)
-			}
-			err := newval.UnmarshalBinary(data[cursor : cursor+int(recitem.FieldLength)])
-			if err != nil {
-				return err
-			}
-			datrec.FieldValues = append(datrec.FieldValues, newval)
-			cursor += int(recitem.FieldLength)
-		} else {
-			if cursor+3 > len(data) {
-				return NewError(fmt.Sprintf("Insufficient data to decode. Needed %d, but have %d", 3, len(data[cursor:])), ErrCritical)
-			}
-			fieldlen, cursorshift, err := DecodeVariableLength(data[cursor : cursor+3])
-			if err != nil {
-				return err
-			}
-			if cursor+int(fieldlen)+int(cursorshift) > len(data) {
-				return NewError(fmt.Sprintf("XInsufficient data to decode. Needed %d, but have %d", int(fieldlen)+int(cursorshift), len(data[cursor:])), ErrCritical)
-			}
-			cursor += int(cursorshift)
-			err = newval.UnmarshalBinary(data[cursor : cursor+int(fieldlen)])
-			if err != nil {
-				return err
-			}
-			datrec.FieldValues = append(datrec.FieldValues, newval)
-			cursor += int(fieldlen)
+// fieldErrorContext adds the location of a field to an error that was returned while decoding that field
+func fieldErrorContext(err error, cursor int, templateid uint16, fieldidx int) error {
+	if perr, ok := err.(*ProtocolError); ok {
+		if perr.Offset < 0 {
+			perr.Offset = 0
 		}
+		perr.shiftOffset(cursor)
+		perr.InTemplate(templateid).AtField(fieldidx)
 	}
 	return err
 }
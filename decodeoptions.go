@@ -0,0 +1,32 @@
+package ipfix
+
+/*
+
+RFC 7011, section 9.1: if the Collecting Process receives a malformed IPFIX Message, it MUST discard the whole IPFIX Message and SHOULD log the error.
+In practice it is often more useful to keep the Sets that could be decoded and only report the ones that could not.
+DecodeOptions selects between these two behaviours.
+
+*/
+
+// DecodeOptions controls how malformed input is handled when unmarshalling Messages and Sets
+type DecodeOptions struct {
+	// Lenient keeps the Sets that could be decoded and reports the bad ones as stacked errors.
+	// When false (strict, the default) a malformed Message is discarded as a whole, as RFC 7011 prescribes.
+	// Malformations are: Set lengths that are too short or overrun the Message, records that overrun their Set (including variable-length fields),
+	// padding that is not all zeroes and Set IDs that are reserved (0, 1 and 4-255).
+	Lenient bool
+
+	// Limits caps the resources that decoding may use. The zero value sets no limits at all.
+	Limits Limits
+}
+
+// DefaultDecodeOptions are the options used by UnmarshalBinary: strict, with the DefaultLimits
+var DefaultDecodeOptions = DecodeOptions{Limits: DefaultLimits}
+
+// malformed returns the severity that a malformation gets under the options: critical when strict, a failure when lenient
+func (opts DecodeOptions) malformed() int {
+	if opts.Lenient {
+		return ErrFailure
+	}
+	return ErrCritical
+}
@@ -0,0 +1,94 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	decodeoptionsTestPrint = false
+)
+
+func TestDecodeOptionsMarker(t *testing.T) {
+	if decodeoptionsTestPrint {
+		fmt.Printf(testMarkerString, "Decode Options")
+	}
+}
+
+func TestDecodeOptionsMalformed(t *testing.T) {
+	tmplset := []byte{0, 2, 0, 20,
+		1, 0, 0, 1, 0, 8, 0, 4, //Template 256, sourceIPv4Address
+		1, 1, 0, 1, 0, 82, 0xff, 0xff, //Template 257, interfaceName (variable length)
+	}
+	datset := []byte{1, 0, 0, 8, 10, 0, 0, 1}
+
+	tests := []struct {
+		name     string
+		badset   []byte
+		category error
+		kept     int //Number of sets kept in lenient mode
+	}{
+		{"bad set length", []byte{1, 0, 0, 2}, ErrMalformedLength, 2},
+		{"set overruns message", []byte{1, 0, 0, 12, 10, 0, 0, 1}, ErrMalformedLength, 2},
+		{"variable length overruns set", []byte{1, 1, 0, 8, 10, 'a', 'b', 'c'}, ErrMalformedLength, 2},
+		{"non-zero padding", []byte{1, 0, 0, 10, 10, 0, 0, 2, 0, 1}, ErrMalformedLength, 3},
+		{"reserved set id", []byte{0, 4, 0, 8, 1, 2, 3, 4}, ErrInvalidSetID, 2},
+	}
+	for _, test := range tests {
+		data := sessionTestMessage(1, tmplset, datset, test.badset)
+
+		msg, _ := NewMessage()
+		msg.AssociatedTemplates = NewActiveTemplateList()
+		err := msg.UnmarshalBinary(data)
+		if !errors.Is(err, test.category) || err.(*ProtocolError).Severity != ErrCritical {
+			t.Errorf(errorPrefixMarker+"%s: strict decoding should have failed with %v, but got %v", test.name, test.category, err)
+		}
+		if len(msg.Sets) != 0 {
+			t.Errorf(errorPrefixMarker+"%s: strict decoding should have discarded the message, but kept %d sets", test.name, len(msg.Sets))
+		}
+		if _, err := msg.AssociatedTemplates.Get(256); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf(errorPrefixMarker+"%s: the templates of a discarded message should not have been set", test.name)
+		}
+
+		msg, _ = NewMessage()
+		msg.AssociatedTemplates = NewActiveTemplateList()
+		err = msg.UnmarshalBinaryWithOptions(data, DecodeOptions{Lenient: true})
+		if !errors.Is(err, test.category) || err.(*ProtocolError).Severity == ErrCritical {
+			t.Errorf(errorPrefixMarker+"%s: lenient decoding should have reported %v, but got %v", test.name, test.category, err)
+		}
+		if len(msg.Sets) != test.kept {
+			t.Errorf(errorPrefixMarker+"%s: lenient decoding should have kept %d sets, but kept %d", test.name, test.kept, len(msg.Sets))
+		}
+		if _, err := msg.AssociatedTemplates.Get(257); err != nil {
+			t.Errorf(errorPrefixMarker+"%s: lenient decoding should have set the templates, but got %v", test.name, err)
+		}
+		if decodeoptionsTestPrint {
+			fmt.Println(test.name, err)
+		}
+	}
+}
+
+func TestDecodeOptionsValid(t *testing.T) {
+	tmplset := []byte{0, 2, 0, 24,
+		1, 0, 0, 1, 0, 8, 0, 4,
+		1, 1, 0, 1, 0, 82, 0xff, 0xff,
+		0, 0, 0, 0, //Padding
+	}
+	paddedset := []byte{1, 0, 0, 10, 10, 0, 0, 1, 0, 0}
+	varset := []byte{1, 1, 0, 14, 3, 'e', 't', 'h', 255, 0, 3, 'l', 'o', '0'} //Second length in 3 octets
+	zeroset := []byte{1, 0, 0, 8, 0, 0, 0, 0}                                 //A record with all zeroes is not padding
+
+	msg, _ := NewMessage()
+	msg.AssociatedTemplates = NewActiveTemplateList()
+	err := msg.UnmarshalBinary(sessionTestMessage(1, tmplset, paddedset, varset, zeroset))
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling valid message: %v", err)
+	}
+	if len(msg.Sets) != 4 || len(msg.Sets[1].Records) != 1 || len(msg.Sets[2].Records) != 2 || len(msg.Sets[3].Records) != 1 {
+		t.Errorf(errorPrefixMarker+"Wrong sets decoded: %s", msg)
+	}
+	if value := (*msg.Sets[2].Records[1]).(*DataRecord).FieldValues[0].Value(); value != "lo0" {
+		t.Errorf(errorPrefixMarker+"Wrong value for variable length field with 3 octet length: %v", value)
+	}
+}
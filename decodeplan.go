@@ -0,0 +1,222 @@
+package ipfix
+
+import "fmt"
+
+/*
+
+Decoding a Data Record needs its template: the lengths of the fields and the types of their values.
+Looking these up for every record is wasteful, so a template is compiled into a decode plan when it is set in the ActiveTemplates,
+and all records of the template are decoded with the plan. The plan of a template is looked up once per message, also for the records of lists.
+Templates without variable-length fields have fixed offsets, so their records are decoded without computing any lengths.
+
+A plan is recompiled if specifiers were added to its template after it was set, or if custom elements were registered since it was compiled,
+but other changes to a template that has been set are not noticed: set a new template instead.
+
+*/
+
+// planField is a field of a decodePlan
+type planField struct {
+	length   uint16            //Field length from the template, VariableLength for variable-length fields
+	offset   int               //Offset in the record, only used if the template has no variable-length fields
+	newValue func() FieldValue //Returns a new, empty value of the type of the Information Element
+	list     bool              //The value is a subTemplateList or subTemplateMultiList, which needs the associated templates
+}
+
+// decodePlan is a template compiled for decoding its records
+type decodePlan struct {
+	templateID uint16
+	template   *TemplateRecord //The template that was compiled
+	scopeCount int             //Number of scope fields of the template when it was compiled
+	fields     []planField
+	unknown    []int  //Indexes of the fields with Information Elements that are not known, they are decoded as octet arrays
+	variable   bool   //Whether the template has variable-length fields
+	minLength  int    //Length of the records if the template has no variable-length fields, the length of the shortest record otherwise
+	version    uint64 //Version of the custom elements when it was compiled
+}
+
+// newDecodePlan compiles tmplrec into a decode plan
+func newDecodePlan(tmplrec *TemplateRecord) *decodePlan {
+	fsps := tmplrec.allFieldSpecifiers()
+	plan := &decodePlan{
+		templateID: tmplrec.TemplateID,
+		template:   tmplrec,
+		version:    customMapVersion.Load(),
+		scopeCount: len(tmplrec.ScopeFieldSpecifiers),
+		fields:     make([]planField, 0, len(fsps)),
+	}
+	for fieldidx, fsp := range fsps {
+		newvalue, known := newFieldValueConstructor(fsp.EnterpriseNumber, fsp.InformationElementIdentifier)
+		if !known {
+			plan.unknown = append(plan.unknown, fieldidx)
+		}
+		field := planField{
+			length:   fsp.FieldLength,
+			offset:   plan.minLength,
+			newValue: newvalue,
+		}
+		switch field.newValue().(type) {
+		case *FieldValueSubTemplateList, *FieldValueSubTemplateMultiList:
+			field.list = true
+		}
+		if fsp.FieldLength == VariableLength {
+			plan.variable = true
+			plan.minLength++ //A variable-length field takes at least its length octet
+		} else {
+			plan.minLength += int(fsp.FieldLength)
+		}
+		plan.fields = append(plan.fields, field)
+	}
+	return plan
+}
+
+// compiles returns whether the plan is the compiled form of tmplrec, as it is now
+func (plan *decodePlan) compiles(tmplrec *TemplateRecord) bool {
+	return plan.template == tmplrec && plan.version == customMapVersion.Load() && plan.scopeCount == len(tmplrec.ScopeFieldSpecifiers) && len(plan.fields) == len(tmplrec.ScopeFieldSpecifiers)+len(tmplrec.FieldSpecifiers)
+}
+
+// unknownElements returns an error with severity ErrFailure that reports the fields of unknown Information Elements, or nil if there are none.
+// The records are decoded nonetheless, with the values of those fields as octet arrays.
+func (plan *decodePlan) unknownElements() error {
+	var err error
+	fsps := plan.template.allFieldSpecifiers()
+	for _, fieldidx := range plan.unknown {
+		suberr := NewCategoryError(ErrUnknownElement, fmt.Sprintf("Unknown element E%did%d decoded as octet array", fsps[fieldidx].EnterpriseNumber, fsps[fieldidx].InformationElementIdentifier), ErrFailure).InTemplate(plan.templateID).AtField(fieldidx)
+		err = stackError(err, "Sub errors unmarshalling data record.", suberr, 0)
+	}
+	return err
+}
+
+// newFieldValueConstructor returns a function that returns new, empty values for the Information Element, and whether the element is known.
+// Unknown Information Elements are kept as raw octets so the rest of the record can still be decoded.
+func newFieldValueConstructor(enterpriseid uint32, elementid uint16) (func() FieldValue, bool) {
+	prototype, err := NewFieldValueByID(enterpriseid, elementid)
+	if err != nil {
+		return func() FieldValue { return &FieldValueOctetArray{} }, false
+	}
+	return fieldValueConstructor(prototype), true
+}
+
+// fieldValueConstructor returns a function that returns new, empty values of the type of prototype
+func fieldValueConstructor(prototype FieldValue) func() FieldValue {
+	switch prototype.(type) {
+	case *FieldValueUnsigned8:
+		return func() FieldValue { return &FieldValueUnsigned8{} }
+	case *FieldValueUnsigned16:
+		return func() FieldValue { return &FieldValueUnsigned16{} }
+	case *FieldValueUnsigned32:
+		return func() FieldValue { return &FieldValueUnsigned32{} }
+	case *FieldValueUnsigned64:
+		return func() FieldValue { return &FieldValueUnsigned64{} }
+	case *FieldValueSigned8:
+		return func() FieldValue { return &FieldValueSigned8{} }
+	case *FieldValueSigned16:
+		return func() FieldValue { return &FieldValueSigned16{} }
+	case *FieldValueSigned32:
+		return func() FieldValue { return &FieldValueSigned32{} }
+	case *FieldValueSigned64:
+		return func() FieldValue { return &FieldValueSigned64{} }
+	case *FieldValueFloat32:
+		return func() FieldValue { return &FieldValueFloat32{} }
+	case *FieldValueFloat64:
+		return func() FieldValue { return &FieldValueFloat64{} }
+	case *FieldValueBoolean:
+		return func() FieldValue { return &FieldValueBoolean{} }
+	case *FieldValueMacAddress:
+		return func() FieldValue { return &FieldValueMacAddress{} }
+	case *FieldValueOctetArray:
+		return func() FieldValue { return &FieldValueOctetArray{} }
+	case *FieldValueString:
+		return func() FieldValue { return &FieldValueString{} }
+	case *FieldValueDateTimeSeconds:
+		return func() FieldValue { return &FieldValueDateTimeSeconds{} }
+	case *FieldValueDateTimeMilliseconds:
+		return func() FieldValue { return &FieldValueDateTimeMilliseconds{} }
+	case *FieldValueDateTimeMicroseconds:
+		return func() FieldValue { return &FieldValueDateTimeMicroseconds{} }
+	case *FieldValueDateTimeNanoseconds:
+		return func() FieldValue { return &FieldValueDateTimeNanoseconds{} }
+	case *FieldValueIPv4Address:
+		return func() FieldValue { return &FieldValueIPv4Address{} }
+	case *FieldValueIPv6Address:
+		return func() FieldValue { return &FieldValueIPv6Address{} }
+	case *FieldValueBasicList:
+		return func() FieldValue { return &FieldValueBasicList{} }
+	case *FieldValueSubTemplateList:
+		return func() FieldValue { return &FieldValueSubTemplateList{} }
+	case *FieldValueSubTemplateMultiList:
+		return func() FieldValue { return &FieldValueSubTemplateMultiList{} }
+	}
+	return func() FieldValue { //A type we do not know, so it can only be copied the slow way
+		fieldval, err := getNewFieldValue(prototype)
+		if err != nil {
+			return &FieldValueOctetArray{}
+		}
+		return fieldval
+	}
+}
+
+// decode decodes the record at the start of data into datrec and returns the number of octets it takes on the wire
+func (plan *decodePlan) decode(datrec *DataRecord, data []byte, state *decodeState) (int, error) {
+	datrec.FieldValues = make([]FieldValue, 0, len(plan.fields))
+	if !plan.variable {
+		if len(data) < plan.minLength {
+			for fieldidx, field := range plan.fields {
+				if field.offset+int(field.length) > len(data) {
+					return 0, NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data to decode. Needed %d, but have %d", field.length, len(data)-field.offset), ErrCritical).AtOffset(field.offset).InTemplate(plan.templateID).AtField(fieldidx)
+				}
+			}
+		}
+		for fieldidx, field := range plan.fields {
+			newval := plan.newValue(field, datrec)
+			err := unmarshalFieldValue(newval, data[field.offset:field.offset+int(field.length)], state)
+			if err != nil {
+				return 0, fieldErrorContext(err, field.offset, plan.templateID, fieldidx)
+			}
+			datrec.FieldValues = append(datrec.FieldValues, newval)
+		}
+		return plan.minLength, nil
+	}
+
+	cursor := 0
+	for fieldidx, field := range plan.fields {
+		fieldlen := int(field.length)
+		if field.length == VariableLength {
+			if cursor >= len(data) || (data[cursor] == 255 && cursor+3 > len(data)) {
+				return 0, NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data to decode variable length. Have %d", len(data[cursor:])), ErrCritical).AtOffset(cursor).InTemplate(plan.templateID).AtField(fieldidx)
+			}
+			varlen, cursorshift, err := DecodeVariableLength(data[cursor:])
+			if err != nil {
+				return 0, fieldErrorContext(err, cursor, plan.templateID, fieldidx)
+			}
+			if cursor+int(varlen)+int(cursorshift) > len(data) {
+				return 0, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Insufficient data to decode. Needed %d, but have %d", int(varlen)+int(cursorshift), len(data[cursor:])), ErrCritical).AtOffset(cursor).InTemplate(plan.templateID).AtField(fieldidx)
+			}
+			cursor += int(cursorshift)
+			fieldlen = int(varlen)
+		} else if cursor+fieldlen > len(data) {
+			return 0, NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data to decode. Needed %d, but have %d", field.length, len(data[cursor:])), ErrCritical).AtOffset(cursor).InTemplate(plan.templateID).AtField(fieldidx)
+		}
+		newval := plan.newValue(field, datrec)
+		err := unmarshalFieldValue(newval, data[cursor:cursor+fieldlen], state)
+		if err != nil {
+			return 0, fieldErrorContext(err, cursor, plan.templateID, fieldidx)
+		}
+		datrec.FieldValues = append(datrec.FieldValues, newval)
+		cursor += fieldlen
+	}
+	return cursor, nil
+}
+
+// newValue returns a new value for the field, with the templates of datrec if it is a list that needs them
+func (plan *decodePlan) newValue(field planField, datrec *DataRecord) FieldValue {
+	newval := field.newValue()
+	if field.list {
+		switch newval.(type) {
+		case *FieldValueSubTemplateList:
+			newval.(*FieldValueSubTemplateList).SetAssiocatedTemplates(datrec.AssociatedTemplates)
+		case *FieldValueSubTemplateMultiList:
+			newval.(*FieldValueSubTemplateMultiList).SetAssiocatedTemplates(datrec.AssociatedTemplates)
+		}
+	}
+	return newval
+}
@@ -0,0 +1,208 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	decodeplanTestPrint = false
+)
+
+func TestDecodePlanMarker(t *testing.T) {
+	if decodeplanTestPrint {
+		fmt.Printf(testMarkerString, "Decode Plan")
+	}
+}
+
+var (
+	decodePlanTestFixedTemplateSet = []byte{0, 2, 0, 36,
+		1, 0, 0, 7, //Template 256, 7 fields
+		0, 8, 0, 4, //sourceIPv4Address
+		0, 12, 0, 4, //destinationIPv4Address
+		0, 7, 0, 2, //sourceTransportPort
+		0, 11, 0, 2, //destinationTransportPort
+		0, 1, 0, 8, //octetDeltaCount
+		0, 2, 0, 8, //packetDeltaCount
+		0, 4, 0, 1, //protocolIdentifier
+	}
+	decodePlanTestFixedRecord = []byte{
+		10, 0, 0, 1, 10, 0, 0, 2, //Addresses
+		0x30, 0x39, 0, 80, //Ports
+		0, 0, 0, 0, 0, 0, 5, 220, //Octets
+		0, 0, 0, 0, 0, 0, 0, 1, //Packets
+		6, //TCP
+	}
+	decodePlanTestVariableTemplateSet = []byte{0, 2, 0, 24,
+		1, 1, 0, 3, //Template 257, 3 fields
+		0, 8, 0, 4, //sourceIPv4Address
+		0, 82, 0xff, 0xff, //interfaceName
+		0xa7, 0x0f, 0xff, 0xff, 0, 0, 0xaf, 0x71, //Unknown enterprise specific element 9999, variable length
+	}
+	decodePlanTestVariableRecord = []byte{
+		10, 0, 0, 1, //sourceIPv4Address
+		4, 'e', 't', 'h', '0', //interfaceName
+		255, 0, 3, 'a', 'b', 'c', //Enterprise specific, with a three octet length
+	}
+)
+
+// decodePlanTestTemplates returns the active templates of both test template sets
+func decodePlanTestTemplates(t testing.TB) *ActiveTemplates {
+	templates := NewActiveTemplateList()
+	for _, data := range [][]byte{decodePlanTestFixedTemplateSet, decodePlanTestVariableTemplateSet} {
+		tmplset := &Set{}
+		if err := tmplset.UnmarshalBinary(data); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error unmarshalling template set: %v", err)
+		}
+		tmplrec := (*tmplset.Records[0]).(*TemplateRecord)
+		templates.Set(tmplrec.TemplateID, tmplrec)
+	}
+	return templates
+}
+
+// decodePlanTestDataSet returns a data set with count copies of record
+func decodePlanTestDataSet(setid uint16, record []byte, count int) []byte {
+	setlen := 4 + count*len(record)
+	set := []byte{byte(setid >> 8), byte(setid), byte(setlen >> 8), byte(setlen)}
+	for cnt := 0; cnt < count; cnt++ {
+		set = append(set, record...)
+	}
+	return set
+}
+
+func TestDecodePlan(t *testing.T) {
+	templates := decodePlanTestTemplates(t)
+	fixed, err := templates.plan(256)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error getting plan: %v", err)
+	}
+	if fixed.variable || fixed.minLength != len(decodePlanTestFixedRecord) || len(fixed.fields) != 7 || fixed.fields[4].offset != 12 {
+		t.Errorf(errorPrefixMarker+"Wrong plan for fixed-length template: %+v", fixed)
+	}
+	variable, _ := templates.plan(257)
+	if !variable.variable || variable.minLength != 4+1+1 {
+		t.Errorf(errorPrefixMarker+"Wrong plan for variable-length template: %+v", variable)
+	}
+
+	datrec := &DataRecord{TemplateID: 257, AssociatedTemplates: templates}
+	reclen, err := variable.decode(datrec, decodePlanTestVariableRecord, newDecodeState(DefaultLimits))
+	if err != nil || reclen != len(decodePlanTestVariableRecord) {
+		t.Fatalf(errorPrefixMarker+"Expected record of %d octets, but got %d (%v)", len(decodePlanTestVariableRecord), reclen, err)
+	}
+	if name := datrec.FieldValues[1].Value(); name != "eth0" {
+		t.Errorf(errorPrefixMarker+"Wrong interface name %q", name)
+	}
+	if _, ok := datrec.FieldValues[2].(*FieldValueOctetArray); !ok {
+		t.Errorf(errorPrefixMarker+"Unknown element should be kept as octets, but got %T", datrec.FieldValues[2])
+	}
+	var perr *ProtocolError
+	if err := variable.unknownElements(); !errors.Is(err, ErrUnknownElement) || !errors.As(err, &perr) || perr.SubError[0].FieldIndex != 2 || fixed.unknownElements() != nil {
+		t.Errorf(errorPrefixMarker+"Expected field 2 to be reported as unknown, but got %v", err)
+	}
+
+	set := &Set{}
+	set.AssociateTemplates(templates)
+	if err := set.UnmarshalBinary(decodePlanTestDataSet(256, decodePlanTestFixedRecord, 3)); err != nil || len(set.Records) != 3 {
+		t.Fatalf(errorPrefixMarker+"Expected 3 records, but got %d (%v)", len(set.Records), err)
+	}
+	if octets := (*set.Records[2]).(*DataRecord).FieldValues[4].Value(); octets != uint64(1500) {
+		t.Errorf(errorPrefixMarker+"Wrong octet count %d", octets)
+	}
+}
+
+func TestDecodePlanErrors(t *testing.T) {
+	templates := decodePlanTestTemplates(t)
+	fixed, _ := templates.plan(256)
+	datrec := &DataRecord{TemplateID: 256, AssociatedTemplates: templates}
+	_, err := fixed.decode(datrec, decodePlanTestFixedRecord[:14], newDecodeState(DefaultLimits))
+	var perr *ProtocolError
+	if !errors.Is(err, ErrInsufficientData) || !errors.As(err, &perr) || perr.Offset != 12 || perr.FieldIndex != 4 {
+		t.Errorf(errorPrefixMarker+"Expected insufficient data for the octet count at offset 12, but got %v", err)
+	}
+
+	set := &Set{}
+	set.AssociateTemplates(templates)
+	if err := set.UnmarshalBinary(decodePlanTestDataSet(258, decodePlanTestFixedRecord, 1)); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Expected missing template, but got %v", err)
+	}
+}
+
+func TestDecodePlanRecompiled(t *testing.T) {
+	templates := decodePlanTestTemplates(t)
+	compiled, _ := templates.plan(256)
+	if cached, _ := templates.plan(256); cached != compiled {
+		t.Errorf(errorPrefixMarker + "Plan should be compiled once")
+	}
+
+	tmplrec, _ := NewTemplateRecord(256)
+	fsp, _ := NewFieldSpecifier(0, 12, 4)
+	tmplrec.AddSpecifier(fsp)
+	templates.Set(256, tmplrec)
+	replaced, _ := templates.plan(256)
+	if replaced == compiled || replaced.minLength != 4 {
+		t.Errorf(errorPrefixMarker+"Plan should be recompiled when the template is replaced, got %+v", replaced)
+	}
+
+	fsp, _ = NewFieldSpecifier(0, 8, 4)
+	tmplrec.AddSpecifier(fsp) //Changing a template after it was set
+	extended, _ := templates.plan(256)
+	if len(extended.fields) != 2 || extended.minLength != 8 {
+		t.Errorf(errorPrefixMarker+"Plan should be recompiled when specifiers are added, got %+v", extended)
+	}
+}
+
+func TestDecodePlanLookups(t *testing.T) {
+	templates := decodePlanTestTemplates(t)
+	tmplrec, _ := NewTemplateRecord(258)
+	fsp, _ := NewFieldSpecifier(0, 292, VariableLength) //subTemplateList
+	tmplrec.AddSpecifier(fsp)
+	templates.Set(258, tmplrec)
+	record := append([]byte{byte(3 + len(decodePlanTestFixedRecord)), 3, 1, 0}, decodePlanTestFixedRecord...) //A list of one record of template 256
+
+	before := templates.templates[256].NofAccess
+	set := &Set{}
+	set.AssociateTemplates(templates)
+	if err := set.UnmarshalBinary(decodePlanTestDataSet(258, record, 3)); err != nil || len(set.Records) != 3 {
+		t.Fatalf(errorPrefixMarker+"Expected 3 records, but got %d (%v)", len(set.Records), err)
+	}
+	if lookups := templates.templates[256].NofAccess - before; lookups != 1 {
+		t.Errorf(errorPrefixMarker+"Expected the plan of the lists to be looked up once, but got %d", lookups)
+	}
+}
+
+func benchmarkSetUnmarshal(b *testing.B, setid uint16, record []byte) {
+	templates := decodePlanTestTemplates(b)
+	data := decodePlanTestDataSet(setid, record, 50)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for cnt := 0; cnt < b.N; cnt++ {
+		set := &Set{}
+		set.AssociateTemplates(templates)
+		if err := set.UnmarshalBinary(data); err != nil {
+			b.Fatalf(errorPrefixMarker+"Error unmarshalling set: %v", err)
+		}
+	}
+}
+
+func BenchmarkSetUnmarshalFixed(b *testing.B) {
+	benchmarkSetUnmarshal(b, 256, decodePlanTestFixedRecord)
+}
+
+func BenchmarkSetUnmarshalVariable(b *testing.B) {
+	benchmarkSetUnmarshal(b, 257, decodePlanTestVariableRecord)
+}
+
+func BenchmarkDataRecordUnmarshal(b *testing.B) {
+	templates := decodePlanTestTemplates(b)
+	b.SetBytes(int64(len(decodePlanTestFixedRecord)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for cnt := 0; cnt < b.N; cnt++ {
+		datrec := &DataRecord{TemplateID: 256, AssociatedTemplates: templates}
+		if err := datrec.UnmarshalBinary(decodePlanTestFixedRecord); err != nil {
+			b.Fatalf(errorPrefixMarker+"Error unmarshalling record: %v", err)
+		}
+	}
+}
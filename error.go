@@ -1,9 +1,13 @@
 package ipfix
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	//MaxMoreErrors - Maximum of stacked errors before 'More Errors' is used. This is to prevent out-of-memory issues
+	//An error of a category that is not stacked yet is always kept, so errors.Is finds every category that occurred.
 	MaxMoreErrors = 5
 )
 
@@ -14,17 +18,47 @@ const (
 	ErrCritical        //When code can not or should not continue
 )
 
+// Error categories. A ProtocolError with a category matches it with errors.Is, so callers can tell, for example, a missing template from a corrupt packet.
+var (
+	ErrTemplateNotFound   = errors.New("template not found")                  //The template for a data set or data record is not (yet) known
+	ErrMalformedLength    = errors.New("malformed length")                    //A length field is inconsistent with the available data
+	ErrInsufficientData   = errors.New("insufficient data")                   //There is not enough data to decode an element
+	ErrInvalidVersion     = errors.New("invalid version")                     //The version number of the message is not supported
+	ErrInvalidSetID       = errors.New("invalid set id")                      //The set id is reserved or otherwise not allowed here
+	ErrInvalidTemplateID  = errors.New("invalid template id")                 //The template id is not in the range 256-65535
+	ErrUnknownElement     = errors.New("unknown information element")         //The enterprise id and element id are not registered
+	ErrInvalidValue       = errors.New("invalid value")                       //A value can not be encoded or decoded
+	ErrNoTemplates        = errors.New("no associated templates")             //The element needs a list of active templates, but has none
+	ErrUnrepresentable    = errors.New("unrepresentable field")               //A field can not be represented in the target format
+	ErrRecordTypeMismatch = errors.New("record type does not match set type") //A template record in a data set or vice versa
+	ErrOutOfFrame         = errors.New("stream out of frame")                 //No valid message header could be found in a stream
+	ErrLimitExceeded      = errors.New("limit exceeded")                      //One of the configured Limits was exceeded, the category is a *LimitError
+)
+
+// errorCategories are the ErrXXX categories, of which the first error is stacked even if there are already MaxMoreErrors
+var errorCategories = []error{ErrTemplateNotFound, ErrMalformedLength, ErrInsufficientData, ErrInvalidVersion, ErrInvalidSetID, ErrInvalidTemplateID,
+	ErrUnknownElement, ErrInvalidValue, ErrNoTemplates, ErrUnrepresentable, ErrRecordTypeMismatch, ErrOutOfFrame, ErrLimitExceeded}
+
 //ProtocolError is a custom error message that can stack multiple errors
 type ProtocolError struct {
 	SubError    []ProtocolError
 	Severity    int
 	Description string
 	MoreErrors  int
+
+	Category   error  //One of the ErrXXX categories (or a foreign error that was stacked), may be nil
+	Offset     int    //Byte offset in the message where the error was found, -1 if unknown
+	SetID      uint16 //The set id of the set that was being processed, 0 if unknown
+	TemplateID uint16 //The template id of the record that was being processed, 0 if unknown
+	FieldIndex int    //The index of the field in the record that was being processed, -1 if unknown
 }
 
 //Error implements the error interface
 func (err *ProtocolError) Error() string {
 	ret := fmt.Sprintf("%d - %s ", err.Severity, err.Description)
+	if context := err.contextString(); context != "" {
+		ret += "(" + context + ") "
+	}
 	for _, sube := range err.SubError {
 		ret += "{" + sube.Error() + "}"
 	}
@@ -34,27 +68,152 @@ func (err *ProtocolError) Error() string {
 	return ret
 }
 
+//contextString returns the known context of the error
+func (err *ProtocolError) contextString() string {
+	ret := ""
+	if err.Offset >= 0 {
+		ret += fmt.Sprintf("offset=%d, ", err.Offset)
+	}
+	if err.SetID != 0 {
+		ret += fmt.Sprintf("set id=%d, ", err.SetID)
+	}
+	if err.TemplateID != 0 {
+		ret += fmt.Sprintf("template id=%d, ", err.TemplateID)
+	}
+	if err.FieldIndex >= 0 {
+		ret += fmt.Sprintf("field index=%d, ", err.FieldIndex)
+	}
+	if len(ret) > 0 {
+		ret = ret[:len(ret)-2]
+	}
+	return ret
+}
+
+//Is reports whether the error belongs to the target category, for use with errors.Is
+func (err *ProtocolError) Is(target error) bool {
+	return err.Category != nil && errors.Is(err.Category, target)
+}
+
+//As finds the first error in the category that matches target, for use with errors.As
+func (err *ProtocolError) As(target interface{}) bool {
+	return err.Category != nil && errors.As(err.Category, target)
+}
+
+//Unwrap returns the stacked sub errors, for use with errors.Is and errors.As
+func (err *ProtocolError) Unwrap() []error {
+	suberrs := make([]error, 0, len(err.SubError))
+	for idx := range err.SubError {
+		suberrs = append(suberrs, &err.SubError[idx])
+	}
+	return suberrs
+}
+
 //NewError returns a new protocol error
 func NewError(desc string, sev int) *ProtocolError {
 	return &ProtocolError{
 		SubError:    []ProtocolError{},
 		Severity:    sev,
 		Description: desc,
+		Offset:      -1,
+		FieldIndex:  -1,
+	}
+}
+
+//NewCategoryError returns a new protocol error that belongs to one of the ErrXXX categories
+func NewCategoryError(category error, desc string, sev int) *ProtocolError {
+	err := NewError(desc, sev)
+	err.Category = category
+	return err
+}
+
+//AtOffset sets the byte offset in the message at which the error was found
+func (err *ProtocolError) AtOffset(offset int) *ProtocolError {
+	err.Offset = offset
+	return err
+}
+
+//InSet sets the set id of the set that was being processed
+func (err *ProtocolError) InSet(setid uint16) *ProtocolError {
+	err.SetID = setid
+	return err
+}
+
+//InTemplate sets the template id of the record that was being processed
+func (err *ProtocolError) InTemplate(templateid uint16) *ProtocolError {
+	err.TemplateID = templateid
+	return err
+}
+
+//AtField sets the index of the field in the record that was being processed
+func (err *ProtocolError) AtField(fieldindex int) *ProtocolError {
+	err.FieldIndex = fieldindex
+	return err
+}
+
+//shiftOffset adds delta to the known offsets of the error and its sub errors.
+//Decoders report offsets relative to the data they got, the caller shifts them to be relative to its own data.
+func (err *ProtocolError) shiftOffset(delta int) {
+	if err.Offset >= 0 {
+		err.Offset += delta
+	}
+	for idx := range err.SubError {
+		err.SubError[idx].shiftOffset(delta)
+	}
+}
+
+//errorAtOffset shifts the offsets of err by cursor, if it is a ProtocolError. An unknown offset is taken to be the start of the data at cursor.
+func errorAtOffset(err error, cursor int) error {
+	if perr, ok := err.(*ProtocolError); ok {
+		if perr.Offset < 0 {
+			perr.Offset = 0
+		}
+		perr.shiftOffset(cursor)
 	}
+	return err
 }
 
 //Stack stacks an error on top of the current error
 func (err *ProtocolError) Stack(stackerr interface{}) {
-	if len(err.SubError) >= MaxMoreErrors {
-		err.MoreErrors++
-		return
-	}
+	var suberr ProtocolError
 	switch stackerr.(type) {
 	case ProtocolError:
-		err.SubError = append(err.SubError, stackerr.(ProtocolError))
+		suberr = stackerr.(ProtocolError)
 	case *ProtocolError:
-		err.SubError = append(err.SubError, *(stackerr.(*ProtocolError)))
+		suberr = *(stackerr.(*ProtocolError))
+	case error:
+		suberr = *(NewCategoryError(stackerr.(error), stackerr.(error).Error(), ErrFailure))
 	default:
-		err.SubError = append(err.SubError, *(NewError(fmt.Sprintf("%+v", err), ErrINFO)))
+		suberr = *(NewError(fmt.Sprintf("%+v", stackerr), ErrINFO))
+	}
+	if len(err.SubError) >= MaxMoreErrors && !err.newCategory(&suberr) {
+		err.MoreErrors++
+		return
+	}
+	err.SubError = append(err.SubError, suberr)
+}
+
+//newCategory returns whether suberr, or one of its sub errors, belongs to an ErrXXX category that err does not have yet
+func (err *ProtocolError) newCategory(suberr *ProtocolError) bool {
+	for _, category := range errorCategories {
+		if errors.Is(suberr, category) && !errors.Is(err, category) {
+			return true
+		}
+	}
+	return false
+}
+
+//stackError stacks suberr onto err, creating err with the given description if it does not exist yet, and returns the result.
+//Offsets of suberr are shifted by offset, so they are relative to the data of the caller.
+func stackError(err error, desc string, suberr error, offset int) error {
+	if suberr == nil {
+		return err
+	}
+	if perr, ok := suberr.(*ProtocolError); ok {
+		perr.shiftOffset(offset)
+	}
+	if err == nil {
+		err = NewError(desc, ErrFailure)
 	}
+	err.(*ProtocolError).Stack(suberr)
+	return err
 }
@@ -0,0 +1,125 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	errorTestPrint = false
+)
+
+func TestErrorMarker(t *testing.T) {
+	if errorTestPrint {
+		fmt.Printf(testMarkerString, "Errors")
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	err := NewCategoryError(ErrTemplateNotFound, "No such template", ErrFailure).InSet(300).InTemplate(300).AtOffset(16).AtField(2)
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Error should match its category: %v", err)
+	}
+	if errors.Is(err, ErrMalformedLength) {
+		t.Errorf(errorPrefixMarker+"Error should not match another category: %v", err)
+	}
+	if err.Offset != 16 || err.SetID != 300 || err.TemplateID != 300 || err.FieldIndex != 2 {
+		t.Errorf(errorPrefixMarker+"Error context not set: %#v", err)
+	}
+	if errorTestPrint {
+		fmt.Println(err)
+	}
+
+	plain := NewError("No category", ErrCritical)
+	if plain.Offset != -1 || plain.FieldIndex != -1 || errors.Is(plain, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Error without category should have unknown context and match nothing: %#v", plain)
+	}
+	plain.Stack(err)
+	plain.Stack(fmt.Errorf("foreign: %w", ErrInvalidValue))
+	if !errors.Is(plain, ErrTemplateNotFound) || !errors.Is(plain, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Stacked errors should be reachable with errors.Is: %v", plain)
+	}
+	if len(plain.Unwrap()) != 2 {
+		t.Errorf(errorPrefixMarker+"Expected 2 unwrapped errors, but got %d", len(plain.Unwrap()))
+	}
+
+	full := NewError("Many errors", ErrFailure)
+	for cnt := 0; cnt < MaxMoreErrors+2; cnt++ {
+		full.Stack(NewCategoryError(ErrMalformedLength, "Bad length", ErrFailure))
+	}
+	full.Stack(NewCategoryError(ErrTemplateNotFound, "No such template", ErrFailure))
+	full.Stack(NewCategoryError(ErrTemplateNotFound, "No such template either", ErrFailure))
+	if !errors.Is(full, ErrTemplateNotFound) || len(full.SubError) != MaxMoreErrors+1 || full.MoreErrors != 3 {
+		t.Errorf(errorPrefixMarker+"Expected the first error of a new category to be kept, but got %v", full)
+	}
+}
+
+func TestErrorMessageContext(t *testing.T) {
+	msgdata := []byte{
+		0, 10, 0, 24, //Version, Length
+		0x52, 0xdd, 0xa6, 0xec, //Export time
+		0, 0, 0, 1, //Sequence number
+		0, 0, 0, 1, //Observation Domain ID
+		1, 44, 0, 8, //Data set for unknown template 300
+		10, 0, 0, 1,
+	}
+	msg, _ := NewMessage()
+	msg.AssociatedTemplates = NewActiveTemplateList()
+	err := msg.UnmarshalBinary(msgdata)
+	if !errors.Is(err, ErrTemplateNotFound) {
+		t.Fatalf(errorPrefixMarker+"Expected a missing template, but got %v", err)
+	}
+	if errors.Is(err, ErrMalformedLength) {
+		t.Errorf(errorPrefixMarker+"A missing template is not a corrupt packet: %v", err)
+	}
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatalf(errorPrefixMarker+"Expected a ProtocolError, but got %#v", err)
+	}
+	found := false
+	for _, sube := range perr.Unwrap() {
+		subperr := sube.(*ProtocolError)
+		if errors.Is(subperr, ErrTemplateNotFound) {
+			found = true
+			if subperr.SetID != 300 || subperr.TemplateID != 300 || subperr.Offset != 16 {
+				t.Errorf(errorPrefixMarker+"Wrong context for missing template: %v", subperr)
+			}
+		}
+	}
+	if !found {
+		t.Errorf(errorPrefixMarker+"Missing template not found in sub errors: %v", err)
+	}
+
+	msgdata[1] = 9
+	err = msg.UnmarshalBinary(msgdata)
+	if !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf(errorPrefixMarker+"Expected an invalid version, but got %v", err)
+	}
+	msgdata[1] = 10
+	msgdata[3] = 40
+	err = msg.UnmarshalBinary(msgdata)
+	if !errors.Is(err, ErrMalformedLength) {
+		t.Errorf(errorPrefixMarker+"Expected a malformed length, but got %v", err)
+	}
+}
+
+func TestErrorRecordContext(t *testing.T) {
+	tmplrec, _ := NewTemplateRecord(256)
+	fsp, _ := NewFieldSpecifier(0, 8, 4)
+	tmplrec.AddSpecifier(fsp)
+	fsp, _ = NewFieldSpecifier(0, 12, 4)
+	tmplrec.AddSpecifier(fsp)
+	tpls := NewActiveTemplateList()
+	tpls.Set(256, tmplrec)
+
+	datrec, _ := NewDataRecord(256, tpls)
+	err := datrec.UnmarshalBinary([]byte{10, 0, 0, 1, 10, 0})
+	if !errors.Is(err, ErrInsufficientData) {
+		t.Fatalf(errorPrefixMarker+"Expected insufficient data, but got %v", err)
+	}
+	perr := err.(*ProtocolError)
+	if perr.Offset != 4 || perr.TemplateID != 256 || perr.FieldIndex != 1 {
+		t.Errorf(errorPrefixMarker+"Wrong context for truncated record: %v", perr)
+	}
+}
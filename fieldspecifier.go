@@ -22,7 +22,7 @@ type FieldSpecifier struct {
 // NewFieldSpecifier returns a Field Specifier. If the Enterprise ID is 0 then the Enterprise Bit will not be set.
 func NewFieldSpecifier(enterpriseid uint32, informationelementid, fieldlength uint16) (*FieldSpecifier, error) {
 	if informationelementid > 32767 {
-		return &FieldSpecifier{}, NewError(fmt.Sprintf("Information Element ID can not be greater than 32767, but got %d", informationelementid), ErrCritical)
+		return &FieldSpecifier{}, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Information Element ID can not be greater than 32767, but got %d", informationelementid), ErrCritical)
 	}
 	return &FieldSpecifier{
 		E: (enterpriseid != 0),
@@ -65,48 +65,36 @@ func (fsp *FieldSpecifier) Len() uint16 {
 
 // MarshalBinary satisfies the encoding/BinaryMarshaler interface
 func (fsp *FieldSpecifier) MarshalBinary() (data []byte, err error) {
-	marshalValue := make([]byte, 0, 0)
-	marshalFieldID, err := marshalBinarySingleValue(fsp.InformationElementIdentifier)
-	if err != nil {
-		return nil, err
-	}
+	return fsp.AppendBinary(make([]byte, 0, fsp.Len()))
+}
+
+// AppendBinary satisfies the encoding/BinaryAppender interface
+func (fsp *FieldSpecifier) AppendBinary(dst []byte) (data []byte, err error) {
+	fieldid := fsp.InformationElementIdentifier
 	if fsp.E {
-		marshalFieldID[0] = marshalFieldID[0] | 128 //Setting the EnterpriseID bit
+		fieldid |= 0x8000 //Setting the EnterpriseID bit
 	}
-	marshalValue = append(marshalValue, marshalFieldID...)
-	marshalFieldLength, err := marshalBinarySingleValue(fsp.FieldLength)
-	if err != nil {
-		return nil, err
-	}
-	marshalValue = append(marshalValue, marshalFieldLength...)
-
+	dst = binary.BigEndian.AppendUint16(dst, fieldid)
+	dst = binary.BigEndian.AppendUint16(dst, fsp.FieldLength)
 	if fsp.E {
-		marshalEnterpriseID, err := marshalBinarySingleValue(fsp.EnterpriseNumber)
-		if err != nil {
-			return nil, err
-		}
-		marshalValue = append(marshalValue, marshalEnterpriseID...)
+		dst = binary.BigEndian.AppendUint32(dst, fsp.EnterpriseNumber)
 	}
-
-	return marshalValue, nil
+	return dst, nil
 }
 
 // UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
 func (fsp *FieldSpecifier) UnmarshalBinary(data []byte) error {
-	if data == nil || len(data) == 0 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+	if data == nil || len(data) < 4 {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
 	}
-	if (data[0] & 128) != 0 {
-		fsp.E = true
-		data[0] = data[0] & 127 //Remove the bit
-	} else {
-		fsp.E = false
-		fsp.EnterpriseNumber = 0
+	if (data[0]&128) != 0 && len(data) < 8 {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, enterprise bit set but no enterprise number. %#v", data), ErrCritical)
 	}
-	fsp.InformationElementIdentifier = binary.BigEndian.Uint16(data[0:2])
+	fsp.E = (data[0] & 128) != 0
+	fsp.InformationElementIdentifier = binary.BigEndian.Uint16(data[0:2]) & 0x7FFF //Without the enterprise bit. The data is never written to, it may be shared or read-only.
+	fsp.EnterpriseNumber = 0
 	if fsp.E {
-		data[0] = data[0] | 128 //Restore the bit (so we keep the original datablob)
-		fsp.EnterpriseNumber = binary.BigEndian.Uint32(data[4:])
+		fsp.EnterpriseNumber = binary.BigEndian.Uint32(data[4:8])
 	}
 	fsp.FieldLength = binary.BigEndian.Uint16(data[2:4])
 	return nil
@@ -12,6 +12,7 @@ import (
 
 // FieldValue Interface definition
 // Note that all implementations must be pointer receivers so that unmarshal can change the value
+// Implementing the encoding/BinaryAppender interface as well is optional, but saves allocations when marshalling
 type FieldValue interface {
 	MarshalBinary() ([]byte, error)    // Each FieldValue *must* implement the encoding/BinaryMarshaler interface
 	UnmarshalBinary(data []byte) error // Each FieldValue *must* implement the encoding/BinaryUnmarshaler interface
@@ -21,12 +22,38 @@ type FieldValue interface {
 }
 
 func marshalBinarySingleValue(val interface{}) ([]byte, error) {
-	buf := new(bytes.Buffer) //should get from pool?
-	err := binary.Write(buf, binary.BigEndian, val)
-	if err == nil {
-		return buf.Bytes(), nil
+	return binary.Append(nil, binary.BigEndian, val)
+}
+
+// reserveVariableLength appends room for the variable length of a value that is appended next, see putVariableLength
+func reserveVariableLength(dst []byte, rfc6313recommended bool) []byte {
+	if rfc6313recommended {
+		return append(dst, 255, 0, 0)
+	}
+	return append(dst, 0)
+}
+
+// putVariableLength encodes the length of the value that was appended after the room reserved at lenpos, as specified in RFC 7011, section 7.
+// See EncodeVariableLength for rfc6313recommended. The value is appended first, so it is only marshalled once.
+func putVariableLength(dst []byte, lenpos int, rfc6313recommended bool) ([]byte, error) {
+	contentlen := len(dst) - lenpos - 1
+	if rfc6313recommended {
+		contentlen -= 2
+	}
+	switch {
+	case contentlen > 65535:
+		return nil, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Content too large, maximum of 65535 octets, but it is %d", contentlen), ErrCritical)
+	case rfc6313recommended:
+	case contentlen < 255:
+		dst[lenpos] = uint8(contentlen)
+		return dst, nil
+	default: //Make room for the two length octets
+		dst = append(dst, 0, 0)
+		copy(dst[lenpos+3:], dst[lenpos+1:len(dst)-2])
 	}
-	return nil, err
+	dst[lenpos] = 255
+	binary.BigEndian.PutUint16(dst[lenpos+1:lenpos+3], uint16(contentlen))
+	return dst, nil
 }
 
 func unmarshalBinaryOctets(data []byte, val interface{}) error {
@@ -34,10 +61,48 @@ func unmarshalBinaryOctets(data []byte, val interface{}) error {
 	return binary.Read(buf, binary.BigEndian, val)
 }
 
-var (
-	// bufferfiller is used when the exporting process encodes a value in less bytes than real length. See below for explanation
-	bufferfiller = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+// listFieldValue is implemented by the list types, which decode their elements with the state of the record they are in
+type listFieldValue interface {
+	unmarshal(data []byte, state *decodeState) error
+}
 
+// unmarshalFieldValue decodes data into fv, accounting for the octets and the list nesting in state
+func unmarshalFieldValue(fv FieldValue, data []byte, state *decodeState) error {
+	if limiterr := state.decode(len(data)); limiterr != nil {
+		return limiterr
+	}
+	list, ok := fv.(listFieldValue)
+	if !ok {
+		return fv.UnmarshalBinary(data)
+	}
+	if limiterr := state.enterList(); limiterr != nil {
+		return limiterr
+	}
+	defer state.leaveList()
+	return list.unmarshal(data, state)
+}
+
+// ntpTime decodes the NTP timestamp at the start of data, as used by dateTimeMicroseconds and dateTimeNanoseconds
+func ntpTime(data []byte) time.Time {
+	baseValueSeconds := int64(binary.BigEndian.Uint32(data[:4])) - int64(epochDelta)
+	baseValueFractions := (int64(1+binary.BigEndian.Uint32(data[4:8])) * 1e9) >> 32 //Yeah... that offset... for some reason it is necessary
+	return time.Unix(baseValueSeconds, baseValueFractions)
+}
+
+// leftPad is used when the exporting process encodes a value in less bytes than real length. See below for explanation
+// Signed values are sign extended, unsigned values are prepended with zeroes.
+func leftPad(data []byte, size int, signed bool) []byte {
+	padded := make([]byte, size)
+	if signed && len(data) > 0 && data[0]&128 != 0 {
+		for idx := range padded {
+			padded[idx] = 255
+		}
+	}
+	copy(padded[size-len(data):], data)
+	return padded
+}
+
+var (
 	//Reduced-size encoding MAY be applied to the following integer types:
 	//unsigned64, signed64, unsigned32, signed32, unsigned16, and signed16.
 	//The signed versus unsigned property of the reported value MUST be preserved.
@@ -49,6 +114,37 @@ var (
 	//Reduced-size encoding MUST NOT be applied to any other data type defined in [RFC7012] that implies a fixed length, as these types either have internal structure (such as ipv4Address or dateTimeMicroseconds) or restricted ranges that are not suitable for reduced-size encoding (such as dateTimeMilliseconds).
 )
 
+// reduceSize returns the reduced-size encoding of the marshalled field value so that it fits in fieldlength octets.
+// Only the leading octets that carry no information (zeroes, or the sign extension for signed types) can be dropped.
+// The encoding is done within data, which is overwritten.
+func reduceSize(fv FieldValue, data []byte, fieldlength uint16) ([]byte, error) {
+	if int(fieldlength) >= len(data) {
+		return data, nil
+	}
+	drop := len(data) - int(fieldlength)
+	switch fv.(type) {
+	case *FieldValueUnsigned16, *FieldValueUnsigned32, *FieldValueUnsigned64:
+		if bytes.Count(data[:drop], []byte{0}) == drop {
+			return data[drop:], nil
+		}
+	case *FieldValueSigned16, *FieldValueSigned32, *FieldValueSigned64:
+		extension := byte(0)
+		if data[drop]&128 != 0 {
+			extension = 255
+		}
+		if bytes.Count(data[:drop], []byte{extension}) == drop {
+			return data[drop:], nil
+		}
+	case *FieldValueFloat64:
+		if fieldlength == 4 {
+			return binary.BigEndian.AppendUint32(data[:0], math.Float32bits(float32(fv.(*FieldValueFloat64).value))), nil
+		}
+	default:
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Reduced-size encoding can not be applied to %s", reflect.TypeOf(fv)), ErrCritical)
+	}
+	return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Value %v does not fit in %d octets", fv.Value(), fieldlength), ErrCritical)
+}
+
 /* */
 // FieldValueUnsigned8 , "unsigned8" represents a non-negative integer value in the range of 0 to 255.
 type FieldValueUnsigned8 struct {
@@ -57,13 +153,18 @@ type FieldValueUnsigned8 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueUnsigned8) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueUnsigned8) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, fv.value), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueUnsigned8) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	fv.value = data[0]
 	return nil
@@ -85,7 +186,7 @@ func (fv *FieldValueUnsigned8) Set(val interface{}) error {
 	case uint8:
 		fv.value = val.(uint8)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -98,16 +199,21 @@ type FieldValueUnsigned16 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueUnsigned16) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueUnsigned16) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint16(dst, fv.value), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueUnsigned16) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) < 2 {
 		//We prepend 0s (zeroes) if the exporter encoded it in less bytes than we need
-		fv.value = binary.BigEndian.Uint16(append(bufferfiller[:1], data...))
+		fv.value = binary.BigEndian.Uint16(leftPad(data, 2, false))
 	} else {
 		fv.value = binary.BigEndian.Uint16(data)
 	}
@@ -130,7 +236,7 @@ func (fv *FieldValueUnsigned16) Set(val interface{}) error {
 	case uint16:
 		fv.value = val.(uint16)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -143,16 +249,21 @@ type FieldValueUnsigned32 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueUnsigned32) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueUnsigned32) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint32(dst, fv.value), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueUnsigned32) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) < 4 {
 		//We prepend 0s if the exporter encoded it in less bytes than we need
-		fv.value = binary.BigEndian.Uint32(append(bufferfiller[:4-len(data)], data...))
+		fv.value = binary.BigEndian.Uint32(leftPad(data, 4, false))
 	} else {
 		fv.value = binary.BigEndian.Uint32(data)
 	}
@@ -175,7 +286,7 @@ func (fv *FieldValueUnsigned32) Set(val interface{}) error {
 	case uint32:
 		fv.value = val.(uint32)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -188,16 +299,21 @@ type FieldValueUnsigned64 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueUnsigned64) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueUnsigned64) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, fv.value), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueUnsigned64) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) < 8 {
 		//We prepend 0s if the exporter encoded it in less bytes than we need
-		fv.value = binary.BigEndian.Uint64(append(bufferfiller[:8-len(data)], data...))
+		fv.value = binary.BigEndian.Uint64(leftPad(data, 8, false))
 	} else {
 		fv.value = binary.BigEndian.Uint64(data)
 	}
@@ -220,7 +336,7 @@ func (fv *FieldValueUnsigned64) Set(val interface{}) error {
 	case uint64:
 		fv.value = val.(uint64)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -233,13 +349,18 @@ type FieldValueSigned8 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueSigned8) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueSigned8) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, byte(fv.value)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueSigned8) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	fv.value = int8(data[0])
 	return nil
@@ -261,7 +382,7 @@ func (fv *FieldValueSigned8) Set(val interface{}) error {
 	case int8:
 		fv.value = val.(int8)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -274,16 +395,21 @@ type FieldValueSigned16 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueSigned16) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueSigned16) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint16(dst, uint16(fv.value)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueSigned16) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) < 2 {
 		//We prepend 0s if the exporter encoded it in less bytes than we need
-		fv.value = int16(binary.BigEndian.Uint16(append(bufferfiller[:2-len(data)], data...)))
+		fv.value = int16(binary.BigEndian.Uint16(leftPad(data, 2, true)))
 	} else {
 		fv.value = int16(binary.BigEndian.Uint16(data))
 	}
@@ -306,7 +432,7 @@ func (fv *FieldValueSigned16) Set(val interface{}) error {
 	case int16:
 		fv.value = val.(int16)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -319,16 +445,21 @@ type FieldValueSigned32 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueSigned32) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueSigned32) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint32(dst, uint32(fv.value)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueSigned32) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) < 4 {
 		//We prepend 0s if the exporter encoded it in less bytes than we need
-		fv.value = int32(binary.BigEndian.Uint32(append(bufferfiller[:4-len(data)], data...)))
+		fv.value = int32(binary.BigEndian.Uint32(leftPad(data, 4, true)))
 	} else {
 		fv.value = int32(binary.BigEndian.Uint32(data))
 	}
@@ -351,7 +482,7 @@ func (fv *FieldValueSigned32) Set(val interface{}) error {
 	case int32:
 		fv.value = val.(int32)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -364,16 +495,21 @@ type FieldValueSigned64 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueSigned64) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueSigned64) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, uint64(fv.value)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueSigned64) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) < 8 {
 		//We prepend 0s if the exporter encoded it in less bytes than we need
-		fv.value = int64(binary.BigEndian.Uint64(append(bufferfiller[:8-len(data)], data...)))
+		fv.value = int64(binary.BigEndian.Uint64(leftPad(data, 8, true)))
 	} else {
 		fv.value = int64(binary.BigEndian.Uint64(data))
 	}
@@ -396,7 +532,7 @@ func (fv *FieldValueSigned64) Set(val interface{}) error {
 	case int64:
 		fv.value = val.(int64)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -409,13 +545,18 @@ type FieldValueFloat32 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueFloat32) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueFloat32) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint32(dst, math.Float32bits(fv.value)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueFloat32) UnmarshalBinary(data []byte) error {
 	if len(data) < 4 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	fv.value = math.Float32frombits(binary.BigEndian.Uint32(data))
 	return nil
@@ -437,7 +578,7 @@ func (fv *FieldValueFloat32) Set(val interface{}) error {
 	case float32:
 		fv.value = val.(float32)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -450,19 +591,24 @@ type FieldValueFloat64 struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueFloat64) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueFloat64) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, math.Float64bits(fv.value)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueFloat64) UnmarshalBinary(data []byte) error {
 	if len(data) < 4 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	} else if len(data) == 4 {
 		fv.value = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
 	} else if len(data) == 8 {
 		fv.value = math.Float64frombits(binary.BigEndian.Uint64(data))
 	} else {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	return nil
 }
@@ -483,7 +629,7 @@ func (fv *FieldValueFloat64) Set(val interface{}) error {
 	case float64:
 		fv.value = val.(float64)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -499,16 +645,21 @@ type FieldValueBoolean struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueBoolean) MarshalBinary() ([]byte, error) {
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueBoolean) AppendBinary(dst []byte) ([]byte, error) {
 	if fv.value {
-		return marshalBinarySingleValue(uint8(1))
+		return append(dst, 1), nil
 	}
-	return marshalBinarySingleValue(uint8(2))
+	return append(dst, 2), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueBoolean) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	switch data[0] {
 	case 1:
@@ -516,7 +667,7 @@ func (fv *FieldValueBoolean) UnmarshalBinary(data []byte) error {
 	case 2:
 		fv.value = false
 	default:
-		return NewError(fmt.Sprintf("Invalid encoded value for boolean: %d, must be either 1 or 2", data[0]), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid encoded value for boolean: %d, must be either 1 or 2", data[0]), ErrCritical)
 	}
 	return nil
 }
@@ -537,7 +688,7 @@ func (fv *FieldValueBoolean) Set(val interface{}) error {
 	case bool:
 		fv.value = val.(bool)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -551,13 +702,18 @@ type FieldValueMacAddress struct {
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 // Address types -- macAddress, ipv4Address, and ipv6Address -- MUST be encoded the same way as the integral data types, as six, four, and sixteen octets in network byte order, respectively.
 func (fv *FieldValueMacAddress) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue(fv.value)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueMacAddress) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, fv.value...), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueMacAddress) UnmarshalBinary(data []byte) error {
 	if len(data) < 6 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	tmpval := make([]byte, len(data))
 	err := unmarshalBinaryOctets(data, tmpval)
@@ -584,7 +740,7 @@ func (fv *FieldValueMacAddress) Set(val interface{}) error {
 	case net.HardwareAddr:
 		fv.value = val.(net.HardwareAddr)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -598,11 +754,12 @@ type FieldValueOctetArray struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueOctetArray) MarshalBinary() ([]byte, error) {
-	marshalValue, err := marshalBinarySingleValue(fv.value)
-	if err != nil {
-		return []byte{}, err
-	}
-	return marshalValue, nil
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueOctetArray) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, fv.value...), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
@@ -629,7 +786,7 @@ func (fv *FieldValueOctetArray) Set(val interface{}) error {
 	case []byte:
 		fv.value = val.([]byte)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -644,7 +801,12 @@ type FieldValueString struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueString) MarshalBinary() ([]byte, error) {
-	return marshalBinarySingleValue([]byte(fv.value))
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueString) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, fv.value...), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
@@ -676,7 +838,7 @@ func (fv *FieldValueString) Set(val interface{}) error {
 	case []byte:
 		fv.value = string(val.([]byte))
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -691,14 +853,18 @@ type FieldValueDateTimeSeconds struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueDateTimeSeconds) MarshalBinary() ([]byte, error) {
-	marshalValue := uint32(fv.value.Unix())
-	return marshalBinarySingleValue(marshalValue)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueDateTimeSeconds) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint32(dst, uint32(fv.value.Unix())), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueDateTimeSeconds) UnmarshalBinary(data []byte) error {
 	if len(data) < 4 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	secondsSinceEpoch := binary.BigEndian.Uint32(data)
 	fv.value = time.Unix(int64(secondsSinceEpoch), 0)
@@ -721,7 +887,7 @@ func (fv *FieldValueDateTimeSeconds) Set(val interface{}) error {
 	case time.Time:
 		fv.value = val.(time.Time)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -735,18 +901,22 @@ type FieldValueDateTimeMilliseconds struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueDateTimeMilliseconds) MarshalBinary() ([]byte, error) {
-	marshalValue := uint64(fv.value.UnixNano() / 1000000)
-	return marshalBinarySingleValue(marshalValue)
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueDateTimeMilliseconds) AppendBinary(dst []byte) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, uint64(fv.value.UnixNano()/1000000)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueDateTimeMilliseconds) UnmarshalBinary(data []byte) error {
 	if len(data) < 8 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
 	milliSecondsSinceEpoch := binary.BigEndian.Uint64(data)
 	secondsSinceEpoch := uint64(milliSecondsSinceEpoch) / uint64(1000)
-	nanosecondsSinceEpoch := uint64(milliSecondsSinceEpoch) % uint64(1000)
+	nanosecondsSinceEpoch := (uint64(milliSecondsSinceEpoch) % uint64(1000)) * uint64(1000000)
 	fv.value = time.Unix(int64(secondsSinceEpoch), int64(nanosecondsSinceEpoch))
 	return nil
 }
@@ -767,7 +937,7 @@ func (fv *FieldValueDateTimeMilliseconds) Set(val interface{}) error {
 	case time.Time:
 		fv.value = val.(time.Time)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -789,35 +959,23 @@ type FieldValueDateTimeMicroseconds struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueDateTimeMicroseconds) MarshalBinary() ([]byte, error) {
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueDateTimeMicroseconds) AppendBinary(dst []byte) ([]byte, error) {
 	marshalValueSeconds := fv.value.Unix() + int64(epochDelta)
 	marshalValueFractions := ((uint64(fv.value.UnixNano()) % 1e9) << 32) / 1e9
-
-	marshalSec, err := marshalBinarySingleValue(uint32(marshalValueSeconds))
-	if err != nil {
-		return nil, err
-	}
-	marshalFrac, err := marshalBinarySingleValue(uint32(marshalValueFractions))
-	if err != nil {
-		return nil, err
-	}
-
-	marshalValue := make([]byte, 0, 0)
-	marshalValue = append(marshalValue, marshalSec...)
-	marshalValue = append(marshalValue, marshalFrac...)
-	if len(marshalValue) != 8 {
-		return nil, NewError(fmt.Sprintf("Incorrect length when marshalling. Wanted %d, got %d.", 8, len(marshalValue)), ErrCritical)
-	}
-	return marshalValue, nil
+	dst = binary.BigEndian.AppendUint32(dst, uint32(marshalValueSeconds))
+	return binary.BigEndian.AppendUint32(dst, uint32(marshalValueFractions)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueDateTimeMicroseconds) UnmarshalBinary(data []byte) error {
 	if len(data) < 8 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
-	baseValueSeconds := int64(binary.BigEndian.Uint32(data[:4])) - int64(epochDelta)
-	baseValueFractions := (int64(1+binary.BigEndian.Uint32(data[4:])) * 1e9) >> 32 //Yeah... that offset... for some reason it is necessary
-	fv.value = time.Unix(baseValueSeconds, baseValueFractions)
+	fv.value = ntpTime(data)
 	return nil
 }
 
@@ -837,7 +995,7 @@ func (fv *FieldValueDateTimeMicroseconds) Set(val interface{}) error {
 	case time.Time:
 		fv.value = val.(time.Time)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -857,35 +1015,23 @@ type FieldValueDateTimeNanoseconds struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueDateTimeNanoseconds) MarshalBinary() ([]byte, error) {
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueDateTimeNanoseconds) AppendBinary(dst []byte) ([]byte, error) {
 	marshalValueSeconds := fv.value.Unix() + int64(epochDelta)
 	marshalValueFractions := ((uint64(fv.value.UnixNano()) % 1e9) << 32) / 1e9 //We only want the remainder of nanoseconds
-
-	marshalSec, err := marshalBinarySingleValue(uint32(marshalValueSeconds))
-	if err != nil {
-		return nil, err
-	}
-	marshalFrac, err := marshalBinarySingleValue(uint32(marshalValueFractions))
-	if err != nil {
-		return nil, err
-	}
-
-	marshalValue := make([]byte, 0, 0)
-	marshalValue = append(marshalValue, marshalSec...)
-	marshalValue = append(marshalValue, marshalFrac...)
-	if len(marshalValue) != 8 {
-		return nil, NewError(fmt.Sprintf("Incorrect length when marshalling. Wanted %d, got %d.", 8, len(marshalValue)), ErrCritical)
-	}
-	return marshalValue, nil
+	dst = binary.BigEndian.AppendUint32(dst, uint32(marshalValueSeconds))
+	return binary.BigEndian.AppendUint32(dst, uint32(marshalValueFractions)), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
 func (fv *FieldValueDateTimeNanoseconds) UnmarshalBinary(data []byte) error {
 	if len(data) < 8 {
-		return NewError(fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data. Need length %d, but got %d.", fv.Len(), len(data)), ErrCritical)
 	}
-	baseValueSeconds := int64(binary.BigEndian.Uint32(data[:4])) - int64(epochDelta)
-	baseValueFractions := (int64(1+binary.BigEndian.Uint32(data[4:])) * 1e9) >> 32 //Yeah... that offset... for some reason it is necessary
-	fv.value = time.Unix(baseValueSeconds, baseValueFractions)
+	fv.value = ntpTime(data)
 	return nil
 }
 
@@ -905,7 +1051,7 @@ func (fv *FieldValueDateTimeNanoseconds) Set(val interface{}) error {
 	case time.Time:
 		fv.value = val.(time.Time)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -918,11 +1064,12 @@ type FieldValueIPv4Address struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueIPv4Address) MarshalBinary() ([]byte, error) {
-	marshalValue, err := marshalBinarySingleValue(fv.value.To4())
-	if err != nil {
-		return []byte{}, err
-	}
-	return marshalValue, nil
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueIPv4Address) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, fv.value.To4()...), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
@@ -949,12 +1096,12 @@ func (fv *FieldValueIPv4Address) Set(val interface{}) error {
 		if tmpip != nil {
 			fv.value = tmpip
 		} else {
-			return NewError(fmt.Sprintf("Value is not an IP Address: %s", val.(string)), ErrCritical)
+			return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Value is not an IP Address: %s", val.(string)), ErrCritical)
 		}
 	case net.IP:
 		fv.value = val.(net.IP)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -967,11 +1114,12 @@ type FieldValueIPv6Address struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueIPv6Address) MarshalBinary() ([]byte, error) {
-	marshalValue, err := marshalBinarySingleValue(fv.value.To16())
-	if err != nil {
-		return []byte{}, err
-	}
-	return marshalValue, nil
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueIPv6Address) AppendBinary(dst []byte) ([]byte, error) {
+	return append(dst, fv.value.To16()...), nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
@@ -998,12 +1146,12 @@ func (fv *FieldValueIPv6Address) Set(val interface{}) error {
 		if tmpip != nil {
 			fv.value = tmpip
 		} else {
-			return NewError(fmt.Sprintf("Value is not an IP Address: %s", val.(string)), ErrCritical)
+			return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Value is not an IP Address: %s", val.(string)), ErrCritical)
 		}
 	case net.IP:
 		fv.value = val.(net.IP)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -1016,108 +1164,102 @@ type FieldValueBasicList struct {
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueBasicList) MarshalBinary() ([]byte, error) {
-	marshalValue := make([]byte, 0, 0)
-	marshalValue = append(marshalValue, fv.value.Semantic)
-	marshalFieldID, err := marshalBinarySingleValue(fv.value.InformationElementIdentifier)
-	if err != nil {
-		return nil, err
-	}
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueBasicList) AppendBinary(dst []byte) ([]byte, error) {
+	dst = append(dst, fv.value.Semantic)
+	fieldid := fv.value.InformationElementIdentifier
 	if fv.value.E {
-		marshalFieldID[0] = marshalFieldID[0] | 128 //Setting the EnterpriseID bit
-	}
-	marshalValue = append(marshalValue, marshalFieldID...)
-	marshalFieldLength, err := marshalBinarySingleValue(fv.value.FieldLength)
-	if err != nil {
-		return nil, err
+		fieldid |= 0x8000 //Setting the EnterpriseID bit
 	}
-	marshalValue = append(marshalValue, marshalFieldLength...)
+	dst = binary.BigEndian.AppendUint16(dst, fieldid)
+	dst = binary.BigEndian.AppendUint16(dst, fv.value.FieldLength)
 	if fv.value.E {
-		marshalEnterpriseID, err := marshalBinarySingleValue(fv.value.EnterpriseNumber)
-		if err != nil {
-			return nil, err
-		}
-		marshalValue = append(marshalValue, marshalEnterpriseID...)
+		dst = binary.BigEndian.AppendUint32(dst, fv.value.EnterpriseNumber)
 	}
 
+	var err error
 	for _, listitem := range fv.value.FieldValues {
-		itemdata, err := listitem.MarshalBinary()
+		if fv.value.FieldLength != VariableLength {
+			dst, err = appendBinary(dst, listitem)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rfc6313recommended := false
+		switch listitem.(type) {
+		case *FieldValueBasicList, *FieldValueSubTemplateList, *FieldValueSubTemplateMultiList:
+			rfc6313recommended = true
+		}
+		lenpos := len(dst)
+		dst, err = appendBinary(reserveVariableLength(dst, rfc6313recommended), listitem)
 		if err != nil {
 			return nil, err
 		}
-		if fv.value.FieldLength == VariableLength {
-			var marshalLength []byte
-			switch listitem.(type) {
-			case *FieldValueBasicList, *FieldValueSubTemplateList, *FieldValueSubTemplateMultiList:
-				marshalLength, err = EncodeVariableLength(itemdata, true)
-				if err != nil {
-					return nil, err
-				}
-
-			default:
-				marshalLength, err = EncodeVariableLength(itemdata, false)
-				if err != nil {
-					return nil, err
-				}
-			}
-			marshalValue = append(marshalValue, marshalLength...)
+		dst, err = putVariableLength(dst, lenpos, rfc6313recommended)
+		if err != nil {
+			return nil, err
 		}
-		marshalValue = append(marshalValue, itemdata...)
 	}
-
-	return marshalValue, nil
+	return dst, nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
+// The DefaultLimits apply.
 func (fv *FieldValueBasicList) UnmarshalBinary(data []byte) error {
+	return unmarshalFieldValue(fv, data, newDecodeState(DefaultLimits))
+}
+
+// unmarshal fills the value from data, accounting for the elements in state
+func (fv *FieldValueBasicList) unmarshal(data []byte, state *decodeState) error {
 	fv.value = BasicList{}
 	fv.value.FieldValues = make([]FieldValue, 0, 0)
 
-	fv.value.Semantic = data[0]
-	if (data[1] & 128) != 0 {
-		fv.value.E = true
-		data[1] = data[1] & 127 //Remove the bit
-	}
-	fv.value.InformationElementIdentifier = binary.BigEndian.Uint16(data[1:3])
-	if fv.value.E {
-		data[1] = data[1] | 128 //Restore the bit (so we keep the original datablob)
+	if len(data) < 5 {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
 	}
+	fv.value.Semantic = data[0]
+	fv.value.E = (data[1] & 128) != 0
+	fv.value.InformationElementIdentifier = binary.BigEndian.Uint16(data[1:3]) & 0x7FFF //Without the enterprise bit. The data is never written to, it may be shared or read-only.
 	fv.value.FieldLength = binary.BigEndian.Uint16(data[3:5])
+	if fv.value.FieldLength == 0 {
+		return NewCategoryError(ErrMalformedLength, "Basic list can not have a field length of 0", ErrCritical).AtOffset(3)
+	}
 
 	cursor := int(5)
 	if fv.value.E {
+		if len(data) < 9 {
+			return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, enterprise bit set but no enterprise number. %#v", data), ErrCritical)
+		}
 		fv.value.EnterpriseNumber = binary.BigEndian.Uint32(data[cursor : cursor+4])
 		cursor += 4
 	}
-	for fv.value.FieldLength != VariableLength && cursor+int(fv.value.FieldLength) <= len(data) ||
-		(fv.value.FieldLength == VariableLength && cursor < len(data)) {
+	for cursor < len(data) {
+		newval, err := NewFieldValueByID(fv.value.EnterpriseNumber, fv.value.InformationElementIdentifier)
+		if err != nil {
+			newval = &FieldValueOctetArray{} //Unknown Information Elements are kept as raw octets, like in a data record
+		}
+		fieldlength := int(fv.value.FieldLength)
 		if fv.value.FieldLength == VariableLength {
-			fieldlength, cursorshift, err := DecodeVariableLength(data[cursor : cursor+3]) //Max. 3 positions cursor shift
+			varlength, cursorshift, err := DecodeVariableLength(data[cursor:])
 			if err != nil {
-				return err
+				return errorAtOffset(err, cursor)
 			}
 			cursor += int(cursorshift)
-			newval, err := NewFieldValueByID(fv.value.EnterpriseNumber, fv.value.InformationElementIdentifier)
-			if err != nil {
-				return err
-			}
-			err = newval.UnmarshalBinary(data[cursor : cursor+int(fieldlength)])
-			if err != nil {
-				return err
-			}
-			cursor += int(fieldlength)
-			fv.value.FieldValues = append(fv.value.FieldValues, newval)
-		} else {
-			newval, err := NewFieldValueByID(fv.value.EnterpriseNumber, fv.value.InformationElementIdentifier)
-			if err != nil {
-				return err
-			}
-			err = newval.UnmarshalBinary(data[cursor : cursor+int(fv.value.FieldLength)])
-			if err != nil {
-				return err
-			}
-			cursor += int(fv.value.FieldLength)
-			fv.value.FieldValues = append(fv.value.FieldValues, newval)
+			fieldlength = int(varlength)
+		}
+		if cursor+fieldlength > len(data) {
+			return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Insufficient data to decode basic list element. Needed %d, but have %d", fieldlength, len(data)-cursor), ErrCritical).AtOffset(cursor)
 		}
+		err = unmarshalFieldValue(newval, data[cursor:cursor+fieldlength], state)
+		if err != nil {
+			return errorAtOffset(err, cursor)
+		}
+		cursor += fieldlength
+		fv.value.FieldValues = append(fv.value.FieldValues, newval)
 	}
 
 	return nil
@@ -1139,7 +1281,7 @@ func (fv *FieldValueBasicList) Set(val interface{}) error {
 	case BasicList:
 		fv.value = val.(BasicList)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -1153,7 +1295,7 @@ type FieldValueSubTemplateList struct {
 // SetAssiocatedTemplates sets the list of templates belonging to this session
 func (fv *FieldValueSubTemplateList) SetAssiocatedTemplates(at *ActiveTemplates) error {
 	if at == nil {
-		return NewError("Can not set associated templates to nil", ErrCritical)
+		return NewCategoryError(ErrNoTemplates, "Can not set associated templates to nil", ErrCritical)
 	}
 	fv.value.AssociatedTemplates = at
 	return nil
@@ -1161,63 +1303,79 @@ func (fv *FieldValueSubTemplateList) SetAssiocatedTemplates(at *ActiveTemplates)
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueSubTemplateList) MarshalBinary() ([]byte, error) {
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueSubTemplateList) AppendBinary(dst []byte) ([]byte, error) {
 	if fv.value.AssociatedTemplates == nil {
-		return nil, NewError("Can not marshal without associated templates", ErrCritical)
+		return nil, NewCategoryError(ErrNoTemplates, "Can not marshal without associated templates", ErrCritical)
 	}
 	if fv.value.TemplateID < 256 {
 		return nil, NewError("Can not marshal without a template id", ErrCritical)
 	}
-	marshalValue := make([]byte, 0, 0)
-	marshalValue = append(marshalValue, fv.value.Semantic)
-
-	marshalTemplateID, err := marshalBinarySingleValue(fv.value.TemplateID)
-	if err != nil {
-		return nil, err
-	}
-	marshalValue = append(marshalValue, marshalTemplateID...)
+	dst = append(dst, fv.value.Semantic)
+	dst = binary.BigEndian.AppendUint16(dst, fv.value.TemplateID)
+	var err error
 	for _, listitem := range fv.value.Records {
 		listitem.(*DataRecord).AssociateTemplates(fv.value.AssociatedTemplates)
 		listitem.(*DataRecord).SetTemplateID(fv.value.TemplateID)
-		recordBinary, err := listitem.(*DataRecord).MarshalBinary()
+		dst, err = listitem.(*DataRecord).AppendBinary(dst)
 		if err != nil {
 			return nil, err
 		}
-		marshalValue = append(marshalValue, recordBinary...)
 	}
-
-	return marshalValue, nil
+	return dst, nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
+// The DefaultLimits apply.
 func (fv *FieldValueSubTemplateList) UnmarshalBinary(data []byte) error {
+	return unmarshalFieldValue(fv, data, newDecodeState(DefaultLimits))
+}
+
+// unmarshal fills the value from data, accounting for the records in state
+func (fv *FieldValueSubTemplateList) unmarshal(data []byte, state *decodeState) error {
 	if fv.value.AssociatedTemplates == nil {
-		return NewError("Can not marshal without associated templates", ErrFailure) //This is a failure and not critical because we can re-do later
+		return NewCategoryError(ErrNoTemplates, "Can not marshal without associated templates", ErrFailure) //This is a failure and not critical because we can re-do later
 	}
 	if data == nil || len(data) == 0 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
 	}
 
 	fv.value = SubTemplateList{AssociatedTemplates: fv.value.AssociatedTemplates, TemplateID: fv.value.TemplateID} //Create a clean copy with correct data, may not be necessary
 	fv.value.Records = make([]Record, 0, 0)
 
+	if len(data) < 3 {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+	}
 	fv.value.Semantic = data[0]
 	fv.value.TemplateID = binary.BigEndian.Uint16(data[1:3])
 	if fv.value.TemplateID < 256 {
-		return NewError(fmt.Sprintf("Can not marshal without a template id"), ErrCritical)
+		return NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Can not unmarshal without a proper template id, got %d", fv.value.TemplateID), ErrCritical).AtOffset(1)
 	}
-	cursor := uint16(3)
-	for cursor < uint16(len(data)) {
+	if len(data) == 3 { //An empty list does not need its template
+		return nil
+	}
+	plan, err := state.plan(fv.value.AssociatedTemplates, fv.value.TemplateID)
+	if err != nil {
+		return errorAtOffset(err, 1)
+	}
+	cursor := 3
+	for cursor < len(data) {
 		newdatrec := &DataRecord{
 			AssociatedTemplates: fv.value.AssociatedTemplates,
 			TemplateID:          fv.value.TemplateID,
-			FieldValues:         make([]FieldValue, 0, 0),
 		}
-		err := newdatrec.UnmarshalBinary(data[cursor:])
+		reclen, err := plan.decode(newdatrec, data[cursor:], state)
 		if err != nil {
-			return err
+			return errorAtOffset(err, cursor)
+		}
+		if reclen == 0 {
+			return NewCategoryError(ErrMalformedLength, "Can not unmarshal records of length 0", ErrCritical).AtOffset(cursor).InTemplate(fv.value.TemplateID)
 		}
 		fv.value.Records = append(fv.value.Records, newdatrec)
-		cursor += newdatrec.Len()
+		cursor += reclen
 	}
 	return nil
 }
@@ -1238,7 +1396,7 @@ func (fv *FieldValueSubTemplateList) Set(val interface{}) error {
 	case FieldValueSubTemplateList:
 		fv.value = val.(SubTemplateList)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -1252,7 +1410,7 @@ type FieldValueSubTemplateMultiList struct {
 // SetAssiocatedTemplates sets the list of templates belonging to this session
 func (fv *FieldValueSubTemplateMultiList) SetAssiocatedTemplates(at *ActiveTemplates) error {
 	if at == nil {
-		return NewError(fmt.Sprintf("Can not set associated templates to nil"), ErrCritical)
+		return NewCategoryError(ErrNoTemplates, fmt.Sprintf("Can not set associated templates to nil"), ErrCritical)
 	}
 	fv.value.AssociatedTemplates = at
 	return nil
@@ -1260,11 +1418,16 @@ func (fv *FieldValueSubTemplateMultiList) SetAssiocatedTemplates(at *ActiveTempl
 
 // MarshalBinary returns the Network Byte Order byte representation of this Field Value
 func (fv *FieldValueSubTemplateMultiList) MarshalBinary() ([]byte, error) {
+	return fv.AppendBinary(nil)
+}
+
+// AppendBinary appends the Network Byte Order byte representation of this Field Value to dst
+func (fv *FieldValueSubTemplateMultiList) AppendBinary(dst []byte) ([]byte, error) {
 	if fv.value.AssociatedTemplates == nil {
-		return nil, NewError("Can not marshal without associated templates", ErrFailure) //Failure because we may be able to do this later
+		return nil, NewCategoryError(ErrNoTemplates, "Can not marshal without associated templates", ErrFailure) //Failure because we may be able to do this later
 	}
-	marshalValue := make([]byte, 0, 0)
-	marshalValue = append(marshalValue, fv.value.Semantic)
+	dst = append(dst, fv.value.Semantic)
+	var err error
 	for idx, subtpldat := range fv.value.SubTemplates {
 		if subtpldat.AssociatedTemplates == nil {
 			subtpldat.AssociateTemplates(fv.value.AssociatedTemplates)
@@ -1272,79 +1435,96 @@ func (fv *FieldValueSubTemplateMultiList) MarshalBinary() ([]byte, error) {
 		if subtpldat.TemplateID < 256 {
 			return nil, NewError(fmt.Sprintf("Can not marshal without a template id. Error in sub template %d (%#v)", idx, *subtpldat), ErrCritical)
 		}
+		start := len(dst)
+		dst = binary.BigEndian.AppendUint16(dst, subtpldat.TemplateID)
+		dst = append(dst, 0, 0) //Data Records Length, filled in when the records are appended
 
-		marshalTemplateID, err := marshalBinarySingleValue(subtpldat.TemplateID)
-		if err != nil {
-			return nil, err
+		for _, listitem := range subtpldat.Records {
+			listitem.(*DataRecord).AssociateTemplates(subtpldat.AssociatedTemplates)
+			listitem.(*DataRecord).SetTemplateID(subtpldat.TemplateID)
+			dst, err = listitem.(*DataRecord).AppendBinary(dst)
+			if err != nil {
+				return nil, err
+			}
 		}
-		marshalValue = append(marshalValue, marshalTemplateID...)
 
 		// Data Records Length
 		// This is the total length of the Data Records encoding for the Template ID previously specified, including the two bytes for the Template ID and the two bytes for the Data Records Length field itself.
 		// In the exceptional case of zero instances in the subTemplateMultiList, no data is encoded, only the Semantic field and Template ID field(s), and the Data Record Length field is set to zero.
-		enclen := uint16(subtpldat.Len() + uint16(4))
+		enclen := len(dst) - start
 		if len(subtpldat.Records) == 0 {
-			enclen = uint16(0)
-		}
-		marshalLen, err := marshalBinarySingleValue(enclen)
-		if err != nil {
-			return nil, err
+			enclen = 0
 		}
-		marshalValue = append(marshalValue, marshalLen...)
-
-		for _, listitem := range subtpldat.Records {
-			listitem.(*DataRecord).AssociateTemplates(subtpldat.AssociatedTemplates)
-			listitem.(*DataRecord).SetTemplateID(subtpldat.TemplateID)
-			recordBinary, err := listitem.(*DataRecord).MarshalBinary()
-			if err != nil {
-				return nil, err
-			}
-			marshalValue = append(marshalValue, recordBinary...)
+		if enclen > 65535 {
+			return nil, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Sub template %d too large, maximum of 65535 octets, but it is %d", idx, enclen), ErrCritical).InTemplate(subtpldat.TemplateID)
 		}
+		binary.BigEndian.PutUint16(dst[start+2:start+4], uint16(enclen))
 	}
-	return marshalValue, nil
+	return dst, nil
 }
 
 // UnmarshalBinary fills the value from Network Byte Order byte representation
+// The DefaultLimits apply.
 func (fv *FieldValueSubTemplateMultiList) UnmarshalBinary(data []byte) error {
+	return unmarshalFieldValue(fv, data, newDecodeState(DefaultLimits))
+}
+
+// unmarshal fills the value from data, accounting for the records in state
+func (fv *FieldValueSubTemplateMultiList) unmarshal(data []byte, state *decodeState) error {
 	if fv.value.AssociatedTemplates == nil {
-		return NewError("Can not marshal without associated templates", ErrFailure) //Failure because we may be able to do this later
+		return NewCategoryError(ErrNoTemplates, "Can not marshal without associated templates", ErrFailure) //Failure because we may be able to do this later
 	}
 	if data == nil || len(data) == 0 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
 	}
 
 	fv.value = SubTemplateMultiList{AssociatedTemplates: fv.value.AssociatedTemplates, SubTemplates: make([]*SubTemplateData, 0, 0)} //Create a clean copy with correct data, may not be necessary
 
-	cursor := uint16(1)
-	for cursor < uint16(len(data)) {
+	fv.value.Semantic = data[0]
+	cursor := 1
+	for cursor < len(data) {
+		if cursor+4 > len(data) {
+			return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data to decode sub template header. Have %d", len(data)-cursor), ErrCritical).AtOffset(cursor)
+		}
 		newtplid := binary.BigEndian.Uint16(data[cursor : cursor+2])
 		if newtplid < 256 {
-			return NewError("Can not unmarshal without a proper template id", ErrCritical)
+			return NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Can not unmarshal without a proper template id, got %d", newtplid), ErrCritical).AtOffset(cursor)
 		}
-		cursor += 2
-		newtpllen := binary.BigEndian.Uint16(data[cursor : cursor+2])
+		newtpllen := int(binary.BigEndian.Uint16(data[cursor+2 : cursor+4]))
 		newsubtemplate, err := NewSubTemplateData(newtplid)
 		if err != nil {
 			return err
 		}
-		cursor += 2
-		if newtpllen > 4 { //Length is including template and length itself
-			newsubtemplate.AssociateTemplates(fv.value.AssociatedTemplates)
-			for cursor < newtpllen-4 {
-				newdatrec := &DataRecord{
-					AssociatedTemplates: fv.value.AssociatedTemplates,
-					TemplateID:          newtplid,
-					FieldValues:         make([]FieldValue, 0, 0),
-				}
-				err := newdatrec.UnmarshalBinary(data[cursor:])
-				if err != nil {
-					return err
-				}
-				newsubtemplate.Records = append(newsubtemplate.Records, newdatrec)
-				cursor += newdatrec.Len()
+		newsubtemplate.AssociateTemplates(fv.value.AssociatedTemplates)
+		if newtpllen == 0 { //Zero instances, only the template id and length are encoded
+			newtpllen = 4
+		}
+		if newtpllen < 4 || cursor+newtpllen > len(data) {
+			return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid sub template length %d, have %d", newtpllen, len(data)-cursor), ErrCritical).AtOffset(cursor + 2).InTemplate(newtplid)
+		}
+		end := cursor + newtpllen //Length is including template and length itself
+		var plan *decodePlan
+		if newtpllen > 4 {
+			plan, err = state.plan(fv.value.AssociatedTemplates, newtplid)
+			if err != nil {
+				return errorAtOffset(err, cursor)
+			}
+		}
+		cursor += 4
+		for cursor < end {
+			newdatrec := &DataRecord{
+				AssociatedTemplates: fv.value.AssociatedTemplates,
+				TemplateID:          newtplid,
+			}
+			reclen, err := plan.decode(newdatrec, data[cursor:end], state)
+			if err != nil {
+				return errorAtOffset(err, cursor)
+			}
+			if reclen == 0 {
+				return NewCategoryError(ErrMalformedLength, "Can not unmarshal records of length 0", ErrCritical).AtOffset(cursor).InTemplate(newtplid)
 			}
-			cursor += newtpllen
+			newsubtemplate.Records = append(newsubtemplate.Records, newdatrec)
+			cursor += reclen
 		}
 		fv.value.SubTemplates = append(fv.value.SubTemplates, newsubtemplate)
 	}
@@ -1367,7 +1547,7 @@ func (fv *FieldValueSubTemplateMultiList) Set(val interface{}) error {
 	case FieldValueSubTemplateMultiList:
 		fv.value = val.(SubTemplateMultiList)
 	default:
-		return NewError(fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid type for %s", reflect.TypeOf(fv)), ErrCritical)
 	}
 	return nil
 }
@@ -0,0 +1,646 @@
+package ipfix
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"weak"
+)
+
+/*
+
+A Filter selects Data Records with an expression over the names of their Information Elements:
+
+	filter, err := ParseFilter(`protocolIdentifier == 6 && destinationTransportPort in [80, 443] && sourceIPv4Address in 10.0.0.0/8`)
+	matches, err := filter.Match(datrec)
+
+The expression language:
+
+  - comparisons of a field with a value: ==, !=, <, <=, > and >=
+  - membership of a list of values, or of prefixes for addresses: name in [value, ...], or name in 10.0.0.0/8 for a single prefix
+  - && or and, || or or, ! or not, and parentheses; && binds stronger than ||
+  - values are numbers (also 0x hexadecimal), IPv4 and IPv6 addresses, MAC addresses, true and false, and "quoted strings"
+
+Names are those of FieldDescriptionByID, see FieldIDByName; elements without a name can be written as E<enterprise id>id<element id>.
+A comparison of a field that is not in the template of a record is false, so !(name == value) is true for it.
+The expression is compiled once for every template it is evaluated on, where the types of the fields are checked against the values.
+
+A FilterStage removes the records that do not match from messages, and drops them or passes them to a RecordHandler.
+Options data is never filtered, as it describes the data rather than being data.
+
+*/
+
+// RecordHandler is a function that receives Data Records, like the records a FilterStage does not pass
+type RecordHandler func(datrec *DataRecord)
+
+// filterNodeType is the type of a node of the syntax tree of a filter expression
+type filterNodeType uint8
+
+// The types of the nodes of a filter expression
+const (
+	filterAnd filterNodeType = iota
+	filterOr
+	filterNot
+	filterComparison
+)
+
+// filterNode is a node of the syntax tree of a filter expression
+type filterNode struct {
+	nodeType filterNodeType
+	children []*filterNode //Of and, or and not
+
+	//Of comparisons
+	name         string
+	enterpriseID uint32
+	elementID    uint16
+	operator     string
+	values       []*filterValue
+}
+
+// filterValueKind is the type of a value in a filter expression
+type filterValueKind uint8
+
+// The types of values in a filter expression
+const (
+	filterNumber filterValueKind = iota
+	filterAddress
+	filterPrefix
+	filterMAC
+	filterString
+	filterBoolean
+)
+
+// filterValue is a value in a filter expression
+type filterValue struct {
+	kind filterValueKind
+	text string
+
+	unsigned   uint64
+	isUnsigned bool
+	signed     int64
+	isSigned   bool
+	float      float64
+	address    net.IP
+	prefix     *net.IPNet
+	mac        net.HardwareAddr
+	str        string
+	boolean    bool
+}
+
+// filterMatcher evaluates a compiled filter expression on the field values of a record
+type filterMatcher func(values []FieldValue) bool
+
+// Filter is a parsed filter expression
+type Filter struct {
+	expression string
+	root       *filterNode
+	compiled   map[weak.Pointer[TemplateRecord]]*CompiledFilter //Until the template is garbage collected
+
+	sync.Mutex
+}
+
+// CompiledFilter is a filter expression compiled for the records of a template
+type CompiledFilter struct {
+	match   filterMatcher
+	version uint64 //customMapVersion when compiled, the types of custom fields may change
+}
+
+// Match returns whether the record, of the template the filter was compiled for, matches
+func (cf *CompiledFilter) Match(datrec *DataRecord) bool {
+	return cf.match(datrec.FieldValues)
+}
+
+// ParseFilter parses a filter expression. The names in the expression must be known, see FieldIDByName.
+func ParseFilter(expression string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens, end: len(expression)}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := parser.peek(); ok {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Unexpected %q in filter", token.text), ErrCritical).AtOffset(token.offset)
+	}
+	return &Filter{expression: expression, root: root, compiled: make(map[weak.Pointer[TemplateRecord]]*CompiledFilter)}, nil
+}
+
+// String returns the expression of the filter
+func (filter *Filter) String() string {
+	return filter.expression
+}
+
+// Compile returns the filter compiled for the records of the template. The compiled filters are kept, so this is only done once per template.
+// A compiled filter is dropped when its template is garbage collected, like a template that was replaced and is no longer used.
+func (filter *Filter) Compile(tmplrec *TemplateRecord) (*CompiledFilter, error) {
+	filter.Lock()
+	defer filter.Unlock()
+	version := customMapVersion.Load()
+	key := weak.Make(tmplrec)
+	cf, found := filter.compiled[key]
+	if found && cf.version == version {
+		return cf, nil
+	}
+	match, err := compileFilter(filter.root, tmplrec.allFieldSpecifiers())
+	if err != nil {
+		if perr, ok := err.(*ProtocolError); ok {
+			perr.InTemplate(tmplrec.TemplateID)
+		}
+		return nil, err
+	}
+	if !found {
+		runtime.AddCleanup(tmplrec, filter.forget, key)
+	}
+	cf = &CompiledFilter{match: match, version: version}
+	filter.compiled[key] = cf
+	return cf, nil
+}
+
+// forget drops the compiled filter of a garbage collected template
+func (filter *Filter) forget(key weak.Pointer[TemplateRecord]) {
+	filter.Lock()
+	defer filter.Unlock()
+	delete(filter.compiled, key)
+}
+
+// Match returns whether the record matches the filter
+func (filter *Filter) Match(datrec *DataRecord) (bool, error) {
+	if _, err := datrec.recordFields(); err != nil {
+		return false, err
+	}
+	tmplrec, _ := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	cf, err := filter.Compile(tmplrec)
+	if err != nil {
+		return false, err
+	}
+	return cf.Match(datrec), nil
+}
+
+// compileFilter returns the matcher of the node for records with the field specifiers
+func compileFilter(node *filterNode, fsps []*FieldSpecifier) (filterMatcher, error) {
+	if node.nodeType == filterComparison {
+		return compileComparison(node, fsps)
+	}
+	matchers := make([]filterMatcher, len(node.children))
+	for idx, child := range node.children {
+		matcher, err := compileFilter(child, fsps)
+		if err != nil {
+			return nil, err
+		}
+		matchers[idx] = matcher
+	}
+	switch node.nodeType {
+	case filterNot:
+		return func(values []FieldValue) bool { return !matchers[0](values) }, nil
+	case filterOr:
+		return func(values []FieldValue) bool {
+			for _, matcher := range matchers {
+				if matcher(values) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return func(values []FieldValue) bool {
+		for _, matcher := range matchers {
+			if !matcher(values) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compileComparison returns the matcher of a comparison, after checking the values can be compared with the field
+func compileComparison(node *filterNode, fsps []*FieldSpecifier) (filterMatcher, error) {
+	idx := findField(fsps, node.enterpriseID, node.elementID)
+	if idx < 0 {
+		return func([]FieldValue) bool { return false }, nil
+	}
+	fieldval, err := NewFieldValueByID(node.enterpriseID, node.elementID)
+	if err != nil {
+		fieldval = &FieldValueOctetArray{} //Elements that are not known are decoded as octet arrays
+	}
+	for _, value := range node.values {
+		if !filterComparable(fieldval, value) {
+			return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not compare %s to %s", node.name, value.text), ErrCritical).AtField(idx)
+		}
+	}
+	operator, values := node.operator, node.values
+	return func(fieldvals []FieldValue) bool {
+		if idx >= len(fieldvals) {
+			return false
+		}
+		if operator == "in" {
+			for _, value := range values {
+				if filterMember(fieldvals[idx], value) {
+					return true
+				}
+			}
+			return false
+		}
+		result, ok := filterCompare(fieldvals[idx], values[0])
+		if !ok {
+			return false
+		}
+		switch operator {
+		case "==":
+			return result == 0
+		case "!=":
+			return result != 0
+		case "<":
+			return result < 0
+		case "<=":
+			return result <= 0
+		case ">":
+			return result > 0
+		}
+		return result >= 0
+	}, nil
+}
+
+// filterComparable returns whether the value can be compared with values of the type of fieldval
+func filterComparable(fieldval FieldValue, value *filterValue) bool {
+	switch fieldval.Value().(type) {
+	case uint8, uint16, uint32, uint64, int8, int16, int32, int64, float32, float64:
+		return value.kind == filterNumber
+	case net.IP:
+		return value.kind == filterAddress || value.kind == filterPrefix
+	case net.HardwareAddr:
+		return value.kind == filterMAC
+	case string:
+		return value.kind == filterString
+	case bool:
+		return value.kind == filterBoolean
+	}
+	return false
+}
+
+// filterMember returns whether the field value equals the value, or is an address in the prefix
+func filterMember(fieldval FieldValue, value *filterValue) bool {
+	if value.kind == filterPrefix {
+		address, ok := fieldval.Value().(net.IP)
+		return ok && value.prefix.Contains(address)
+	}
+	result, ok := filterCompare(fieldval, value)
+	return ok && result == 0
+}
+
+// filterCompare returns -1, 0 or 1 if the field value is smaller than, equal to or larger than the value, or false if they can not be compared
+func filterCompare(fieldval FieldValue, value *filterValue) (int, bool) {
+	if unsigned, ok := unsignedValue(fieldval); ok {
+		if value.isUnsigned {
+			return compareOrdered(unsigned, value.unsigned), true
+		}
+		return compareOrdered(float64(unsigned), value.float), value.kind == filterNumber
+	}
+	if signed, ok := signedValue(fieldval); ok {
+		if value.isSigned {
+			return compareOrdered(signed, value.signed), true
+		}
+		return compareOrdered(float64(signed), value.float), value.kind == filterNumber
+	}
+	switch fv := fieldval.Value().(type) {
+	case float32:
+		return compareOrdered(float64(fv), value.float), value.kind == filterNumber
+	case float64:
+		return compareOrdered(fv, value.float), value.kind == filterNumber
+	case net.IP:
+		if value.kind == filterAddress && (fv.To4() == nil) == (value.address.To4() == nil) {
+			return bytes.Compare(fv.To16(), value.address.To16()), true
+		}
+	case net.HardwareAddr:
+		if value.kind == filterMAC {
+			return bytes.Compare(fv, value.mac), true
+		}
+	case string:
+		if value.kind == filterString {
+			return strings.Compare(fv, value.str), true
+		}
+	case bool:
+		if value.kind == filterBoolean && fv == value.boolean {
+			return 0, true
+		} else if value.kind == filterBoolean {
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// filterToken is a token of a filter expression
+type filterToken struct {
+	text   string
+	offset int
+	quoted bool //A string value
+}
+
+// filterSymbols are the operators and punctuation of filter expressions, longest first
+var filterSymbols = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// tokenizeFilter splits a filter expression into tokens
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	for pos := 0; pos < len(expression); {
+		char := expression[pos]
+		if char == ' ' || char == '\t' || char == '\n' || char == '\r' {
+			pos++
+			continue
+		}
+		if char == '"' {
+			end := pos + 1
+			for end < len(expression) && expression[end] != '"' {
+				if expression[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expression) {
+				return nil, NewCategoryError(ErrInvalidValue, "Unterminated string in filter", ErrCritical).AtOffset(pos)
+			}
+			str, err := strconv.Unquote(expression[pos : end+1])
+			if err != nil {
+				return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid string in filter: %v", err), ErrCritical).AtOffset(pos)
+			}
+			tokens = append(tokens, filterToken{text: str, offset: pos, quoted: true})
+			pos = end + 1
+			continue
+		}
+		symbol := ""
+		for _, candidate := range filterSymbols {
+			if strings.HasPrefix(expression[pos:], candidate) {
+				symbol = candidate
+				break
+			}
+		}
+		if symbol != "" {
+			tokens = append(tokens, filterToken{text: symbol, offset: pos})
+			pos += len(symbol)
+			continue
+		}
+		end := pos
+		for end < len(expression) && !strings.ContainsRune(" \t\n\r\"&|=!<>()[],", rune(expression[end])) {
+			end++
+		}
+		if end == pos {
+			return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Unexpected %q in filter", expression[pos:pos+1]), ErrCritical).AtOffset(pos)
+		}
+		tokens = append(tokens, filterToken{text: expression[pos:end], offset: pos})
+		pos = end
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser of filter expressions
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	end    int //Length of the expression, the offset of errors at its end
+}
+
+// peek returns the next token, or false at the end
+func (parser *filterParser) peek() (filterToken, bool) {
+	if parser.pos >= len(parser.tokens) {
+		return filterToken{offset: parser.end}, false
+	}
+	return parser.tokens[parser.pos], true
+}
+
+// accept consumes the next token if it is one of the texts
+func (parser *filterParser) accept(texts ...string) bool {
+	token, ok := parser.peek()
+	if !ok || token.quoted {
+		return false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			parser.pos++
+			return true
+		}
+	}
+	return false
+}
+
+// expect consumes the next token, which must be text
+func (parser *filterParser) expect(text string) error {
+	if !parser.accept(text) {
+		token, _ := parser.peek()
+		return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Expected %q in filter", text), ErrCritical).AtOffset(token.offset)
+	}
+	return nil
+}
+
+// parseOr parses: and {("||" | "or") and}
+func (parser *filterParser) parseOr() (*filterNode, error) {
+	return parser.parseBinary(filterOr, parser.parseAnd, "||", "or")
+}
+
+// parseAnd parses: unary {("&&" | "and") unary}
+func (parser *filterParser) parseAnd() (*filterNode, error) {
+	return parser.parseBinary(filterAnd, parser.parseUnary, "&&", "and")
+}
+
+// parseBinary parses operands separated by the operators into a node of the type, or returns the operand if there is only one
+func (parser *filterParser) parseBinary(nodeType filterNodeType, operand func() (*filterNode, error), operators ...string) (*filterNode, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	node := &filterNode{nodeType: nodeType, children: []*filterNode{first}}
+	for parser.accept(operators...) {
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, next)
+	}
+	if len(node.children) == 1 {
+		return first, nil
+	}
+	return node, nil
+}
+
+// parseUnary parses: ("!" | "not") unary | "(" or ")" | comparison
+func (parser *filterParser) parseUnary() (*filterNode, error) {
+	if parser.accept("!", "not") {
+		child, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNode{nodeType: filterNot, children: []*filterNode{child}}, nil
+	}
+	if parser.accept("(") {
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, parser.expect(")")
+	}
+	return parser.parseComparison()
+}
+
+// parseComparison parses: name operator value | name "in" (value | "[" value {"," value} "]")
+func (parser *filterParser) parseComparison() (*filterNode, error) {
+	token, ok := parser.peek()
+	if !ok || token.quoted || strings.ContainsAny(token.text[:1], "&|=!<>()[],") {
+		return nil, NewCategoryError(ErrInvalidValue, "Expected the name of an element in filter", ErrCritical).AtOffset(token.offset)
+	}
+	parser.pos++
+	node := &filterNode{nodeType: filterComparison, name: token.text}
+	var err error
+	if node.enterpriseID, node.elementID, err = filterElement(token.text); err != nil {
+		return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("Unknown element %s in filter", token.text), ErrCritical).AtOffset(token.offset)
+	}
+
+	operator, _ := parser.peek()
+	if !parser.accept("==", "!=", "<", "<=", ">", ">=", "in") {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Expected a comparison of %s in filter", node.name), ErrCritical).AtOffset(operator.offset)
+	}
+	node.operator = operator.text
+	if node.operator == "in" && parser.accept("[") {
+		for {
+			value, err := parser.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, value)
+			if !parser.accept(",") {
+				break
+			}
+		}
+		return node, parser.expect("]")
+	}
+	value, err := parser.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if value.kind == filterPrefix && node.operator != "in" {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Prefix %s can only be used with in", value.text), ErrCritical).AtOffset(operator.offset)
+	}
+	node.values = []*filterValue{value}
+	return node, nil
+}
+
+// parseValue parses a value
+func (parser *filterParser) parseValue() (*filterValue, error) {
+	token, ok := parser.peek()
+	if !ok || (!token.quoted && strings.ContainsAny(token.text[:1], "&|=!<>()[],")) {
+		return nil, NewCategoryError(ErrInvalidValue, "Expected a value in filter", ErrCritical).AtOffset(token.offset)
+	}
+	parser.pos++
+	value := &filterValue{text: token.text}
+	if token.quoted {
+		value.kind, value.str = filterString, token.text
+		value.text = strconv.Quote(token.text)
+		return value, nil
+	}
+	if unsigned, err := strconv.ParseUint(token.text, 0, 64); err == nil {
+		value.kind, value.unsigned, value.isUnsigned, value.float = filterNumber, unsigned, true, float64(unsigned)
+		value.signed, value.isSigned = int64(unsigned), unsigned <= 1<<63-1
+		return value, nil
+	}
+	if signed, err := strconv.ParseInt(token.text, 0, 64); err == nil {
+		value.kind, value.signed, value.isSigned, value.float = filterNumber, signed, true, float64(signed)
+		return value, nil
+	}
+	if float, err := strconv.ParseFloat(token.text, 64); err == nil {
+		value.kind, value.float = filterNumber, float
+		return value, nil
+	}
+	if address := net.ParseIP(token.text); address != nil {
+		value.kind, value.address = filterAddress, address
+		return value, nil
+	}
+	if _, prefix, err := net.ParseCIDR(token.text); err == nil {
+		value.kind, value.prefix = filterPrefix, prefix
+		return value, nil
+	}
+	if mac, err := net.ParseMAC(token.text); err == nil {
+		value.kind, value.mac = filterMAC, mac
+		return value, nil
+	}
+	if token.text == "true" || token.text == "false" {
+		value.kind, value.boolean = filterBoolean, token.text == "true"
+		return value, nil
+	}
+	return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Invalid value %s in filter", token.text), ErrCritical).AtOffset(token.offset)
+}
+
+// filterElement returns the enterprise id and element id of a name, or of E<enterprise id>id<element id>
+func filterElement(name string) (uint32, uint16, error) {
+	var enterpriseid uint32
+	var elementid uint16
+	if count, err := fmt.Sscanf(name, "E%did%d", &enterpriseid, &elementid); err == nil && count == 2 && fmt.Sprintf("E%did%d", enterpriseid, elementid) == name {
+		return enterpriseid, elementid, nil
+	}
+	return FieldIDByName(name)
+}
+
+// FilterStage passes the Data Records that match its Filter, and drops the others or passes them to its Rejected handler
+type FilterStage struct {
+	Filter   *Filter
+	Rejected RecordHandler //Receives the records that do not match, nil drops them
+}
+
+// NewFilterStage returns a stage that passes the records that match the filter, and the others to rejected, which may be nil
+func NewFilterStage(filter *Filter, rejected RecordHandler) *FilterStage {
+	return &FilterStage{Filter: filter, Rejected: rejected}
+}
+
+// FilterMessage removes the Data Records that do not match from the message, and returns the number of records removed.
+// Options data is left as it is, and Data Sets that become empty are removed. Records that can not be evaluated are kept,
+// and their errors returned.
+func (stage *FilterStage) FilterMessage(ipfixmsg *Message) (int, error) {
+	var err error
+	removed := 0
+	sets := ipfixmsg.Sets[:0]
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			sets = append(sets, set)
+			continue
+		}
+		records := set.Records[:0]
+		for _, rec := range set.Records {
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok {
+				records = append(records, rec)
+				continue
+			}
+			if tmplrec, tmplerr := datrec.AssociatedTemplates.Get(datrec.TemplateID); tmplerr == nil && len(tmplrec.ScopeFieldSpecifiers) > 0 {
+				records = append(records, rec)
+				continue
+			}
+			matches, suberr := stage.Filter.Match(datrec)
+			if suberr != nil {
+				err = stackError(err, "Sub errors filtering records.", suberr, 0)
+				matches = true
+			}
+			if matches {
+				records = append(records, rec)
+				continue
+			}
+			removed++
+			if stage.Rejected != nil {
+				stage.Rejected(datrec)
+			}
+		}
+		for idx := len(records); idx < len(set.Records); idx++ {
+			set.Records[idx] = nil
+		}
+		set.Records = records
+		if len(records) > 0 {
+			sets = append(sets, set)
+		}
+	}
+	for idx := len(sets); idx < len(ipfixmsg.Sets); idx++ {
+		ipfixmsg.Sets[idx] = nil
+	}
+	ipfixmsg.Sets = sets
+	return removed, err
+}
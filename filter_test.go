@@ -0,0 +1,189 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const (
+	filterTestPrint = false
+)
+
+func TestFilterMarker(t *testing.T) {
+	if filterTestPrint {
+		fmt.Printf(testMarkerString, "Filter")
+	}
+}
+
+// filterTestFlow returns a flow of template 256 with octetDeltaCount 1000 and packetDeltaCount 10, of the protocol, port and source
+func filterTestFlow(templates *ActiveTemplates, protocol uint8, port uint16, source string) *DataRecord {
+	mac, _ := net.ParseMAC("00:1b:21:3a:4b:5c")
+	return samplingTestFlow(templates, 256,
+		psampTestField{4, &FieldValueUnsigned8{value: protocol}},
+		psampTestField{11, &FieldValueUnsigned16{value: port}},
+		psampTestField{8, &FieldValueIPv4Address{value: net.ParseIP(source).To4()}},
+		psampTestField{56, &FieldValueMacAddress{value: mac}},
+		psampTestField{82, &FieldValueString{value: "eth0"}})
+}
+
+func TestFieldIDByName(t *testing.T) {
+	t.Cleanup(func() { UnregisterCustomField(9999, 5) })
+	RegisterCustomField(9999, 5, 4, "vendorCounter", &FieldValueUnsigned32{})
+	for name, expected := range map[string][2]uint32{
+		"octetDeltaCount":        {0, 1},
+		"sourceIPv4Address":      {0, 8},
+		"reverseOctetDeltaCount": {ReversePEN, 1},
+		"vendorCounter":          {9999, 5},
+	} {
+		if enterpriseid, elementid, err := FieldIDByName(name); err != nil || enterpriseid != expected[0] || uint32(elementid) != expected[1] {
+			t.Errorf(errorPrefixMarker+"Expected %s to be E%did%d, but got E%did%d (%v)", name, expected[0], expected[1], enterpriseid, elementid, err)
+		}
+	}
+	if _, _, err := FieldIDByName("noSuchElement"); !errors.Is(err, ErrUnknownElement) {
+		t.Errorf(errorPrefixMarker+"Expected an unknown name to fail, but got %v", err)
+	}
+	for _, field := range fieldNames {
+		if name, err := FieldDescriptionByID(field.enterpriseID, field.elementID); err != nil || name != field.name {
+			t.Errorf(errorPrefixMarker+"Name of E%did%d is %s, but the registry has %s", field.enterpriseID, field.elementID, field.name, name)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	templates := NewActiveTemplateList()
+	filter, err := ParseFilter("protocolIdentifier == 6 && destinationTransportPort in [80,443] && sourceIPv4Address in 10.0.0.0/8")
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error parsing filter: %v", err)
+	}
+	for _, test := range []struct {
+		datrec  *DataRecord
+		matches bool
+	}{
+		{filterTestFlow(templates, 6, 80, "10.1.2.3"), true},
+		{filterTestFlow(templates, 6, 443, "10.255.0.1"), true},
+		{filterTestFlow(templates, 17, 443, "10.1.2.3"), false},
+		{filterTestFlow(templates, 6, 22, "10.1.2.3"), false},
+		{filterTestFlow(templates, 6, 80, "192.168.1.1"), false},
+	} {
+		if matches, err := filter.Match(test.datrec); err != nil || matches != test.matches {
+			t.Errorf(errorPrefixMarker+"Expected %s to be %t for %v, but got %t (%v)", filter, test.matches, test.datrec, matches, err)
+		}
+	}
+
+	datrec := filterTestFlow(templates, 6, 80, "10.1.2.3")
+	for expression, matches := range map[string]bool{
+		"octetDeltaCount >= 1000 and not (packetDeltaCount < 5)":    true,
+		"octetDeltaCount > 1000 || packetDeltaCount != 10":          false,
+		"octetDeltaCount > -1 && octetDeltaCount < 1000.5":          true,
+		"E0id4 == 0x06 && E0id11 in [0x50, 8080]":                   true,
+		"sourceIPv4Address == 10.1.2.3 && sourceIPv4Address != ::1": false,
+		"sourceIPv4Address in [192.168.0.0/16, 10.1.2.3]":           true,
+		"sourceMacAddress == 00:1b:21:3a:4b:5c":                     true,
+		`interfaceName == "eth0" && interfaceName < "eth1"`:         true,
+		"ipNextHopIPv4Address == 10.0.0.1":                          false,
+		"!(ipNextHopIPv4Address == 10.0.0.1)":                       true,
+
+		"protocolIdentifier == 17 || protocolIdentifier == 6 && octetDeltaCount <= 1000":  true,
+		"(protocolIdentifier == 17 || protocolIdentifier == 6) && octetDeltaCount < 1000": false,
+	} {
+		filter, err := ParseFilter(expression)
+		if err != nil {
+			t.Errorf(errorPrefixMarker+"Error parsing %s: %v", expression, err)
+			continue
+		}
+		if result, err := filter.Match(datrec); err != nil || result != matches {
+			t.Errorf(errorPrefixMarker+"Expected %s to be %t, but got %t (%v)", expression, matches, result, err)
+		}
+	}
+
+	tmplrec, _ := templates.Get(256)
+	first, _ := filter.Compile(tmplrec)
+	if second, _ := filter.Compile(tmplrec); first != second {
+		t.Errorf(errorPrefixMarker + "Expected the compiled filter to be kept for the template")
+	}
+}
+
+func TestFilterDroppedTemplates(t *testing.T) {
+	filter, _ := ParseFilter("protocolIdentifier == 6")
+	for port := range uint16(100) { //Sessions that come and go, all with a template 256
+		if matches, err := filter.Match(filterTestFlow(NewActiveTemplateList(), 6, port, "10.1.2.3")); !matches || err != nil {
+			t.Fatalf(errorPrefixMarker+"Expected the flow to match, but got %t (%v)", matches, err)
+		}
+	}
+	for range 100 {
+		runtime.GC()
+		filter.Lock()
+		kept := len(filter.compiled)
+		filter.Unlock()
+		if kept == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf(errorPrefixMarker + "Expected the compiled filters of replaced templates to be dropped")
+}
+
+func TestFilterErrors(t *testing.T) {
+	for expression, offset := range map[string]int{
+		"protocolIdentifier ==":           21,
+		"protocolIdentifier == 6)":        23,
+		"noSuchElement == 1":              0,
+		"protocolIdentifier 6":            19,
+		"sourceIPv4Address == 10.0.0.0/8": 18,
+		`interfaceName == "eth0`:          17,
+		"protocolIdentifier in [6, 17":    28,
+		"protocolIdentifier == six":       22,
+		"(protocolIdentifier == 6":        24,
+	} {
+		_, err := ParseFilter(expression)
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || perr.Offset != offset {
+			t.Errorf(errorPrefixMarker+"Expected %q to fail at offset %d, but got %v", expression, offset, err)
+		}
+	}
+
+	filter, _ := ParseFilter("protocolIdentifier == 10.0.0.1")
+	if _, err := filter.Match(filterTestFlow(NewActiveTemplateList(), 6, 80, "10.1.2.3")); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected comparing a protocol with an address to fail, but got %v", err)
+	}
+}
+
+func TestFilterStage(t *testing.T) {
+	templates := NewActiveTemplateList()
+	ipfixmsg := samplingTestMessage(t, templates, 1,
+		psampTestRecord(templates, 300, psampTestField{149, &FieldValueUnsigned32{value: 1}}, psampTestField{4, &FieldValueUnsigned8{value: 17}}),
+		filterTestFlow(templates, 6, 80, "10.1.2.3"),
+		filterTestFlow(templates, 17, 53, "10.1.2.3"),
+		filterTestFlow(templates, 6, 443, "10.1.2.4"),
+		samplingTestFlow(templates, 257, psampTestField{4, &FieldValueUnsigned8{value: 1}}))
+
+	filter, _ := ParseFilter("protocolIdentifier == 6")
+	rejected := []*DataRecord{}
+	stage := NewFilterStage(filter, func(datrec *DataRecord) { rejected = append(rejected, datrec) })
+	removed, err := stage.FilterMessage(ipfixmsg)
+	if err != nil || removed != 2 || len(rejected) != 2 {
+		t.Fatalf(errorPrefixMarker+"Expected 2 records to be removed, but got %d and %d rejected (%v)", removed, len(rejected), err)
+	}
+	if rejected[0].FieldValues[2].Value() != uint8(17) || rejected[1].TemplateID != 257 {
+		t.Errorf(errorPrefixMarker+"Wrong rejected records %v", rejected)
+	}
+	setids := []uint16{}
+	for _, set := range ipfixmsg.Sets {
+		setids = append(setids, set.SetID)
+	}
+	if fmt.Sprint(setids) != "[2 3 300 256]" || len(ipfixmsg.Sets[3].Records) != 2 {
+		t.Errorf(errorPrefixMarker+"Expected the options data and the TCP flows to remain, but got sets %v", setids)
+	}
+	data, err := ipfixmsg.MarshalBinary()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error marshalling filtered message: %v", err)
+	}
+	decoded, err := NewSession(nil).UnmarshalMessage(data)
+	if err != nil || len(decoded.Sets) != 4 {
+		t.Errorf(errorPrefixMarker+"Expected the filtered message to decode, but got %v (%v)", decoded, err)
+	}
+}
@@ -0,0 +1,261 @@
+package ipfix
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+/*
+
+The fuzz targets feed arbitrary data to the decoders. Decoding may fail, but it must never panic.
+Run a single target with, for example:
+
+	go test -run=^$ -fuzz=FuzzMessage -fuzztime=60s
+
+*/
+
+const (
+	fuzzTestPrint = false
+)
+
+func TestFuzzMarker(t *testing.T) {
+	if fuzzTestPrint {
+		fmt.Printf(testMarkerString, "Fuzz")
+	}
+}
+
+var (
+	fuzzTestTemplateSet = []byte{0, 2, 0, 44,
+		1, 0, 0, 6, //Template 256, 6 fields
+		0, 8, 0, 4, //sourceIPv4Address
+		0, 82, 0xff, 0xff, //interfaceName
+		0, 1, 0, 4, //octetDeltaCount, reduced size
+		1, 35, 0xff, 0xff, //basicList
+		1, 36, 0xff, 0xff, //subTemplateList
+		1, 37, 0xff, 0xff, //subTemplateMultiList
+		1, 1, 0, 2, //Template 257, 2 fields
+		0, 12, 0, 4, //destinationIPv4Address
+		0, 11, 0, 2, //destinationTransportPort
+	}
+	fuzzTestOptionsTemplateSet = []byte{0, 3, 0, 20,
+		1, 2, 0, 2, 0, 1, //Options template 258, 2 fields, 1 scope field
+		0, 10, 0, 4, //Scope: ingressInterface
+		0, 34, 0, 4, //samplingInterval
+		0, 0, //Padding
+	}
+	fuzzTestBasicList            = []byte{3, 0, 11, 0, 2, 0, 80, 1, 187}
+	fuzzTestSubTemplateList      = []byte{3, 1, 1, 10, 0, 0, 2, 0, 53}
+	fuzzTestSubTemplateMultiList = []byte{3, 1, 1, 0, 10, 10, 0, 0, 3, 0, 22}
+	fuzzTestDataRecord           = []byte{
+		10, 0, 0, 1, //sourceIPv4Address
+		4, 'e', 't', 'h', '0', //interfaceName
+		0, 0, 5, 220, //octetDeltaCount
+		9, 3, 0, 11, 0, 2, 0, 80, 1, 187, //basicList
+		9, 3, 1, 1, 10, 0, 0, 2, 0, 53, //subTemplateList
+		11, 3, 1, 1, 0, 10, 10, 0, 0, 3, 0, 22, //subTemplateMultiList
+	}
+	fuzzTestDataSet    = append([]byte{1, 0, 0, byte(4 + len(fuzzTestDataRecord))}, fuzzTestDataRecord...)
+	fuzzTestOptionsSet = []byte{1, 2, 0, 12, 0, 0, 0, 1, 0, 0, 0, 100}
+)
+
+// fuzzTestTemplates returns a new list of active templates holding the templates of fuzzTestTemplateSet and fuzzTestOptionsTemplateSet
+func fuzzTestTemplates(t testing.TB) *ActiveTemplates {
+	templates := NewActiveTemplateList()
+	for _, data := range [][]byte{fuzzTestTemplateSet, fuzzTestOptionsTemplateSet} {
+		tmplset := &Set{}
+		if err := tmplset.UnmarshalBinary(data); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error unmarshalling fuzz templates: %v", err)
+		}
+		for _, rec := range tmplset.Records {
+			tmplrec := (*rec).(*TemplateRecord)
+			if err := templates.Set(tmplrec.TemplateID, tmplrec); err != nil {
+				t.Fatalf(errorPrefixMarker+"Error registering fuzz template: %v", err)
+			}
+		}
+	}
+	return templates
+}
+
+func TestFuzzSeeds(t *testing.T) {
+	ipfixmsg, err := NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipfixmsg.AssociatedTemplates = NewActiveTemplateList()
+	err = ipfixmsg.UnmarshalBinary(sessionTestMessage(1, fuzzTestTemplateSet, fuzzTestOptionsTemplateSet, fuzzTestDataSet, fuzzTestOptionsSet))
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling seed message: %v", err)
+	}
+	if len(ipfixmsg.Sets) != 4 || len(ipfixmsg.Sets[2].Records) != 1 {
+		t.Fatalf(errorPrefixMarker+"Expected 4 sets and a data record, got %s", ipfixmsg)
+	}
+	datrec := (*ipfixmsg.Sets[2].Records[0]).(*DataRecord)
+	if len(datrec.FieldValues) != 6 {
+		t.Fatalf(errorPrefixMarker+"Expected 6 field values, got %s", datrec)
+	}
+	if bl := datrec.FieldValues[3].Value().(BasicList); len(bl.FieldValues) != 2 || bl.FieldValues[1].Value().(uint16) != 443 {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling basic list: %+v", bl)
+	}
+	if stl := datrec.FieldValues[4].Value().(SubTemplateList); len(stl.Records) != 1 {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling sub template list: %+v", stl)
+	}
+	if stml := datrec.FieldValues[5].Value().(SubTemplateMultiList); len(stml.SubTemplates) != 1 || len(stml.SubTemplates[0].Records) != 1 {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling sub template multi list: %+v", stml)
+	}
+	data, err := datrec.MarshalBinary()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error marshalling seed record: %v", err)
+	}
+	if !bytes.Equal(data, fuzzTestDataRecord) {
+		t.Errorf(errorPrefixMarker+"Seed record does not survive a round trip.\nExpected %v\nbut got  %v", fuzzTestDataRecord, data)
+	}
+}
+
+func TestFuzzRegressions(t *testing.T) {
+	templates := fuzzTestTemplates(t)
+	tests := []struct {
+		name      string
+		unmarshal func([]byte) error
+		data      []byte
+	}{
+		{"field specifier without enterprise number", (&FieldSpecifier{}).UnmarshalBinary, []byte{0x80, 1, 0, 4}},
+		{"basic list header", (&FieldValueBasicList{}).UnmarshalBinary, []byte{3, 0}},
+		{"basic list zero field length", (&FieldValueBasicList{}).UnmarshalBinary, []byte{3, 0, 11, 0, 0, 1}},
+		{"basic list variable length", (&FieldValueBasicList{}).UnmarshalBinary, []byte{3, 0, 82, 0xff, 0xff, 0xff, 0}},
+		{"sub template list header", (&FieldValueSubTemplateList{value: SubTemplateList{AssociatedTemplates: templates}}).UnmarshalBinary, []byte{3, 1}},
+		{"sub template multi list header", (&FieldValueSubTemplateMultiList{value: SubTemplateMultiList{AssociatedTemplates: templates}}).UnmarshalBinary, []byte{3, 1, 1, 0}},
+		{"sub template multi list length", (&FieldValueSubTemplateMultiList{value: SubTemplateMultiList{AssociatedTemplates: templates}}).UnmarshalBinary, []byte{3, 1, 1, 0, 30, 10, 0, 0, 3, 0, 22}},
+		{"variable length", func(data []byte) error { _, _, err := DecodeVariableLength(data); return err }, []byte{0xff, 1}},
+	}
+	for _, test := range tests {
+		if err := test.unmarshal(test.data); err == nil {
+			t.Errorf(errorPrefixMarker+"%s: expected an error", test.name)
+		}
+	}
+}
+
+func FuzzMessage(f *testing.F) {
+	f.Add(sessionTestMessage(1, fuzzTestTemplateSet, fuzzTestOptionsTemplateSet, fuzzTestDataSet, fuzzTestOptionsSet))
+	f.Add(sessionTestMessage(1, fuzzTestTemplateSet, fuzzTestDataSet, fuzzTestDataSet))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, opts := range []DecodeOptions{{Lenient: false}, {Lenient: true}} {
+			ipfixmsg, _ := NewMessage()
+			ipfixmsg.AssociatedTemplates = NewActiveTemplateList()
+			ipfixmsg.UnmarshalBinaryWithOptions(data, opts)
+		}
+	})
+}
+
+func FuzzSet(f *testing.F) {
+	for _, seed := range [][]byte{fuzzTestTemplateSet, fuzzTestOptionsTemplateSet, fuzzTestDataSet, fuzzTestOptionsSet} {
+		f.Add(seed)
+	}
+	templates := fuzzTestTemplates(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ipfixset := &Set{}
+		ipfixset.AssociateTemplates(templates)
+		ipfixset.UnmarshalBinary(data)
+	})
+}
+
+func FuzzTemplateRecord(f *testing.F) {
+	f.Add(fuzzTestTemplateSet[4:], false)
+	f.Add(fuzzTestOptionsTemplateSet[4:], true)
+	f.Fuzz(func(t *testing.T, data []byte, options bool) {
+		tmplrec := &TemplateRecord{}
+		if options {
+			tmplrec.ScopeFieldSpecifiers = make([]*FieldSpecifier, 0, 0)
+		}
+		tmplrec.UnmarshalBinary(data)
+	})
+}
+
+func FuzzFieldSpecifier(f *testing.F) {
+	f.Add([]byte{0, 8, 0, 4})
+	f.Add([]byte{0x80, 20, 0xff, 0xff, 0, 0, 0xaf, 0x71})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&FieldSpecifier{}).UnmarshalBinary(data)
+	})
+}
+
+func FuzzDataRecord(f *testing.F) {
+	f.Add(uint16(256), fuzzTestDataRecord)
+	f.Add(uint16(257), fuzzTestSubTemplateList[3:])
+	f.Add(uint16(258), fuzzTestOptionsSet[4:])
+	templates := fuzzTestTemplates(f)
+	f.Fuzz(func(t *testing.T, templateid uint16, data []byte) {
+		datrec, err := NewDataRecord(templateid, templates)
+		if err != nil {
+			return
+		}
+		datrec.UnmarshalBinary(data)
+	})
+}
+
+func FuzzBasicList(f *testing.F) {
+	f.Add(fuzzTestBasicList)
+	f.Add([]byte{3, 0x80, 21, 0xff, 0xff, 0, 0, 0xaf, 0x71, 3, 'a', 'b', 'c'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&FieldValueBasicList{}).UnmarshalBinary(data)
+	})
+}
+
+func FuzzSubTemplateList(f *testing.F) {
+	f.Add(fuzzTestSubTemplateList)
+	templates := fuzzTestTemplates(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stl := &FieldValueSubTemplateList{}
+		stl.SetAssiocatedTemplates(templates)
+		stl.UnmarshalBinary(data)
+	})
+}
+
+func FuzzSubTemplateMultiList(f *testing.F) {
+	f.Add(fuzzTestSubTemplateMultiList)
+	f.Add([]byte{3, 1, 1, 0, 0, 1, 2, 0, 12, 0, 0, 0, 1, 0, 0, 0, 100})
+	templates := fuzzTestTemplates(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stml := &FieldValueSubTemplateMultiList{}
+		stml.SetAssiocatedTemplates(templates)
+		stml.UnmarshalBinary(data)
+	})
+}
+
+func FuzzNetflowV9(f *testing.F) {
+	f.Add(netflowV9TestPacket)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v9msg, _ := NewNetflowV9Message()
+		v9msg.AssociatedTemplates = NewActiveTemplateList()
+		v9msg.UnmarshalBinary(data)
+	})
+}
+
+func FuzzNetflowV5(f *testing.F) {
+	f.Add(netflowV5TestPacket())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v5msg, _ := NewNetflowV5Message()
+		v5msg.AssociatedTemplates = NewActiveTemplateList()
+		v5msg.UnmarshalBinary(data)
+	})
+}
+
+func FuzzRecordView(f *testing.F) {
+	f.Add(recordViewTestRecord)
+	_, layout := recordViewTestLayout(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		view, err := layout.View(data)
+		if err != nil {
+			return
+		}
+		for _, element := range []uint16{1, 7, 8, 27, 82, 152} {
+			view.Uint64(element)
+			view.Int64(element)
+			view.Float64(element)
+			view.Bool(element)
+			view.Addr(element)
+			view.Time(element)
+		}
+		view.Enterprise(44913).Bytes(20)
+	})
+}
@@ -0,0 +1,118 @@
+package ipfix
+
+import (
+	"runtime"
+	"sync"
+	"weak"
+)
+
+// generatedKey identifies what a stage generated for up to two original templates, and a variant of the stage
+type generatedKey struct {
+	templates [2]weak.Pointer[TemplateRecord]
+	variant   string
+}
+
+// generatedEntry is what a stage generated for its key, with the template ids it uses
+type generatedEntry[T any] struct {
+	value T
+	ids   []uint16
+}
+
+// generatedTemplates keeps what a stage generates for the templates of its input, like templates of the records it returns, until
+// one of the original templates is garbage collected. The template ids of the dropped entries are handed out again,
+// so a stage that sees many templates come and go does not run out of ids.
+type generatedTemplates[T any] struct {
+	next      uint16 //Next template id that was never handed out, 0 when all were
+	free      []uint16
+	entries   map[generatedKey]*generatedEntry[T]
+	collected []generatedKey //Keys of garbage collected templates, appended by the cleanups
+
+	sync.Mutex //Guards collected
+}
+
+// newGeneratedTemplates returns an empty list that hands out template ids from firsttemplateid up
+func newGeneratedTemplates[T any](firsttemplateid uint16) *generatedTemplates[T] {
+	if firsttemplateid < 256 {
+		firsttemplateid = 256
+	}
+	return &generatedTemplates[T]{next: firsttemplateid, entries: make(map[generatedKey]*generatedEntry[T])}
+}
+
+// newGeneratedKey returns the key of the original templates and the variant
+func newGeneratedKey(variant string, tmplrecs ...*TemplateRecord) generatedKey {
+	key := generatedKey{variant: variant}
+	for idx, tmplrec := range tmplrecs {
+		key.templates[idx] = weak.Make(tmplrec)
+	}
+	return key
+}
+
+// get returns what was generated for the key
+func (gt *generatedTemplates[T]) get(key generatedKey) (T, bool) {
+	entry, found := gt.entries[key]
+	if !found {
+		var none T
+		return none, false
+	}
+	return entry.value, true
+}
+
+// allocate returns a template id that is not in use, ids that were never used first
+func (gt *generatedTemplates[T]) allocate() (uint16, bool) {
+	if gt.next != 0 {
+		id := gt.next
+		gt.next++ //Wraps to 0 after 65535
+		return id, true
+	}
+	if len(gt.free) == 0 {
+		return 0, false
+	}
+	id := gt.free[len(gt.free)-1]
+	gt.free = gt.free[:len(gt.free)-1]
+	return id, true
+}
+
+// release hands out the template ids again
+func (gt *generatedTemplates[T]) release(ids ...uint16) {
+	gt.free = append(gt.free, ids...)
+}
+
+// add keeps what was generated for the original templates with the key, using the template ids, until one of them is garbage collected.
+// Nil templates are skipped.
+func (gt *generatedTemplates[T]) add(key generatedKey, tmplrecs []*TemplateRecord, value T, ids ...uint16) {
+	gt.entries[key] = &generatedEntry[T]{value: value, ids: ids}
+	for _, tmplrec := range tmplrecs {
+		if tmplrec != nil {
+			runtime.AddCleanup(tmplrec, gt.collect, key)
+		}
+	}
+}
+
+// collect marks the entry of a garbage collected template to be dropped
+func (gt *generatedTemplates[T]) collect(key generatedKey) {
+	gt.Lock()
+	defer gt.Unlock()
+	gt.collected = append(gt.collected, key)
+}
+
+// drop removes the entries of garbage collected templates, releases their template ids and returns what they held
+func (gt *generatedTemplates[T]) drop() []T {
+	gt.Lock()
+	collected := gt.collected
+	gt.collected = nil
+	gt.Unlock()
+	dropped := []T{}
+	for _, key := range collected {
+		if entry, found := gt.entries[key]; found {
+			delete(gt.entries, key)
+			gt.release(entry.ids...)
+			dropped = append(dropped, entry.value)
+		}
+	}
+	return dropped
+}
+
+// len returns the number of entries that are kept
+func (gt *generatedTemplates[T]) len() int {
+	return len(gt.entries)
+}
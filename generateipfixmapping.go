@@ -321,7 +321,8 @@ func FetchIPFIXColStyle() {
 					EnterpriseID, err := strconv.ParseInt(el.EnterpriseID, 10, 32)
 					if err == nil && strings.TrimSpace(el.Name) != "" &&
 						strings.TrimSpace(el.DataType) != "" &&
-						ElementID != 0 && EnterpriseID != 0 {
+						ElementID != 0 && EnterpriseID != 0 &&
+						EnterpriseID != 29305 { //The reverse elements of RFC 5103 are derived from the IANA elements in the template
 						if _, exists := elementsmap[int(EnterpriseID)]; !exists {
 							elementsmap[int(EnterpriseID)] = make(map[int]fieldvalueelement)
 							sources[int(EnterpriseID)] = "IPFIXColStyle - " + fetchurl
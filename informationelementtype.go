@@ -0,0 +1,402 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+/*
+
+RFC 5610 lets an Exporting Process describe its enterprise-specific Information Elements in options data, so a Collecting Process can
+decode them without knowing them beforehand. An Information Element Type Record is scoped on the privateEnterpriseNumber and the
+informationElementId, and holds the type, semantics, units and name of the element.
+
+The exporter describes the custom fields it registered:
+
+	tmplrec, _ := NewInformationElementTypeTemplate(300)
+	templates.Set(300, tmplrec)
+	records, err := NewInformationElementTypeRecords(templates, 300)
+
+The collector registers the elements it receives as custom fields, either by setting Session.RegisterTypes or by calling
+RegisterInformationElementTypes for every message. Custom fields are registered for the whole process, not per session.
+A received type never replaces a field registered with RegisterCustomField, only a type received before.
+As every exporter can add types, Limits.MaxCustomTypes caps the number of elements that are registered by types, process-wide.
+Records that were decoded before their elements were registered hold the values as octet arrays.
+
+*/
+
+// The Information Elements of RFC 5610
+const (
+	informationElementIDElement          = 303
+	informationElementDataTypeElement    = 339
+	informationElementDescriptionElement = 340
+	informationElementNameElement        = 341
+	informationElementRangeBeginElement  = 342
+	informationElementRangeEndElement    = 343
+	informationElementSemanticsElement   = 344
+	informationElementUnitsElement       = 345
+	privateEnterpriseNumberElement       = 346
+)
+
+// InformationElementDataType is the abstract data type of an Information Element, as in the IANA IPFIX Information Element Data Types registry
+type InformationElementDataType uint8
+
+// The values of informationElementDataType
+const (
+	DataTypeOctetArray InformationElementDataType = iota
+	DataTypeUnsigned8
+	DataTypeUnsigned16
+	DataTypeUnsigned32
+	DataTypeUnsigned64
+	DataTypeSigned8
+	DataTypeSigned16
+	DataTypeSigned32
+	DataTypeSigned64
+	DataTypeFloat32
+	DataTypeFloat64
+	DataTypeBoolean
+	DataTypeMacAddress
+	DataTypeString
+	DataTypeDateTimeSeconds
+	DataTypeDateTimeMilliseconds
+	DataTypeDateTimeMicroseconds
+	DataTypeDateTimeNanoseconds
+	DataTypeIPv4Address
+	DataTypeIPv6Address
+	DataTypeBasicList
+	DataTypeSubTemplateList
+	DataTypeSubTemplateMultiList
+)
+
+// dataTypes holds the name, default field length and Field Value of every data type, indexed by the data type
+var dataTypes = []struct {
+	name     string
+	length   uint16
+	newValue func() FieldValue
+}{
+	{"octetArray", VariableLength, func() FieldValue { return &FieldValueOctetArray{} }},
+	{"unsigned8", 1, func() FieldValue { return &FieldValueUnsigned8{} }},
+	{"unsigned16", 2, func() FieldValue { return &FieldValueUnsigned16{} }},
+	{"unsigned32", 4, func() FieldValue { return &FieldValueUnsigned32{} }},
+	{"unsigned64", 8, func() FieldValue { return &FieldValueUnsigned64{} }},
+	{"signed8", 1, func() FieldValue { return &FieldValueSigned8{} }},
+	{"signed16", 2, func() FieldValue { return &FieldValueSigned16{} }},
+	{"signed32", 4, func() FieldValue { return &FieldValueSigned32{} }},
+	{"signed64", 8, func() FieldValue { return &FieldValueSigned64{} }},
+	{"float32", 4, func() FieldValue { return &FieldValueFloat32{} }},
+	{"float64", 8, func() FieldValue { return &FieldValueFloat64{} }},
+	{"boolean", 1, func() FieldValue { return &FieldValueBoolean{} }},
+	{"macAddress", 6, func() FieldValue { return &FieldValueMacAddress{} }},
+	{"string", VariableLength, func() FieldValue { return &FieldValueString{} }},
+	{"dateTimeSeconds", 4, func() FieldValue { return &FieldValueDateTimeSeconds{} }},
+	{"dateTimeMilliseconds", 8, func() FieldValue { return &FieldValueDateTimeMilliseconds{} }},
+	{"dateTimeMicroseconds", 8, func() FieldValue { return &FieldValueDateTimeMicroseconds{} }},
+	{"dateTimeNanoseconds", 8, func() FieldValue { return &FieldValueDateTimeNanoseconds{} }},
+	{"ipv4Address", 4, func() FieldValue { return &FieldValueIPv4Address{} }},
+	{"ipv6Address", 16, func() FieldValue { return &FieldValueIPv6Address{} }},
+	{"basicList", VariableLength, func() FieldValue { return &FieldValueBasicList{} }},
+	{"subTemplateList", VariableLength, func() FieldValue { return &FieldValueSubTemplateList{} }},
+	{"subTemplateMultiList", VariableLength, func() FieldValue { return &FieldValueSubTemplateMultiList{} }},
+}
+
+// String returns the name of the data type
+func (datatype InformationElementDataType) String() string {
+	if int(datatype) < len(dataTypes) {
+		return dataTypes[datatype].name
+	}
+	return fmt.Sprintf("unassigned(%d)", uint8(datatype))
+}
+
+// dataTypeOf returns the data type of a Field Value, or false if it has none
+func dataTypeOf(fieldval FieldValue) (InformationElementDataType, bool) {
+	if fieldval == nil {
+		return 0, false
+	}
+	valtype := reflect.TypeOf(fieldval)
+	for idx, datatype := range dataTypes {
+		if reflect.TypeOf(datatype.newValue()) == valtype {
+			return InformationElementDataType(idx), true
+		}
+	}
+	return 0, false
+}
+
+// InformationElementSemantics is the data type semantics of an Information Element, as in the IANA IPFIX Information Element Semantics registry
+type InformationElementSemantics uint8
+
+// The values of informationElementSemantics
+const (
+	SemanticsDefault InformationElementSemantics = iota
+	SemanticsQuantity
+	SemanticsTotalCounter
+	SemanticsDeltaCounter
+	SemanticsIdentifier
+	SemanticsFlags
+	SemanticsList
+	SemanticsSNMPCounter
+	SemanticsSNMPGauge
+)
+
+// semanticsNames are the names of the semantics, indexed by the semantics
+var semanticsNames = []string{"default", "quantity", "totalCounter", "deltaCounter", "identifier", "flags", "list", "snmpCounter", "snmpGauge"}
+
+// String returns the name of the semantics
+func (semantics InformationElementSemantics) String() string {
+	if int(semantics) < len(semanticsNames) {
+		return semanticsNames[semantics]
+	}
+	return fmt.Sprintf("unassigned(%d)", uint8(semantics))
+}
+
+// InformationElementType is the description of an Information Element in an Information Element Type Record, RFC 5610
+type InformationElementType struct {
+	EnterpriseID uint32
+	ElementID    uint16
+	DataType     InformationElementDataType
+	Semantics    InformationElementSemantics
+	Units        uint16 //As in the IANA IPFIX Information Element Units registry, 0 is none
+	RangeBegin   uint64 //The range of the values, both 0 if it is not known
+	RangeEnd     uint64
+	Name         string
+	Description  string
+}
+
+// NewInformationElementType returns the type of an Information Element Type Record, an options record with informationElementId and informationElementDataType
+func NewInformationElementType(datrec *DataRecord) (*InformationElementType, error) {
+	fields, _, err := psampFields(datrec)
+	if err != nil {
+		return nil, err
+	}
+	if fields[informationElementIDElement] == nil || fields[informationElementDataTypeElement] == nil {
+		return nil, NewCategoryError(ErrInvalidValue, "Not an Information Element Type Record, it needs an informationElementId and an informationElementDataType", ErrCritical).InTemplate(datrec.TemplateID)
+	}
+	unsigned := func(elementid uint16) uint64 {
+		if fieldval, found := fields[elementid]; found {
+			value, _ := unsignedValue(fieldval)
+			return value
+		}
+		return 0
+	}
+	text := func(elementid uint16) string {
+		if fieldval, found := fields[elementid]; found {
+			value, _ := fieldval.Value().(string)
+			return value
+		}
+		return ""
+	}
+	return &InformationElementType{
+		EnterpriseID: uint32(unsigned(privateEnterpriseNumberElement)),
+		ElementID:    uint16(unsigned(informationElementIDElement)),
+		DataType:     InformationElementDataType(unsigned(informationElementDataTypeElement)),
+		Semantics:    InformationElementSemantics(unsigned(informationElementSemanticsElement)),
+		Units:        uint16(unsigned(informationElementUnitsElement)),
+		RangeBegin:   unsigned(informationElementRangeBeginElement),
+		RangeEnd:     unsigned(informationElementRangeEndElement),
+		Name:         text(informationElementNameElement),
+		Description:  text(informationElementDescriptionElement),
+	}, nil
+}
+
+// errTypeRegistered tells registerCustomField not to register a type that is registered already
+var errTypeRegistered = errors.New("type is registered already")
+
+// Register registers the element as a custom field, with the default field length of its data type.
+// It returns false if the element was registered with the same type before, or if it is an IANA or Reverse Information Element: those are known already.
+// A type replaces the type registered before, but never a field registered with RegisterCustomField: that is reported as an error.
+func (ietype *InformationElementType) Register() (bool, error) {
+	return ietype.register(0)
+}
+
+// register is Register, but does not add an element if maxtypes elements are registered by types already (0 means no limit)
+func (ietype *InformationElementType) register(maxtypes int) (bool, error) {
+	if ietype.EnterpriseID == 0 || ietype.EnterpriseID == ReversePEN {
+		return false, nil
+	}
+	if int(ietype.DataType) >= len(dataTypes) {
+		return false, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not register element E%did%d of unknown data type %s", ietype.EnterpriseID, ietype.ElementID, ietype.DataType), ErrCritical)
+	}
+	registered := *ietype
+	datatype := dataTypes[ietype.DataType]
+	err := registerCustomField(ietype.EnterpriseID, ietype.ElementID, customFieldType{
+		FieldLength: datatype.length,
+		Description: ietype.Name,
+		FieldVal:    datatype.newValue(),
+		Type:        &registered,
+	}, func(old customFieldType, found bool) error {
+		switch {
+		case !found:
+			if limiterr := checkLimit("MaxCustomTypes", maxtypes, customTypeCount()+1); limiterr != nil {
+				return limiterr
+			}
+		case old.Type == nil:
+			return NewCategoryError(ErrInvalidValue, fmt.Sprintf("Element E%did%d is registered as %s without a type, the type %s of %s does not replace it", ietype.EnterpriseID, ietype.ElementID, old.Description, ietype.DataType, ietype.Name), ErrCritical)
+		case *old.Type == *ietype:
+			return errTypeRegistered
+		}
+		return nil
+	})
+	if err == errTypeRegistered {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// customTypeCount returns the number of custom fields that were registered by types, customMapLock must be held
+func customTypeCount() int {
+	count := 0
+	for _, fields := range customIPFIXIDMap {
+		for _, custfield := range fields {
+			if custfield.Type != nil {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// CustomInformationElementTypes returns the types of the registered custom fields, ordered by enterprise id and element id.
+// Fields registered without type information get the default semantics; fields of a type that has no data type are left out.
+func CustomInformationElementTypes() []*InformationElementType {
+	customMapLock.Lock()
+	defer customMapLock.Unlock()
+	ietypes := []*InformationElementType{}
+	for enterpriseid, fields := range customIPFIXIDMap {
+		for elementid, custfield := range fields {
+			if custfield.Type != nil {
+				ietype := *custfield.Type
+				ietypes = append(ietypes, &ietype)
+				continue
+			}
+			datatype, ok := dataTypeOf(custfield.FieldVal)
+			if !ok {
+				continue
+			}
+			ietypes = append(ietypes, &InformationElementType{EnterpriseID: enterpriseid, ElementID: elementid, DataType: datatype, Name: custfield.Description})
+		}
+	}
+	sort.Slice(ietypes, func(i, j int) bool {
+		if ietypes[i].EnterpriseID != ietypes[j].EnterpriseID {
+			return ietypes[i].EnterpriseID < ietypes[j].EnterpriseID
+		}
+		return ietypes[i].ElementID < ietypes[j].ElementID
+	})
+	return ietypes
+}
+
+// NewInformationElementTypeTemplate returns the options template of Information Element Type Records, as used by NewInformationElementTypeRecord.
+// The range of the values is not exported.
+func NewInformationElementTypeTemplate(templateid uint16) (*TemplateRecord, error) {
+	tmplrec, err := NewOptionsTemplateRecord(templateid)
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range [][2]uint16{
+		{privateEnterpriseNumberElement, 4},
+		{informationElementIDElement, 2},
+	} {
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddScopeSpecifier(fsp)
+	}
+	for _, element := range [][2]uint16{
+		{informationElementDataTypeElement, 1},
+		{informationElementSemanticsElement, 1},
+		{informationElementUnitsElement, 2},
+		{informationElementNameElement, VariableLength},
+		{informationElementDescriptionElement, VariableLength},
+	} {
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	return tmplrec, nil
+}
+
+// NewInformationElementTypeRecord returns the Information Element Type Record of the type, of the template of NewInformationElementTypeTemplate.
+// Variable-length fields can not be empty, so a missing name is exported as "E<enterprise id>id<element id>" and a missing description as the name.
+func NewInformationElementTypeRecord(templates *ActiveTemplates, templateid uint16, ietype *InformationElementType) (*DataRecord, error) {
+	datrec, err := NewDataRecord(templateid, templates)
+	if err != nil {
+		return nil, err
+	}
+	name, description := ietype.Name, ietype.Description
+	if name == "" {
+		name = fmt.Sprintf("E%did%d", ietype.EnterpriseID, ietype.ElementID)
+	}
+	if description == "" {
+		description = name
+	}
+	datrec.FieldValues = []FieldValue{
+		&FieldValueUnsigned32{value: ietype.EnterpriseID},
+		&FieldValueUnsigned16{value: ietype.ElementID},
+		&FieldValueUnsigned8{value: uint8(ietype.DataType)},
+		&FieldValueUnsigned8{value: uint8(ietype.Semantics)},
+		&FieldValueUnsigned16{value: ietype.Units},
+		&FieldValueString{value: name},
+		&FieldValueString{value: description},
+	}
+	return datrec, nil
+}
+
+// NewInformationElementTypeRecords returns the Information Element Type Records of all registered custom fields, see CustomInformationElementTypes
+func NewInformationElementTypeRecords(templates *ActiveTemplates, templateid uint16) ([]*DataRecord, error) {
+	ietypes := CustomInformationElementTypes()
+	records := make([]*DataRecord, 0, len(ietypes))
+	for _, ietype := range ietypes {
+		datrec, err := NewInformationElementTypeRecord(templates, templateid, ietype)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, datrec)
+	}
+	return records, nil
+}
+
+// RegisterInformationElementTypes registers the elements of the Information Element Type Records in the message as custom fields.
+// It returns the number of elements that were registered or changed. The DefaultLimits apply.
+func RegisterInformationElementTypes(ipfixmsg *Message) (int, error) {
+	return RegisterInformationElementTypesWithLimits(ipfixmsg, DefaultLimits)
+}
+
+// RegisterInformationElementTypesWithLimits is RegisterInformationElementTypes with the MaxCustomTypes of limits.
+// Types of new elements beyond the limit are not registered, that is reported as an error.
+func RegisterInformationElementTypesWithLimits(ipfixmsg *Message, limits Limits) (int, error) {
+	var err error
+	registered := 0
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok || !isInformationElementTypeRecord(datrec) {
+				continue
+			}
+			ietype, suberr := NewInformationElementType(datrec)
+			if suberr == nil {
+				var changed bool
+				if changed, suberr = ietype.register(limits.MaxCustomTypes); changed {
+					registered++
+				}
+			}
+			if suberr != nil {
+				err = stackError(err, "Sub errors registering Information Element types.", suberr, 0)
+			}
+		}
+	}
+	return registered, err
+}
+
+// isInformationElementTypeRecord returns whether the record is options data scoped on the informationElementId
+func isInformationElementTypeRecord(datrec *DataRecord) bool {
+	tmplrec, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	if err != nil {
+		return false
+	}
+	for _, fsp := range tmplrec.ScopeFieldSpecifiers {
+		if fsp.EnterpriseNumber == 0 && fsp.InformationElementIdentifier == informationElementIDElement {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	informationElementTypeTestPrint = false
+)
+
+func TestInformationElementTypeMarker(t *testing.T) {
+	if informationElementTypeTestPrint {
+		fmt.Printf(testMarkerString, "Information Element Type")
+	}
+}
+
+// informationElementTypeTestMessage returns a message with the Information Element Type Records of the custom fields,
+// followed by a record of the custom elements 9999/1 and 9999/2
+func informationElementTypeTestMessage(t *testing.T) []byte {
+	templates := NewActiveTemplateList()
+	typetmpl, _ := NewInformationElementTypeTemplate(300)
+	templates.Set(300, typetmpl)
+	tmplrec, _ := NewTemplateRecord(400)
+	for _, element := range [][2]uint16{{1, 4}, {2, VariableLength}} {
+		fsp, _ := NewFieldSpecifier(9999, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	templates.Set(400, tmplrec)
+
+	records, err := NewInformationElementTypeRecords(templates, 300)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating type records: %v", err)
+	}
+	datrec, _ := NewDataRecord(400, templates)
+	datrec.FieldValues = []FieldValue{&FieldValueUnsigned32{value: 42}, &FieldValueString{value: "vendor"}}
+	builder := NewMessageBuilder(1, templates, 0)
+	for _, rec := range append(records, datrec) {
+		if err := builder.AddRecord(rec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding record: %v", err)
+		}
+	}
+	data, err := builder.Flush()[0].MarshalBinary()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error marshalling message: %v", err)
+	}
+	return data
+}
+
+func TestInformationElementTypes(t *testing.T) {
+	t.Cleanup(func() {
+		UnregisterCustomField(9999, 1)
+		UnregisterCustomField(9999, 2)
+	})
+	counter := &InformationElementType{EnterpriseID: 9999, ElementID: 1, DataType: DataTypeUnsigned32, Semantics: SemanticsDeltaCounter, Units: 3, Name: "vendorPackets", Description: "Packets seen by the vendor"}
+	if registered, err := counter.Register(); !registered || err != nil {
+		t.Fatalf(errorPrefixMarker+"Error registering type: %v", err)
+	}
+	if registered, _ := counter.Register(); registered {
+		t.Errorf(errorPrefixMarker + "Registering the same type again should not change anything")
+	}
+	RegisterCustomField(9999, 2, VariableLength, "vendorName", &FieldValueString{})
+	if fieldval, err := NewFieldValueByID(9999, 2); err != nil || fieldval.Len() != 0 {
+		t.Errorf(errorPrefixMarker+"Expected a new string for custom field, but got %#v (%v)", fieldval, err)
+	}
+
+	ietypes := CustomInformationElementTypes()
+	found := 0
+	for _, ietype := range ietypes {
+		if ietype.EnterpriseID != 9999 {
+			continue
+		}
+		found++
+		if ietype.ElementID == 2 && (ietype.DataType != DataTypeString || ietype.Name != "vendorName" || ietype.Semantics != SemanticsDefault) {
+			t.Errorf(errorPrefixMarker+"Wrong type derived from custom field %+v", ietype)
+		}
+	}
+	if found != 2 {
+		t.Fatalf(errorPrefixMarker+"Expected 2 custom types of enterprise 9999, but got %d", found)
+	}
+	data := informationElementTypeTestMessage(t)
+
+	//A collector that does not know the elements
+	UnregisterCustomField(9999, 1)
+	UnregisterCustomField(9999, 2)
+	ipfixmsg, err := NewSession(nil).UnmarshalMessage(data)
+	if !errors.Is(err, ErrUnknownElement) {
+		t.Fatalf(errorPrefixMarker+"Expected the unknown elements to be reported, but got %v", err)
+	}
+	datrec := (*ipfixmsg.Sets[len(ipfixmsg.Sets)-1].Records[0]).(*DataRecord)
+	if _, ok := datrec.FieldValues[0].(*FieldValueOctetArray); !ok {
+		t.Errorf(errorPrefixMarker+"Unknown elements should be decoded as octet arrays, but got %T", datrec.FieldValues[0])
+	}
+	customMapLock.Lock()
+	limits := Limits{MaxCustomTypes: customTypeCount() + 1} //Room for one of the two types
+	customMapLock.Unlock()
+	if registered, err := RegisterInformationElementTypesWithLimits(ipfixmsg, limits); registered != 1 || !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf(errorPrefixMarker+"Expected only one type to be registered within the limit, but got %d (%v)", registered, err)
+	}
+	UnregisterCustomField(9999, 1)
+	UnregisterCustomField(9999, 2)
+
+	session := NewSession(nil)
+	session.RegisterTypes = true
+	ipfixmsg, err = session.UnmarshalMessage(data)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	datrec = (*ipfixmsg.Sets[len(ipfixmsg.Sets)-1].Records[0]).(*DataRecord)
+	if datrec.FieldValues[0].Value() != uint32(42) || datrec.FieldValues[1].Value() != "vendor" {
+		t.Errorf(errorPrefixMarker+"Expected the record to use the registered types, but got %T and %T", datrec.FieldValues[0], datrec.FieldValues[1])
+	}
+	custfield, err := GetCustomField(9999, 1)
+	if err != nil || custfield.Type == nil || *custfield.Type != *counter || custfield.FieldLength != 4 || custfield.Description != "vendorPackets" {
+		t.Errorf(errorPrefixMarker+"Wrong registered field %+v (%v)", custfield, err)
+	}
+	if name, _ := FieldDescriptionByID(9999, 2); name != "vendorName" {
+		t.Errorf(errorPrefixMarker+"Expected the name vendorName, but got %s", name)
+	}
+}
+
+func TestInformationElementTypeErrors(t *testing.T) {
+	if registered, err := (&InformationElementType{EnterpriseID: 0, ElementID: 1, DataType: DataTypeUnsigned64}).Register(); registered || err != nil {
+		t.Errorf(errorPrefixMarker+"IANA elements should not be registered (%v)", err)
+	}
+	if _, err := (&InformationElementType{EnterpriseID: 9999, ElementID: 3, DataType: 42}).Register(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected an unknown data type to fail, but got %v", err)
+	}
+	t.Cleanup(func() { UnregisterCustomField(9999, 4) })
+	RegisterCustomField(9999, 4, 8, "vendorOctets", &FieldValueUnsigned64{})
+	if registered, err := (&InformationElementType{EnterpriseID: 9999, ElementID: 4, DataType: DataTypeString, Name: "vendorOctets"}).Register(); registered || !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a type not to replace a custom field, but got %v", err)
+	}
+	if custfield, _ := GetCustomField(9999, 4); custfield.Type != nil || custfield.FieldLength != 8 {
+		t.Errorf(errorPrefixMarker+"Expected the custom field to be kept, but got %+v", custfield)
+	}
+	if DataTypeDateTimeNanoseconds.String() != "dateTimeNanoseconds" || InformationElementDataType(42).String() != "unassigned(42)" {
+		t.Errorf(errorPrefixMarker+"Wrong data type names %s and %s", DataTypeDateTimeNanoseconds, InformationElementDataType(42))
+	}
+	if SemanticsSNMPGauge.String() != "snmpGauge" || InformationElementSemantics(42).String() != "unassigned(42)" {
+		t.Errorf(errorPrefixMarker+"Wrong semantics names %s and %s", SemanticsSNMPGauge, InformationElementSemantics(42))
+	}
+	for idx, datatype := range dataTypes {
+		if found, ok := dataTypeOf(datatype.newValue()); !ok || int(found) != idx {
+			t.Errorf(errorPrefixMarker+"Data type %s of its own value is %s", InformationElementDataType(idx), found)
+		}
+	}
+
+	templates := NewActiveTemplateList()
+	notype := psampTestRecord(templates, 300, psampTestField{303, &FieldValueUnsigned16{value: 1}})
+	if _, err := NewInformationElementType(notype); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a record without data type to fail, but got %v", err)
+	}
+}
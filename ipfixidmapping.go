@@ -8,34 +8,48 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	customIPFIXIDMap = map[uint32]map[uint16]customFieldType{}
 	customMapLock    sync.Mutex
+	customMapVersion atomic.Uint64 //Increased on every change, so compiled decode plans know to look up the elements again
 )
 
 type customFieldType struct {
 	FieldLength uint16
 	Description string
 	FieldVal    FieldValue
+	Type        *InformationElementType //The RFC 5610 type information, nil if the element was registered without it
 }
 
 //RegisterCustomField allows runtime addition of new elements
 func RegisterCustomField(enterpriseid uint32, elementid uint16, fieldlen uint16, desc string, val FieldValue) error {
+	return registerCustomField(enterpriseid, elementid, customFieldType{FieldLength: fieldlen, Description: desc, FieldVal: val}, nil)
+}
+
+//registerCustomField adds or replaces a custom element, check may refuse to do so given the element that is registered, if found
+func registerCustomField(enterpriseid uint32, elementid uint16, field customFieldType, check func(old customFieldType, found bool) error) error {
 	if enterpriseid == 0 {
 		return NewError("Can not use IANA specified enterprise id.", ErrCritical)
 	}
+	if enterpriseid == ReversePEN {
+		return NewError("Can not use the reverse enterprise id, reverse elements are derived from the IANA elements.", ErrCritical)
+	}
 	customMapLock.Lock()
 	defer customMapLock.Unlock()
+	if check != nil {
+		old, found := customIPFIXIDMap[enterpriseid][elementid]
+		if err := check(old, found); err != nil {
+			return err
+		}
+	}
 	if _, found := customIPFIXIDMap[enterpriseid]; !found {
 		customIPFIXIDMap[enterpriseid] = make(map[uint16]customFieldType)
 	}
-	customIPFIXIDMap[enterpriseid][elementid] = customFieldType{
-		FieldLength: fieldlen,
-		Description: desc,
-		FieldVal:    val,
-	}
+	customIPFIXIDMap[enterpriseid][elementid] = field
+	customMapVersion.Add(1)
 
 	return nil
 }
@@ -45,12 +59,13 @@ func UnregisterCustomField(enterpriseid uint32, elementid uint16) error {
 	customMapLock.Lock()
 	defer customMapLock.Unlock()
 	if _, found := customIPFIXIDMap[enterpriseid]; !found {
-		return NewError(fmt.Sprintf("Did not find enterprise id %d", enterpriseid), ErrCritical)
+		return NewCategoryError(ErrUnknownElement, fmt.Sprintf("Did not find enterprise id %d", enterpriseid), ErrCritical)
 	}
 	if _, found := customIPFIXIDMap[enterpriseid][elementid]; !found {
-		return NewError(fmt.Sprintf("Did not find enterprise id %d, element id", enterpriseid, elementid), ErrCritical)
+		return NewCategoryError(ErrUnknownElement, fmt.Sprintf("Did not find enterprise id %d, element id %d", enterpriseid, elementid), ErrCritical)
 	}
 	delete(customIPFIXIDMap[enterpriseid], elementid)
+	customMapVersion.Add(1)
 	if len(customIPFIXIDMap[enterpriseid]) == 0 {
 		delete(customIPFIXIDMap, enterpriseid)
 	}
@@ -63,10 +78,10 @@ func GetCustomField(enterpriseid uint32, elementid uint16) (customFieldType, err
 	customMapLock.Lock()
 	defer customMapLock.Unlock()
 	if _, found := customIPFIXIDMap[enterpriseid]; !found {
-		return customFieldType{}, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+		return customFieldType{}, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 	}
 	if field, found := customIPFIXIDMap[enterpriseid][elementid]; !found {
-		return customFieldType{}, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+		return customFieldType{}, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 	} else {
 		return field, nil
 	}
@@ -952,7 +967,7 @@ func NewFieldValueByID(enterpriseid uint32, elementid uint16) (FieldValue, error
 		case 467:
 			return &FieldValueUnsigned32{}, nil // natThresholdEvent
 		default:
-			return nil, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 8057: // IPFIXColStyle - https://raw.githubusercontent.com/CESNET/ipfixcol/master/base/config/ipfix-elements.xml
@@ -1049,7 +1064,7 @@ func NewFieldValueByID(enterpriseid uint32, elementid uint16) (FieldValue, error
 		case 838:
 			return &FieldValueString{}, nil // SIPRecordRoute
 		default:
-			return nil, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 16982: // IPFIXColStyle - https://raw.githubusercontent.com/CESNET/ipfixcol/master/base/config/ipfix-elements.xml
@@ -1154,7 +1169,7 @@ func NewFieldValueByID(enterpriseid uint32, elementid uint16) (FieldValue, error
 		case 811:
 			return &FieldValueString{}, nil // tlsServerName
 		default:
-			return nil, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 35632: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -1165,7 +1180,7 @@ func NewFieldValueByID(enterpriseid uint32, elementid uint16) (FieldValue, error
 		case 187:
 			return &FieldValueString{}, nil // HTTPHost
 		default:
-			return nil, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 39499: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -1302,7 +1317,7 @@ func NewFieldValueByID(enterpriseid uint32, elementid uint16) (FieldValue, error
 		case 124:
 			return &FieldValueUnsigned16{}, nil // DNSCRRRDATALen
 		default:
-			return nil, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 44913: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -1329,15 +1344,21 @@ func NewFieldValueByID(enterpriseid uint32, elementid uint16) (FieldValue, error
 		case 12345:
 			return &FieldValueString{}, nil // Unknown
 		default:
-			return nil, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+		}
+
+	case ReversePEN: // RFC 5103 - the reverse counterparts of the reversible IANA elements
+		if !IsReversible(elementid) {
+			return nil, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
+		return NewFieldValueByID(0, elementid)
 
 	default: //Checking if we registered any custom elements
 		custfield, err := GetCustomField(enterpriseid, elementid)
 		if err != nil {
 			return nil, err
 		}
-		retval, err := getNewFieldValue(custfield)
+		retval, err := getNewFieldValue(custfield.FieldVal)
 		if err != nil {
 			return nil, err
 		}
@@ -2225,7 +2246,7 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		case 467:
 			return 4, nil // natThresholdEvent
 		default:
-			return 0, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 8057: // IPFIXColStyle - https://raw.githubusercontent.com/CESNET/ipfixcol/master/base/config/ipfix-elements.xml
@@ -2322,7 +2343,7 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		case 838:
 			return 65535, nil // SIPRecordRoute
 		default:
-			return 0, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 16982: // IPFIXColStyle - https://raw.githubusercontent.com/CESNET/ipfixcol/master/base/config/ipfix-elements.xml
@@ -2427,7 +2448,7 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		case 811:
 			return 65535, nil // tlsServerName
 		default:
-			return 0, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 35632: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -2438,7 +2459,7 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		case 187:
 			return 65535, nil // HTTPHost
 		default:
-			return 0, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 39499: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -2575,7 +2596,7 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		case 124:
 			return 2, nil // DNSCRRRDATALen
 		default:
-			return 0, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 44913: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -2602,9 +2623,15 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		case 12345:
 			return 65535, nil // Unknown
 		default:
-			return 0, NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
+	case ReversePEN: // RFC 5103 - the reverse counterparts of the reversible IANA elements
+		if !IsReversible(elementid) {
+			return 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+		}
+		return FieldLengthByID(0, elementid)
+
 	default: //Checking if we registered any custom elements
 		custfield, err := GetCustomField(enterpriseid, elementid)
 		if err != nil {
@@ -2612,7 +2639,6 @@ func FieldLengthByID(enterpriseid uint32, elementid uint16) (uint16, error) {
 		}
 		return custfield.FieldLength, nil
 	}
-	return 0, nil
 }
 
 // FieldDescriptionByID returns the given semantic description that matches the enterprise id and element id
@@ -3495,7 +3521,7 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		case 467:
 			return "natThresholdEvent", nil
 		default:
-			return "", NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 8057: // IPFIXColStyle - https://raw.githubusercontent.com/CESNET/ipfixcol/master/base/config/ipfix-elements.xml
@@ -3592,7 +3618,7 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		case 838:
 			return "SIPRecordRoute", nil
 		default:
-			return "", NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 16982: // IPFIXColStyle - https://raw.githubusercontent.com/CESNET/ipfixcol/master/base/config/ipfix-elements.xml
@@ -3697,7 +3723,7 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		case 811:
 			return "tlsServerName", nil
 		default:
-			return "", NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 35632: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -3708,7 +3734,7 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		case 187:
 			return "HTTPHost", nil
 		default:
-			return "", NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 39499: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -3845,7 +3871,7 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		case 124:
 			return "DNSCRRRDATALen", nil
 		default:
-			return "", NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
 
 	case 44913: // IPFIXColStyle - https://raw.githubusercontent.com/SecDorks/ipfixcol/master/base/config/ipfix-elements.xml
@@ -3872,8 +3898,15 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		case 12345:
 			return "Unknown", nil
 		default:
-			return "", NewError(fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
+		}
+
+	case ReversePEN: // RFC 5103 - the reverse counterparts of the reversible IANA elements
+		if !IsReversible(elementid) {
+			return "", NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: E%did%d", enterpriseid, elementid), ErrCritical)
 		}
+		name, err := FieldDescriptionByID(0, elementid)
+		return ReverseElementName(name), err
 
 	default: //Checking if we registered any custom elements
 		custfield, err := GetCustomField(enterpriseid, elementid)
@@ -3882,7 +3915,667 @@ func FieldDescriptionByID(enterpriseid uint32, elementid uint16) (string, error)
 		}
 		return custfield.Description, nil
 	}
-	return "", nil
+}
+
+// fieldName is the name of an element of the registries
+type fieldName struct {
+	enterpriseID uint32
+	elementID    uint16
+	name         string
+}
+
+// fieldNames are the names of the elements of the registries, by enterprise id, for FieldIDByName
+var fieldNames = []fieldName{
+	{0, 1, "octetDeltaCount"},
+	{0, 2, "packetDeltaCount"},
+	{0, 3, "deltaFlowCount"},
+	{0, 4, "protocolIdentifier"},
+	{0, 5, "ipClassOfService"},
+	{0, 6, "tcpControlBits"},
+	{0, 7, "sourceTransportPort"},
+	{0, 8, "sourceIPv4Address"},
+	{0, 9, "sourceIPv4PrefixLength"},
+	{0, 10, "ingressInterface"},
+	{0, 11, "destinationTransportPort"},
+	{0, 12, "destinationIPv4Address"},
+	{0, 13, "destinationIPv4PrefixLength"},
+	{0, 14, "egressInterface"},
+	{0, 15, "ipNextHopIPv4Address"},
+	{0, 16, "bgpSourceAsNumber"},
+	{0, 17, "bgpDestinationAsNumber"},
+	{0, 18, "bgpNextHopIPv4Address"},
+	{0, 19, "postMCastPacketDeltaCount"},
+	{0, 20, "postMCastOctetDeltaCount"},
+	{0, 21, "flowEndSysUpTime"},
+	{0, 22, "flowStartSysUpTime"},
+	{0, 23, "postOctetDeltaCount"},
+	{0, 24, "postPacketDeltaCount"},
+	{0, 25, "minimumIpTotalLength"},
+	{0, 26, "maximumIpTotalLength"},
+	{0, 27, "sourceIPv6Address"},
+	{0, 28, "destinationIPv6Address"},
+	{0, 29, "sourceIPv6PrefixLength"},
+	{0, 30, "destinationIPv6PrefixLength"},
+	{0, 31, "flowLabelIPv6"},
+	{0, 32, "icmpTypeCodeIPv4"},
+	{0, 33, "igmpType"},
+	{0, 34, "samplingInterval"},
+	{0, 35, "samplingAlgorithm"},
+	{0, 36, "flowActiveTimeout"},
+	{0, 37, "flowIdleTimeout"},
+	{0, 38, "engineType"},
+	{0, 39, "engineId"},
+	{0, 40, "exportedOctetTotalCount"},
+	{0, 41, "exportedMessageTotalCount"},
+	{0, 42, "exportedFlowRecordTotalCount"},
+	{0, 43, "ipv4RouterSc"},
+	{0, 44, "sourceIPv4Prefix"},
+	{0, 45, "destinationIPv4Prefix"},
+	{0, 46, "mplsTopLabelType"},
+	{0, 47, "mplsTopLabelIPv4Address"},
+	{0, 48, "samplerId"},
+	{0, 49, "samplerMode"},
+	{0, 50, "samplerRandomInterval"},
+	{0, 51, "classId"},
+	{0, 52, "minimumTTL"},
+	{0, 53, "maximumTTL"},
+	{0, 54, "fragmentIdentification"},
+	{0, 55, "postIpClassOfService"},
+	{0, 56, "sourceMacAddress"},
+	{0, 57, "postDestinationMacAddress"},
+	{0, 58, "vlanId"},
+	{0, 59, "postVlanId"},
+	{0, 60, "ipVersion"},
+	{0, 61, "flowDirection"},
+	{0, 62, "ipNextHopIPv6Address"},
+	{0, 63, "bgpNextHopIPv6Address"},
+	{0, 64, "ipv6ExtensionHeaders"},
+	{0, 70, "mplsTopLabelStackSection"},
+	{0, 71, "mplsLabelStackSection2"},
+	{0, 72, "mplsLabelStackSection3"},
+	{0, 73, "mplsLabelStackSection4"},
+	{0, 74, "mplsLabelStackSection5"},
+	{0, 75, "mplsLabelStackSection6"},
+	{0, 76, "mplsLabelStackSection7"},
+	{0, 77, "mplsLabelStackSection8"},
+	{0, 78, "mplsLabelStackSection9"},
+	{0, 79, "mplsLabelStackSection10"},
+	{0, 80, "destinationMacAddress"},
+	{0, 81, "postSourceMacAddress"},
+	{0, 82, "interfaceName"},
+	{0, 83, "interfaceDescription"},
+	{0, 84, "samplerName"},
+	{0, 85, "octetTotalCount"},
+	{0, 86, "packetTotalCount"},
+	{0, 87, "flagsAndSamplerId"},
+	{0, 88, "fragmentOffset"},
+	{0, 89, "forwardingStatus"},
+	{0, 90, "mplsVpnRouteDistinguisher"},
+	{0, 91, "mplsTopLabelPrefixLength"},
+	{0, 92, "srcTrafficIndex"},
+	{0, 93, "dstTrafficIndex"},
+	{0, 94, "applicationDescription"},
+	{0, 95, "applicationId"},
+	{0, 96, "applicationName"},
+	{0, 98, "postIpDiffServCodePoint"},
+	{0, 99, "multicastReplicationFactor"},
+	{0, 100, "className"},
+	{0, 101, "classificationEngineId"},
+	{0, 102, "layer2packetSectionOffset"},
+	{0, 103, "layer2packetSectionSize"},
+	{0, 104, "layer2packetSectionData"},
+	{0, 128, "bgpNextAdjacentAsNumber"},
+	{0, 129, "bgpPrevAdjacentAsNumber"},
+	{0, 130, "exporterIPv4Address"},
+	{0, 131, "exporterIPv6Address"},
+	{0, 132, "droppedOctetDeltaCount"},
+	{0, 133, "droppedPacketDeltaCount"},
+	{0, 134, "droppedOctetTotalCount"},
+	{0, 135, "droppedPacketTotalCount"},
+	{0, 136, "flowEndReason"},
+	{0, 137, "commonPropertiesId"},
+	{0, 138, "observationPointId"},
+	{0, 139, "icmpTypeCodeIPv6"},
+	{0, 140, "mplsTopLabelIPv6Address"},
+	{0, 141, "lineCardId"},
+	{0, 142, "portId"},
+	{0, 143, "meteringProcessId"},
+	{0, 144, "exportingProcessId"},
+	{0, 145, "templateId"},
+	{0, 146, "wlanChannelId"},
+	{0, 147, "wlanSSID"},
+	{0, 148, "flowId"},
+	{0, 149, "observationDomainId"},
+	{0, 150, "flowStartSeconds"},
+	{0, 151, "flowEndSeconds"},
+	{0, 152, "flowStartMilliseconds"},
+	{0, 153, "flowEndMilliseconds"},
+	{0, 154, "flowStartMicroseconds"},
+	{0, 155, "flowEndMicroseconds"},
+	{0, 156, "flowStartNanoseconds"},
+	{0, 157, "flowEndNanoseconds"},
+	{0, 158, "flowStartDeltaMicroseconds"},
+	{0, 159, "flowEndDeltaMicroseconds"},
+	{0, 160, "systemInitTimeMilliseconds"},
+	{0, 161, "flowDurationMilliseconds"},
+	{0, 162, "flowDurationMicroseconds"},
+	{0, 163, "observedFlowTotalCount"},
+	{0, 164, "ignoredPacketTotalCount"},
+	{0, 165, "ignoredOctetTotalCount"},
+	{0, 166, "notSentFlowTotalCount"},
+	{0, 167, "notSentPacketTotalCount"},
+	{0, 168, "notSentOctetTotalCount"},
+	{0, 169, "destinationIPv6Prefix"},
+	{0, 170, "sourceIPv6Prefix"},
+	{0, 171, "postOctetTotalCount"},
+	{0, 172, "postPacketTotalCount"},
+	{0, 173, "flowKeyIndicator"},
+	{0, 174, "postMCastPacketTotalCount"},
+	{0, 175, "postMCastOctetTotalCount"},
+	{0, 176, "icmpTypeIPv4"},
+	{0, 177, "icmpCodeIPv4"},
+	{0, 178, "icmpTypeIPv6"},
+	{0, 179, "icmpCodeIPv6"},
+	{0, 180, "udpSourcePort"},
+	{0, 181, "udpDestinationPort"},
+	{0, 182, "tcpSourcePort"},
+	{0, 183, "tcpDestinationPort"},
+	{0, 184, "tcpSequenceNumber"},
+	{0, 185, "tcpAcknowledgementNumber"},
+	{0, 186, "tcpWindowSize"},
+	{0, 187, "tcpUrgentPointer"},
+	{0, 188, "tcpHeaderLength"},
+	{0, 189, "ipHeaderLength"},
+	{0, 190, "totalLengthIPv4"},
+	{0, 191, "payloadLengthIPv6"},
+	{0, 192, "ipTTL"},
+	{0, 193, "nextHeaderIPv6"},
+	{0, 194, "mplsPayloadLength"},
+	{0, 195, "ipDiffServCodePoint"},
+	{0, 196, "ipPrecedence"},
+	{0, 197, "fragmentFlags"},
+	{0, 198, "octetDeltaSumOfSquares"},
+	{0, 199, "octetTotalSumOfSquares"},
+	{0, 200, "mplsTopLabelTTL"},
+	{0, 201, "mplsLabelStackLength"},
+	{0, 202, "mplsLabelStackDepth"},
+	{0, 203, "mplsTopLabelExp"},
+	{0, 204, "ipPayloadLength"},
+	{0, 205, "udpMessageLength"},
+	{0, 206, "isMulticast"},
+	{0, 207, "ipv4IHL"},
+	{0, 208, "ipv4Options"},
+	{0, 209, "tcpOptions"},
+	{0, 210, "paddingOctets"},
+	{0, 211, "collectorIPv4Address"},
+	{0, 212, "collectorIPv6Address"},
+	{0, 213, "exportInterface"},
+	{0, 214, "exportProtocolVersion"},
+	{0, 215, "exportTransportProtocol"},
+	{0, 216, "collectorTransportPort"},
+	{0, 217, "exporterTransportPort"},
+	{0, 218, "tcpSynTotalCount"},
+	{0, 219, "tcpFinTotalCount"},
+	{0, 220, "tcpRstTotalCount"},
+	{0, 221, "tcpPshTotalCount"},
+	{0, 222, "tcpAckTotalCount"},
+	{0, 223, "tcpUrgTotalCount"},
+	{0, 224, "ipTotalLength"},
+	{0, 225, "postNATSourceIPv4Address"},
+	{0, 226, "postNATDestinationIPv4Address"},
+	{0, 227, "postNAPTSourceTransportPort"},
+	{0, 228, "postNAPTDestinationTransportPort"},
+	{0, 229, "natOriginatingAddressRealm"},
+	{0, 230, "natEvent"},
+	{0, 231, "initiatorOctets"},
+	{0, 232, "responderOctets"},
+	{0, 233, "firewallEvent"},
+	{0, 234, "ingressVRFID"},
+	{0, 235, "egressVRFID"},
+	{0, 236, "VRFname"},
+	{0, 237, "postMplsTopLabelExp"},
+	{0, 238, "tcpWindowScale"},
+	{0, 239, "biflowDirection"},
+	{0, 240, "ethernetHeaderLength"},
+	{0, 241, "ethernetPayloadLength"},
+	{0, 242, "ethernetTotalLength"},
+	{0, 243, "dot1qVlanId"},
+	{0, 244, "dot1qPriority"},
+	{0, 245, "dot1qCustomerVlanId"},
+	{0, 246, "dot1qCustomerPriority"},
+	{0, 247, "metroEvcId"},
+	{0, 248, "metroEvcType"},
+	{0, 249, "pseudoWireId"},
+	{0, 250, "pseudoWireType"},
+	{0, 251, "pseudoWireControlWord"},
+	{0, 252, "ingressPhysicalInterface"},
+	{0, 253, "egressPhysicalInterface"},
+	{0, 254, "postDot1qVlanId"},
+	{0, 255, "postDot1qCustomerVlanId"},
+	{0, 256, "ethernetType"},
+	{0, 257, "postIpPrecedence"},
+	{0, 258, "collectionTimeMilliseconds"},
+	{0, 259, "exportSctpStreamId"},
+	{0, 260, "maxExportSeconds"},
+	{0, 261, "maxFlowEndSeconds"},
+	{0, 262, "messageMD5Checksum"},
+	{0, 263, "messageScope"},
+	{0, 264, "minExportSeconds"},
+	{0, 265, "minFlowStartSeconds"},
+	{0, 266, "opaqueOctets"},
+	{0, 267, "sessionScope"},
+	{0, 268, "maxFlowEndMicroseconds"},
+	{0, 269, "maxFlowEndMilliseconds"},
+	{0, 270, "maxFlowEndNanoseconds"},
+	{0, 271, "minFlowStartMicroseconds"},
+	{0, 272, "minFlowStartMilliseconds"},
+	{0, 273, "minFlowStartNanoseconds"},
+	{0, 274, "collectorCertificate"},
+	{0, 275, "exporterCertificate"},
+	{0, 276, "dataRecordsReliability"},
+	{0, 277, "observationPointType"},
+	{0, 278, "newConnectionDeltaCount"},
+	{0, 279, "connectionSumDurationSeconds"},
+	{0, 280, "connectionTransactionId"},
+	{0, 281, "postNATSourceIPv6Address"},
+	{0, 282, "postNATDestinationIPv6Address"},
+	{0, 283, "natPoolId"},
+	{0, 284, "natPoolName"},
+	{0, 285, "anonymizationFlags"},
+	{0, 286, "anonymizationTechnique"},
+	{0, 287, "informationElementIndex"},
+	{0, 288, "p2pTechnology"},
+	{0, 289, "tunnelTechnology"},
+	{0, 290, "encryptedTechnology"},
+	{0, 291, "basicList"},
+	{0, 292, "subTemplateList"},
+	{0, 293, "subTemplateMultiList"},
+	{0, 294, "bgpValidityState"},
+	{0, 295, "IPSecSPI"},
+	{0, 296, "greKey"},
+	{0, 297, "natType"},
+	{0, 298, "initiatorPackets"},
+	{0, 299, "responderPackets"},
+	{0, 300, "observationDomainName"},
+	{0, 301, "selectionSequenceId"},
+	{0, 302, "selectorId"},
+	{0, 303, "informationElementId"},
+	{0, 304, "selectorAlgorithm"},
+	{0, 305, "samplingPacketInterval"},
+	{0, 306, "samplingPacketSpace"},
+	{0, 307, "samplingTimeInterval"},
+	{0, 308, "samplingTimeSpace"},
+	{0, 309, "samplingSize"},
+	{0, 310, "samplingPopulation"},
+	{0, 311, "samplingProbability"},
+	{0, 312, "dataLinkFrameSize"},
+	{0, 313, "ipHeaderPacketSection"},
+	{0, 314, "ipPayloadPacketSection"},
+	{0, 315, "dataLinkFrameSection"},
+	{0, 316, "mplsLabelStackSection"},
+	{0, 317, "mplsPayloadPacketSection"},
+	{0, 318, "selectorIdTotalPktsObserved"},
+	{0, 319, "selectorIdTotalPktsSelected"},
+	{0, 320, "absoluteError"},
+	{0, 321, "relativeError"},
+	{0, 322, "observationTimeSeconds"},
+	{0, 323, "observationTimeMilliseconds"},
+	{0, 324, "observationTimeMicroseconds"},
+	{0, 325, "observationTimeNanoseconds"},
+	{0, 326, "digestHashValue"},
+	{0, 327, "hashIPPayloadOffset"},
+	{0, 328, "hashIPPayloadSize"},
+	{0, 329, "hashOutputRangeMin"},
+	{0, 330, "hashOutputRangeMax"},
+	{0, 331, "hashSelectedRangeMin"},
+	{0, 332, "hashSelectedRangeMax"},
+	{0, 333, "hashDigestOutput"},
+	{0, 334, "hashInitialiserValue"},
+	{0, 335, "selectorName"},
+	{0, 336, "upperCILimit"},
+	{0, 337, "lowerCILimit"},
+	{0, 338, "confidenceLevel"},
+	{0, 339, "informationElementDataType"},
+	{0, 340, "informationElementDescription"},
+	{0, 341, "informationElementName"},
+	{0, 342, "informationElementRangeBegin"},
+	{0, 343, "informationElementRangeEnd"},
+	{0, 344, "informationElementSemantics"},
+	{0, 345, "informationElementUnits"},
+	{0, 346, "privateEnterpriseNumber"},
+	{0, 347, "virtualStationInterfaceId"},
+	{0, 348, "virtualStationInterfaceName"},
+	{0, 349, "virtualStationUUID"},
+	{0, 350, "virtualStationName"},
+	{0, 351, "layer2SegmentId"},
+	{0, 352, "layer2OctetDeltaCount"},
+	{0, 353, "layer2OctetTotalCount"},
+	{0, 354, "ingressUnicastPacketTotalCount"},
+	{0, 355, "ingressMulticastPacketTotalCount"},
+	{0, 356, "ingressBroadcastPacketTotalCount"},
+	{0, 357, "egressUnicastPacketTotalCount"},
+	{0, 358, "egressBroadcastPacketTotalCount"},
+	{0, 359, "monitoringIntervalStartMilliSeconds"},
+	{0, 360, "monitoringIntervalEndMilliSeconds"},
+	{0, 361, "portRangeStart"},
+	{0, 362, "portRangeEnd"},
+	{0, 363, "portRangeStepSize"},
+	{0, 364, "portRangeNumPorts"},
+	{0, 365, "staMacAddress"},
+	{0, 366, "staIPv4Address"},
+	{0, 367, "wtpMacAddress"},
+	{0, 368, "ingressInterfaceType"},
+	{0, 369, "egressInterfaceType"},
+	{0, 370, "rtpSequenceNumber"},
+	{0, 371, "userName"},
+	{0, 372, "applicationCategoryName"},
+	{0, 373, "applicationSubCategoryName"},
+	{0, 374, "applicationGroupName"},
+	{0, 375, "originalFlowsPresent"},
+	{0, 376, "originalFlowsInitiated"},
+	{0, 377, "originalFlowsCompleted"},
+	{0, 378, "distinctCountOfSourceIPAddress"},
+	{0, 379, "distinctCountOfDestinationIPAddress"},
+	{0, 380, "distinctCountOfSourceIPv4Address"},
+	{0, 381, "distinctCountOfDestinationIPv4Address"},
+	{0, 382, "distinctCountOfSourceIPv6Address"},
+	{0, 383, "distinctCountOfDestinationIPv6Address"},
+	{0, 384, "valueDistributionMethod"},
+	{0, 385, "rfc3550JitterMilliseconds"},
+	{0, 386, "rfc3550JitterMicroseconds"},
+	{0, 387, "rfc3550JitterNanoseconds"},
+	{0, 388, "dot1qDEI"},
+	{0, 389, "dot1qCustomerDEI"},
+	{0, 390, "flowSelectorAlgorithm"},
+	{0, 391, "flowSelectedOctetDeltaCount"},
+	{0, 392, "flowSelectedPacketDeltaCount"},
+	{0, 393, "flowSelectedFlowDeltaCount"},
+	{0, 394, "selectorIDTotalFlowsObserved"},
+	{0, 395, "selectorIDTotalFlowsSelected"},
+	{0, 396, "samplingFlowInterval"},
+	{0, 397, "samplingFlowSpacing"},
+	{0, 398, "flowSamplingTimeInterval"},
+	{0, 399, "flowSamplingTimeSpacing"},
+	{0, 400, "hashFlowDomain"},
+	{0, 401, "transportOctetDeltaCount"},
+	{0, 402, "transportPacketDeltaCount"},
+	{0, 403, "originalExporterIPv4Address"},
+	{0, 404, "originalExporterIPv6Address"},
+	{0, 405, "originalObservationDomainId"},
+	{0, 406, "intermediateProcessId"},
+	{0, 407, "ignoredDataRecordTotalCount"},
+	{0, 408, "dataLinkFrameType"},
+	{0, 409, "sectionOffset"},
+	{0, 410, "sectionExportedOctets"},
+	{0, 411, "dot1qServiceInstanceTag"},
+	{0, 412, "dot1qServiceInstanceId"},
+	{0, 413, "dot1qServiceInstancePriority"},
+	{0, 414, "dot1qCustomerSourceMacAddress"},
+	{0, 415, "dot1qCustomerDestinationMacAddress"},
+	{0, 417, "postLayer2OctetDeltaCount"},
+	{0, 418, "postMCastLayer2OctetDeltaCount"},
+	{0, 420, "postLayer2OctetTotalCount"},
+	{0, 421, "postMCastLayer2OctetTotalCount"},
+	{0, 422, "minimumLayer2TotalLength"},
+	{0, 423, "maximumLayer2TotalLength"},
+	{0, 424, "droppedLayer2OctetDeltaCount"},
+	{0, 425, "droppedLayer2OctetTotalCount"},
+	{0, 426, "ignoredLayer2OctetTotalCount"},
+	{0, 427, "notSentLayer2OctetTotalCount"},
+	{0, 428, "layer2OctetDeltaSumOfSquares"},
+	{0, 429, "layer2OctetTotalSumOfSquares"},
+	{0, 430, "layer2FrameDeltaCount"},
+	{0, 431, "layer2FrameTotalCount"},
+	{0, 432, "pseudoWireDestinationIPv4Address"},
+	{0, 433, "ignoredLayer2FrameTotalCount"},
+	{0, 434, "mibObjectValueInteger"},
+	{0, 435, "mibObjectValueOctetString"},
+	{0, 436, "mibObjectValueOID"},
+	{0, 437, "mibObjectValueBits"},
+	{0, 438, "mibObjectValueIPAddress"},
+	{0, 439, "mibObjectValueCounter"},
+	{0, 440, "mibObjectValueGauge"},
+	{0, 441, "mibObjectValueTimeTicks"},
+	{0, 442, "mibObjectValueUnsigned"},
+	{0, 443, "mibObjectValueTable"},
+	{0, 444, "mibObjectValueRow"},
+	{0, 445, "mibObjectIdentifier"},
+	{0, 446, "mibSubIdentifier"},
+	{0, 447, "mibIndexIndicator"},
+	{0, 448, "mibCaptureTimeSemantics"},
+	{0, 449, "mibContextEngineID"},
+	{0, 450, "mibContextName"},
+	{0, 451, "mibObjectName"},
+	{0, 452, "mibObjectDescription"},
+	{0, 453, "mibObjectSyntax"},
+	{0, 454, "mibModuleName"},
+	{0, 455, "mobileIMSI"},
+	{0, 456, "mobileMSISDN"},
+	{0, 457, "httpStatusCode"},
+	{0, 458, "sourceTransportPortsLimit"},
+	{0, 459, "httpRequestMethod"},
+	{0, 460, "httpRequestHost"},
+	{0, 461, "httpRequestTarget"},
+	{0, 462, "httpMessageVersion"},
+	{0, 463, "natInstanceID"},
+	{0, 464, "internalAddressRealm"},
+	{0, 465, "externalAddressRealm"},
+	{0, 466, "natQuotaExceededEvent"},
+	{0, 467, "natThresholdEvent"},
+	{8057, 1, "DNSRCode"},
+	{8057, 2, "DNSName"},
+	{8057, 3, "DNSQType"},
+	{8057, 4, "DNSClass"},
+	{8057, 5, "DNSRRTTL"},
+	{8057, 6, "DNSRDataLength"},
+	{8057, 7, "DNSRData"},
+	{8057, 8, "DNSPSIZE"},
+	{8057, 9, "DNSRDO"},
+	{8057, 10, "DNSTransactionID"},
+	{8057, 700, "HBType"},
+	{8057, 701, "HBDir"},
+	{8057, 702, "HBSizeMsg"},
+	{8057, 703, "HBSizePayload"},
+	{8057, 800, "HTTPRequestMethod"},
+	{8057, 801, "HTTPRequestHost"},
+	{8057, 802, "HTTPRequestURL"},
+	{8057, 803, "HTTPRequestReferer"},
+	{8057, 804, "HTTPRequestAgent"},
+	{8057, 805, "HTTPResponseCode"},
+	{8057, 806, "HTTPResponseType"},
+	{8057, 807, "HTTPResponseTime"},
+	{8057, 808, "HTTPSHost"},
+	{8057, 809, "HTTPSResponseTime"},
+	{8057, 810, "SMTPCommands"},
+	{8057, 811, "SMTPMailCount"},
+	{8057, 812, "SMTPRcptCount"},
+	{8057, 813, "SMTPSender"},
+	{8057, 814, "SMTPRecipient"},
+	{8057, 815, "SMTPStatusCodes"},
+	{8057, 816, "SMTPCode2XXCount"},
+	{8057, 817, "SMTPCode3XXCount"},
+	{8057, 818, "SMTPCode4XXCount"},
+	{8057, 819, "SMTPCode5XXCount"},
+	{8057, 820, "SMTPDomain"},
+	{8057, 821, "HTTPRequestRange"},
+	{8057, 830, "SIPMethod"},
+	{8057, 831, "SIPStatusCode"},
+	{8057, 832, "SIPRequestURI"},
+	{8057, 833, "SIPFrom"},
+	{8057, 834, "SIPTo"},
+	{8057, 835, "SIPContact"},
+	{8057, 836, "SIPVia"},
+	{8057, 837, "SIPRoute"},
+	{8057, 838, "SIPRecordRoute"},
+	{16982, 1, "destinationGeo"},
+	{16982, 100, "HTTPUserAgent"},
+	{16982, 101, "HTTPMethod"},
+	{16982, 102, "HTTPDomain"},
+	{16982, 103, "HTTPReferer"},
+	{16982, 104, "HTTPContentType"},
+	{16982, 105, "HTTPUrl"},
+	{16982, 106, "HTTPStatus"},
+	{16982, 107, "HTTPHeaderCount"},
+	{16982, 200, "appPID"},
+	{16982, 201, "appName"},
+	{16982, 202, "appUID"},
+	{16982, 203, "appMatchLevel"},
+	{16982, 400, "tunnelSrcIPv6"},
+	{16982, 401, "tunnelDstIPv6"},
+	{16982, 402, "tunnelSrcPort"},
+	{16982, 403, "tunnelDstPort"},
+	{16982, 404, "tunnelProtocol"},
+	{16982, 405, "tunnelType"},
+	{16982, 406, "tunnelTCPFlags"},
+	{16982, 407, "tunnelICMPcode"},
+	{16982, 408, "tunnelTeredoHeaders"},
+	{16982, 409, "tunnelTeredoTrailers"},
+	{16982, 410, "tunnelSourceGeo"},
+	{16982, 411, "tunnelDestinationGeo"},
+	{16982, 412, "tunnelOuterSourceGeo"},
+	{16982, 413, "tunnelOuterDestinationGeo"},
+	{16982, 414, "tunnelHOPLimit"},
+	{16982, 500, "HTTPRequestType"},
+	{16982, 501, "HTTPRequestHost"},
+	{16982, 502, "HTTPRequestURL"},
+	{16982, 503, "HTTPRequestAgentID"},
+	{16982, 504, "HTTPRequestAgent"},
+	{16982, 505, "HTTPRequestReferer"},
+	{16982, 506, "HTTPResponseCode"},
+	{16982, 507, "HTTPResponseType"},
+	{16982, 508, "HTTPResponseTime"},
+	{16982, 800, "tlsCliVer"},
+	{16982, 801, "tlsSerVer"},
+	{16982, 802, "tlsSerCips"},
+	{16982, 803, "tlsCliCips"},
+	{16982, 804, "tlsCertSubject"},
+	{16982, 805, "tlsCertIssuer"},
+	{16982, 806, "tlsCertNotBefore"},
+	{16982, 807, "tlsCerNotAfter"},
+	{16982, 808, "tlsPkeyLength"},
+	{16982, 809, "tlsPkeyExponent"},
+	{16982, 810, "tlsPkeyAlgorithm"},
+	{16982, 811, "tlsServerName"},
+	{35632, 180, "HTTPUrl"},
+	{35632, 187, "HTTPHost"},
+	{39499, 1, "HTTPRequestHost"},
+	{39499, 2, "HTTPRequestURL"},
+	{39499, 3, "HTTPRequestReferer"},
+	{39499, 4, "HTTPRequestType"},
+	{39499, 10, "HTTPResponseType"},
+	{39499, 12, "HTTPResponseCode"},
+	{39499, 20, "HTTPRequestAgent"},
+	{39499, 21, "HTTPRequestAgentID"},
+	{39499, 22, "HTTPRequestAgentOS"},
+	{39499, 23, "HTTPRequestAgentOSMajor"},
+	{39499, 24, "HTTPRequestAgentOSMinor"},
+	{39499, 25, "HTTPRequestAgentOSBuild"},
+	{39499, 26, "HTTPRequestAgentApp"},
+	{39499, 27, "HTTPRequestAgentAppMajor"},
+	{39499, 28, "HTTPRequestAgentAppMinor"},
+	{39499, 29, "HTTPRequestAgentAppBuild"},
+	{39499, 32, "voipPacketType"},
+	{39499, 33, "sipCallId"},
+	{39499, 34, "sipCallingParty"},
+	{39499, 35, "sipCalledParty"},
+	{39499, 36, "sipVia"},
+	{39499, 37, "sipInviteRingingTime"},
+	{39499, 38, "sipOkTime"},
+	{39499, 39, "sipByeTime"},
+	{39499, 40, "sipRtpIp4"},
+	{39499, 41, "sipRtpIp6"},
+	{39499, 42, "sipRtpAudio"},
+	{39499, 43, "sipRtpVideo"},
+	{39499, 44, "sipStats"},
+	{39499, 45, "rtpCodec"},
+	{39499, 46, "rtpJitter"},
+	{39499, 47, "rtcpLost"},
+	{39499, 48, "rtcpPackets"},
+	{39499, 49, "rtcpOctets"},
+	{39499, 50, "rtcpSourceCount"},
+	{39499, 51, "sipUserAgent"},
+	{39499, 52, "sipRequestUri"},
+	{39499, 53, "sipCSeq"},
+	{39499, 61, "NPMJitterDev"},
+	{39499, 62, "NPMJitterAvg"},
+	{39499, 63, "NPMJitterMin"},
+	{39499, 64, "NPMJitterMax"},
+	{39499, 65, "NPMDelayDev"},
+	{39499, 66, "NPMDelayAvg"},
+	{39499, 67, "NPMDelayMin"},
+	{39499, 68, "NPMDelayMax"},
+	{39499, 69, "NPMRoundTripTime"},
+	{39499, 70, "NPMServerResponseTime"},
+	{39499, 71, "NPMTCPRetransmission"},
+	{39499, 72, "NPMTCPOutOfOrder"},
+	{39499, 110, "DNSID"},
+	{39499, 111, "DNSFlagsCodes"},
+	{39499, 112, "DNSQuestionCount"},
+	{39499, 113, "DNSAnswRecCount"},
+	{39499, 114, "DNSAuthRecCount"},
+	{39499, 115, "DNSAddtRecCount"},
+	{39499, 116, "DNSCRRName"},
+	{39499, 117, "DNSCRRType"},
+	{39499, 118, "DNSCRRClass"},
+	{39499, 119, "DNSCRRTTL"},
+	{39499, 120, "DNSCRRRDATA"},
+	{39499, 121, "DNSQNAME"},
+	{39499, 122, "DNSQTYPE"},
+	{39499, 123, "DNSQCLASS"},
+	{39499, 124, "DNSCRRRDATALen"},
+	{44913, 10, "origSourceTransportPort"},
+	{44913, 11, "origSourceIPv4Address"},
+	{44913, 12, "origDestinationTransportPort"},
+	{44913, 13, "origDestinationIPv4Address"},
+	{44913, 14, "origSourceIPv6Address"},
+	{44913, 15, "origDestinationIPv6Address"},
+	{44913, 20, "HTTPRequestHost"},
+	{44913, 21, "HTTPRequestURL"},
+	{44913, 22, "HTTPRequestUserAgent"},
+	{44913, 12345, "Unknown"},
+}
+
+var (
+	fieldNameIndex     map[string]fieldName
+	fieldNameIndexOnce sync.Once
+)
+
+// FieldIDByName returns the enterprise id and element id of the element with the name, the reverse of FieldDescriptionByID.
+// If enterprises use the same name, the element of the lowest enterprise id is returned, so IANA elements come first.
+// Reverse elements are found by their reverse name, custom fields by their description.
+func FieldIDByName(name string) (uint32, uint16, error) {
+	fieldNameIndexOnce.Do(func() {
+		fieldNameIndex = make(map[string]fieldName, len(fieldNames))
+		for _, field := range fieldNames {
+			if _, found := fieldNameIndex[field.name]; !found {
+				fieldNameIndex[field.name] = field
+			}
+		}
+		for _, field := range fieldNames {
+			reversename := ReverseElementName(field.name)
+			if _, found := fieldNameIndex[reversename]; !found && field.enterpriseID == 0 && IsReversible(field.elementID) {
+				fieldNameIndex[reversename] = fieldName{enterpriseID: ReversePEN, elementID: field.elementID, name: reversename}
+			}
+		}
+	})
+	if field, found := fieldNameIndex[name]; found {
+		return field.enterpriseID, field.elementID, nil
+	}
+
+	customMapLock.Lock()
+	defer customMapLock.Unlock()
+	var found *fieldName
+	for enterpriseid, fields := range customIPFIXIDMap {
+		for elementid, custfield := range fields {
+			if custfield.Description == name && (found == nil || enterpriseid < found.enterpriseID || (enterpriseid == found.enterpriseID && elementid < found.elementID)) {
+				found = &fieldName{enterpriseID: enterpriseid, elementID: elementid, name: name}
+			}
+		}
+	}
+	if found == nil {
+		return 0, 0, NewCategoryError(ErrUnknownElement, fmt.Sprintf("No such element: %s", name), ErrCritical)
+	}
+	return found.enterpriseID, found.elementID, nil
 }
 
 // fieldInstanceExists returns whether a specific field already exists (first bool) and whether it is a custom field or not (second bool)
@@ -5145,6 +5838,9 @@ func fieldInstanceExists(enterpriseid uint32, elementid uint16) (bool, bool) {
 			return false, false
 		}
 
+	case ReversePEN: // RFC 5103 - the reverse counterparts of the reversible IANA elements
+		return IsReversible(elementid), false
+
 	default: //Checking if we registered any custom elements
 		_, err := GetCustomField(enterpriseid, elementid)
 		if err != nil {
@@ -5152,7 +5848,6 @@ func fieldInstanceExists(enterpriseid uint32, elementid uint16) (bool, bool) {
 		}
 		return true, true
 	}
-	return false, false
 }
 
 //getNewFieldValue returns a new empty FieldValue based on the FieldValue provided.
@@ -0,0 +1,146 @@
+package ipfix
+
+import "fmt"
+
+/*
+
+An exporter controls most of what a collector allocates: it can announce up to 65,280 templates per Observation Domain,
+templates with thousands of fields, and basicLists and subTemplateLists nested inside each other without end.
+Limits caps these, so a single hostile or broken exporter can not exhaust the memory or CPU of a collector.
+A violated limit is reported as a ProtocolError with severity ErrCritical whose category is a *LimitError.
+
+*/
+
+// Limits are the resource limits that are applied while decoding. A limit of 0 means no limit.
+type Limits struct {
+	MaxTemplates         int //Maximum number of active templates in a template list, so per session
+	MaxFieldsPerTemplate int //Maximum number of fields in a single template, including the scope fields
+	MaxListDepth         int //Maximum nesting depth of basicLists, subTemplateLists and subTemplateMultiLists. A list in a data record has depth 1.
+	MaxRecordsPerSet     int //Maximum number of records in a single set
+	MaxDecodedBytes      int //Maximum number of octets decoded for a single message. The octets of a nested list are counted again for every level.
+	MaxCustomTypes       int //Maximum number of custom fields registered by received Information Element types, for the whole process, see Session.RegisterTypes
+}
+
+// DefaultLimits are the limits used by the DefaultDecodeOptions. They are well above what exporters send in practice.
+var DefaultLimits = Limits{
+	MaxTemplates:         4096,
+	MaxFieldsPerTemplate: 512,
+	MaxListDepth:         8,
+	MaxRecordsPerSet:     16384,
+	MaxDecodedBytes:      1 << 20,
+	MaxCustomTypes:       1024,
+}
+
+// LimitError tells which limit was exceeded. It matches ErrLimitExceeded with errors.Is and can be retrieved from a ProtocolError with errors.As.
+type LimitError struct {
+	Limit string //The name of the limit, as in Limits
+	Max   int    //The configured value of the limit
+	Value int    //The value that exceeded the limit
+}
+
+// Error implements the error interface
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("%s of %d exceeded: %d", err.Limit, err.Max, err.Value)
+}
+
+// Is reports whether target is ErrLimitExceeded, for use with errors.Is
+func (err *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// checkLimit returns a critical error if value exceeds max, or nil if it does not or max is 0
+func checkLimit(limit string, max, value int) *ProtocolError {
+	if max <= 0 || value <= max {
+		return nil
+	}
+	limiterr := &LimitError{Limit: limit, Max: max, Value: value}
+	return NewCategoryError(limiterr, fmt.Sprintf("Limit exceeded: %s", limiterr), ErrCritical)
+}
+
+// decodeState keeps track of the limits while decoding a single message, and of the templates of the message that are not set yet
+type decodeState struct {
+	limits  Limits
+	depth   int //Nesting depth of the list that is being decoded
+	decoded int //Octets decoded so far
+
+	templates *ActiveTemplates              //The templates that the staged templates are set in when the message is accepted
+	staged    map[uint16]*decodePlan        //The templates of the message, by Template ID, which later sets of the message use
+	order     []*TemplateRecord             //The staged templates in the order of the message
+	plans     map[decodePlanKey]*decodePlan //The plans looked up so far, so the templates are locked once per template and message, not per list
+}
+
+// decodePlanKey identifies the decode plan of a template in a template list
+type decodePlanKey struct {
+	templates *ActiveTemplates
+	id        uint16
+}
+
+// newDecodeState returns the state for decoding a new message under limits
+func newDecodeState(limits Limits) *decodeState {
+	return &decodeState{limits: limits}
+}
+
+// decode accounts for n octets that are about to be decoded
+func (state *decodeState) decode(n int) *ProtocolError {
+	state.decoded += n
+	return checkLimit("MaxDecodedBytes", state.limits.MaxDecodedBytes, state.decoded)
+}
+
+// enterList accounts for decoding a list nested one level deeper, leaveList must be called when it is done
+func (state *decodeState) enterList() *ProtocolError {
+	state.depth++
+	return checkLimit("MaxListDepth", state.limits.MaxListDepth, state.depth)
+}
+
+// leaveList accounts for having decoded a list
+func (state *decodeState) leaveList() {
+	state.depth--
+}
+
+// plan returns the decode plan of the template with the id in templates, or of the staged template of the message with the id
+func (state *decodeState) plan(templates *ActiveTemplates, id uint16) (*decodePlan, error) {
+	if templates != nil && templates == state.templates {
+		if plan, found := state.staged[id]; found {
+			return plan, nil
+		}
+	}
+	key := decodePlanKey{templates: templates, id: id}
+	if plan, found := state.plans[key]; found {
+		return plan, nil
+	}
+	plan, err := templates.plan(id)
+	if err != nil {
+		return nil, err
+	}
+	if state.plans == nil {
+		state.plans = make(map[decodePlanKey]*decodePlan)
+	}
+	state.plans[key] = plan
+	return plan, nil
+}
+
+// stage keeps a template of the message until the message is accepted and setStaged is called.
+// The later sets of the message are decoded with it, but the templates are not changed by a message that is discarded.
+func (state *decodeState) stage(templates *ActiveTemplates, tmplrec *TemplateRecord) {
+	if state.staged == nil {
+		state.staged = make(map[uint16]*decodePlan)
+	}
+	state.templates = templates
+	state.staged[tmplrec.TemplateID] = newDecodePlan(tmplrec)
+	state.order = append(state.order, tmplrec)
+}
+
+// setStaged sets the staged templates in the order of the message, and stacks the errors of setting them onto err, which gets desc if it is nil
+func (state *decodeState) setStaged(err error, desc string) error {
+	for _, tmplrec := range state.order {
+		var suberr error
+		if tmplrec.IsWithdrawal() {
+			suberr = state.templates.withdraw(tmplrec)
+		} else {
+			suberr = state.templates.setLimited(tmplrec.TemplateID, tmplrec, state.limits.MaxTemplates)
+		}
+		err = stackError(err, desc, suberr, 0)
+	}
+	state.staged, state.order = nil, nil
+	return err
+}
@@ -0,0 +1,88 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	limitsTestPrint = false
+)
+
+func TestLimitsMarker(t *testing.T) {
+	if limitsTestPrint {
+		fmt.Printf(testMarkerString, "Limits")
+	}
+}
+
+func TestLimitsExceeded(t *testing.T) {
+	tmplset := []byte{0, 2, 0, 24,
+		1, 0, 0, 1, 0, 8, 0, 4, //Template 256, sourceIPv4Address
+		1, 1, 0, 2, 0, 8, 0, 4, 0, 12, 0, 4, //Template 257, sourceIPv4Address and destinationIPv4Address
+	}
+	listtmplset := []byte{0, 2, 0, 12, 1, 2, 0, 1, 1, 35, 0xff, 0xff} //Template 258, basicList
+	datset := []byte{1, 0, 0, 12, 10, 0, 0, 1, 10, 0, 0, 2}
+	nestedset := []byte{1, 2, 0, 18,
+		13,                      //Variable length of the outer list
+		3, 1, 35, 0xff, 0xff, 7, //Outer basicList of basicLists, variable length of the inner list
+		3, 0, 11, 0, 2, 0, 80, //Inner basicList of destinationTransportPort
+	}
+
+	tests := []struct {
+		name   string
+		limits Limits
+		sets   [][]byte
+		limit  string
+	}{
+		{"templates", Limits{MaxTemplates: 1}, [][]byte{tmplset}, "MaxTemplates"},
+		{"fields per template", Limits{MaxFieldsPerTemplate: 1}, [][]byte{tmplset}, "MaxFieldsPerTemplate"},
+		{"list depth", Limits{MaxListDepth: 1}, [][]byte{listtmplset, nestedset}, "MaxListDepth"},
+		{"records per set", Limits{MaxRecordsPerSet: 1}, [][]byte{tmplset, datset}, "MaxRecordsPerSet"},
+		{"decoded bytes", Limits{MaxDecodedBytes: 6}, [][]byte{tmplset, datset}, "MaxDecodedBytes"},
+	}
+	for _, test := range tests {
+		data := sessionTestMessage(1, test.sets...)
+
+		ipfixmsg, _ := NewMessage()
+		ipfixmsg.AssociatedTemplates = NewActiveTemplateList()
+		err := ipfixmsg.UnmarshalBinaryWithOptions(data, DecodeOptions{Limits: test.limits})
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf(errorPrefixMarker+"%s: expected a limit error, but got %v", test.name, err)
+			continue
+		}
+		var limiterr *LimitError
+		if !errors.As(err, &limiterr) || limiterr.Limit != test.limit {
+			t.Errorf(errorPrefixMarker+"%s: expected %s to be exceeded, but got %#v", test.name, test.limit, limiterr)
+		}
+		if limitsTestPrint {
+			fmt.Println(err)
+		}
+
+		ipfixmsg.AssociatedTemplates = NewActiveTemplateList()
+		if err := ipfixmsg.UnmarshalBinaryWithOptions(data, DefaultDecodeOptions); err != nil {
+			t.Errorf(errorPrefixMarker+"%s: should be within the default limits, but got %v", test.name, err)
+		}
+	}
+}
+
+func TestLimitsTemplates(t *testing.T) {
+	session := NewSession(nil)
+	session.DecodeOptions.Limits.MaxTemplates = 1
+	first := []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 8, 0, 4}
+	second := []byte{0, 2, 0, 12, 1, 1, 0, 1, 0, 8, 0, 4}
+	replaced := []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 12, 0, 4}
+
+	if _, err := session.UnmarshalMessage(sessionTestMessage(1, first)); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error adding first template: %v", err)
+	}
+	if _, err := session.UnmarshalMessage(sessionTestMessage(1, second)); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf(errorPrefixMarker+"Expected a limit error adding second template, but got %v", err)
+	}
+	if _, err := session.AssociatedTemplates.Get(257); err == nil {
+		t.Errorf(errorPrefixMarker + "Template over the limit should not have been added")
+	}
+	if _, err := session.UnmarshalMessage(sessionTestMessage(1, replaced)); err != nil {
+		t.Errorf(errorPrefixMarker+"Replacing a template should not count against the limit, but got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package ipfix
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+
+A Mediator is the core of an RFC 6183 intermediate process that proxies: it receives the messages of many Exporting Processes
+and re-exports their records on a single Transport Session to an upstream Collecting Process. The Template IDs and Observation
+Domain IDs of different exporters collide, so the Mediator remaps them:
+
+  - every Observation Domain of every exporter gets an upstream Observation Domain ID, from 1 up or as set with SetObservationDomain
+  - every template of every Observation Domain gets an upstream Template ID that is unique on the upstream session, from 256 up
+  - the Data Records get the upstream Template ID, so they end up in Data Sets with the upstream Set ID
+
+The upstream templates are kept in the Templates of the Mediator, and are exported before the first records that use them.
+
+	mediator := NewMediator(0)
+	mediator.TemplateRefreshInterval = 10 * time.Minute
+	...
+	ipfixmsg, err := sessions[exporter].UnmarshalMessage(data) //A Session per exporter
+	err = mediator.AddMessage(exporter, ipfixmsg)
+	...
+	for _, upstreammsg := range mediator.Flush() {
+		...
+	}
+
+An exporter is identified by a string chosen by the caller, like the address of its Transport Session. A template that an exporter
+replaces gets a new upstream Template ID, and the old one is reused after the next Flush, when no message that uses it is pending.
+Over TCP and SCTP, a MaxMessageSize of 0, RFC 7011 section 8.1 only allows that after a Template Withdrawal: the next Flush returns
+the withdrawals of the old upstream templates after the records that use them.
+The records are changed in place and referenced by the messages, not copied. A Mediator is safe for concurrent use.
+
+*/
+
+// mediatorDomain identifies an Observation Domain of an exporter
+type mediatorDomain struct {
+	exporter            string
+	observationDomainID uint32
+}
+
+// mediatorTemplateKey identifies a template of an Observation Domain of an exporter
+type mediatorTemplateKey struct {
+	domain     mediatorDomain
+	templateID uint16
+}
+
+// mediatorTemplate is the upstream template of a template of an exporter
+type mediatorTemplate struct {
+	record   *TemplateRecord //The template of the exporter, to notice it is replaced
+	upstream *TemplateRecord
+	builder  *MessageBuilder //Of the upstream Observation Domain the template is exported in
+}
+
+// Mediator re-exports the messages of many exporters upstream, with remapped Template IDs and Observation Domain IDs
+type Mediator struct {
+	Templates               *ActiveTemplates //The upstream templates
+	MaxMessageSize          int              //Maximum length of the upstream messages, see NewMessageBuilder
+	TemplateRefreshInterval time.Duration    //Templates are exported again after this interval, for UDP. 0 does not refresh.
+
+	domains        map[mediatorDomain]uint32
+	upstreamIDs    map[uint32]bool
+	builders       map[uint32]*MessageBuilder
+	builderOrder   []uint32
+	templates      map[mediatorTemplateKey]*mediatorTemplate
+	usedTemplates  map[uint16]bool     //Upstream Template IDs in use, or retired until the next Flush
+	retired        []*mediatorTemplate //Retired upstream templates, which are withdrawn over TCP and SCTP and whose IDs are reused after the next Flush
+	nextDomainID   uint32
+	nextTemplateID uint16
+	lastRefresh    time.Time
+
+	sync.Mutex
+}
+
+// NewMediator returns a mediator of upstream messages of at most maxmessagesize octets; use 0 for TCP and SCTP, and MaxUDPMessageSize for UDP.
+func NewMediator(maxmessagesize int) *Mediator {
+	return &Mediator{
+		Templates:      NewActiveTemplateList(),
+		MaxMessageSize: maxmessagesize,
+		domains:        make(map[mediatorDomain]uint32),
+		upstreamIDs:    make(map[uint32]bool),
+		builders:       make(map[uint32]*MessageBuilder),
+		templates:      make(map[mediatorTemplateKey]*mediatorTemplate),
+		usedTemplates:  make(map[uint16]bool),
+		nextDomainID:   1,
+		nextTemplateID: 256,
+		lastRefresh:    time.Now(),
+	}
+}
+
+// SetObservationDomain sets the upstream Observation Domain ID of an Observation Domain of an exporter.
+// Several Observation Domains may share an upstream one, their Template IDs do not collide.
+func (med *Mediator) SetObservationDomain(exporter string, observationdomainid uint32, upstreamid uint32) {
+	med.Lock()
+	defer med.Unlock()
+	med.domains[mediatorDomain{exporter: exporter, observationDomainID: observationdomainid}] = upstreamid
+	med.upstreamIDs[upstreamid] = true
+}
+
+// ObservationDomain returns the upstream Observation Domain ID of an Observation Domain of an exporter, or false if it has none yet
+func (med *Mediator) ObservationDomain(exporter string, observationdomainid uint32) (uint32, bool) {
+	med.Lock()
+	defer med.Unlock()
+	upstreamid, found := med.domains[mediatorDomain{exporter: exporter, observationDomainID: observationdomainid}]
+	return upstreamid, found
+}
+
+// AddMessage adds the Data Records of a message of the exporter, with their upstream Template ID.
+// The templates are taken from the AssociatedTemplates of the records, Template Sets in the message are not needed.
+// Records of unknown templates are skipped, and returned as errors.
+func (med *Mediator) AddMessage(exporter string, ipfixmsg *Message) error {
+	med.Lock()
+	defer med.Unlock()
+	if med.TemplateRefreshInterval > 0 && time.Since(med.lastRefresh) >= med.TemplateRefreshInterval {
+		med.refreshTemplates()
+	}
+	domain := mediatorDomain{exporter: exporter, observationDomainID: ipfixmsg.ObservationDomainID}
+	builder := med.builder(domain)
+
+	var err error
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok {
+				continue
+			}
+			if suberr := med.addRecord(domain, builder, datrec); suberr != nil {
+				err = stackError(err, "Sub errors mediating records.", suberr, 0)
+			}
+		}
+	}
+	return err
+}
+
+// builder returns the message builder of the upstream Observation Domain of the domain, and assigns one if it has none
+func (med *Mediator) builder(domain mediatorDomain) *MessageBuilder {
+	upstreamid, found := med.domains[domain]
+	if !found {
+		for med.upstreamIDs[med.nextDomainID] {
+			med.nextDomainID++
+		}
+		upstreamid = med.nextDomainID
+		med.domains[domain] = upstreamid
+		med.upstreamIDs[upstreamid] = true
+	}
+	builder, found := med.builders[upstreamid]
+	if !found {
+		builder = NewMessageBuilder(upstreamid, med.Templates, med.MaxMessageSize)
+		med.builders[upstreamid] = builder
+		med.builderOrder = append(med.builderOrder, upstreamid)
+	}
+	return builder
+}
+
+// addRecord adds the record to the builder with the upstream Template ID of its template
+func (med *Mediator) addRecord(domain mediatorDomain, builder *MessageBuilder, datrec *DataRecord) error {
+	tmplrec, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	if err != nil {
+		return err
+	}
+	upstream, err := med.template(domain, builder, tmplrec)
+	if err != nil {
+		return err
+	}
+	datrec.TemplateID = upstream.TemplateID
+	datrec.AssociatedTemplates = med.Templates
+	return builder.AddRecord(datrec)
+}
+
+// template returns the upstream template of the template of the domain. A new or replaced template gets a new upstream Template ID.
+func (med *Mediator) template(domain mediatorDomain, builder *MessageBuilder, tmplrec *TemplateRecord) (*TemplateRecord, error) {
+	key := mediatorTemplateKey{domain: domain, templateID: tmplrec.TemplateID}
+	mapped, found := med.templates[key]
+	if found && (mapped.record == tmplrec || templatesEqual(mapped.record, tmplrec)) {
+		mapped.record = tmplrec
+		return mapped.upstream, nil
+	}
+	if found {
+		med.retire(key)
+	}
+	id, err := med.allocateTemplateID()
+	if err != nil {
+		return nil, err.InTemplate(tmplrec.TemplateID)
+	}
+	upstream := &TemplateRecord{TemplateID: id, ScopeFieldSpecifiers: tmplrec.ScopeFieldSpecifiers, FieldSpecifiers: tmplrec.FieldSpecifiers}
+	if err := builder.AddRecord(upstream); err != nil {
+		return nil, err
+	}
+	med.usedTemplates[id] = true
+	med.templates[key] = &mediatorTemplate{record: tmplrec, upstream: upstream, builder: builder}
+	return upstream, nil
+}
+
+// allocateTemplateID returns the next upstream Template ID that is not used
+func (med *Mediator) allocateTemplateID() (uint16, *ProtocolError) {
+	for range 65536 - 256 {
+		id := med.nextTemplateID
+		if med.nextTemplateID == 65535 {
+			med.nextTemplateID = 256
+		} else {
+			med.nextTemplateID++
+		}
+		if !med.usedTemplates[id] {
+			return id, nil
+		}
+	}
+	return 0, NewCategoryError(ErrInvalidTemplateID, "No upstream Template IDs left", ErrCritical)
+}
+
+// retire removes the upstream template of the key. Its Template ID is used again after the next Flush.
+func (med *Mediator) retire(key mediatorTemplateKey) {
+	med.retired = append(med.retired, med.templates[key])
+	delete(med.templates, key)
+}
+
+// RemoveExporter retires the templates of all Observation Domains of the exporter, call it when its Transport Session ends.
+// Its Observation Domains keep their upstream Observation Domain ID.
+func (med *Mediator) RemoveExporter(exporter string) {
+	med.Lock()
+	defer med.Unlock()
+	for key := range med.templates {
+		if key.domain.exporter == exporter {
+			med.retire(key)
+		}
+	}
+}
+
+// RefreshTemplates makes the mediator export the upstream templates again, before the next records that use them
+func (med *Mediator) RefreshTemplates() {
+	med.Lock()
+	defer med.Unlock()
+	med.refreshTemplates()
+}
+
+// refreshTemplates makes all builders export their templates again
+func (med *Mediator) refreshTemplates() {
+	for _, builder := range med.builders {
+		builder.ResendTemplates()
+	}
+	med.lastRefresh = time.Now()
+}
+
+// Flush returns the upstream messages of all Observation Domains, in the order the Observation Domains were first used.
+// Within an Observation Domain the messages are in the order they must be sent.
+func (med *Mediator) Flush() []*Message {
+	med.Lock()
+	defer med.Unlock()
+	if med.MaxMessageSize <= 0 {
+		for _, retired := range med.retired {
+			retired.builder.WithdrawTemplate(retired.upstream.TemplateID) //Its template is in the Templates, the builder does not fail
+		}
+	}
+	messages := []*Message{}
+	for _, upstreamid := range med.builderOrder {
+		messages = append(messages, med.builders[upstreamid].Flush()...)
+	}
+	for _, retired := range med.retired { //No pending message uses them anymore, and over TCP and SCTP they are withdrawn
+		delete(med.usedTemplates, retired.upstream.TemplateID)
+	}
+	med.retired = nil
+	return messages
+}
@@ -0,0 +1,195 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	mediatorTestPrint = false
+)
+
+func TestMediatorMarker(t *testing.T) {
+	if mediatorTestPrint {
+		fmt.Printf(testMarkerString, "Mediator")
+	}
+}
+
+// mediatorTestMessage returns the decoded message of a record of template 256 with the fields, of an exporter with the session
+func mediatorTestMessage(t *testing.T, session *Session, odid uint32, fields ...psampTestField) *Message {
+	templates := NewActiveTemplateList()
+	datrec := samplingTestFlow(templates, 256, fields...)
+	builder := NewMessageBuilder(odid, templates, 0)
+	if err := builder.AddRecord(datrec); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error adding record: %v", err)
+	}
+	data, _ := builder.Flush()[0].MarshalBinary()
+	ipfixmsg, err := session.UnmarshalMessage(data)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	return ipfixmsg
+}
+
+// mediatorTestRecords returns the Observation Domain ID, Template ID and last field value of the data records of the messages
+func mediatorTestRecords(messages []*Message) [][3]interface{} {
+	records := [][3]interface{}{}
+	for _, ipfixmsg := range messages {
+		for _, set := range ipfixmsg.Sets {
+			if set.SetID < 256 {
+				continue
+			}
+			for _, rec := range set.Records {
+				datrec := (*rec).(*DataRecord)
+				records = append(records, [3]interface{}{ipfixmsg.ObservationDomainID, datrec.TemplateID, datrec.FieldValues[len(datrec.FieldValues)-1].Value()})
+			}
+		}
+	}
+	return records
+}
+
+func TestMediator(t *testing.T) {
+	sessions := map[string]*Session{"pop1": NewSession(nil), "pop2": NewSession(nil)}
+	mediator := NewMediator(0)
+	mediator.SetObservationDomain("pop2", 1, 7)
+	for _, ipfixmsg := range []struct {
+		exporter string
+		message  *Message
+	}{
+		{"pop1", mediatorTestMessage(t, sessions["pop1"], 1, psampTestField{10, &FieldValueUnsigned32{value: 11}})},
+		{"pop2", mediatorTestMessage(t, sessions["pop2"], 1, psampTestField{4, &FieldValueUnsigned8{value: 6}})},
+		{"pop1", mediatorTestMessage(t, sessions["pop1"], 2, psampTestField{10, &FieldValueUnsigned32{value: 12}})},
+		{"pop1", mediatorTestMessage(t, sessions["pop1"], 1, psampTestField{10, &FieldValueUnsigned32{value: 13}})},
+	} {
+		if err := mediator.AddMessage(ipfixmsg.exporter, ipfixmsg.message); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding message of %s: %v", ipfixmsg.exporter, err)
+		}
+	}
+	upstream := messageBuilderTestDecode(t, mediator.Flush(), maxMessageSize)
+	expected := [][3]interface{}{{uint32(1), uint16(256), uint32(11)}, {uint32(1), uint16(256), uint32(13)}, {uint32(7), uint16(257), uint8(6)}, {uint32(2), uint16(258), uint32(12)}}
+	if records := mediatorTestRecords(upstream); fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf(errorPrefixMarker+"Expected upstream records %v, but got %v", expected, records)
+	}
+	if odid, found := mediator.ObservationDomain("pop1", 2); !found || odid != 2 {
+		t.Errorf(errorPrefixMarker+"Expected upstream Observation Domain 2, but got %d", odid)
+	}
+
+	//pop1 replaces its template 256, pop2 sends with the same template
+	replaced := mediatorTestMessage(t, sessions["pop1"], 1, psampTestField{8, &FieldValueIPv4Address{value: []byte{10, 0, 0, 1}}})
+	mediator.AddMessage("pop1", replaced)
+	mediator.AddMessage("pop2", mediatorTestMessage(t, sessions["pop2"], 1, psampTestField{4, &FieldValueUnsigned8{value: 17}}))
+	messages := mediator.Flush()
+	for _, ipfixmsg := range messages {
+		for _, set := range ipfixmsg.Sets {
+			if set.SetID == SetIDTemplate && (ipfixmsg.ObservationDomainID != 1 || len(set.Records) != 2 || !(*set.Records[1]).(*TemplateRecord).IsWithdrawal()) {
+				t.Errorf(errorPrefixMarker+"Only the replaced template and the withdrawal of its old upstream template should be exported, but got %v", set)
+			}
+		}
+	}
+	if records := mediatorTestRecords(messages); len(records) != 2 || records[0][1] != uint16(259) || records[1][1] != uint16(257) {
+		t.Errorf(errorPrefixMarker+"Expected the replaced template to get Template ID 259, but got %v", records)
+	}
+
+	mediator.RefreshTemplates()
+	mediator.RemoveExporter("pop1")
+	mediator.AddMessage("pop2", mediatorTestMessage(t, sessions["pop2"], 1, psampTestField{4, &FieldValueUnsigned8{value: 1}}))
+	mediator.AddMessage("pop1", mediatorTestMessage(t, NewSession(nil), 1, psampTestField{10, &FieldValueUnsigned32{value: 14}}))
+	messages = mediator.Flush()
+	if len(messages) != 3 || len(messages[0].Sets) != 2 || len(messages[1].Sets) != 2 || len(messages[2].Sets) != 1 {
+		t.Fatalf(errorPrefixMarker+"Expected the templates to be exported again in both messages, and the templates of pop1 to be withdrawn, but got %v", messages)
+	}
+	if records := mediatorTestRecords(messages); records[0][1] != uint16(260) || records[1][1] != uint16(257) {
+		t.Errorf(errorPrefixMarker+"Expected the new session of pop1 to get a new Template ID, but got %v", records)
+	}
+	mediator.RemoveExporter("pop1")
+	mediator.Flush()
+	mediator.AddMessage("pop1", mediatorTestMessage(t, NewSession(nil), 1, psampTestField{10, &FieldValueUnsigned32{value: 15}}))
+	if records := mediatorTestRecords(mediator.Flush()); len(records) != 1 || records[0][1] == uint16(257) {
+		t.Errorf(errorPrefixMarker+"Expected a free Template ID, but got %v", records)
+	}
+}
+
+func TestMediatorTemplateReuse(t *testing.T) {
+	for _, test := range []struct {
+		maxmessagesize int
+		withdrawn      bool
+	}{
+		{0, true}, //TCP and SCTP
+		{MaxUDPMessageSize(1500, false), false},
+	} {
+		mediator := NewMediator(test.maxmessagesize)
+		mediator.AddMessage("pop1", mediatorTestMessage(t, NewSession(nil), 1, psampTestField{10, &FieldValueUnsigned32{value: 1}}))
+		mediator.RemoveExporter("pop1")
+		collector := NewSession(nil)
+		for _, ipfixmsg := range mediator.Flush() {
+			data, _ := ipfixmsg.MarshalBinary()
+			if _, err := collector.UnmarshalMessage(data); err != nil {
+				t.Fatalf(errorPrefixMarker+"Error unmarshalling upstream message: %v", err)
+			}
+		}
+		if _, err := collector.AssociatedTemplates.Get(256); (err != nil) != test.withdrawn {
+			t.Errorf(errorPrefixMarker+"Maximum message size %d: expected Template ID 256 to be withdrawn %t, but got %v", test.maxmessagesize, test.withdrawn, err)
+		}
+		mediator.AddMessage("pop1", mediatorTestMessage(t, NewSession(nil), 1, psampTestField{10, &FieldValueUnsigned32{value: 2}}))
+		mediator.nextTemplateID = 256
+		mediator.AddMessage("pop2", mediatorTestMessage(t, NewSession(nil), 1, psampTestField{4, &FieldValueUnsigned8{value: 6}}))
+		records := mediatorTestRecords(mediator.Flush())
+		if len(records) != 2 || records[1][1] != uint16(256) {
+			t.Errorf(errorPrefixMarker+"Maximum message size %d: expected Template ID 256 to be reused, but got %v", test.maxmessagesize, records)
+		}
+	}
+}
+
+func TestMediatorTemplateWithdrawals(t *testing.T) {
+	mediator := NewMediator(0)
+	templates := NewActiveTemplateList()
+	var previous, upstreamid uint16
+	for idx := range 65536 { //An exporter that replaces its template more often than there are upstream Template IDs
+		tmplrec, _ := NewTemplateRecord(256)
+		tmplrec.AddSpecifier(&FieldSpecifier{InformationElementIdentifier: uint16(10 + idx%2*4), FieldLength: 4}) //ingressInterface or egressInterface
+		templates.Set(256, tmplrec)
+		datrec, _ := NewDataRecord(256, templates)
+		datrec.FieldValues = []FieldValue{&FieldValueUnsigned32{value: uint32(idx)}}
+		ipfixmsg, _ := NewMessage()
+		set, _ := NewSet(256)
+		set.AddRecord(datrec)
+		ipfixmsg.AddSet(set)
+		if err := mediator.AddMessage("pop1", ipfixmsg); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding message %d: %v", idx, err)
+		}
+		messages := mediator.Flush()
+		previous, upstreamid = upstreamid, datrec.TemplateID
+		if idx == 0 {
+			continue
+		}
+		tmplset := messages[0].Sets[0]
+		withdrawal := (*tmplset.Records[len(tmplset.Records)-1]).(*TemplateRecord)
+		if len(messages) != 1 || !withdrawal.IsWithdrawal() || withdrawal.TemplateID != previous {
+			t.Fatalf(errorPrefixMarker+"Expected upstream template %d to be withdrawn, but got %v", previous, messages)
+		}
+	}
+}
+
+func TestMediatorErrors(t *testing.T) {
+	mediator := NewMediator(0)
+	ipfixmsg, _ := NewMessage()
+	set, _ := NewSet(256)
+	datrec, _ := NewDataRecord(256, NewActiveTemplateList())
+	set.AddRecord(datrec)
+	ipfixmsg.AddSet(set)
+	if err := mediator.AddMessage("pop1", ipfixmsg); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Expected a record of an unknown template to fail, but got %v", err)
+	}
+
+	mediator.nextTemplateID = 65535
+	if id, err := mediator.allocateTemplateID(); id != 65535 || err != nil || mediator.nextTemplateID != 256 {
+		t.Errorf(errorPrefixMarker+"Expected Template ID 65535 and to wrap to 256, but got %d and %d (%v)", id, mediator.nextTemplateID, err)
+	}
+	for id := 256; id <= 65535; id++ {
+		mediator.usedTemplates[uint16(id)] = true
+	}
+	if _, err := mediator.allocateTemplateID(); !errors.Is(err, ErrInvalidTemplateID) {
+		t.Errorf(errorPrefixMarker+"Expected to run out of Template IDs, but got %v", err)
+	}
+}
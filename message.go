@@ -1,8 +1,8 @@
 package ipfix
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -105,7 +105,7 @@ func (ipfixmsg *Message) SetSequenceNumber(sequencenumber uint32) error {
 // AddSet adds an existing set to the message.
 func (ipfixmsg *Message) AddSet(newset *Set) (err error) {
 	if int(ipfixmsg.Len())+int(newset.Len()) > 65535 {
-		return NewError(fmt.Sprintf("Can not add set to message; resulting length would be %d but must be <= 65535", int(ipfixmsg.Len())+int(newset.Len())), ErrCritical)
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Can not add set to message; resulting length would be %d but must be <= 65535", int(ipfixmsg.Len())+int(newset.Len())), ErrCritical).InSet(newset.SetID)
 	}
 	ipfixmsg.Sets = append(ipfixmsg.Sets, newset)
 	return nil
@@ -113,76 +113,69 @@ func (ipfixmsg *Message) AddSet(newset *Set) (err error) {
 
 // MarshalBinary satisfies the encoding/BinaryMarshaler interface
 func (ipfixmsg *Message) MarshalBinary() (data []byte, err error) {
+	return ipfixmsg.AppendBinary(nil)
+}
+
+// AppendBinary satisfies the encoding/BinaryAppender interface, it appends the message to dst.
+// Appending to a buffer from GetBuffer marshals a message without allocating.
+func (ipfixmsg *Message) AppendBinary(dst []byte) (data []byte, err error) {
 	//Set ID value identifies the Set.  A value of 2 is reserved for the Template Set.  A value of 3 is reserved for the Option Template Set.
 	//All other values from 4 to 255 are reserved for future use. Values above 255 are used for Data Sets.
 	if ipfixmsg.VersionNumber < IPFIXVersion {
-		return nil, NewError(fmt.Sprintf("Invalid IPFIX Version Number: %d", ipfixmsg.VersionNumber), ErrCritical)
-	}
-	buf := new(bytes.Buffer) //should get from pool?
-
-	err = binary.Write(buf, binary.BigEndian, uint16(IPFIXVersion))
-	if err != nil {
-		return nil, err
-	}
-
-	totalsetlength := uint16(16)
-	for _, set := range ipfixmsg.Sets {
-		if int(totalsetlength)+int(set.Len()) > 65535 {
-			return nil, NewError(fmt.Sprintf("Invalid total length of message. Got %d but should be <= 65535", int(totalsetlength)+int(set.Len())), ErrCritical)
-		}
-		totalsetlength += set.Len()
-	}
-	err = binary.Write(buf, binary.BigEndian, totalsetlength)
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(buf, binary.BigEndian, uint32(ipfixmsg.ExportTime.Unix()))
-	if err != nil {
-		return nil, err
-	}
-
-	err = binary.Write(buf, binary.BigEndian, uint32(ipfixmsg.SequenceNumber))
-	if err != nil {
-		return nil, err
+		return nil, NewCategoryError(ErrInvalidVersion, fmt.Sprintf("Invalid IPFIX Version Number: %d", ipfixmsg.VersionNumber), ErrCritical)
 	}
 
-	err = binary.Write(buf, binary.BigEndian, uint32(ipfixmsg.ObservationDomainID))
-	if err != nil {
-		return nil, err
-	}
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(IPFIXVersion))
+	dst = append(dst, 0, 0) //The length is filled in when the sets are appended
+	dst = binary.BigEndian.AppendUint32(dst, uint32(ipfixmsg.ExportTime.Unix()))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(ipfixmsg.SequenceNumber))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(ipfixmsg.ObservationDomainID))
 
-	data = buf.Bytes()
 	for _, set := range ipfixmsg.Sets {
-		setdat, suberr := (*set).MarshalBinary()
+		setstart := len(dst)
+		appended, suberr := (*set).AppendBinary(dst)
 		if suberr != nil {
-			if err == nil {
-				err = NewError("Sub errors marshalling message.", ErrFailure)
-			}
-			err.(*ProtocolError).Stack(*suberr.(*ProtocolError))
+			err = stackError(err, "Sub errors marshalling message.", suberr, 0)
+			continue
+		}
+		dst = appended
+		if len(dst)-start > 65535 {
+			return nil, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid total length of message. Got %d but should be <= 65535", len(dst)-start), ErrCritical).InSet(set.SetID).AtOffset(setstart - start)
 		}
-		data = append(data, setdat...)
 	}
+	binary.BigEndian.PutUint16(dst[start+2:start+4], uint16(len(dst)-start))
 
-	return data, err
+	return dst, err
 }
 
 // UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
+// It uses the DefaultDecodeOptions.
 func (ipfixmsg *Message) UnmarshalBinary(data []byte) (err error) {
+	return ipfixmsg.UnmarshalBinaryWithOptions(data, DefaultDecodeOptions)
+}
+
+// UnmarshalBinaryWithOptions decodes the Message in data, handling malformed input according to opts.
+// In strict mode a malformed Message is discarded: no Sets are kept and an error with severity ErrCritical is returned.
+// In lenient mode the Sets that could be decoded are kept and the bad ones are reported as stacked errors.
+// Data Sets for templates that are not (yet) known are not malformed; they are kept without records and reported in both modes.
+// The templates of the message are set in the AssociatedTemplates when it has been decoded, so not when it is discarded.
+func (ipfixmsg *Message) UnmarshalBinaryWithOptions(data []byte, opts DecodeOptions) (err error) {
 	if data == nil || len(data) < 16 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical).AtOffset(0)
 	}
 	if ipfixmsg.AssociatedTemplates == nil {
-		return NewError(fmt.Sprintf("Can not have nil pointer to associated templates"), ErrCritical)
+		return NewCategoryError(ErrNoTemplates, fmt.Sprintf("Can not have nil pointer to associated templates"), ErrCritical)
 	}
 
 	ipfixmsg.VersionNumber = binary.BigEndian.Uint16(data[0:2])
 	if ipfixmsg.VersionNumber < IPFIXVersion {
-		return NewError(fmt.Sprintf("Unusable IPFIX version. Want at least 10, but got %d", ipfixmsg.VersionNumber), ErrCritical)
+		return NewCategoryError(ErrInvalidVersion, fmt.Sprintf("Unusable IPFIX version. Want at least 10, but got %d", ipfixmsg.VersionNumber), ErrCritical).AtOffset(0)
 	}
 
 	totalmessagelength := binary.BigEndian.Uint16(data[2:4])
-	if int(totalmessagelength) > len(data) {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid length. Message states %d but only have %d bytes of data", totalmessagelength, len(data)), ErrCritical)
+	if int(totalmessagelength) > len(data) || totalmessagelength < ipfixMessageHeaderLength {
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Can not unmarshal, invalid length. Message states %d but only have %d bytes of data", totalmessagelength, len(data)), ErrCritical).AtOffset(2)
 	}
 	err = ipfixmsg.SetExportTime(time.Unix(int64(binary.BigEndian.Uint32(data[4:8])), 0))
 	if err != nil {
@@ -199,36 +192,60 @@ func (ipfixmsg *Message) UnmarshalBinary(data []byte) (err error) {
 		return err
 	}
 
-	cursor := uint16(16)
-	for cursor < (totalmessagelength - 4) { //Must have at least 4 bytes (actually more) for a set to be unmarshalled
+	ipfixmsg.Sets = make([]*Set, 0, 0)
+	state := newDecodeState(opts.Limits)
+	cursor := int(ipfixMessageHeaderLength)
+	for cursor < int(totalmessagelength) {
+		if cursor+ipfixSetHeaderLength > int(totalmessagelength) {
+			suberr := NewCategoryError(ErrMalformedLength, fmt.Sprintf("%d octets left at end of message, which is too short for a set", int(totalmessagelength)-cursor), opts.malformed()).AtOffset(cursor)
+			return ipfixmsg.malformed(err, suberr, opts, state)
+		}
+		setlength := int(binary.BigEndian.Uint16(data[cursor+2 : cursor+4]))
+		if setlength < ipfixSetHeaderLength || cursor+setlength > int(totalmessagelength) {
+			suberr := NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid set length %d, only %d octets left in message", setlength, int(totalmessagelength)-cursor), opts.malformed()).AtOffset(cursor + 2).InSet(binary.BigEndian.Uint16(data[cursor : cursor+2]))
+			return ipfixmsg.malformed(err, suberr, opts, state) //Can not find the next set, so this is where it ends
+		}
+
 		tmpset := NewBlankSet()
 		tmpset.AssociateTemplates(ipfixmsg.AssociatedTemplates)
-		suberr := tmpset.UnmarshalBinary(data[cursor:])
-		if suberr != nil {
-			if err == nil {
-				err = NewError("Sub errors unmarshalling message.", ErrFailure)
+		suberr := tmpset.unmarshal(data[cursor:cursor+setlength], opts, state)
+		if perr, ok := suberr.(*ProtocolError); ok && perr.Severity == ErrCritical && !errors.Is(perr, ErrTemplateNotFound) {
+			perr.shiftOffset(cursor)
+			if !opts.Lenient {
+				return ipfixmsg.malformed(err, perr, opts, state)
 			}
-			err.(*ProtocolError).Stack(suberr)
+			err = stackError(err, "Sub errors unmarshalling message.", perr, 0)
+			cursor += setlength
+			continue
 		}
+		err = stackError(err, "Sub errors unmarshalling message.", suberr, cursor)
 
-		if tmpset.SetID == 2 { //Need to add/update all the messages
+		if tmpset.SetID == SetIDTemplate || tmpset.SetID == SetIDOptionTemplate { //Need to add/update all the templates, once the whole message is decoded
 			for _, rec := range tmpset.Records {
 				switch (*rec).(type) {
 				case *TemplateRecord:
-					suberr := ipfixmsg.AssociatedTemplates.Set((*rec).(*TemplateRecord).TemplateID, (*rec).(*TemplateRecord))
-					if suberr != nil {
-						if err == nil {
-							err = NewError("Sub errors unmarshalling message.", ErrFailure)
-						}
-						err.(*ProtocolError).Stack(suberr)
-					}
+					state.stage(ipfixmsg.AssociatedTemplates, (*rec).(*TemplateRecord))
 				case *DataRecord:
-					return NewError(fmt.Sprintf("Datarecord in template set"), ErrCritical)
+					return NewCategoryError(ErrRecordTypeMismatch, fmt.Sprintf("Datarecord in template set"), ErrCritical).AtOffset(cursor).InSet(tmpset.SetID)
 				}
 			}
 		}
-		ipfixmsg.Sets = append(ipfixmsg.Sets, tmpset)
-		cursor += tmpset.Len()
+		if tmpset.SetID >= SetIDTemplate && (tmpset.SetID <= SetIDOptionTemplate || tmpset.SetID > 255) { //Reserved sets are skipped in lenient mode
+			ipfixmsg.Sets = append(ipfixmsg.Sets, tmpset)
+		}
+		cursor += setlength
+	}
+	return state.setStaged(err, "Sub errors unmarshalling message.")
+}
+
+// malformed handles a malformation that ends the decoding of the message.
+// In strict mode the message is discarded and the templates of the message are not set, in lenient mode the sets decoded so far are kept.
+func (ipfixmsg *Message) malformed(err error, suberr *ProtocolError, opts DecodeOptions, state *decodeState) error {
+	if opts.Lenient {
+		return state.setStaged(stackError(err, "Sub errors unmarshalling message.", suberr, 0), "Sub errors unmarshalling message.")
 	}
-	return err
+	ipfixmsg.Sets = make([]*Set, 0, 0)
+	discarded := NewCategoryError(suberr.Category, "Malformed message discarded.", ErrCritical)
+	discarded.Stack(suberr)
+	return discarded
 }
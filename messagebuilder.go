@@ -0,0 +1,276 @@
+package ipfix
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+
+A MessageBuilder packs records into as few messages as possible. Data Records are grouped into Data Sets by template,
+whatever order they are added in, and a message is finished as soon as the next record would not fit.
+The template of a Data Record is put in a Template Set before the Data Sets of the first message that uses it,
+so a Collecting Process always knows the template before it sees the data.
+
+	builder := NewMessageBuilder(odid, templates, MaxUDPMessageSize(1500, false))
+	for _, datrec := range records {
+		if err := builder.AddRecord(datrec); err != nil {
+			return err
+		}
+	}
+	for _, ipfixmsg := range builder.Flush() {
+		buf := GetBuffer()
+		*buf, err = ipfixmsg.AppendBinary(*buf)
+		...
+	}
+
+Over UDP templates must be resent regularly, ResendTemplates makes the builder include them again.
+
+*/
+
+const maxMessageSize = 65535 //The length field of the message header is 16 bits
+
+// MaxUDPMessageSize returns the largest message that fits in a single UDP datagram on a path with the given MTU
+func MaxUDPMessageSize(pathmtu int, ipv6 bool) int {
+	size := pathmtu - 20 - 8 //IPv4 and UDP header
+	if ipv6 {
+		size = pathmtu - 40 - 8
+	}
+	if size > maxMessageSize {
+		return maxMessageSize
+	}
+	return size
+}
+
+// MessageBuilder groups records into messages of at most MaxMessageSize octets
+type MessageBuilder struct {
+	ObservationDomainID uint32           //Of all messages
+	MaxMessageSize      int              //Maximum length of a message in octets, including the header. 0 means 65535, the maximum for IPFIX.
+	AssociatedTemplates *ActiveTemplates //The templates of the Data Records, templates that are added are set here too
+
+	sequenceNumber uint32                     //Number of Data Records in the finished messages
+	messages       []*Message                 //Finished messages
+	exported       map[uint16]*TemplateRecord //Templates that are in a finished message or the message being built
+	pending        map[uint16]bool            //Templates of the Data Records in the messages that have not been flushed
+
+	//The message being built
+	length       int
+	records      uint32
+	templateSets [2]*Set //The Template Set and the Options Template Set
+	dataSets     []*Set
+	dataSetIndex map[uint16]*Set
+}
+
+// NewMessageBuilder returns a builder for messages of at most maxmessagesize octets; use 0 for TCP and SCTP, and MaxUDPMessageSize for UDP.
+func NewMessageBuilder(observationdomainid uint32, templates *ActiveTemplates, maxmessagesize int) *MessageBuilder {
+	if templates == nil {
+		templates = NewActiveTemplateList()
+	}
+	mb := &MessageBuilder{
+		ObservationDomainID: observationdomainid,
+		MaxMessageSize:      maxmessagesize,
+		AssociatedTemplates: templates,
+		exported:            make(map[uint16]*TemplateRecord),
+		pending:             make(map[uint16]bool),
+	}
+	mb.reset()
+	return mb
+}
+
+// reset starts a new, empty message
+func (mb *MessageBuilder) reset() {
+	mb.length = ipfixMessageHeaderLength
+	mb.records = 0
+	mb.templateSets = [2]*Set{}
+	mb.dataSets = nil
+	mb.dataSetIndex = make(map[uint16]*Set)
+}
+
+// maxSize returns the maximum length of a message
+func (mb *MessageBuilder) maxSize() int {
+	if mb.MaxMessageSize <= 0 || mb.MaxMessageSize > maxMessageSize {
+		return maxMessageSize
+	}
+	return mb.MaxMessageSize
+}
+
+// AddRecord adds a Template Record or a Data Record.
+// A Template Record is set in the AssociatedTemplates and will be exported before the next Data Record that uses it.
+// A Data Record is added to the Data Set of its template, and its template is added to the message if it has not been exported yet,
+// also when it replaced an exported template with the same id in the AssociatedTemplates.
+// The record is referenced, not copied, so it must not be changed until the messages are flushed.
+// A template can only be replaced when the messages with records that use it have been flushed, and marshalled:
+// the messages and their records share the AssociatedTemplates of the builder.
+func (mb *MessageBuilder) AddRecord(rec Record) error {
+	switch rec.(type) {
+	case *TemplateRecord:
+		return mb.addTemplateRecord(rec.(*TemplateRecord))
+	case *DataRecord:
+		return mb.addDataRecord(rec.(*DataRecord))
+	}
+	return NewCategoryError(ErrRecordTypeMismatch, fmt.Sprintf("Can not add record of type %T to a message", rec), ErrCritical)
+}
+
+// addTemplateRecord sets the template so it is exported with the first record that uses it
+func (mb *MessageBuilder) addTemplateRecord(tmplrec *TemplateRecord) error {
+	if mb.pending[tmplrec.TemplateID] {
+		return NewCategoryError(ErrInvalidTemplateID, fmt.Sprintf("Can not replace template %d, it is used by messages that have not been flushed", tmplrec.TemplateID), ErrFailure).InTemplate(tmplrec.TemplateID)
+	}
+	if err := mb.AssociatedTemplates.Set(tmplrec.TemplateID, tmplrec); err != nil {
+		return err
+	}
+	delete(mb.exported, tmplrec.TemplateID)
+	return nil
+}
+
+// addDataRecord adds the record to the current message, or to a new one if it does not fit
+func (mb *MessageBuilder) addDataRecord(datrec *DataRecord) error {
+	tmplrec, err := mb.AssociatedTemplates.Get(datrec.TemplateID)
+	if err != nil {
+		return err
+	}
+	if datrec.AssociatedTemplates == nil {
+		datrec.AssociatedTemplates = mb.AssociatedTemplates
+	}
+	reclen := int(datrec.Len())
+	if reclen == 0 {
+		return NewCategoryError(ErrMalformedLength, "Can not add a record of length 0", ErrCritical).InTemplate(datrec.TemplateID)
+	}
+
+	needed := mb.neededLength(tmplrec, reclen)
+	if mb.length+needed > mb.maxSize() {
+		mb.finish()
+		needed = mb.neededLength(tmplrec, reclen)
+		if mb.length+needed > mb.maxSize() {
+			return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Record of %d octets does not fit in a message of at most %d octets", reclen, mb.maxSize()), ErrCritical).InTemplate(datrec.TemplateID)
+		}
+	}
+
+	if mb.exported[tmplrec.TemplateID] != tmplrec {
+		mb.appendRecord(mb.templateSet(tmplrec), tmplrec)
+		mb.exported[tmplrec.TemplateID] = tmplrec
+	}
+	dataset, found := mb.dataSetIndex[datrec.TemplateID]
+	if !found {
+		dataset, _ = NewSet(datrec.TemplateID)
+		dataset.AssociateTemplates(mb.AssociatedTemplates)
+		mb.dataSetIndex[datrec.TemplateID] = dataset
+		mb.dataSets = append(mb.dataSets, dataset)
+	}
+	mb.appendRecord(dataset, datrec)
+	mb.pending[datrec.TemplateID] = true
+	mb.length += needed
+	mb.records++
+	return nil
+}
+
+// WithdrawTemplate adds a Template Withdrawal of the template with the id after the records that use it, as RFC 7011 section 8.1
+// requires before a Template ID is used for another template over TCP and SCTP. The template is kept in the AssociatedTemplates,
+// as the messages that use it may not be marshalled yet, and is exported again before the next record that uses it.
+func (mb *MessageBuilder) WithdrawTemplate(templateid uint16) error {
+	tmplrec, err := mb.AssociatedTemplates.Get(templateid)
+	if err != nil {
+		return err
+	}
+	withdrawal, err := NewTemplateWithdrawalRecord(templateid, tmplrec.ScopeFieldSpecifiers != nil)
+	if err != nil {
+		return err
+	}
+	if _, found := mb.dataSetIndex[templateid]; found {
+		mb.finish() //The Template Sets come before the Data Sets of a message
+	}
+	needed := mb.neededTemplateLength(withdrawal)
+	if mb.length+needed > mb.maxSize() {
+		mb.finish()
+		needed = mb.neededTemplateLength(withdrawal)
+	}
+	mb.appendRecord(mb.templateSet(withdrawal), withdrawal)
+	mb.length += needed
+	delete(mb.exported, templateid)
+	return nil
+}
+
+// templateSet returns the (Options) Template Set of the current message for the template record, and adds it if there is none
+func (mb *MessageBuilder) templateSet(tmplrec *TemplateRecord) *Set {
+	idx, setid := 0, uint16(SetIDTemplate)
+	if tmplrec.ScopeFieldSpecifiers != nil {
+		idx, setid = 1, SetIDOptionTemplate
+	}
+	if mb.templateSets[idx] == nil {
+		mb.templateSets[idx], _ = NewSet(setid)
+	}
+	return mb.templateSets[idx]
+}
+
+// neededTemplateLength returns the number of octets the template record adds to the current message, including the set header it needs
+func (mb *MessageBuilder) neededTemplateLength(tmplrec *TemplateRecord) int {
+	needed := int(tmplrec.Len())
+	idx := 0
+	if tmplrec.ScopeFieldSpecifiers != nil {
+		idx = 1
+	}
+	if mb.templateSets[idx] == nil {
+		needed += ipfixSetHeaderLength
+	}
+	return needed
+}
+
+// neededLength returns the number of octets a record of reclen octets of the template adds to the current message, including the template and set headers it needs
+func (mb *MessageBuilder) neededLength(tmplrec *TemplateRecord, reclen int) int {
+	needed := reclen
+	if _, found := mb.dataSetIndex[tmplrec.TemplateID]; !found {
+		needed += ipfixSetHeaderLength
+	}
+	if mb.exported[tmplrec.TemplateID] != tmplrec {
+		needed += mb.neededTemplateLength(tmplrec)
+	}
+	return needed
+}
+
+// appendRecord adds the record to the set. Set.AddRecord is not used, as it computes the length of the set for every record.
+func (mb *MessageBuilder) appendRecord(set *Set, rec Record) {
+	set.Records = append(set.Records, &rec)
+}
+
+// finish finishes the current message, if it has any records, and starts a new one
+func (mb *MessageBuilder) finish() {
+	if mb.length == ipfixMessageHeaderLength {
+		return
+	}
+	ipfixmsg := &Message{
+		VersionNumber:       IPFIXVersion,
+		ExportTime:          time.Now(),
+		SequenceNumber:      mb.sequenceNumber,
+		ObservationDomainID: mb.ObservationDomainID,
+		AssociatedTemplates: mb.AssociatedTemplates,
+		Sets:                make([]*Set, 0, len(mb.dataSets)+2),
+	}
+	for _, tmplset := range mb.templateSets {
+		if tmplset != nil {
+			ipfixmsg.Sets = append(ipfixmsg.Sets, tmplset)
+		}
+	}
+	ipfixmsg.Sets = append(ipfixmsg.Sets, mb.dataSets...)
+	mb.messages = append(mb.messages, ipfixmsg)
+	mb.sequenceNumber += mb.records
+	mb.reset()
+}
+
+// Flush finishes the message being built and returns all messages, in the order they must be sent.
+// The export time of the messages is the time they were finished, set it again just before sending if that matters.
+func (mb *MessageBuilder) Flush() []*Message {
+	mb.finish()
+	messages := mb.messages
+	mb.messages = nil
+	mb.pending = make(map[uint16]bool)
+	return messages
+}
+
+// ResendTemplates makes the builder export the templates again, before the next records that use them
+func (mb *MessageBuilder) ResendTemplates() {
+	for id := range mb.exported {
+		if _, found := mb.dataSetIndex[id]; !found { //Templates in the message being built are still in it
+			delete(mb.exported, id)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	messagebuilderTestPrint = false
+)
+
+func TestMessageBuilderMarker(t *testing.T) {
+	if messagebuilderTestPrint {
+		fmt.Printf(testMarkerString, "Message Builder")
+	}
+}
+
+// messageBuilderTestRecord returns a record of template 256 (destinationIPv4Address) or 257 (destinationTransportPort)
+func messageBuilderTestRecord(templateid uint16, value interface{}) *DataRecord {
+	datrec, _ := NewDataRecord(templateid, nil)
+	fieldval, _ := NewFieldValueByID(0, map[uint16]uint16{256: 12, 257: 11}[templateid])
+	fieldval.Set(value)
+	datrec.FieldValues = append(datrec.FieldValues, fieldval)
+	return datrec
+}
+
+// messageBuilderTestTemplates returns templates 256 and 257 of messageBuilderTestRecord
+func messageBuilderTestTemplates() []*TemplateRecord {
+	templates := make([]*TemplateRecord, 0, 2)
+	for id, element := range map[uint16]uint16{256: 12, 257: 11} {
+		tmplrec, _ := NewTemplateRecord(id)
+		fsp, _ := NewFieldSpecifier(0, element, map[uint16]uint16{12: 4, 11: 2}[element])
+		tmplrec.AddSpecifier(fsp)
+		templates = append(templates, tmplrec)
+	}
+	return templates
+}
+
+// messageBuilderTestDecode marshals the messages and decodes them in a new session, so it only knows the templates that were exported
+func messageBuilderTestDecode(t *testing.T, messages []*Message, maxsize int) []*Message {
+	session := NewSession(nil)
+	decoded := make([]*Message, 0, len(messages))
+	for idx, ipfixmsg := range messages {
+		data, err := ipfixmsg.MarshalBinary()
+		if err != nil {
+			t.Fatalf(errorPrefixMarker+"Error marshalling message %d: %v", idx, err)
+		}
+		if len(data) > maxsize {
+			t.Errorf(errorPrefixMarker+"Message %d has %d octets, more than %d", idx, len(data), maxsize)
+		}
+		decodedmsg, err := session.UnmarshalMessage(data)
+		if err != nil {
+			t.Fatalf(errorPrefixMarker+"Error unmarshalling message %d: %v", idx, err)
+		}
+		decoded = append(decoded, decodedmsg)
+	}
+	return decoded
+}
+
+func TestMessageBuilder(t *testing.T) {
+	builder := NewMessageBuilder(7, nil, 0)
+	for _, tmplrec := range messageBuilderTestTemplates() {
+		if err := builder.AddRecord(tmplrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding template: %v", err)
+		}
+	}
+	for idx := 0; idx < 3; idx++ { //Interleaved records of both templates
+		builder.AddRecord(messageBuilderTestRecord(256, fmt.Sprintf("10.0.0.%d", idx)))
+		builder.AddRecord(messageBuilderTestRecord(257, uint16(80+idx)))
+	}
+	messages := builder.Flush()
+	if len(messages) != 1 {
+		t.Fatalf(errorPrefixMarker+"Expected 1 message, but got %d", len(messages))
+	}
+	sets := messages[0].Sets
+	if len(sets) != 3 || sets[0].SetID != SetIDTemplate || len(sets[0].Records) != 2 || len(sets[1].Records) != 3 || len(sets[2].Records) != 3 {
+		t.Errorf(errorPrefixMarker+"Expected a template set with 2 templates and two data sets of 3 records, but got %v", messages[0])
+	}
+	if messages[0].ObservationDomainID != 7 || messages[0].SequenceNumber != 0 {
+		t.Errorf(errorPrefixMarker+"Wrong header %v", messages[0])
+	}
+	decoded := messageBuilderTestDecode(t, messages, 65535)
+	if len(decoded[0].Sets) != 3 {
+		t.Errorf(errorPrefixMarker+"Expected 3 decoded sets, but got %v", decoded[0])
+	}
+	if len(builder.Flush()) != 0 {
+		t.Errorf(errorPrefixMarker + "Flushing again should not return messages")
+	}
+}
+
+func TestMessageBuilderWithdrawals(t *testing.T) {
+	builder := NewMessageBuilder(7, nil, 0)
+	for _, tmplrec := range messageBuilderTestTemplates() {
+		builder.AddRecord(tmplrec)
+	}
+	options := psampTestRecord(builder.AssociatedTemplates, 258, psampTestField{149, &FieldValueUnsigned32{value: 1}}, psampTestField{34, &FieldValueUnsigned32{value: 100}})
+	for _, datrec := range []*DataRecord{messageBuilderTestRecord(256, "10.0.0.1"), messageBuilderTestRecord(257, uint16(80)), options} {
+		builder.AddRecord(datrec)
+	}
+	for _, id := range []uint16{256, 258} {
+		if err := builder.WithdrawTemplate(id); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error withdrawing template %d: %v", id, err)
+		}
+	}
+	if err := builder.WithdrawTemplate(300); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Expected withdrawing an unknown template to fail, but got %v", err)
+	}
+	messages := builder.Flush()
+	if len(messages) != 2 || len(messages[1].Sets) != 2 || messages[1].Len() != 16+8+8 {
+		t.Fatalf(errorPrefixMarker+"Expected the withdrawals in a message after the records, of 4 octets each, but got %v", messages)
+	}
+	session := NewSession(nil)
+	for _, ipfixmsg := range messages {
+		data, _ := ipfixmsg.MarshalBinary()
+		if _, err := session.UnmarshalMessage(data); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+		}
+	}
+	for id, withdrawn := range map[uint16]bool{256: true, 257: false, 258: true} {
+		if _, err := session.AssociatedTemplates.Get(id); (err != nil) != withdrawn {
+			t.Errorf(errorPrefixMarker+"Expected template %d to be withdrawn %t, but got %v", id, withdrawn, err)
+		}
+	}
+	builder.AddRecord(messageBuilderTestRecord(256, "10.0.0.2"))
+	if messages := builder.Flush(); len(messages) != 1 || len(messages[0].Sets) != 2 {
+		t.Errorf(errorPrefixMarker+"Expected a withdrawn template to be exported again, but got %v", messages)
+	}
+}
+
+func TestMessageBuilderMaxSize(t *testing.T) {
+	maxsize := 100
+	builder := NewMessageBuilder(1, nil, maxsize)
+	for _, tmplrec := range messageBuilderTestTemplates() {
+		builder.AddRecord(tmplrec)
+	}
+	for idx := 0; idx < 25; idx++ { //Two records of template 257 for every record of template 256
+		for _, datrec := range []*DataRecord{messageBuilderTestRecord(256, fmt.Sprintf("10.0.0.%d", idx)), messageBuilderTestRecord(257, uint16(idx)), messageBuilderTestRecord(257, uint16(idx+1000))} {
+			if err := builder.AddRecord(datrec); err != nil {
+				t.Fatalf(errorPrefixMarker+"Error adding record %d: %v", idx, err)
+			}
+		}
+	}
+	messages := builder.Flush()
+	if len(messages) < 2 {
+		t.Fatalf(errorPrefixMarker+"Expected several messages, but got %d", len(messages))
+	}
+	decoded := messageBuilderTestDecode(t, messages, maxsize)
+
+	records := uint32(0)
+	for idx, ipfixmsg := range decoded {
+		if ipfixmsg.SequenceNumber != records {
+			t.Errorf(errorPrefixMarker+"Message %d: expected sequence number %d, but got %d", idx, records, ipfixmsg.SequenceNumber)
+		}
+		for _, set := range ipfixmsg.Sets {
+			if set.SetID == SetIDTemplate && idx > 0 {
+				t.Errorf(errorPrefixMarker+"Message %d: templates should only be exported once, but got %v", idx, set)
+			}
+			if set.SetID > 255 {
+				records += uint32(len(set.Records))
+			}
+		}
+	}
+	if records != 75 {
+		t.Errorf(errorPrefixMarker+"Expected 75 records, but got %d", records)
+	}
+
+	builder.ResendTemplates()
+	builder.AddRecord(messageBuilderTestRecord(257, uint16(80)))
+	if messages := builder.Flush(); len(messages) != 1 || messages[0].Sets[0].SetID != SetIDTemplate || messages[0].SequenceNumber != 75 {
+		t.Errorf(errorPrefixMarker+"Expected the template to be resent, but got %v", messages)
+	}
+}
+
+func TestMessageBuilderErrors(t *testing.T) {
+	builder := NewMessageBuilder(1, nil, 30)
+	if err := builder.AddRecord(messageBuilderTestRecord(256, "10.0.0.1")); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Expected missing template, but got %v", err)
+	}
+	templates := messageBuilderTestTemplates()
+	for _, tmplrec := range templates {
+		builder.AddRecord(tmplrec)
+	}
+	if err := builder.AddRecord(messageBuilderTestRecord(256, "10.0.0.1")); !errors.Is(err, ErrMalformedLength) {
+		t.Errorf(errorPrefixMarker+"Expected a record that does not fit, but got %v", err)
+	}
+
+	builder.MaxMessageSize = 0
+	builder.AddRecord(messageBuilderTestRecord(templates[0].TemplateID, map[uint16]interface{}{256: "10.0.0.1", 257: uint16(80)}[templates[0].TemplateID]))
+	if err := builder.AddRecord(templates[0]); !errors.Is(err, ErrInvalidTemplateID) {
+		t.Errorf(errorPrefixMarker+"Replacing a template in use should fail, but got %v", err)
+	}
+	builder.Flush()
+	if err := builder.AddRecord(templates[0]); err != nil {
+		t.Errorf(errorPrefixMarker+"Replacing a template after flushing should work, but got %v", err)
+	}
+	if err := builder.AddRecord(&FieldSpecifier{}); !errors.Is(err, ErrRecordTypeMismatch) {
+		t.Errorf(errorPrefixMarker+"Expected a record type mismatch, but got %v", err)
+	}
+}
+
+func TestMaxUDPMessageSize(t *testing.T) {
+	if MaxUDPMessageSize(1500, false) != 1472 || MaxUDPMessageSize(1500, true) != 1452 || MaxUDPMessageSize(100000, false) != 65535 {
+		t.Errorf(errorPrefixMarker+"Wrong message sizes %d, %d and %d", MaxUDPMessageSize(1500, false), MaxUDPMessageSize(1500, true), MaxUDPMessageSize(100000, false))
+	}
+}
@@ -0,0 +1,263 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+
+NetFlow version 5 has a fixed record layout; there are no templates on the wire.
+To get v5 flows into the same model as IPFIX, every v5 record is decoded against a synthetic Template Record that uses the IANA Information Elements matching the v5 fields.
+The sysUpTime based First and Last fields are kept as flowStartSysUpTime and flowEndSysUpTime, and the absolute flowStartMilliseconds and flowEndMilliseconds are added, computed from unix_secs, unix_nsecs and sysUptime of the header.
+
+*/
+
+const (
+	// NetflowV5Version denotes the version of the NetFlow Export Packet format
+	NetflowV5Version = 5
+
+	// NetflowV5DefaultTemplateID is the Template ID used for the synthetic v5 template if none is set
+	NetflowV5DefaultTemplateID = 0xFF05
+
+	netflowV5HeaderLength = 24 //Length of the NetFlow v5 packet header. For calculations.
+	netflowV5RecordLength = 48 //Length of a NetFlow v5 flow record. For calculations.
+	netflowV5MaxRecords   = 30 //Maximum number of records in a single packet
+)
+
+// netflowV5Field describes where a v5 record field is located and which Information Element it maps to
+type netflowV5Field struct {
+	offset      int
+	length      uint16
+	elementid   uint16
+	description string
+}
+
+// netflowV5Fields lists the fields of a NetFlow v5 record in template order. The padding octets are left out.
+var netflowV5Fields = []netflowV5Field{
+	{0, 4, 8, "srcaddr"},
+	{4, 4, 12, "dstaddr"},
+	{8, 4, 15, "nexthop"},
+	{12, 2, 10, "input"},
+	{14, 2, 14, "output"},
+	{16, 4, 2, "dPkts"},
+	{20, 4, 1, "dOctets"},
+	{24, 4, 22, "First"},
+	{28, 4, 21, "Last"},
+	{32, 2, 7, "srcport"},
+	{34, 2, 11, "dstport"},
+	{37, 1, 6, "tcp_flags"},
+	{38, 1, 4, "prot"},
+	{39, 1, 5, "tos"},
+	{40, 2, 16, "src_as"},
+	{42, 2, 17, "dst_as"},
+	{44, 1, 9, "src_mask"},
+	{45, 1, 13, "dst_mask"},
+}
+
+// Information Elements that are added to the synthetic v5 template on top of the record fields
+const (
+	netflowV5EngineTypeID            = 38  // engineType, from the header
+	netflowV5EngineIDID              = 39  // engineId, from the header
+	netflowV5FlowStartMillisecondsID = 152 // flowStartMilliseconds, computed
+	netflowV5FlowEndMillisecondsID   = 153 // flowEndMilliseconds, computed
+)
+
+// NewNetflowV5Template returns the synthetic Template Record that describes the Data Records decoded from NetFlow v5 records.
+func NewNetflowV5Template(templateid uint16) (*TemplateRecord, error) {
+	tmplrec, err := NewTemplateRecord(templateid)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range netflowV5Fields {
+		fsp, err := NewFieldSpecifier(0, field.elementid, field.length)
+		if err != nil {
+			return nil, err
+		}
+		tmplrec.AddSpecifier(fsp)
+	}
+	for _, extra := range []struct{ elementid, length uint16 }{
+		{netflowV5EngineTypeID, 1},
+		{netflowV5EngineIDID, 1},
+		{netflowV5FlowStartMillisecondsID, 8},
+		{netflowV5FlowEndMillisecondsID, 8},
+	} {
+		fsp, err := NewFieldSpecifier(0, extra.elementid, extra.length)
+		if err != nil {
+			return nil, err
+		}
+		tmplrec.AddSpecifier(fsp)
+	}
+	return tmplrec, nil
+}
+
+// NetflowV5Message represents a NetFlow v5 Export Packet.
+type NetflowV5Message struct {
+	VersionNumber    uint16        // Must be Version NetflowV5Version
+	Count            uint16        // Number of flows exported in this packet (1-30)
+	SysUpTime        uint32        // Current time in milliseconds since the export device booted
+	ExportTime       time.Time     // Current count of seconds and residual nanoseconds since 0000 UTC 1970
+	FlowSequence     uint32        // Sequence counter of total flows seen
+	EngineType       uint8         // Type of flow-switching engine
+	EngineID         uint8         // Slot number of the flow-switching engine
+	SamplingInterval uint16        // First two bits hold the sampling mode; remaining 14 bits hold value of sampling interval
+	TemplateID       uint16        // The Template ID under which the synthetic template is registered
+	Records          []*DataRecord // The flows, as Data Records following the synthetic template
+
+	//AssociatedTemplates Templates points to the list of active templates (whether in a session or not). Without a template record a data record can not be encoded or decoded
+	AssociatedTemplates *ActiveTemplates
+}
+
+// NewNetflowV5Message creates a new NetFlow v5 message that uses the NetflowV5DefaultTemplateID.
+func NewNetflowV5Message() (*NetflowV5Message, error) {
+	return &NetflowV5Message{
+		VersionNumber: NetflowV5Version,
+		ExportTime:    time.Unix(233431200, 0), //A long time ago. Needs to be set when message is sent
+		TemplateID:    NetflowV5DefaultTemplateID,
+		Records:       make([]*DataRecord, 0, 0),
+	}, nil
+}
+
+// String returns the string representation of the NetFlow v5 Message
+func (v5msg *NetflowV5Message) String() string {
+	retstr := fmt.Sprintf("version=%d, count=%d, sysuptime=%d, export time=%s, flow sequence=%d, engine type=%d, engine id=%d, sampling interval=%d", v5msg.VersionNumber, v5msg.Count, v5msg.SysUpTime, v5msg.ExportTime, v5msg.FlowSequence, v5msg.EngineType, v5msg.EngineID, v5msg.SamplingInterval)
+	for _, rec := range v5msg.Records {
+		retstr += "\n" + rec.String()
+	}
+	return retstr
+}
+
+// SysUpTimeToTime converts a timestamp relative to the exporter's sysUpTime to an absolute time.
+func (v5msg *NetflowV5Message) SysUpTimeToTime(uptime uint32) time.Time {
+	boottime := v5msg.ExportTime.Add(-time.Duration(v5msg.SysUpTime) * time.Millisecond)
+	return boottime.Add(time.Duration(uptime) * time.Millisecond)
+}
+
+// ToIPFIX returns an IPFIX Message with a Template Set holding the synthetic template, followed by a Data Set with the flows.
+// The Flow Sequence becomes the Sequence Number as both count flows. The Observation Domain ID is composed of the engine type and engine ID.
+func (v5msg *NetflowV5Message) ToIPFIX() (*Message, error) {
+	ipfixmsg, err := NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	err = ipfixmsg.SetExportTime(v5msg.ExportTime)
+	if err != nil {
+		return nil, err
+	}
+	ipfixmsg.SetSequenceNumber(v5msg.FlowSequence)
+	ipfixmsg.SetObservationDomainID(uint32(v5msg.EngineType)<<8 | uint32(v5msg.EngineID))
+	ipfixmsg.AssociatedTemplates = v5msg.AssociatedTemplates
+
+	tmplrec, err := v5msg.AssociatedTemplates.Get(v5msg.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	tmplset, err := NewSet(SetIDTemplate)
+	if err != nil {
+		return nil, err
+	}
+	err = tmplset.AddRecord(tmplrec)
+	if err != nil {
+		return nil, err
+	}
+	err = ipfixmsg.AddSet(tmplset)
+	if err != nil {
+		return nil, err
+	}
+	if len(v5msg.Records) == 0 {
+		return ipfixmsg, nil
+	}
+	datset, err := NewSet(v5msg.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	datset.AssociateTemplates(v5msg.AssociatedTemplates)
+	for _, rec := range v5msg.Records {
+		err = datset.AddRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = ipfixmsg.AddSet(datset)
+	if err != nil {
+		return nil, err
+	}
+	return ipfixmsg, nil
+}
+
+// UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
+// The synthetic template is registered in the associated templates under TemplateID.
+func (v5msg *NetflowV5Message) UnmarshalBinary(data []byte) (err error) {
+	if data == nil || len(data) < netflowV5HeaderLength {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+	}
+	if v5msg.AssociatedTemplates == nil {
+		return NewCategoryError(ErrNoTemplates, fmt.Sprintf("Can not have nil pointer to associated templates"), ErrCritical)
+	}
+
+	v5msg.VersionNumber = binary.BigEndian.Uint16(data[0:2])
+	if v5msg.VersionNumber != NetflowV5Version {
+		return NewCategoryError(ErrInvalidVersion, fmt.Sprintf("Unusable NetFlow version. Want %d, but got %d", NetflowV5Version, v5msg.VersionNumber), ErrCritical)
+	}
+	v5msg.Count = binary.BigEndian.Uint16(data[2:4])
+	if v5msg.Count > netflowV5MaxRecords {
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid record count. Must be <= %d, but got %d", netflowV5MaxRecords, v5msg.Count), ErrCritical)
+	}
+	if netflowV5HeaderLength+int(v5msg.Count)*netflowV5RecordLength > len(data) {
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Can not unmarshal, invalid length. Packet states %d records but only have %d bytes of data", v5msg.Count, len(data)), ErrCritical)
+	}
+	v5msg.SysUpTime = binary.BigEndian.Uint32(data[4:8])
+	v5msg.ExportTime = time.Unix(int64(binary.BigEndian.Uint32(data[8:12])), int64(binary.BigEndian.Uint32(data[12:16])))
+	v5msg.FlowSequence = binary.BigEndian.Uint32(data[16:20])
+	v5msg.EngineType = data[20]
+	v5msg.EngineID = data[21]
+	v5msg.SamplingInterval = binary.BigEndian.Uint16(data[22:24])
+
+	tmplrec, err := NewNetflowV5Template(v5msg.TemplateID)
+	if err != nil {
+		return err
+	}
+	err = v5msg.AssociatedTemplates.Set(v5msg.TemplateID, tmplrec)
+	if err != nil {
+		return err
+	}
+
+	v5msg.Records = make([]*DataRecord, 0, v5msg.Count)
+	for cnt := 0; cnt < int(v5msg.Count); cnt++ {
+		cursor := netflowV5HeaderLength + cnt*netflowV5RecordLength
+		datrec, suberr := v5msg.unmarshalRecord(data[cursor : cursor+netflowV5RecordLength])
+		if suberr != nil {
+			err = stackError(err, "Sub errors unmarshalling NetFlow v5 message.", suberr, cursor)
+			continue
+		}
+		v5msg.Records = append(v5msg.Records, datrec)
+	}
+	return err
+}
+
+// unmarshalRecord decodes a single 48 octet v5 record into a Data Record following the synthetic template
+func (v5msg *NetflowV5Message) unmarshalRecord(data []byte) (*DataRecord, error) {
+	datrec, err := NewDataRecord(v5msg.TemplateID, v5msg.AssociatedTemplates)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range netflowV5Fields {
+		fieldval, err := NewFieldValueByID(0, field.elementid)
+		if err != nil {
+			return nil, err
+		}
+		err = fieldval.UnmarshalBinary(data[field.offset : field.offset+int(field.length)])
+		if err != nil {
+			return nil, err
+		}
+		datrec.FieldValues = append(datrec.FieldValues, fieldval)
+	}
+	datrec.FieldValues = append(datrec.FieldValues,
+		&FieldValueUnsigned8{value: v5msg.EngineType},
+		&FieldValueUnsigned8{value: v5msg.EngineID},
+		&FieldValueDateTimeMilliseconds{value: v5msg.SysUpTimeToTime(binary.BigEndian.Uint32(data[24:28]))},
+		&FieldValueDateTimeMilliseconds{value: v5msg.SysUpTimeToTime(binary.BigEndian.Uint32(data[28:32]))},
+	)
+	return datrec, nil
+}
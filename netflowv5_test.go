@@ -0,0 +1,118 @@
+package ipfix
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	netflowv5TestPrint = false
+)
+
+func TestNetflowV5Marker(t *testing.T) {
+	if netflowv5TestPrint {
+		fmt.Printf(testMarkerString, "NetFlow v5")
+	}
+}
+
+func netflowV5TestPacket() []byte {
+	packet := []byte{
+		0, 5, 0, 2, //Version, Count
+		0, 1, 0x86, 0xa0, //SysUpTime (100 seconds)
+		0x52, 0xdd, 0xa6, 0xec, //Unix seconds
+		0x1d, 0xcd, 0x65, 0x00, //Unix nanoseconds (0.5 seconds)
+		0, 0, 0, 99, //Flow sequence
+		1, 2, //Engine type and id
+		0, 0, //Sampling interval
+	}
+	for idx := byte(1); idx <= 2; idx++ {
+		packet = append(packet,
+			10, 0, 0, idx, //srcaddr
+			192, 168, 0, idx, //dstaddr
+			10, 0, 0, 254, //nexthop
+			0, 3, 0, 4, //input, output
+			0, 0, 0, 10*idx, //dPkts
+			0, 0, 0x10, 0, //dOctets
+			0, 1, 0x5f, 0x90, //First (90 seconds)
+			0, 1, 0x73, 0x18, //Last (95 seconds)
+			0x30, 0x39, 0, 80, //srcport, dstport
+			0, 0x12, 6, 0, //pad1, tcp_flags, prot, tos
+			0xfd, 0xe8, 0, 0, //src_as, dst_as
+			24, 16, 0, 0, //src_mask, dst_mask, pad2
+		)
+	}
+	return packet
+}
+
+func TestNetflowV5Unmarshal(t *testing.T) {
+	v5msg, err := NewNetflowV5Message()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating NetFlow v5 message: %#v", err)
+	}
+	v5msg.AssociatedTemplates = NewActiveTemplateList()
+	err = v5msg.UnmarshalBinary(netflowV5TestPacket())
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling NetFlow v5 message: %v", err)
+	}
+	if netflowv5TestPrint {
+		fmt.Println(v5msg)
+	}
+	if len(v5msg.Records) != 2 || v5msg.FlowSequence != 99 || v5msg.EngineID != 2 {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling NetFlow v5 message: %s", v5msg)
+	}
+	rec := v5msg.Records[1]
+	if !rec.FieldValues[0].Value().(net.IP).Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf(errorPrefixMarker+"Wrong source address %v", rec.FieldValues[0].Value())
+	}
+	if rec.FieldValues[5].Value().(uint64) != 20 || rec.FieldValues[6].Value().(uint64) != 4096 {
+		t.Errorf(errorPrefixMarker+"Wrong counters %v, %v", rec.FieldValues[5].Value(), rec.FieldValues[6].Value())
+	}
+	if rec.FieldValues[14].Value().(uint32) != 65000 || rec.FieldValues[11].Value().(uint16) != 0x12 {
+		t.Errorf(errorPrefixMarker+"Wrong AS or flags %v, %v", rec.FieldValues[14].Value(), rec.FieldValues[11].Value())
+	}
+	exporttime := time.Unix(0x52dda6ec, 500000000)
+	start := rec.FieldValues[20].Value().(time.Time)
+	end := rec.FieldValues[21].Value().(time.Time)
+	if !start.Equal(exporttime.Add(-10*time.Second)) || !end.Equal(exporttime.Add(-5*time.Second)) {
+		t.Errorf(errorPrefixMarker+"Wrong absolute timestamps %s - %s", start, end)
+	}
+
+	ipfixmsg, err := v5msg.ToIPFIX()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error converting to IPFIX: %v", err)
+	}
+	data, err := ipfixmsg.MarshalBinary()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error marshalling converted message: %v", err)
+	}
+	receivermessage, _ := NewMessage()
+	receivermessage.AssociatedTemplates = NewActiveTemplateList()
+	err = receivermessage.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling converted message: %v", err)
+	}
+	if len(receivermessage.Sets) != 2 || len(receivermessage.Sets[1].Records) != 2 {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling converted message: %s", receivermessage)
+	}
+	received := (*receivermessage.Sets[1].Records[1]).(*DataRecord)
+	for idx, fv := range rec.FieldValues {
+		if fmt.Sprintf("%v", fv.Value()) != fmt.Sprintf("%v", received.FieldValues[idx].Value()) {
+			t.Errorf(errorPrefixMarker+"Field %d differs after IPFIX round trip: %v vs %v", idx, fv.Value(), received.FieldValues[idx].Value())
+		}
+	}
+}
+
+func TestNetflowV5UnmarshalInvalid(t *testing.T) {
+	v5msg, _ := NewNetflowV5Message()
+	v5msg.AssociatedTemplates = NewActiveTemplateList()
+	if err := v5msg.UnmarshalBinary(netflowV5TestPacket()[:60]); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for truncated packet")
+	}
+	packet := netflowV5TestPacket()
+	packet[3] = 31
+	if err := v5msg.UnmarshalBinary(packet); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for too many records")
+	}
+}
@@ -0,0 +1,243 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+
+NetFlow version 9, as specified in [RFC3954], is the direct predecessor of IPFIX.
+The record formats are very close to IPFIX: templates define the layout of data records and the field types 1-127 are identical to the IPFIX Information Elements with the same ID.
+The differences that matter for decoding are:
+  - the packet header is 20 octets and carries the sysUpTime of the exporter
+  - the Template FlowSet has ID 0 and the Options Template FlowSet has ID 1 (instead of 2 and 3)
+  - there is no enterprise bit; field types are always IANA (or vendor specific in the upper range)
+  - the Options Template Record lists the scope and option lengths in octets instead of field counts
+  - scope field types are not Information Elements but one of System, Interface, Line Card, Cache or Template
+
+Decoded FlowSets are stored as regular Sets with the IPFIX Set IDs, so the templates and data records look exactly as they would have if the exporter spoke IPFIX.
+
+*/
+
+const (
+	// NetflowV9Version denotes the version of the NetFlow Export Packet format
+	NetflowV9Version = 9
+
+	netflowV9HeaderLength = 20 //Length of the NetFlow v9 packet header. For calculations.
+)
+
+// FlowSet ID values for NetFlow v9
+const (
+	NetflowV9TemplateFlowSetID        = SetIDInvalid1 // The Template FlowSet
+	NetflowV9OptionsTemplateFlowSetID = SetIDInvalid2 // The Options Template FlowSet
+)
+
+// NetFlow v9 scope field types. See [RFC3954], section 6.1.
+const (
+	NetflowV9ScopeSystem    = 1
+	NetflowV9ScopeInterface = 2
+	NetflowV9ScopeLineCard  = 3
+	NetflowV9ScopeCache     = 4
+	NetflowV9ScopeTemplate  = 5
+)
+
+// netflowV9ScopeMapping translates the NetFlow v9 scope field types to the IPFIX Information Elements that are used as scope in IPFIX
+var netflowV9ScopeMapping = map[uint16]uint16{
+	NetflowV9ScopeSystem:    149, // observationDomainId
+	NetflowV9ScopeInterface: 10,  // ingressInterface
+	NetflowV9ScopeLineCard:  141, // lineCardId
+	NetflowV9ScopeCache:     143, // meteringProcessId
+	NetflowV9ScopeTemplate:  145, // templateId
+}
+
+// NetflowV9Message represents a NetFlow v9 Export Packet.
+// An Export Packet consists of a Packet Header, followed by one or more FlowSets.
+// The FlowSets can be any of these three possible types: Template FlowSet, Options Template FlowSet or Data FlowSet.
+type NetflowV9Message struct {
+	VersionNumber  uint16    // Must be Version NetflowV9Version
+	Count          uint16    // The total number of records in the Export Packet, which is the sum of Options FlowSet records, Template FlowSet records, and Data FlowSet records.
+	SysUpTime      uint32    // Time in milliseconds since this device was first booted.
+	ExportTime     time.Time // Time in seconds since 0000 UTC 1970, at which the Export Packet leaves the Exporter.
+	SequenceNumber uint32    // Incremental sequence counter of all Export Packets sent from the current Observation Domain by the Exporter.
+	SourceID       uint32    // A 32-bit value that identifies the Exporter Observation Domain. This is the equivalent of the IPFIX Observation Domain ID.
+	Sets           []*Set    // The FlowSets, using the IPFIX Set IDs
+
+	//AssociatedTemplates Templates points to the list of active templates (whether in a session or not). Without a template record a data record can not be encoded or decoded
+	AssociatedTemplates *ActiveTemplates
+}
+
+// NewNetflowV9Message creates a new NetFlow v9 message.
+func NewNetflowV9Message() (*NetflowV9Message, error) {
+	return &NetflowV9Message{
+		VersionNumber: NetflowV9Version,
+		ExportTime:    time.Unix(233431200, 0), //A long time ago. Needs to be set when message is sent
+		Sets:          make([]*Set, 0, 0),
+	}, nil
+}
+
+// String returns the string representation of the NetFlow v9 Message
+func (v9msg *NetflowV9Message) String() string {
+	retstr := fmt.Sprintf("version=%d, count=%d, sysuptime=%d, export time=%s, sequence=%d, source id=%d", v9msg.VersionNumber, v9msg.Count, v9msg.SysUpTime, v9msg.ExportTime, v9msg.SequenceNumber, v9msg.SourceID)
+	if len(v9msg.Sets) > 0 {
+		retstr += "\n"
+		for _, st := range v9msg.Sets {
+			retstr += st.String() + "\n"
+		}
+	}
+	return retstr
+}
+
+// SysUpTimeToTime converts a timestamp relative to the exporter's sysUpTime (such as flowStartSysUpTime and flowEndSysUpTime) to an absolute time.
+// Note that the export time only has a precision of seconds, so the result is accurate to the second.
+func (v9msg *NetflowV9Message) SysUpTimeToTime(uptime uint32) time.Time {
+	boottime := v9msg.ExportTime.Add(-time.Duration(v9msg.SysUpTime) * time.Millisecond)
+	return boottime.Add(time.Duration(uptime) * time.Millisecond)
+}
+
+// ToIPFIX returns the IPFIX Message that carries the same Sets as this NetFlow v9 Message.
+// The Source ID becomes the Observation Domain ID. The Sequence Number is copied as-is; note that NetFlow v9 counts packets whereas IPFIX counts Data Records.
+func (v9msg *NetflowV9Message) ToIPFIX() (*Message, error) {
+	ipfixmsg, err := NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	err = ipfixmsg.SetExportTime(v9msg.ExportTime)
+	if err != nil {
+		return nil, err
+	}
+	ipfixmsg.SetSequenceNumber(v9msg.SequenceNumber)
+	ipfixmsg.SetObservationDomainID(v9msg.SourceID)
+	ipfixmsg.AssociatedTemplates = v9msg.AssociatedTemplates
+	for _, st := range v9msg.Sets {
+		err = ipfixmsg.AddSet(st)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ipfixmsg, nil
+}
+
+// UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
+// It uses the DefaultDecodeOptions.
+func (v9msg *NetflowV9Message) UnmarshalBinary(data []byte) (err error) {
+	return v9msg.UnmarshalBinaryWithOptions(data, DefaultDecodeOptions)
+}
+
+// UnmarshalBinaryWithOptions decodes the NetFlow v9 message in data, under the Limits of opts.
+// Malformed FlowSets are reported as stacked errors, and the FlowSets that could be decoded are kept.
+func (v9msg *NetflowV9Message) UnmarshalBinaryWithOptions(data []byte, opts DecodeOptions) (err error) {
+	if data == nil || len(data) < netflowV9HeaderLength {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+	}
+	if v9msg.AssociatedTemplates == nil {
+		return NewCategoryError(ErrNoTemplates, fmt.Sprintf("Can not have nil pointer to associated templates"), ErrCritical)
+	}
+
+	v9msg.VersionNumber = binary.BigEndian.Uint16(data[0:2])
+	if v9msg.VersionNumber != NetflowV9Version {
+		return NewCategoryError(ErrInvalidVersion, fmt.Sprintf("Unusable NetFlow version. Want %d, but got %d", NetflowV9Version, v9msg.VersionNumber), ErrCritical)
+	}
+	v9msg.Count = binary.BigEndian.Uint16(data[2:4])
+	v9msg.SysUpTime = binary.BigEndian.Uint32(data[4:8])
+	v9msg.ExportTime = time.Unix(int64(binary.BigEndian.Uint32(data[8:12])), 0)
+	v9msg.SequenceNumber = binary.BigEndian.Uint32(data[12:16])
+	v9msg.SourceID = binary.BigEndian.Uint32(data[16:20])
+
+	state := newDecodeState(opts.Limits)
+	cursor := netflowV9HeaderLength
+	for cursor+ipfixSetHeaderLength <= len(data) {
+		flowsetid := binary.BigEndian.Uint16(data[cursor : cursor+2])
+		flowsetlength := int(binary.BigEndian.Uint16(data[cursor+2 : cursor+4]))
+		if flowsetlength < ipfixSetHeaderLength || cursor+flowsetlength > len(data) {
+			suberr := NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid FlowSet length %d at offset %d, have %d bytes of data", flowsetlength, cursor, len(data)), ErrCritical).AtOffset(0).InSet(flowsetid)
+			return state.setStaged(stackError(err, "Sub errors unmarshalling NetFlow v9 message.", suberr, cursor), "Sub errors unmarshalling NetFlow v9 message.")
+		}
+		flowsetdata := data[cursor : cursor+flowsetlength]
+
+		var tmpset *Set
+		var suberr error
+		switch {
+		case flowsetid == NetflowV9TemplateFlowSetID:
+			tmpset, suberr = unmarshalNetflowV9TemplateFlowSet(flowsetdata, false, opts.Limits)
+		case flowsetid == NetflowV9OptionsTemplateFlowSetID:
+			tmpset, suberr = unmarshalNetflowV9TemplateFlowSet(flowsetdata, true, opts.Limits)
+		case flowsetid > 255:
+			tmpset = NewBlankSet()
+			tmpset.AssociateTemplates(v9msg.AssociatedTemplates)
+			suberr = tmpset.unmarshal(flowsetdata, opts, state)
+		default:
+			suberr = NewCategoryError(ErrInvalidSetID, fmt.Sprintf("Invalid FlowSet ID: %d", flowsetid), ErrFailure).AtOffset(0).InSet(flowsetid)
+		}
+		err = stackError(err, "Sub errors unmarshalling NetFlow v9 message.", suberr, cursor)
+		if tmpset != nil {
+			if tmpset.SetID == SetIDTemplate || tmpset.SetID == SetIDOptionTemplate {
+				for _, rec := range tmpset.Records {
+					if tmplrec, ok := (*rec).(*TemplateRecord); ok {
+						state.stage(v9msg.AssociatedTemplates, tmplrec)
+					}
+				}
+			}
+			v9msg.Sets = append(v9msg.Sets, tmpset)
+		}
+		cursor += flowsetlength
+	}
+	return state.setStaged(err, "Sub errors unmarshalling NetFlow v9 message.")
+}
+
+// unmarshalNetflowV9TemplateFlowSet decodes a (Options) Template FlowSet, including its header, into a Set with the equivalent IPFIX Set ID, under limits
+func unmarshalNetflowV9TemplateFlowSet(data []byte, options bool, limits Limits) (*Set, error) {
+	setid := uint16(SetIDTemplate)
+	headerlength := 4
+	if options {
+		setid = SetIDOptionTemplate
+		headerlength = 6
+	}
+	v9set, err := NewSet(setid)
+	if err != nil {
+		return nil, err
+	}
+	cursor := ipfixSetHeaderLength
+	for cursor+headerlength <= len(data) {
+		templateid := binary.BigEndian.Uint16(data[cursor : cursor+2])
+		if templateid == 0 { //Template IDs are always > 255, so this must be padding
+			break
+		}
+		tmplrec, err := NewTemplateRecord(templateid)
+		if err != nil {
+			return v9set, err
+		}
+		scopecount, fieldcount := 0, int(binary.BigEndian.Uint16(data[cursor+2:cursor+4]))
+		if options {
+			tmplrec.ScopeFieldSpecifiers = make([]*FieldSpecifier, 0, 0)
+			scopecount = int(binary.BigEndian.Uint16(data[cursor+2:cursor+4])) / 4 //Option Scope Length is in octets
+			fieldcount = int(binary.BigEndian.Uint16(data[cursor+4:cursor+6])) / 4 //Option Length is in octets
+		}
+		if limiterr := checkLimit("MaxFieldsPerTemplate", limits.MaxFieldsPerTemplate, scopecount+fieldcount); limiterr != nil {
+			return v9set, limiterr.AtOffset(cursor + 2).InSet(setid).InTemplate(templateid)
+		}
+		cursor += headerlength
+		if cursor+4*(scopecount+fieldcount) > len(data) {
+			return v9set, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Insufficient data to decode template %d. Needed %d, but have %d", templateid, 4*(scopecount+fieldcount), len(data[cursor:])), ErrCritical).AtOffset(cursor).InSet(setid).InTemplate(templateid)
+		}
+		for cnt := 0; cnt < scopecount+fieldcount; cnt++ {
+			fieldtype := binary.BigEndian.Uint16(data[cursor : cursor+2])
+			fieldlength := binary.BigEndian.Uint16(data[cursor+2 : cursor+4])
+			cursor += 4
+			if cnt < scopecount {
+				if ieid, found := netflowV9ScopeMapping[fieldtype]; found {
+					fieldtype = ieid
+				}
+				tmplrec.ScopeFieldSpecifiers = append(tmplrec.ScopeFieldSpecifiers, &FieldSpecifier{InformationElementIdentifier: fieldtype, FieldLength: fieldlength})
+			} else {
+				tmplrec.FieldSpecifiers = append(tmplrec.FieldSpecifiers, &FieldSpecifier{InformationElementIdentifier: fieldtype, FieldLength: fieldlength})
+			}
+		}
+		err = v9set.AddRecord(tmplrec)
+		if err != nil {
+			return v9set, err
+		}
+	}
+	return v9set, nil
+}
@@ -0,0 +1,170 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	netflowv9TestPrint = false
+)
+
+func TestNetflowV9Marker(t *testing.T) {
+	if netflowv9TestPrint {
+		fmt.Printf(testMarkerString, "NetFlow v9")
+	}
+}
+
+var netflowV9TestPacket = []byte{
+	0, 9, 0, 4, //Version, Count
+	0, 0, 0x27, 0x10, //SysUpTime (10 seconds)
+	0x52, 0xdd, 0xa6, 0xec, //Unix seconds
+	0, 0, 0, 7, //Sequence number
+	0, 0, 0, 42, //Source ID
+
+	0, 0, 0, 28, //Template FlowSet
+	1, 0, 0, 5, //Template 256, 5 fields
+	0, 8, 0, 4, //sourceIPv4Address
+	0, 12, 0, 4, //destinationIPv4Address
+	0, 1, 0, 4, //octetDeltaCount, reduced size
+	0, 4, 0, 1, //protocolIdentifier
+	0, 22, 0, 4, //flowStartSysUpTime
+
+	0, 1, 0, 24, //Options Template FlowSet
+	1, 1, 0, 4, 0, 8, //Template 257, scope length 4, option length 8
+	0, 2, 0, 4, //Scope: Interface
+	0, 34, 0, 4, //samplingInterval
+	0, 35, 0, 1, //samplingAlgorithm
+	0, 0, //Padding
+
+	1, 0, 0, 24, //Data FlowSet for template 256
+	10, 0, 0, 1, 10, 0, 0, 2, 0, 0, 5, 220, 6, 0, 0, 0x13, 0x88,
+	0, 0, 0, //Padding
+
+	1, 1, 0, 16, //Data FlowSet for template 257
+	0, 0, 0, 3, 0, 0, 0, 100, 1,
+	0, 0, 0, //Padding
+}
+
+func TestNetflowV9Unmarshal(t *testing.T) {
+	v9msg, err := NewNetflowV9Message()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating NetFlow v9 message: %#v", err)
+	}
+	err = v9msg.UnmarshalBinary(netflowV9TestPacket)
+	if err == nil {
+		t.Fatalf(errorPrefixMarker + "Should have gotten error unmarshalling without associated templates")
+	}
+	v9msg.AssociatedTemplates = NewActiveTemplateList()
+	err = v9msg.UnmarshalBinary(netflowV9TestPacket)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling NetFlow v9 message: %v", err)
+	}
+	if netflowv9TestPrint {
+		fmt.Println(v9msg)
+	}
+	if v9msg.SourceID != 42 || v9msg.SequenceNumber != 7 || v9msg.SysUpTime != 10000 || v9msg.Count != 4 {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling header: %s", v9msg)
+	}
+	if len(v9msg.Sets) != 4 {
+		t.Fatalf(errorPrefixMarker+"Expected 4 sets, but got %d", len(v9msg.Sets))
+	}
+	if v9msg.Sets[0].SetID != SetIDTemplate || v9msg.Sets[1].SetID != SetIDOptionTemplate {
+		t.Errorf(errorPrefixMarker+"Template FlowSets should have IPFIX set ids, got %d and %d", v9msg.Sets[0].SetID, v9msg.Sets[1].SetID)
+	}
+
+	opttpl, err := v9msg.AssociatedTemplates.Get(257)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Options template not registered: %v", err)
+	}
+	if len(opttpl.ScopeFieldSpecifiers) != 1 || opttpl.ScopeFieldSpecifiers[0].InformationElementIdentifier != 10 || len(opttpl.FieldSpecifiers) != 2 {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling options template: %s", opttpl)
+	}
+
+	datrec := (*v9msg.Sets[2].Records[0]).(*DataRecord)
+	if len(v9msg.Sets[2].Records) != 1 || len(datrec.FieldValues) != 5 {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling data flowset: %s", v9msg.Sets[2])
+	}
+	if !datrec.FieldValues[0].Value().(net.IP).Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf(errorPrefixMarker+"Wrong source address %v", datrec.FieldValues[0].Value())
+	}
+	if datrec.FieldValues[2].Value().(uint64) != 1500 {
+		t.Errorf(errorPrefixMarker+"Wrong octet count %v", datrec.FieldValues[2].Value())
+	}
+	start := v9msg.SysUpTimeToTime(datrec.FieldValues[4].Value().(uint32))
+	if !start.Equal(v9msg.ExportTime.Add(-5 * time.Second)) {
+		t.Errorf(errorPrefixMarker+"Wrong absolute flow start %s, export time %s", start, v9msg.ExportTime)
+	}
+
+	optrec := (*v9msg.Sets[3].Records[0]).(*DataRecord)
+	if len(optrec.FieldValues) != 3 || optrec.FieldValues[1].Value().(uint32) != 100 {
+		t.Errorf(errorPrefixMarker+"Error unmarshalling options data: %s", optrec)
+	}
+
+	ipfixmsg, err := v9msg.ToIPFIX()
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error converting to IPFIX: %v", err)
+	}
+	if ipfixmsg.ObservationDomainID != 42 || len(ipfixmsg.Sets) != 4 {
+		t.Errorf(errorPrefixMarker+"Error converting to IPFIX: %s", ipfixmsg)
+	}
+}
+
+func TestNetflowV9UnmarshalUnknown(t *testing.T) {
+	packet := append(append([]byte{0, 9, 0, 2}, netflowV9TestPacket[4:20]...),
+		0, 0, 0, 16, //Template FlowSet
+		1, 2, 0, 2, //Template 258, 2 fields
+		0, 8, 0, 4, //sourceIPv4Address
+		0x70, 0, 0, 2, //Unknown element
+
+		1, 2, 0, 12, //Data FlowSet for template 258
+		10, 0, 0, 1, 0xab, 0xcd,
+		0, 0, //Padding
+	)
+	v9msg, _ := NewNetflowV9Message()
+	v9msg.AssociatedTemplates = NewActiveTemplateList()
+	if err := v9msg.UnmarshalBinary(packet); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for unknown element")
+	}
+	if len(v9msg.Sets) != 2 || len(v9msg.Sets[1].Records) != 1 {
+		t.Fatalf(errorPrefixMarker+"The record with the unknown element should still be decoded: %s", v9msg)
+	}
+	datrec := (*v9msg.Sets[1].Records[0]).(*DataRecord)
+	if value, ok := datrec.FieldValues[1].(*FieldValueOctetArray); !ok || fmt.Sprint(value.Value()) != "[171 205]" {
+		t.Errorf(errorPrefixMarker+"Unknown element should be kept as octets, but got %#v", datrec.FieldValues[1])
+	}
+}
+
+func TestNetflowV9UnmarshalInvalid(t *testing.T) {
+	v9msg, _ := NewNetflowV9Message()
+	v9msg.AssociatedTemplates = NewActiveTemplateList()
+	if err := v9msg.UnmarshalBinary(netflowV9TestPacket[:10]); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for short header")
+	}
+	if err := v9msg.UnmarshalBinary(append([]byte{0, 10}, netflowV9TestPacket[2:]...)); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for wrong version")
+	}
+	if err := v9msg.UnmarshalBinary(netflowV9TestPacket[:40]); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for truncated flowset")
+	}
+}
+
+func TestNetflowV9UnmarshalLimits(t *testing.T) {
+	for limits, limit := range map[Limits]string{
+		{MaxTemplates: 1}:         "MaxTemplates",
+		{MaxFieldsPerTemplate: 4}: "MaxFieldsPerTemplate",
+		{MaxRecordsPerSet: 1}:     "",
+		{MaxDecodedBytes: 16}:     "MaxDecodedBytes",
+	} {
+		v9msg, _ := NewNetflowV9Message()
+		v9msg.AssociatedTemplates = NewActiveTemplateList()
+		err := v9msg.UnmarshalBinaryWithOptions(netflowV9TestPacket, DecodeOptions{Limits: limits})
+		var limiterr *LimitError
+		if limit == "" && err != nil || limit != "" && (!errors.As(err, &limiterr) || limiterr.Limit != limit) {
+			t.Errorf(errorPrefixMarker+"Expected %q to be exceeded, but got %v", limit, err)
+		}
+	}
+}
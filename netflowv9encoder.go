@@ -0,0 +1,319 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+
+Down-conversion of IPFIX to NetFlow v9, for collectors that do not speak IPFIX.
+
+NetFlow v9 can not carry everything IPFIX can:
+  - there is no enterprise bit, so enterprise-specific Information Elements can not be exported
+  - there is no variable length encoding, so variable-length fields (including the structured data types) can not be exported
+  - timestamps are relative to the sysUpTime of the exporter, so absolute timestamps are converted to flowStartSysUpTime and flowEndSysUpTime, keeping only the most precise one of each
+  - scope fields must be one of the five v9 scope types
+
+Fields that can not be represented are either dropped from the template, or the whole template is rejected, and they are always reported.
+
+*/
+
+// netflowV9TimeMapping maps the absolute IPFIX flow timestamps to their sysUpTime relative NetFlow v9 counterparts
+var netflowV9TimeMapping = map[uint16]uint16{
+	150: 22, // flowStartSeconds -> flowStartSysUpTime
+	151: 21, // flowEndSeconds -> flowEndSysUpTime
+	152: 22, // flowStartMilliseconds -> flowStartSysUpTime
+	153: 21, // flowEndMilliseconds -> flowEndSysUpTime
+	154: 22, // flowStartMicroseconds -> flowStartSysUpTime
+	155: 21, // flowEndMicroseconds -> flowEndSysUpTime
+	156: 22, // flowStartNanoseconds -> flowStartSysUpTime
+	157: 21, // flowEndNanoseconds -> flowEndSysUpTime
+}
+
+// netflowV9TimeFields returns per sysUpTime relative v9 field the index of the absolute timestamp that is exported as it.
+// A Data Record can hold only one of each, so the most precise one is chosen; for both directions a higher
+// Information Element identifier is more precise. Of equally precise timestamps the first is chosen.
+func netflowV9TimeFields(fields []*FieldSpecifier, nofscopefields int) map[uint16]int {
+	chosen := make(map[uint16]int)
+	for fieldidx, fsp := range fields {
+		if fieldidx < nofscopefields || fsp.E || fsp.FieldLength == VariableLength {
+			continue
+		}
+		relativeid, found := netflowV9TimeMapping[fsp.InformationElementIdentifier]
+		if !found {
+			continue
+		}
+		if current, found := chosen[relativeid]; !found || fields[current].InformationElementIdentifier < fsp.InformationElementIdentifier {
+			chosen[relativeid] = fieldidx
+		}
+	}
+	return chosen
+}
+
+// NetflowV9FieldReport describes a field of an IPFIX template that could not be represented in NetFlow v9
+type NetflowV9FieldReport struct {
+	TemplateID uint16         // The IPFIX template the field belongs to
+	FieldIndex int            // The index of the field in the Data Record, scope fields first
+	Field      FieldSpecifier // The offending field
+	Reason     string         // Why the field can not be represented
+}
+
+// String returns the string representation of the report
+func (fr NetflowV9FieldReport) String() string {
+	return fmt.Sprintf("template %d, field %d (%s): %s", fr.TemplateID, fr.FieldIndex, fr.Field.String(), fr.Reason)
+}
+
+// netflowV9FieldConversion holds what to do with a single field of an IPFIX Data Record
+type netflowV9FieldConversion struct {
+	drop        bool   //The field is not exported
+	relative    bool   //The field is an absolute timestamp that is exported relative to sysUpTime
+	fieldlength uint16 //The length of the field in the v9 record
+}
+
+// netflowV9Template holds the NetFlow v9 version of an IPFIX template and how to convert its records
+type netflowV9Template struct {
+	record *TemplateRecord            //The v9 template, scope field specifiers hold v9 scope types
+	fields []netflowV9FieldConversion //Per field of the IPFIX Data Record, scope fields first
+}
+
+// NetflowV9Encoder converts IPFIX Template Records and Data Records to NetFlow v9 Export Packets.
+type NetflowV9Encoder struct {
+	BootTime              time.Time // The time at which the sysUpTime of the exporter was 0. Timestamps are exported relative to this time.
+	RejectUnrepresentable bool      // If true, templates with fields that can not be represented are rejected instead of having those fields dropped
+	SequenceNumber        uint32    // The sequence number of the next Export Packet
+
+	templates map[uint16]*netflowV9Template
+}
+
+// NewNetflowV9Encoder returns a new encoder whose sysUpTime started at boottime.
+func NewNetflowV9Encoder(boottime time.Time) *NetflowV9Encoder {
+	return &NetflowV9Encoder{
+		BootTime:  boottime,
+		templates: make(map[uint16]*netflowV9Template),
+	}
+}
+
+// AddTemplate converts an IPFIX (Options) Template Record to its NetFlow v9 form and remembers it for encoding Data Records.
+// The returned report lists all fields that could not be represented. If RejectUnrepresentable is set and the report is not empty, or if no fields remain, an error is returned and the template is not added.
+func (enc *NetflowV9Encoder) AddTemplate(tmplrec *TemplateRecord) ([]NetflowV9FieldReport, error) {
+	if tmplrec == nil {
+		return nil, NewError("Got nil pointer to template", ErrCritical)
+	}
+	v9tmpl := &netflowV9Template{
+		fields: make([]netflowV9FieldConversion, 0, len(tmplrec.ScopeFieldSpecifiers)+len(tmplrec.FieldSpecifiers)),
+	}
+	var err error
+	v9tmpl.record, err = NewTemplateRecord(tmplrec.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	if tmplrec.ScopeFieldSpecifiers != nil {
+		v9tmpl.record.ScopeFieldSpecifiers = make([]*FieldSpecifier, 0, len(tmplrec.ScopeFieldSpecifiers))
+	}
+	report := make([]NetflowV9FieldReport, 0, 0)
+	nofscopefields := len(tmplrec.ScopeFieldSpecifiers)
+	fields := tmplrec.allFieldSpecifiers()
+	timefields := netflowV9TimeFields(fields, nofscopefields)
+	for fieldidx, fsp := range fields {
+		isscope := fieldidx < nofscopefields
+		conversion, v9fsp, reason := netflowV9ConvertField(fsp, isscope)
+		if conversion.relative && timefields[v9fsp.InformationElementIdentifier] != fieldidx {
+			conversion, v9fsp, reason = netflowV9FieldConversion{drop: true}, nil, fmt.Sprintf("a more precise timestamp is exported as NetFlow v9 field %d", v9fsp.InformationElementIdentifier)
+		}
+		if reason != "" {
+			report = append(report, NetflowV9FieldReport{TemplateID: tmplrec.TemplateID, FieldIndex: fieldidx, Field: *fsp, Reason: reason})
+		}
+		v9tmpl.fields = append(v9tmpl.fields, conversion)
+		if conversion.drop {
+			continue
+		}
+		if isscope {
+			v9tmpl.record.ScopeFieldSpecifiers = append(v9tmpl.record.ScopeFieldSpecifiers, v9fsp)
+		} else {
+			v9tmpl.record.FieldSpecifiers = append(v9tmpl.record.FieldSpecifiers, v9fsp)
+		}
+	}
+	if enc.RejectUnrepresentable && len(report) > 0 {
+		return report, NewCategoryError(ErrUnrepresentable, fmt.Sprintf("Template %d can not be represented in NetFlow v9: %s", tmplrec.TemplateID, netflowV9ReportString(report)), ErrCritical)
+	}
+	if len(v9tmpl.record.FieldSpecifiers) == 0 || (tmplrec.ScopeFieldSpecifiers != nil && len(v9tmpl.record.ScopeFieldSpecifiers) == 0) {
+		return report, NewCategoryError(ErrUnrepresentable, fmt.Sprintf("Template %d has no fields left after conversion to NetFlow v9: %s", tmplrec.TemplateID, netflowV9ReportString(report)), ErrCritical)
+	}
+	enc.templates[tmplrec.TemplateID] = v9tmpl
+	return report, nil
+}
+
+// netflowV9ConvertField determines how an IPFIX field is exported in NetFlow v9. If the field can not be represented, a reason is returned.
+func netflowV9ConvertField(fsp *FieldSpecifier, isscope bool) (netflowV9FieldConversion, *FieldSpecifier, string) {
+	if fsp.E {
+		return netflowV9FieldConversion{drop: true}, nil, "enterprise-specific fields can not be represented"
+	}
+	if fsp.FieldLength == VariableLength {
+		return netflowV9FieldConversion{drop: true}, nil, "variable-length fields can not be represented"
+	}
+	if isscope {
+		for scopetype, ieid := range netflowV9ScopeMapping {
+			if ieid == fsp.InformationElementIdentifier {
+				return netflowV9FieldConversion{fieldlength: fsp.FieldLength}, &FieldSpecifier{InformationElementIdentifier: scopetype, FieldLength: fsp.FieldLength}, ""
+			}
+		}
+		return netflowV9FieldConversion{drop: true}, nil, "no NetFlow v9 scope type for this Information Element"
+	}
+	if relativeid, found := netflowV9TimeMapping[fsp.InformationElementIdentifier]; found {
+		return netflowV9FieldConversion{relative: true, fieldlength: 4}, &FieldSpecifier{InformationElementIdentifier: relativeid, FieldLength: 4}, ""
+	}
+	return netflowV9FieldConversion{fieldlength: fsp.FieldLength}, &FieldSpecifier{InformationElementIdentifier: fsp.InformationElementIdentifier, FieldLength: fsp.FieldLength}, ""
+}
+
+// netflowV9ReportString joins the reports for use in an error description
+func netflowV9ReportString(report []NetflowV9FieldReport) string {
+	retstr := ""
+	for idx, fr := range report {
+		if idx > 0 {
+			retstr += "; "
+		}
+		retstr += fr.String()
+	}
+	return retstr
+}
+
+// MarshalMessage converts an IPFIX Message to a NetFlow v9 Export Packet.
+// Templates in the message are added with AddTemplate first; the reports of all of them are returned.
+// Data Sets for templates that are unknown to the encoder result in an error.
+func (enc *NetflowV9Encoder) MarshalMessage(ipfixmsg *Message) ([]byte, []NetflowV9FieldReport, error) {
+	if ipfixmsg == nil {
+		return nil, nil, NewError("Got nil pointer to message", ErrCritical)
+	}
+	if ipfixmsg.ExportTime.Before(enc.BootTime) {
+		return nil, nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Export time %s is before boot time %s", ipfixmsg.ExportTime, enc.BootTime), ErrCritical)
+	}
+	report := make([]NetflowV9FieldReport, 0, 0)
+	data := make([]byte, netflowV9HeaderLength, int(ipfixmsg.Len())+netflowV9HeaderLength)
+	count := 0
+	for _, st := range ipfixmsg.Sets {
+		switch {
+		case st.SetID == SetIDTemplate || st.SetID == SetIDOptionTemplate:
+			flowsetid := uint16(NetflowV9TemplateFlowSetID)
+			if st.SetID == SetIDOptionTemplate {
+				flowsetid = NetflowV9OptionsTemplateFlowSetID
+			}
+			flowset := []byte{byte(flowsetid >> 8), byte(flowsetid), 0, 0}
+			for _, rec := range st.Records {
+				tmplrec, ok := (*rec).(*TemplateRecord)
+				if !ok {
+					return nil, report, NewCategoryError(ErrRecordTypeMismatch, "Data Record in template set", ErrCritical).InSet(st.SetID)
+				}
+				tmplreport, err := enc.AddTemplate(tmplrec)
+				report = append(report, tmplreport...)
+				if err != nil {
+					return nil, report, err
+				}
+				flowset = append(flowset, enc.templates[tmplrec.TemplateID].marshalBinary()...)
+				count++
+			}
+			data = append(data, netflowV9FinishFlowSet(flowset)...)
+		case st.SetID > 255:
+			v9tmpl, found := enc.templates[st.SetID]
+			if !found {
+				return nil, report, NewCategoryError(ErrTemplateNotFound, fmt.Sprintf("No NetFlow v9 template for set %d", st.SetID), ErrFailure).InSet(st.SetID).InTemplate(st.SetID)
+			}
+			flowset := []byte{byte(st.SetID >> 8), byte(st.SetID), 0, 0}
+			for _, rec := range st.Records {
+				datrec, ok := (*rec).(*DataRecord)
+				if !ok {
+					return nil, report, NewCategoryError(ErrRecordTypeMismatch, "Template Record in data set", ErrCritical).InSet(st.SetID)
+				}
+				recdata, err := enc.marshalDataRecord(v9tmpl, datrec)
+				if err != nil {
+					return nil, report, err
+				}
+				flowset = append(flowset, recdata...)
+				count++
+			}
+			data = append(data, netflowV9FinishFlowSet(flowset)...)
+		default:
+			return nil, report, NewCategoryError(ErrInvalidSetID, fmt.Sprintf("Invalid Set ID: %d", st.SetID), ErrCritical).InSet(st.SetID)
+		}
+	}
+	if len(data) > 65535 {
+		return nil, report, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid total length of packet. Got %d but should be <= 65535", len(data)), ErrCritical)
+	}
+	binary.BigEndian.PutUint16(data[0:2], NetflowV9Version)
+	binary.BigEndian.PutUint16(data[2:4], uint16(count))
+	binary.BigEndian.PutUint32(data[4:8], uint32(ipfixmsg.ExportTime.Sub(enc.BootTime)/time.Millisecond))
+	binary.BigEndian.PutUint32(data[8:12], uint32(ipfixmsg.ExportTime.Unix()))
+	binary.BigEndian.PutUint32(data[12:16], enc.SequenceNumber)
+	binary.BigEndian.PutUint32(data[16:20], ipfixmsg.ObservationDomainID)
+	enc.SequenceNumber++
+	return data, report, nil
+}
+
+// netflowV9FinishFlowSet pads the FlowSet to a 32 bit boundary and fills in its length
+func netflowV9FinishFlowSet(flowset []byte) []byte {
+	if len(flowset)%4 != 0 {
+		flowset = append(flowset, make([]byte, 4-len(flowset)%4)...)
+	}
+	binary.BigEndian.PutUint16(flowset[2:4], uint16(len(flowset)))
+	return flowset
+}
+
+// marshalBinary returns the NetFlow v9 encoding of the (Options) Template Record, without FlowSet header
+func (v9tmpl *netflowV9Template) marshalBinary() []byte {
+	tmplrec := v9tmpl.record
+	data := make([]byte, 4, 6+4*(len(tmplrec.ScopeFieldSpecifiers)+len(tmplrec.FieldSpecifiers)))
+	binary.BigEndian.PutUint16(data[0:2], tmplrec.TemplateID)
+	if tmplrec.ScopeFieldSpecifiers != nil {
+		binary.BigEndian.PutUint16(data[2:4], uint16(4*len(tmplrec.ScopeFieldSpecifiers))) //Option Scope Length in octets
+		data = append(data, 0, 0)
+		binary.BigEndian.PutUint16(data[4:6], uint16(4*len(tmplrec.FieldSpecifiers))) //Option Length in octets
+	} else {
+		binary.BigEndian.PutUint16(data[2:4], uint16(len(tmplrec.FieldSpecifiers)))
+	}
+	for _, fsp := range tmplrec.allFieldSpecifiers() {
+		data = append(data, byte(fsp.InformationElementIdentifier>>8), byte(fsp.InformationElementIdentifier), byte(fsp.FieldLength>>8), byte(fsp.FieldLength))
+	}
+	return data
+}
+
+// marshalDataRecord returns the NetFlow v9 encoding of the Data Record
+func (enc *NetflowV9Encoder) marshalDataRecord(v9tmpl *netflowV9Template, datrec *DataRecord) ([]byte, error) {
+	if len(datrec.FieldValues) != len(v9tmpl.fields) {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Data Record has %d fields, but template %d has %d", len(datrec.FieldValues), datrec.TemplateID, len(v9tmpl.fields)), ErrCritical).InTemplate(datrec.TemplateID)
+	}
+	data := make([]byte, 0, datrec.Len())
+	for fieldidx, conversion := range v9tmpl.fields {
+		if conversion.drop {
+			continue
+		}
+		fieldval := datrec.FieldValues[fieldidx]
+		if conversion.relative {
+			timestamp, ok := fieldval.Value().(time.Time)
+			if !ok {
+				return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Field %d of template %d is not a timestamp: %#v", fieldidx, datrec.TemplateID, fieldval), ErrCritical).InTemplate(datrec.TemplateID).AtField(fieldidx)
+			}
+			uptime := timestamp.Sub(enc.BootTime) / time.Millisecond
+			if uptime < 0 || uptime > 0xFFFFFFFF {
+				return nil, NewCategoryError(ErrUnrepresentable, fmt.Sprintf("Timestamp %s can not be expressed relative to boot time %s", timestamp, enc.BootTime), ErrFailure).InTemplate(datrec.TemplateID).AtField(fieldidx)
+			}
+			data = append(data, byte(uptime>>24), byte(uptime>>16), byte(uptime>>8), byte(uptime))
+			continue
+		}
+		start := len(data)
+		var err error
+		data, err = appendBinary(data, fieldval)
+		if err != nil {
+			return nil, err
+		}
+		item, err := reduceSize(fieldval, data[start:], conversion.fieldlength)
+		if err != nil {
+			return nil, err
+		}
+		if len(item) != int(conversion.fieldlength) {
+			return nil, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Wrong marshalled size for item %#v, expected %d, but got %d", fieldval, conversion.fieldlength, len(item)), ErrCritical).InTemplate(datrec.TemplateID).AtField(fieldidx)
+		}
+		data = append(data[:start], item...)
+	}
+	return data, nil
+}
@@ -0,0 +1,193 @@
+package ipfix
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetflowV9EncoderMarker(t *testing.T) {
+	if netflowv9TestPrint {
+		fmt.Printf(testMarkerString, "NetFlow v9 Encoder")
+	}
+}
+
+func netflowV9EncoderTestMessage(t *testing.T, exporttime time.Time) *Message {
+	at := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(300)
+	for _, spec := range []struct {
+		enterpriseid uint32
+		elementid    uint16
+		fieldlength  uint16
+	}{
+		{0, 8, 4},               //sourceIPv4Address
+		{0, 1, 4},               //octetDeltaCount, reduced size
+		{0, 152, 8},             //flowStartMilliseconds
+		{12345, 101, 4},         //enterprise-specific
+		{0, 84, VariableLength}, //samplerName
+		{0, 153, 8},             //flowEndMilliseconds
+	} {
+		fsp, err := NewFieldSpecifier(spec.enterpriseid, spec.elementid, spec.fieldlength)
+		if err != nil {
+			t.Fatalf(errorPrefixMarker+"Error creating field specifier: %v", err)
+		}
+		tmplrec.AddSpecifier(fsp)
+	}
+	at.Set(300, tmplrec)
+
+	datrec, _ := NewDataRecord(300, at)
+	datrec.FieldValues = []FieldValue{
+		&FieldValueIPv4Address{value: net.ParseIP("10.1.2.3")},
+		&FieldValueUnsigned64{value: 1500},
+		&FieldValueDateTimeMilliseconds{value: exporttime.Add(-10 * time.Second)},
+		&FieldValueOctetArray{value: []byte{1, 2, 3, 4}},
+		&FieldValueString{value: "sampler"},
+		&FieldValueDateTimeMilliseconds{value: exporttime.Add(-2 * time.Second)},
+	}
+
+	ipfixmsg, _ := NewMessage()
+	ipfixmsg.AssociatedTemplates = at
+	ipfixmsg.SetExportTime(exporttime)
+	ipfixmsg.SetObservationDomainID(77)
+	tmplset, _ := NewSet(SetIDTemplate)
+	tmplset.AddRecord(tmplrec)
+	ipfixmsg.AddSet(tmplset)
+	datset, _ := NewSet(300)
+	datset.AssociateTemplates(at)
+	datset.AddRecord(datrec)
+	ipfixmsg.AddSet(datset)
+	return ipfixmsg
+}
+
+func TestNetflowV9Encoder(t *testing.T) {
+	exporttime := time.Unix(1400000000, 0)
+	boottime := exporttime.Add(-time.Hour)
+	ipfixmsg := netflowV9EncoderTestMessage(t, exporttime)
+
+	enc := NewNetflowV9Encoder(boottime)
+	data, report, err := enc.MarshalMessage(ipfixmsg)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error encoding NetFlow v9: %v", err)
+	}
+	if len(report) != 2 || report[0].FieldIndex != 3 || report[1].FieldIndex != 4 {
+		t.Errorf(errorPrefixMarker+"Expected the enterprise and variable-length field to be reported, but got %v", report)
+	}
+	if enc.SequenceNumber != 1 {
+		t.Errorf(errorPrefixMarker+"Sequence number should have been incremented, got %d", enc.SequenceNumber)
+	}
+
+	v9msg, _ := NewNetflowV9Message()
+	v9msg.AssociatedTemplates = NewActiveTemplateList()
+	err = v9msg.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error decoding encoded NetFlow v9: %v", err)
+	}
+	if netflowv9TestPrint {
+		fmt.Println(v9msg)
+	}
+	if v9msg.SourceID != 77 || v9msg.Count != 2 || v9msg.SysUpTime != 3600000 {
+		t.Errorf(errorPrefixMarker+"Wrong header: %s", v9msg)
+	}
+	v9tmpl, err := v9msg.AssociatedTemplates.Get(300)
+	if err != nil || len(v9tmpl.FieldSpecifiers) != 4 || v9tmpl.FieldSpecifiers[2].InformationElementIdentifier != 22 || v9tmpl.FieldSpecifiers[3].InformationElementIdentifier != 21 {
+		t.Fatalf(errorPrefixMarker+"Wrong template after conversion: %v %v", v9tmpl, err)
+	}
+	datrec := (*v9msg.Sets[1].Records[0]).(*DataRecord)
+	if datrec.FieldValues[1].Value().(uint64) != 1500 {
+		t.Errorf(errorPrefixMarker+"Wrong octet count %v", datrec.FieldValues[1].Value())
+	}
+	start := v9msg.SysUpTimeToTime(datrec.FieldValues[2].Value().(uint32))
+	end := v9msg.SysUpTimeToTime(datrec.FieldValues[3].Value().(uint32))
+	if !start.Equal(exporttime.Add(-10*time.Second)) || !end.Equal(exporttime.Add(-2*time.Second)) {
+		t.Errorf(errorPrefixMarker+"Wrong timestamps after conversion %s - %s", start, end)
+	}
+}
+
+func TestNetflowV9EncoderTimestamps(t *testing.T) {
+	exporttime := time.Unix(1400000000, 0)
+	at := NewActiveTemplateList()
+	tmplrec, _ := NewTemplateRecord(301)
+	for _, spec := range []struct {
+		elementid   uint16
+		fieldlength uint16
+	}{
+		{150, 4}, //flowStartSeconds
+		{152, 8}, //flowStartMilliseconds
+		{157, 8}, //flowEndNanoseconds
+		{151, 4}, //flowEndSeconds
+		{152, 8}, //flowStartMilliseconds, again
+	} {
+		fsp, _ := NewFieldSpecifier(0, spec.elementid, spec.fieldlength)
+		tmplrec.AddSpecifier(fsp)
+	}
+	at.Set(301, tmplrec)
+
+	enc := NewNetflowV9Encoder(exporttime.Add(-time.Hour))
+	report, err := enc.AddTemplate(tmplrec)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error adding template: %v", err)
+	}
+	if len(report) != 3 || report[0].FieldIndex != 0 || report[1].FieldIndex != 3 || report[2].FieldIndex != 4 {
+		t.Errorf(errorPrefixMarker+"Expected the less precise and repeated timestamps to be reported, but got %v", report)
+	}
+
+	datrec, _ := NewDataRecord(301, at)
+	datrec.FieldValues = []FieldValue{
+		&FieldValueDateTimeSeconds{value: exporttime.Add(-20 * time.Second)},
+		&FieldValueDateTimeMilliseconds{value: exporttime.Add(-10 * time.Second)},
+		&FieldValueDateTimeNanoseconds{value: exporttime.Add(-2 * time.Second)},
+		&FieldValueDateTimeSeconds{value: exporttime.Add(-time.Second)},
+		&FieldValueDateTimeMilliseconds{value: exporttime.Add(-5 * time.Second)},
+	}
+	ipfixmsg, _ := NewMessage()
+	ipfixmsg.AssociatedTemplates = at
+	ipfixmsg.SetExportTime(exporttime)
+	tmplset, _ := NewSet(SetIDTemplate)
+	tmplset.AddRecord(tmplrec)
+	ipfixmsg.AddSet(tmplset)
+	datset, _ := NewSet(301)
+	datset.AssociateTemplates(at)
+	datset.AddRecord(datrec)
+	ipfixmsg.AddSet(datset)
+	data, _, err := enc.MarshalMessage(ipfixmsg)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error encoding NetFlow v9: %v", err)
+	}
+
+	v9msg, _ := NewNetflowV9Message()
+	v9msg.AssociatedTemplates = NewActiveTemplateList()
+	if err = v9msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error decoding encoded NetFlow v9: %v", err)
+	}
+	v9tmpl, err := v9msg.AssociatedTemplates.Get(301)
+	if err != nil || len(v9tmpl.FieldSpecifiers) != 2 || v9tmpl.FieldSpecifiers[0].InformationElementIdentifier != 22 || v9tmpl.FieldSpecifiers[1].InformationElementIdentifier != 21 {
+		t.Fatalf(errorPrefixMarker+"Expected one start and one end timestamp, got %v %v", v9tmpl, err)
+	}
+	v9datrec := (*v9msg.Sets[1].Records[0]).(*DataRecord)
+	start := v9msg.SysUpTimeToTime(v9datrec.FieldValues[0].Value().(uint32))
+	end := v9msg.SysUpTimeToTime(v9datrec.FieldValues[1].Value().(uint32))
+	if !start.Equal(exporttime.Add(-10*time.Second)) || !end.Equal(exporttime.Add(-2*time.Second)) {
+		t.Errorf(errorPrefixMarker+"Expected the most precise timestamps to be exported, got %s - %s", start, end)
+	}
+}
+
+func TestNetflowV9EncoderReject(t *testing.T) {
+	exporttime := time.Unix(1400000000, 0)
+	ipfixmsg := netflowV9EncoderTestMessage(t, exporttime)
+
+	enc := NewNetflowV9Encoder(exporttime.Add(-time.Hour))
+	enc.RejectUnrepresentable = true
+	_, report, err := enc.MarshalMessage(ipfixmsg)
+	if err == nil {
+		t.Fatalf(errorPrefixMarker + "Should have gotten error for unrepresentable fields")
+	}
+	if len(report) != 2 {
+		t.Errorf(errorPrefixMarker+"Expected 2 reported fields, got %v", report)
+	}
+
+	enc = NewNetflowV9Encoder(exporttime.Add(time.Hour))
+	if _, _, err = enc.MarshalMessage(ipfixmsg); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for export time before boot time")
+	}
+}
@@ -0,0 +1,381 @@
+package ipfix
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+
+PSAMP (RFC 5476) exports the configuration of the packet Selectors in options data: the Selector Report Interpretation
+has a selectorId and its selectorAlgorithm with the parameters of the algorithm (RFC 5477). The Selection Sequence Report
+Interpretation tells which Selectors make up a selectionSequenceId, which is the scope of the Packet Reports.
+
+PSAMPSelectors keeps the Selectors per Observation Domain, feed it every message of a session:
+
+	selectors := NewPSAMPSelectors()
+	ipfixmsg, err := session.UnmarshalMessage(data)
+	selectors.AddMessage(ipfixmsg)
+	...
+	for _, selector := range selectors.SequenceSelectors(ipfixmsg.ObservationDomainID, sequenceid) {
+		fraction, _ := selector.SelectedFraction()
+	}
+
+NewPacketReportTemplate and NewPacketReport build Packet Reports with a dataLinkFrameSection from raw frames.
+
+*/
+
+// The PSAMP Information Elements, RFC 5477
+const (
+	selectionSequenceIDElement         = 301
+	selectorIDElement                  = 302
+	selectorAlgorithmElement           = 304
+	samplingPacketIntervalElement      = 305
+	samplingPacketSpaceElement         = 306
+	samplingTimeIntervalElement        = 307
+	samplingTimeSpaceElement           = 308
+	samplingSizeElement                = 309
+	samplingPopulationElement          = 310
+	samplingProbabilityElement         = 311
+	dataLinkFrameSizeElement           = 312
+	dataLinkFrameSectionElement        = 315
+	selectorIDTotalPktsObservedElement = 318
+	selectorIDTotalPktsSelectedElement = 319
+	observationTimeMicrosecondsElement = 324
+	hashIPPayloadOffsetElement         = 327
+	hashIPPayloadSizeElement           = 328
+	hashOutputRangeMinElement          = 329
+	hashOutputRangeMaxElement          = 330
+	hashSelectedRangeMinElement        = 331
+	hashSelectedRangeMaxElement        = 332
+	hashDigestOutputElement            = 333
+	hashInitialiserValueElement        = 334
+	selectorNameElement                = 335
+)
+
+// SelectorAlgorithm is the value of selectorAlgorithm, from the PSAMP parameters registry
+type SelectorAlgorithm uint16
+
+// The values of selectorAlgorithm
+const (
+	SelectorSystematicCount      SelectorAlgorithm = 1 //Systematic count-based Sampling: samplingPacketInterval, samplingPacketSpace
+	SelectorSystematicTime       SelectorAlgorithm = 2 //Systematic time-based Sampling: samplingTimeInterval, samplingTimeSpace
+	SelectorRandomNOutOfN        SelectorAlgorithm = 3 //Random n-out-of-N Sampling: samplingSize, samplingPopulation
+	SelectorUniformProbabilistic SelectorAlgorithm = 4 //Uniform probabilistic Sampling: samplingProbability
+	SelectorPropertyMatch        SelectorAlgorithm = 5 //Property match Filtering
+	SelectorHashBOB              SelectorAlgorithm = 6 //Hash based Filtering using BOB
+	SelectorHashIPSX             SelectorAlgorithm = 7 //Hash based Filtering using IPSX
+	SelectorHashCRC              SelectorAlgorithm = 8 //Hash based Filtering using CRC
+)
+
+var selectorAlgorithmNames = [...]string{
+	"", "systematicCountBased", "systematicTimeBased", "randomNOutOfN", "uniformProbabilistic",
+	"propertyMatch", "hashBOB", "hashIPSX", "hashCRC",
+}
+
+// String returns the name of the algorithm
+func (algorithm SelectorAlgorithm) String() string {
+	if algorithm == 0 || algorithm > SelectorHashCRC {
+		return fmt.Sprintf("unassigned(%d)", uint16(algorithm))
+	}
+	return selectorAlgorithmNames[algorithm]
+}
+
+// IsHash returns whether the algorithm is one of the hash-based filters
+func (algorithm SelectorAlgorithm) IsHash() bool {
+	return algorithm >= SelectorHashBOB && algorithm <= SelectorHashCRC
+}
+
+// HashSelection holds the parameters of hash-based filtering, RFC 5475 section 6.2
+type HashSelection struct {
+	InitialiserValue uint64
+	IPPayloadOffset  uint64
+	IPPayloadSize    uint64
+	SelectedRangeMin uint64
+	SelectedRangeMax uint64
+	OutputRangeMin   uint64
+	OutputRangeMax   uint64
+	DigestOutput     bool
+}
+
+// Selector is a PSAMP Selector as described by a Selector Report Interpretation.
+// Only the parameters of its algorithm are set.
+type Selector struct {
+	ID        uint64
+	Algorithm SelectorAlgorithm
+	Name      string
+
+	PacketInterval uint32        //Systematic count-based: number of packets selected
+	PacketSpace    uint32        //Systematic count-based: number of packets skipped after them
+	TimeInterval   time.Duration //Systematic time-based: time during which packets are selected
+	TimeSpace      time.Duration //Systematic time-based: time during which packets are skipped
+	Size           uint32        //Random n-out-of-N: the n packets selected
+	Population     uint32        //Random n-out-of-N: out of N packets
+	Probability    float64       //Uniform probabilistic: probability a packet is selected
+	Hash           HashSelection //Hash-based filtering
+
+	PacketsObserved uint64 //From the Selector Statistics Report Interpretation, 0 if there was none
+	PacketsSelected uint64
+}
+
+// SelectedFraction returns the expected fraction of the packets that the Selector selects, or false if the algorithm or its parameters do not tell.
+// Property match filtering selects an unknown fraction, unless the statistics of the Selector are known.
+func (sel *Selector) SelectedFraction() (float64, bool) {
+	switch {
+	case sel.Algorithm == SelectorSystematicCount && sel.PacketInterval > 0:
+		return float64(sel.PacketInterval) / float64(sel.PacketInterval+sel.PacketSpace), true
+	case sel.Algorithm == SelectorSystematicTime && sel.TimeInterval > 0:
+		return float64(sel.TimeInterval) / float64(sel.TimeInterval+sel.TimeSpace), true
+	case sel.Algorithm == SelectorRandomNOutOfN && sel.Size > 0 && sel.Population >= sel.Size:
+		return float64(sel.Size) / float64(sel.Population), true
+	case sel.Algorithm == SelectorUniformProbabilistic && sel.Probability > 0 && sel.Probability <= 1:
+		return sel.Probability, true
+	case sel.Algorithm.IsHash() && sel.Hash.OutputRangeMax > sel.Hash.OutputRangeMin && sel.Hash.SelectedRangeMax >= sel.Hash.SelectedRangeMin:
+		return float64(sel.Hash.SelectedRangeMax-sel.Hash.SelectedRangeMin+1) / float64(sel.Hash.OutputRangeMax-sel.Hash.OutputRangeMin+1), true
+	case sel.PacketsObserved > 0 && sel.PacketsSelected <= sel.PacketsObserved:
+		return float64(sel.PacketsSelected) / float64(sel.PacketsObserved), true
+	}
+	return 0, false
+}
+
+// psampFields returns the IANA Field Values of a record by element id, the first one if an element occurs more than once
+func psampFields(datrec *DataRecord) (map[uint16]FieldValue, []uint64, error) {
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make(map[uint16]FieldValue, len(fsps))
+	selectorids := []uint64{}
+	for idx, fsp := range fsps {
+		if fsp.EnterpriseNumber != 0 {
+			continue
+		}
+		if fsp.InformationElementIdentifier == selectorIDElement {
+			if id, ok := unsignedValue(datrec.FieldValues[idx]); ok {
+				selectorids = append(selectorids, id)
+			}
+		}
+		if _, found := fields[fsp.InformationElementIdentifier]; !found {
+			fields[fsp.InformationElementIdentifier] = datrec.FieldValues[idx]
+		}
+	}
+	return fields, selectorids, nil
+}
+
+// NewSelector returns the Selector of a Selector Report Interpretation, an options record with selectorId and selectorAlgorithm
+func NewSelector(datrec *DataRecord) (*Selector, error) {
+	fields, _, err := psampFields(datrec)
+	if err != nil {
+		return nil, err
+	}
+	unsigned := func(elementid uint16) uint64 {
+		if fieldval, found := fields[elementid]; found {
+			value, _ := unsignedValue(fieldval)
+			return value
+		}
+		return 0
+	}
+	if fields[selectorIDElement] == nil || fields[selectorAlgorithmElement] == nil {
+		return nil, NewCategoryError(ErrInvalidValue, "Not a Selector Report Interpretation, it needs a selectorId and a selectorAlgorithm", ErrCritical).InTemplate(datrec.TemplateID)
+	}
+	sel := &Selector{
+		ID:        unsigned(selectorIDElement),
+		Algorithm: SelectorAlgorithm(unsigned(selectorAlgorithmElement)),
+	}
+	if name, found := fields[selectorNameElement]; found {
+		sel.Name, _ = name.Value().(string)
+	}
+	switch {
+	case sel.Algorithm == SelectorSystematicCount:
+		sel.PacketInterval = uint32(unsigned(samplingPacketIntervalElement))
+		sel.PacketSpace = uint32(unsigned(samplingPacketSpaceElement))
+	case sel.Algorithm == SelectorSystematicTime: //In microseconds
+		sel.TimeInterval = time.Duration(unsigned(samplingTimeIntervalElement)) * time.Microsecond
+		sel.TimeSpace = time.Duration(unsigned(samplingTimeSpaceElement)) * time.Microsecond
+	case sel.Algorithm == SelectorRandomNOutOfN:
+		sel.Size = uint32(unsigned(samplingSizeElement))
+		sel.Population = uint32(unsigned(samplingPopulationElement))
+	case sel.Algorithm == SelectorUniformProbabilistic:
+		if probability, found := fields[samplingProbabilityElement]; found {
+			sel.Probability, _ = probability.Value().(float64)
+		}
+	case sel.Algorithm.IsHash():
+		sel.Hash = HashSelection{
+			InitialiserValue: unsigned(hashInitialiserValueElement),
+			IPPayloadOffset:  unsigned(hashIPPayloadOffsetElement),
+			IPPayloadSize:    unsigned(hashIPPayloadSizeElement),
+			SelectedRangeMin: unsigned(hashSelectedRangeMinElement),
+			SelectedRangeMax: unsigned(hashSelectedRangeMaxElement),
+			OutputRangeMin:   unsigned(hashOutputRangeMinElement),
+			OutputRangeMax:   unsigned(hashOutputRangeMaxElement),
+		}
+		if digest, found := fields[hashDigestOutputElement]; found {
+			sel.Hash.DigestOutput, _ = digest.Value().(bool)
+		}
+	}
+	return sel, nil
+}
+
+// PSAMPSelectors keeps the Selectors and Selection Sequences that the options data of each Observation Domain describes
+type PSAMPSelectors struct {
+	selectors map[uint32]map[uint64]*Selector //By Observation Domain ID and selectorId
+	sequences map[uint32]map[uint64][]uint64  //The selectorIds by Observation Domain ID and selectionSequenceId
+
+	sync.Mutex
+}
+
+// NewPSAMPSelectors returns an empty list of Selectors
+func NewPSAMPSelectors() *PSAMPSelectors {
+	return &PSAMPSelectors{
+		selectors: make(map[uint32]map[uint64]*Selector),
+		sequences: make(map[uint32]map[uint64][]uint64),
+	}
+}
+
+// AddMessage adds the Selectors, the Selector statistics and the Selection Sequences in the options data of the message.
+// Other records are ignored. A Selector that is reported again replaces the earlier one, but keeps its statistics.
+func (ps *PSAMPSelectors) AddMessage(ipfixmsg *Message) error {
+	var err error
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok {
+				continue
+			}
+			if suberr := ps.AddRecord(ipfixmsg.ObservationDomainID, datrec); suberr != nil {
+				err = stackError(err, "Sub errors adding PSAMP options data.", suberr, 0)
+			}
+		}
+	}
+	return err
+}
+
+// AddRecord adds a Selector Report, Selector Statistics Report or Selection Sequence Report of the Observation Domain.
+// Records that are none of these are ignored.
+func (ps *PSAMPSelectors) AddRecord(observationdomainid uint32, datrec *DataRecord) error {
+	fields, selectorids, err := psampFields(datrec)
+	if err != nil {
+		return err
+	}
+	if len(selectorids) == 0 {
+		return nil
+	}
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.selectors[observationdomainid] == nil {
+		ps.selectors[observationdomainid] = make(map[uint64]*Selector)
+		ps.sequences[observationdomainid] = make(map[uint64][]uint64)
+	}
+	selectors := ps.selectors[observationdomainid]
+
+	switch {
+	case fields[selectorAlgorithmElement] != nil:
+		sel, err := NewSelector(datrec)
+		if err != nil {
+			return err
+		}
+		if old, found := selectors[sel.ID]; found {
+			sel.PacketsObserved, sel.PacketsSelected = old.PacketsObserved, old.PacketsSelected
+		}
+		selectors[sel.ID] = sel
+	case fields[selectionSequenceIDElement] != nil:
+		sequenceid, _ := unsignedValue(fields[selectionSequenceIDElement])
+		ps.sequences[observationdomainid][sequenceid] = selectorids
+	case fields[selectorIDTotalPktsObservedElement] != nil || fields[selectorIDTotalPktsSelectedElement] != nil:
+		sel := &Selector{ID: selectorids[0]}
+		if old, found := selectors[sel.ID]; found {
+			*sel = *old //The Selectors are replaced, not changed, as they may be in use
+		}
+		selectors[sel.ID] = sel
+		if observed, found := fields[selectorIDTotalPktsObservedElement]; found {
+			sel.PacketsObserved, _ = unsignedValue(observed)
+		}
+		if selected, found := fields[selectorIDTotalPktsSelectedElement]; found {
+			sel.PacketsSelected, _ = unsignedValue(selected)
+		}
+	}
+	return nil
+}
+
+// Selector returns a copy of the Selector of the Observation Domain with the selectorId
+func (ps *PSAMPSelectors) Selector(observationdomainid uint32, selectorid uint64) (*Selector, bool) {
+	ps.Lock()
+	defer ps.Unlock()
+	sel, found := ps.selectors[observationdomainid][selectorid]
+	if !found {
+		return nil, false
+	}
+	selcopy := *sel
+	return &selcopy, true
+}
+
+// SequenceSelectors returns copies of the known Selectors of a Selection Sequence of the Observation Domain, in the order they are applied
+func (ps *PSAMPSelectors) SequenceSelectors(observationdomainid uint32, selectionsequenceid uint64) []*Selector {
+	ps.Lock()
+	defer ps.Unlock()
+	selectors := []*Selector{}
+	for _, id := range ps.sequences[observationdomainid][selectionsequenceid] {
+		if sel, found := ps.selectors[observationdomainid][id]; found {
+			selcopy := *sel
+			selectors = append(selectors, &selcopy)
+		}
+	}
+	return selectors
+}
+
+// NewPacketReportTemplate returns the template of the Packet Reports of NewPacketReport:
+// selectionSequenceId, observationTimeMicroseconds, dataLinkFrameSize and a variable-length dataLinkFrameSection
+func NewPacketReportTemplate(templateid uint16) (*TemplateRecord, error) {
+	tmplrec, err := NewTemplateRecord(templateid)
+	if err != nil {
+		return nil, err
+	}
+	for _, element := range [][2]uint16{
+		{selectionSequenceIDElement, 8},
+		{observationTimeMicrosecondsElement, 8},
+		{dataLinkFrameSizeElement, 2},
+		{dataLinkFrameSectionElement, VariableLength},
+	} {
+		fsp, _ := NewFieldSpecifier(0, element[0], element[1])
+		tmplrec.AddSpecifier(fsp)
+	}
+	return tmplrec, nil
+}
+
+// maxFrameSection is the largest dataLinkFrameSection that fits in a message with a Packet Report
+const maxFrameSection = maxMessageSize - ipfixMessageHeaderLength - ipfixSetHeaderLength - 8 - 8 - 2 - 3
+
+// NewPacketReport returns a Packet Report of a data link frame observed at the given time, of the template of NewPacketReportTemplate.
+// At most maxsection octets of the start of the frame are reported, 0 reports as much as fits in a message.
+// The frame is referenced, not copied.
+func NewPacketReport(templates *ActiveTemplates, templateid uint16, selectionsequenceid uint64, observed time.Time, frame []byte, maxsection int) (*DataRecord, error) {
+	if len(frame) > 65535 {
+		return nil, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Frame of %d octets is larger than dataLinkFrameSize can tell", len(frame)), ErrCritical).InTemplate(templateid)
+	}
+	if maxsection <= 0 || maxsection > maxFrameSection {
+		maxsection = maxFrameSection
+	}
+	section := frame
+	if len(section) > maxsection {
+		section = section[:maxsection]
+	}
+	datrec, err := NewDataRecord(templateid, templates)
+	if err != nil {
+		return nil, err
+	}
+	for _, fieldval := range []FieldValue{
+		&FieldValueUnsigned64{value: selectionsequenceid},
+		&FieldValueDateTimeMicroseconds{value: observed},
+		&FieldValueUnsigned16{value: uint16(len(frame))},
+		&FieldValueOctetArray{value: section},
+	} {
+		if err := datrec.AddFieldValue(fieldval); err != nil {
+			return nil, err
+		}
+	}
+	return datrec, nil
+}
@@ -0,0 +1,207 @@
+package ipfix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+const (
+	psampTestPrint = false
+)
+
+func TestPSAMPMarker(t *testing.T) {
+	if psampTestPrint {
+		fmt.Printf(testMarkerString, "PSAMP")
+	}
+}
+
+// psampTestField is a field of an options record, the first field of a record is its scope
+type psampTestField struct {
+	element uint16
+	value   FieldValue
+}
+
+// psampTestRecord sets an options template for the fields and returns a record of it
+func psampTestRecord(templates *ActiveTemplates, templateid uint16, fields ...psampTestField) *DataRecord {
+	tmplrec, _ := NewOptionsTemplateRecord(templateid)
+	datrec, _ := NewDataRecord(templateid, templates)
+	for idx, field := range fields {
+		fsp, _ := NewFieldSpecifier(0, field.element, field.value.Len())
+		if idx == 0 {
+			tmplrec.AddScopeSpecifier(fsp)
+		} else {
+			tmplrec.AddSpecifier(fsp)
+		}
+		datrec.FieldValues = append(datrec.FieldValues, field.value)
+	}
+	templates.Set(templateid, tmplrec)
+	return datrec
+}
+
+// psampTestMessages returns the options data of a Selection Sequence of a systematic count-based and a hash-based Selector,
+// and a random n-out-of-N Selector with statistics
+func psampTestMessages(t *testing.T, odid uint32) []*Message {
+	templates := NewActiveTemplateList()
+	builder := NewMessageBuilder(odid, templates, 0)
+	for _, datrec := range []*DataRecord{
+		psampTestRecord(templates, 300,
+			psampTestField{302, &FieldValueUnsigned64{value: 1}},
+			psampTestField{304, &FieldValueUnsigned16{value: uint16(SelectorSystematicCount)}},
+			psampTestField{305, &FieldValueUnsigned32{value: 1}},
+			psampTestField{306, &FieldValueUnsigned32{value: 99}},
+			psampTestField{335, &FieldValueString{value: "count"}}),
+		psampTestRecord(templates, 301,
+			psampTestField{302, &FieldValueUnsigned64{value: 2}},
+			psampTestField{304, &FieldValueUnsigned16{value: uint16(SelectorRandomNOutOfN)}},
+			psampTestField{309, &FieldValueUnsigned32{value: 1}},
+			psampTestField{310, &FieldValueUnsigned32{value: 1000}}),
+		psampTestRecord(templates, 302,
+			psampTestField{302, &FieldValueUnsigned64{value: 3}},
+			psampTestField{304, &FieldValueUnsigned16{value: uint16(SelectorHashCRC)}},
+			psampTestField{329, &FieldValueUnsigned64{value: 0}},
+			psampTestField{330, &FieldValueUnsigned64{value: 65535}},
+			psampTestField{331, &FieldValueUnsigned64{value: 0}},
+			psampTestField{332, &FieldValueUnsigned64{value: 16383}},
+			psampTestField{333, &FieldValueBoolean{value: true}}),
+		psampTestRecord(templates, 303,
+			psampTestField{301, &FieldValueUnsigned64{value: 7}},
+			psampTestField{138, &FieldValueUnsigned64{value: 1}}, //observationPointId
+			psampTestField{302, &FieldValueUnsigned64{value: 1}},
+			psampTestField{302, &FieldValueUnsigned64{value: 3}}),
+		psampTestRecord(templates, 304,
+			psampTestField{302, &FieldValueUnsigned64{value: 2}},
+			psampTestField{318, &FieldValueUnsigned64{value: 10000}},
+			psampTestField{319, &FieldValueUnsigned64{value: 12}}),
+	} {
+		if err := builder.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding options record: %v", err)
+		}
+	}
+	return messageBuilderTestDecode(t, builder.Flush(), maxMessageSize)
+}
+
+func TestPSAMPSelectors(t *testing.T) {
+	selectors := NewPSAMPSelectors()
+	for _, ipfixmsg := range psampTestMessages(t, 5) {
+		if err := selectors.AddMessage(ipfixmsg); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding message: %v", err)
+		}
+	}
+
+	count, found := selectors.Selector(5, 1)
+	if !found || count.Algorithm != SelectorSystematicCount || count.PacketInterval != 1 || count.PacketSpace != 99 || count.Name != "count" {
+		t.Errorf(errorPrefixMarker+"Wrong systematic count-based selector %+v", count)
+	}
+	random, _ := selectors.Selector(5, 2)
+	if random == nil || random.Size != 1 || random.Population != 1000 || random.PacketsObserved != 10000 || random.PacketsSelected != 12 {
+		t.Errorf(errorPrefixMarker+"Wrong random n-out-of-N selector %+v", random)
+	}
+	hash, _ := selectors.Selector(5, 3)
+	if hash == nil || !hash.Algorithm.IsHash() || hash.Hash.SelectedRangeMax != 16383 || !hash.Hash.DigestOutput {
+		t.Errorf(errorPrefixMarker+"Wrong hash-based selector %+v", hash)
+	}
+	if _, found := selectors.Selector(6, 1); found {
+		t.Errorf(errorPrefixMarker + "Selectors should be kept per observation domain")
+	}
+
+	sequence := selectors.SequenceSelectors(5, 7)
+	if len(sequence) != 2 || sequence[0].ID != 1 || sequence[1].ID != 3 {
+		t.Fatalf(errorPrefixMarker+"Expected selectors 1 and 3 in sequence 7, but got %v", sequence)
+	}
+	for _, test := range []struct {
+		selector *Selector
+		fraction float64
+	}{
+		{count, 0.01},
+		{random, 0.001},
+		{hash, 0.25},
+		{&Selector{Algorithm: SelectorSystematicTime, TimeInterval: time.Millisecond, TimeSpace: 9 * time.Millisecond}, 0.1},
+		{&Selector{Algorithm: SelectorUniformProbabilistic, Probability: 0.5}, 0.5},
+		{&Selector{Algorithm: SelectorPropertyMatch, PacketsObserved: 200, PacketsSelected: 50}, 0.25},
+	} {
+		if fraction, ok := test.selector.SelectedFraction(); !ok || math.Abs(fraction-test.fraction) > 1e-9 {
+			t.Errorf(errorPrefixMarker+"Selector %s: expected fraction %f, but got %f (%v)", test.selector.Algorithm, test.fraction, fraction, ok)
+		}
+	}
+	if _, ok := (&Selector{Algorithm: SelectorPropertyMatch}).SelectedFraction(); ok {
+		t.Errorf(errorPrefixMarker + "Property match filtering without statistics has no known fraction")
+	}
+	if SelectorHashBOB.String() != "hashBOB" || SelectorAlgorithm(42).String() != "unassigned(42)" {
+		t.Errorf(errorPrefixMarker+"Wrong algorithm names %s and %s", SelectorHashBOB, SelectorAlgorithm(42))
+	}
+
+	templates := NewActiveTemplateList()
+	notselector := psampTestRecord(templates, 305, psampTestField{302, &FieldValueUnsigned64{value: 1}})
+	if _, err := NewSelector(notselector); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a record without algorithm to fail, but got %v", err)
+	}
+}
+
+func TestPSAMPSelectorsConcurrent(t *testing.T) {
+	selectors := NewPSAMPSelectors()
+	messages := psampTestMessages(t, 5)
+	for _, ipfixmsg := range messages {
+		selectors.AddMessage(ipfixmsg)
+	}
+	random, _ := selectors.Selector(5, 2)
+	done := make(chan bool)
+	go func() {
+		for range 100 {
+			selectors.AddMessage(messages[len(messages)-1])
+		}
+		done <- true
+	}()
+	for range 100 {
+		sel, _ := selectors.Selector(5, 2)
+		sel.SelectedFraction()
+		for _, sel := range selectors.SequenceSelectors(5, 7) {
+			sel.SelectedFraction()
+		}
+	}
+	<-done
+	random.PacketsSelected = 0
+	if sel, _ := selectors.Selector(5, 2); sel.PacketsSelected != 12 {
+		t.Errorf(errorPrefixMarker+"Changing a returned Selector should not change the kept one, but got %+v", sel)
+	}
+}
+
+func TestPSAMPPacketReport(t *testing.T) {
+	templates := NewActiveTemplateList()
+	tmplrec, err := NewPacketReportTemplate(400)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating template: %v", err)
+	}
+	templates.Set(400, tmplrec)
+	frame := bytes.Repeat([]byte{0xab}, 1514)
+	observed := time.Date(2026, 1, 1, 12, 0, 0, 123456000, time.UTC)
+
+	datrec, err := NewPacketReport(templates, 400, 7, observed, frame, 128)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error creating packet report: %v", err)
+	}
+	builder := NewMessageBuilder(1, templates, 0)
+	builder.AddRecord(datrec)
+	decoded := messageBuilderTestDecode(t, builder.Flush(), maxMessageSize)
+	report := (*decoded[0].Sets[1].Records[0]).(*DataRecord)
+	if report.FieldValues[0].Value() != uint64(7) || report.FieldValues[2].Value() != uint16(1514) {
+		t.Errorf(errorPrefixMarker+"Wrong sequence id or frame size in %v", report)
+	}
+	if section, _ := report.FieldValues[3].Value().([]byte); !bytes.Equal(section, frame[:128]) {
+		t.Errorf(errorPrefixMarker+"Expected a section of 128 octets, but got %d", len(section))
+	}
+	if when, _ := report.FieldValues[1].Value().(time.Time); when.Sub(observed).Abs() > time.Microsecond {
+		t.Errorf(errorPrefixMarker+"Expected observation time %v, but got %v", observed, when)
+	}
+
+	datrec, _ = NewPacketReport(templates, 400, 7, observed, frame[:60], 0)
+	if section, _ := datrec.FieldValues[3].Value().([]byte); len(section) != 60 {
+		t.Errorf(errorPrefixMarker+"Expected the whole frame of 60 octets, but got %d", len(section))
+	}
+	if _, err := NewPacketReport(templates, 400, 7, observed, make([]byte, 70000), 0); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected a frame that is too large to fail, but got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package ipfix
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const (
+	readonlyTestPrint = false
+)
+
+func TestReadOnlyMarker(t *testing.T) {
+	if readonlyTestPrint {
+		fmt.Printf(testMarkerString, "Read-only decoding")
+	}
+}
+
+// TestReadOnlyConcurrentDecoding decodes the same buffers from many goroutines at once. Run it with -race.
+func TestReadOnlyConcurrentDecoding(t *testing.T) {
+	tmplset := []byte{0, 2, 0, 20,
+		1, 0, 0, 2, //Template 256, 2 fields
+		0x80, 20, 0xff, 0xff, 0, 0, 0xaf, 0x71, //Enterprise specific, variable length
+		1, 35, 0xff, 0xff, //basicList
+	}
+	datset := []byte{1, 0, 0, 23,
+		3, 'w', 'w', 'w', //Enterprise specific field
+		13, 3, 0x80, 21, 0xff, 0xff, 0, 0, 0xaf, 0x71, 3, 'a', 'b', 'c', //basicList with enterprise specific elements
+		0, //Padding
+	}
+	buffers := [][]byte{
+		sessionTestMessage(1, tmplset, datset),
+		sessionTestMessage(1, fuzzTestTemplateSet, fuzzTestOptionsTemplateSet, fuzzTestDataSet, fuzzTestOptionsSet),
+	}
+	originals := make([][]byte, 0, len(buffers))
+	for _, buf := range buffers {
+		originals = append(originals, append([]byte{}, buf...))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for cnt := 0; cnt < 16; cnt++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for iter := 0; iter < 50; iter++ {
+				for _, buf := range buffers {
+					ipfixmsg, _ := NewMessage()
+					ipfixmsg.AssociatedTemplates = NewActiveTemplateList()
+					if err := ipfixmsg.UnmarshalBinary(buf); err != nil {
+						errs <- err
+						return
+					}
+				}
+				if err := (&FieldSpecifier{}).UnmarshalBinary(tmplset[8:16]); err != nil {
+					errs <- err
+					return
+				}
+				if err := (&FieldValueBasicList{}).UnmarshalBinary(datset[9:22]); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf(errorPrefixMarker+"Error decoding shared buffer: %v", err)
+	}
+	for idx, buf := range buffers {
+		if !bytes.Equal(buf, originals[idx]) {
+			t.Errorf(errorPrefixMarker+"Decoding changed the input buffer.\nBefore %v\nafter  %v", originals[idx], buf)
+		}
+	}
+}
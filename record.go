@@ -8,6 +8,7 @@ package ipfix
 */
 
 // Record defines the interface for IPFIX Set Records
+// Implementing the encoding/BinaryAppender interface as well is optional, but saves allocations when marshalling
 type Record interface {
 	Len() uint16                    // The size in Octets of this record, when Marshalled
 	String() string                 // Return a string representation
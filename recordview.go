@@ -0,0 +1,308 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"time"
+)
+
+/*
+
+Decoding a Data Record into FieldValues allocates a value for every field, which dominates the CPU time of a busy collector.
+A RecordView does not decode anything up front: it keeps a reference to the raw record and uses the field offsets of the template,
+which are computed once per template in a TemplateLayout. A field is only decoded when it is accessed, and the typed accessors
+return plain Go values instead of boxing them in an interface{}.
+
+The view references the buffer it was created from, so that buffer must not be reused while the view is in use.
+
+	layout, err := session.AssociatedTemplates.Layout(setid)
+	for cursor := 4; len(setdata)-cursor >= layout.MinLength(); {
+		view, err := layout.View(setdata[cursor:])
+		if err != nil { //Also for templates without fields, whose records have length 0
+			break
+		}
+		octets, _ := view.Uint64(1) //octetDeltaCount
+		srcaddr, _ := view.Addr(8)  //sourceIPv4Address
+		cursor += view.Len()
+	}
+
+*/
+
+// timeKind tells how a field holds a time, derived from the type of its Information Element
+type timeKind uint8
+
+const (
+	timeNone timeKind = iota
+	timeSeconds
+	timeMilliseconds
+	timeNTP //dateTimeMicroseconds and dateTimeNanoseconds share the NTP encoding
+)
+
+// layoutField is a field of a TemplateLayout
+type layoutField struct {
+	enterprise uint32
+	element    uint16
+	length     uint16 //Field length from the template, VariableLength for variable-length fields
+	offset     int    //Offset in the record, -1 if the field follows a variable-length field
+	time       timeKind
+}
+
+// TemplateLayout holds the field offsets of a template, so records can be viewed without decoding them.
+// A layout must be recomputed when the template is replaced; ActiveTemplates.Layout takes care of that.
+type TemplateLayout struct {
+	TemplateID uint16
+
+	fields        []layoutField
+	firstVariable int    //Index of the first variable-length field, the number of fields if there is none
+	minLength     int    //Length of the shortest possible record
+	version       uint64 //Version of the custom elements when it was computed
+}
+
+// NewTemplateLayout computes the layout of the records described by tmplrec
+func NewTemplateLayout(tmplrec *TemplateRecord) (*TemplateLayout, error) {
+	if tmplrec == nil {
+		return nil, NewCategoryError(ErrInvalidValue, "Got nil pointer to template", ErrCritical)
+	}
+	fsps := tmplrec.allFieldSpecifiers()
+	layout := &TemplateLayout{
+		TemplateID:    tmplrec.TemplateID,
+		fields:        make([]layoutField, 0, len(fsps)),
+		firstVariable: len(fsps),
+		version:       customMapVersion.Load(),
+	}
+	offset := 0
+	for idx, fsp := range fsps {
+		field := layoutField{
+			enterprise: fsp.EnterpriseNumber,
+			element:    fsp.InformationElementIdentifier,
+			length:     fsp.FieldLength,
+			offset:     offset,
+		}
+		if fieldval, err := NewFieldValueByID(fsp.EnterpriseNumber, fsp.InformationElementIdentifier); err == nil {
+			switch fieldval.(type) {
+			case *FieldValueDateTimeSeconds:
+				field.time = timeSeconds
+			case *FieldValueDateTimeMilliseconds:
+				field.time = timeMilliseconds
+			case *FieldValueDateTimeMicroseconds, *FieldValueDateTimeNanoseconds:
+				field.time = timeNTP
+			}
+		}
+		if fsp.FieldLength == VariableLength {
+			if layout.firstVariable == len(fsps) {
+				layout.firstVariable = idx
+			}
+			layout.minLength++ //A variable-length field takes at least its length octet
+		} else {
+			layout.minLength += int(fsp.FieldLength)
+		}
+		if offset >= 0 && fsp.FieldLength != VariableLength {
+			offset += int(fsp.FieldLength)
+		} else {
+			offset = -1
+		}
+		layout.fields = append(layout.fields, field)
+	}
+	return layout, nil
+}
+
+// MinLength returns the length of the shortest record of the layout. Anything shorter at the end of a set is padding.
+func (layout *TemplateLayout) MinLength() int {
+	return layout.minLength
+}
+
+// View returns a view on the record at the start of data, which must hold the complete record. The view references data, it is not copied.
+func (layout *TemplateLayout) View(data []byte) (RecordView, error) {
+	if layout.minLength == 0 { //A loop over the records of a set would never end
+		return RecordView{}, NewCategoryError(ErrMalformedLength, "Can not view records of length 0", ErrCritical).InTemplate(layout.TemplateID)
+	}
+	cursor := 0
+	if layout.firstVariable < len(layout.fields) {
+		cursor = layout.fields[layout.firstVariable].offset
+	} else {
+		cursor = layout.minLength
+	}
+	if cursor > len(data) {
+		return RecordView{}, NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data to view record. Needed %d, but have %d", cursor, len(data)), ErrCritical).AtOffset(0).InTemplate(layout.TemplateID)
+	}
+	for idx := layout.firstVariable; idx < len(layout.fields); idx++ {
+		_, end, err := layout.fieldBounds(data, cursor, idx)
+		if err != nil {
+			return RecordView{}, err
+		}
+		cursor = end
+	}
+	return RecordView{layout: layout, data: data[:cursor]}, nil
+}
+
+// fieldBounds returns where the value of field idx, which starts at cursor, begins and ends
+func (layout *TemplateLayout) fieldBounds(data []byte, cursor, idx int) (int, int, error) {
+	field := layout.fields[idx]
+	if field.length != VariableLength {
+		if cursor+int(field.length) > len(data) {
+			return 0, 0, NewCategoryError(ErrInsufficientData, fmt.Sprintf("Insufficient data to view record. Needed %d, but have %d", field.length, len(data)-cursor), ErrCritical).AtOffset(cursor).InTemplate(layout.TemplateID).AtField(idx)
+		}
+		return cursor, cursor + int(field.length), nil
+	}
+	if cursor >= len(data) {
+		return 0, 0, NewCategoryError(ErrInsufficientData, "Insufficient data to decode variable length", ErrCritical).AtOffset(cursor).InTemplate(layout.TemplateID).AtField(idx)
+	}
+	length, shift := int(data[cursor]), 1
+	if length == 255 {
+		if cursor+3 > len(data) {
+			return 0, 0, NewCategoryError(ErrInsufficientData, "Insufficient data to decode variable length", ErrCritical).AtOffset(cursor).InTemplate(layout.TemplateID).AtField(idx)
+		}
+		length, shift = int(binary.BigEndian.Uint16(data[cursor+1:cursor+3])), 3
+	}
+	if cursor+shift+length > len(data) {
+		return 0, 0, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Insufficient data to view record. Needed %d, but have %d", shift+length, len(data)-cursor), ErrCritical).AtOffset(cursor).InTemplate(layout.TemplateID).AtField(idx)
+	}
+	return cursor + shift, cursor + shift + length, nil
+}
+
+// RecordView gives access to the fields of a record without decoding the whole record.
+// Fields are looked up by Information Element; if the template holds an element more than once, the first one is used.
+// The accessors look up IANA elements, use Enterprise for enterprise-specific ones.
+// They return false if the field is not in the record or its length does not fit the requested type.
+type RecordView struct {
+	layout     *TemplateLayout
+	data       []byte
+	enterprise uint32
+}
+
+// Len returns the number of octets the record takes on the wire
+func (view RecordView) Len() int {
+	return len(view.data)
+}
+
+// TemplateID returns the id of the template that describes the record
+func (view RecordView) TemplateID() uint16 {
+	if view.layout == nil {
+		return 0
+	}
+	return view.layout.TemplateID
+}
+
+// Enterprise returns a view on the same record whose accessors look up the elements of the given enterprise number
+func (view RecordView) Enterprise(enterpriseid uint32) RecordView {
+	view.enterprise = enterpriseid
+	return view
+}
+
+// field returns the value of the element and how it holds a time
+func (view RecordView) field(element uint16) ([]byte, timeKind, bool) {
+	if view.layout == nil {
+		return nil, timeNone, false
+	}
+	for idx, field := range view.layout.fields {
+		if field.element != element || field.enterprise != view.enterprise {
+			continue
+		}
+		if field.offset >= 0 && field.length != VariableLength {
+			return view.data[field.offset : field.offset+int(field.length)], field.time, true
+		}
+		cursor := view.layout.fields[view.layout.firstVariable].offset
+		for varidx := view.layout.firstVariable; ; varidx++ {
+			start, end, err := view.layout.fieldBounds(view.data, cursor, varidx)
+			if err != nil {
+				return nil, timeNone, false //Can not happen, the record was checked by View
+			}
+			if varidx == idx {
+				return view.data[start:end], field.time, true
+			}
+			cursor = end
+		}
+	}
+	return nil, timeNone, false
+}
+
+// Bytes returns the raw value of the element. It references the record, so it must not be modified.
+func (view RecordView) Bytes(element uint16) ([]byte, bool) {
+	value, _, found := view.field(element)
+	return value, found
+}
+
+// String returns the value of the element as a string. This allocates.
+func (view RecordView) String(element uint16) (string, bool) {
+	value, _, found := view.field(element)
+	return string(value), found
+}
+
+// Uint64 returns the value of an unsigned integer element of up to 8 octets, including reduced-size encodings
+func (view RecordView) Uint64(element uint16) (uint64, bool) {
+	value, _, found := view.field(element)
+	if !found || len(value) == 0 || len(value) > 8 {
+		return 0, false
+	}
+	ret := uint64(0)
+	for _, octet := range value {
+		ret = ret<<8 | uint64(octet)
+	}
+	return ret, true
+}
+
+// Int64 returns the value of a signed integer element of up to 8 octets, including reduced-size encodings
+func (view RecordView) Int64(element uint16) (int64, bool) {
+	value, _, found := view.field(element)
+	if !found || len(value) == 0 || len(value) > 8 {
+		return 0, false
+	}
+	ret := int64(int8(value[0])) //Sign extended
+	for _, octet := range value[1:] {
+		ret = ret<<8 | int64(octet)
+	}
+	return ret, true
+}
+
+// Float64 returns the value of a float32 or float64 element
+func (view RecordView) Float64(element uint16) (float64, bool) {
+	value, _, found := view.field(element)
+	switch {
+	case found && len(value) == 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(value))), true
+	case found && len(value) == 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), true
+	}
+	return 0, false
+}
+
+// Bool returns the value of a boolean element
+func (view RecordView) Bool(element uint16) (bool, bool) {
+	value, _, found := view.field(element)
+	if !found || len(value) != 1 || (value[0] != 1 && value[0] != 2) {
+		return false, false
+	}
+	return value[0] == 1, true
+}
+
+// Addr returns the value of an IPv4 or IPv6 address element
+func (view RecordView) Addr(element uint16) (netip.Addr, bool) {
+	value, _, found := view.field(element)
+	switch {
+	case found && len(value) == 4:
+		return netip.AddrFrom4([4]byte(value)), true
+	case found && len(value) == 16:
+		return netip.AddrFrom16([16]byte(value)), true
+	}
+	return netip.Addr{}, false
+}
+
+// Time returns the value of a dateTimeSeconds, dateTimeMilliseconds, dateTimeMicroseconds or dateTimeNanoseconds element
+func (view RecordView) Time(element uint16) (time.Time, bool) {
+	value, kind, found := view.field(element)
+	if !found {
+		return time.Time{}, false
+	}
+	switch {
+	case kind == timeSeconds && len(value) == 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(value)), 0), true
+	case kind == timeMilliseconds && len(value) == 8:
+		milliseconds := binary.BigEndian.Uint64(value)
+		return time.Unix(int64(milliseconds/1000), int64(milliseconds%1000)*int64(time.Millisecond)), true
+	case kind == timeNTP && len(value) == 8:
+		return ntpTime(value), true
+	}
+	return time.Time{}, false
+}
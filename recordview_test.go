@@ -0,0 +1,152 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+const (
+	recordviewTestPrint = false
+)
+
+func TestRecordViewMarker(t *testing.T) {
+	if recordviewTestPrint {
+		fmt.Printf(testMarkerString, "Record View")
+	}
+}
+
+var (
+	recordViewTestTemplateSet = []byte{0, 2, 0, 40,
+		1, 0, 0, 7, //Template 256, 7 fields
+		0, 8, 0, 4, //sourceIPv4Address
+		0, 27, 0, 16, //sourceIPv6Address
+		0, 1, 0, 4, //octetDeltaCount, reduced size
+		0, 82, 0xff, 0xff, //interfaceName
+		0x80, 20, 0xff, 0xff, 0, 0, 0xaf, 0x71, //Enterprise specific, variable length
+		0, 152, 0, 8, //flowStartMilliseconds
+		0, 7, 0, 2, //sourceTransportPort
+	}
+	recordViewTestRecord = []byte{
+		10, 0, 0, 1, //sourceIPv4Address
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, //sourceIPv6Address
+		0, 0, 5, 220, //octetDeltaCount
+		4, 'e', 't', 'h', '0', //interfaceName
+		255, 0, 3, 'a', 'b', 'c', //Enterprise specific, with a three octet length
+		0, 0, 1, 67, 162, 116, 204, 123, //flowStartMilliseconds
+		0, 80, //sourceTransportPort
+	}
+)
+
+// recordViewTestLayout returns the layout of the template in recordViewTestTemplateSet
+func recordViewTestLayout(t testing.TB) (*ActiveTemplates, *TemplateLayout) {
+	templates := NewActiveTemplateList()
+	tmplset := &Set{}
+	if err := tmplset.UnmarshalBinary(recordViewTestTemplateSet); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling template set: %v", err)
+	}
+	tmplrec := (*tmplset.Records[0]).(*TemplateRecord)
+	templates.Set(tmplrec.TemplateID, tmplrec)
+	layout, err := templates.Layout(tmplrec.TemplateID)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error getting layout: %v", err)
+	}
+	return templates, layout
+}
+
+func TestRecordView(t *testing.T) {
+	_, layout := recordViewTestLayout(t)
+	if layout.MinLength() != 4+16+4+1+1+8+2 {
+		t.Errorf(errorPrefixMarker+"Wrong minimal length %d", layout.MinLength())
+	}
+	data := append(append([]byte{}, recordViewTestRecord...), 0, 0, 0) //The next record or padding follows
+	view, err := layout.View(data)
+	if err != nil {
+		t.Fatalf(errorPrefixMarker+"Error viewing record: %v", err)
+	}
+	if view.Len() != len(recordViewTestRecord) || view.TemplateID() != 256 {
+		t.Errorf(errorPrefixMarker+"Expected length %d and template 256, but got %d and %d", len(recordViewTestRecord), view.Len(), view.TemplateID())
+	}
+
+	if addr, ok := view.Addr(8); !ok || addr != netip.MustParseAddr("10.0.0.1") {
+		t.Errorf(errorPrefixMarker+"Wrong IPv4 address %v", addr)
+	}
+	if addr, ok := view.Addr(27); !ok || addr != netip.MustParseAddr("::1") {
+		t.Errorf(errorPrefixMarker+"Wrong IPv6 address %v", addr)
+	}
+	if octets, ok := view.Uint64(1); !ok || octets != 1500 {
+		t.Errorf(errorPrefixMarker+"Wrong octet count %d", octets)
+	}
+	if name, ok := view.String(82); !ok || name != "eth0" {
+		t.Errorf(errorPrefixMarker+"Wrong interface name %q", name)
+	}
+	if value, ok := view.Enterprise(44913).Bytes(20); !ok || string(value) != "abc" {
+		t.Errorf(errorPrefixMarker+"Wrong enterprise specific value %q", value)
+	}
+	if start, ok := view.Time(152); !ok || !start.Equal(time.UnixMilli(1390000000123)) {
+		t.Errorf(errorPrefixMarker+"Wrong flow start %s", start)
+	}
+	if port, ok := view.Uint64(7); !ok || port != 80 {
+		t.Errorf(errorPrefixMarker+"Wrong port %d", port)
+	}
+	if _, ok := view.Uint64(12); ok {
+		t.Errorf(errorPrefixMarker + "Found an element that is not in the template")
+	}
+	if _, ok := view.Uint64(27); ok {
+		t.Errorf(errorPrefixMarker + "An IPv6 address does not fit in an unsigned64")
+	}
+	if _, ok := view.Time(1); ok {
+		t.Errorf(errorPrefixMarker + "octetDeltaCount is not a time")
+	}
+	if _, ok := view.Bytes(20); ok {
+		t.Errorf(errorPrefixMarker + "Enterprise specific element should not be found as IANA element")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		view, _ := layout.View(data)
+		view.Uint64(1)
+		view.Addr(8)
+		view.Uint64(7)
+		view.Time(152)
+	})
+	if allocs != 0 {
+		t.Errorf(errorPrefixMarker+"Viewing a record should not allocate, but got %.1f allocations", allocs)
+	}
+}
+
+func TestRecordViewInvalid(t *testing.T) {
+	_, layout := recordViewTestLayout(t)
+	for _, cut := range []int{0, 10, 28, 33, 38} {
+		if _, err := layout.View(recordViewTestRecord[:cut]); !errors.Is(err, ErrInsufficientData) && !errors.Is(err, ErrMalformedLength) {
+			t.Errorf(errorPrefixMarker+"Expected an error viewing %d octets, but got %v", cut, err)
+		}
+	}
+	if _, ok := (RecordView{}).Uint64(1); ok {
+		t.Errorf(errorPrefixMarker + "An empty view has no fields")
+	}
+	empty, _ := NewTemplateRecord(257)
+	emptylayout, _ := NewTemplateLayout(empty)
+	if _, err := emptylayout.View(recordViewTestRecord); !errors.Is(err, ErrMalformedLength) {
+		t.Errorf(errorPrefixMarker+"Expected records of length 0 to fail, but got %v", err)
+	}
+}
+
+func TestRecordViewLayoutCache(t *testing.T) {
+	templates, layout := recordViewTestLayout(t)
+	if cached, _ := templates.Layout(256); cached != layout {
+		t.Errorf(errorPrefixMarker + "Layout should be computed once")
+	}
+	tmplrec, _ := NewTemplateRecord(256)
+	fsp, _ := NewFieldSpecifier(0, 12, 4)
+	tmplrec.AddSpecifier(fsp)
+	templates.Set(256, tmplrec)
+	replaced, err := templates.Layout(256)
+	if err != nil || replaced == layout || replaced.MinLength() != 4 {
+		t.Errorf(errorPrefixMarker+"Layout should be recomputed when the template is replaced, got %+v (%v)", replaced, err)
+	}
+	if _, err := templates.Layout(300); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf(errorPrefixMarker+"Expected missing template, but got %v", err)
+	}
+}
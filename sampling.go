@@ -0,0 +1,330 @@
+package ipfix
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"weak"
+)
+
+/*
+
+Sampled flow records count only the selected packets. To estimate the real traffic the octet and packet counters are
+multiplied by the sampling factor: the number of packets observed for every packet selected.
+
+The sampling parameters are exported in options data, and a data record refers to them in one of these ways, which the
+SamplingNormaliser checks in this order:
+
+  - the record holds the parameters itself, such as samplingInterval or samplerRandomInterval
+  - the record has a selectionSequenceId or selectorId of PSAMP Selectors (RFC 5476)
+  - the record has a samplerId, of options data with that samplerId as a scope or as a field, as NetFlow v9 exports it
+  - the record has an ingressInterface, of options data scoped on that interface
+  - options data scoped on anything else, such as the observationDomainId, applies to every record of the Observation Domain
+
+Options data is kept per Observation Domain ID. Feed the normaliser every message; options data is learned before the data
+records of the same message are normalised:
+
+	normaliser := NewSamplingNormaliser()
+	ipfixmsg, err := session.UnmarshalMessage(data)
+	normalised, err := normaliser.NormaliseMessage(ipfixmsg)
+
+A normaliser normalises a record only once, it keeps the records it normalised until they are garbage collected and
+Normalised tells whether it normalised a record. Records without
+known sampling parameters are left alone. A counter saturates at the largest value its field length can encode, so a counter
+with reduced-size encoding stays at most the maximum of its field length and the record can still be marshalled.
+
+*/
+
+// The sampling Information Elements besides those of PSAMP
+const (
+	ingressInterfaceElement      = 10
+	samplingIntervalElement      = 34
+	samplerIDElement             = 48
+	samplerRandomIntervalElement = 50
+)
+
+// samplingCounterElements are the IANA counters of octets and packets, they are normalised, as are their Reverse Information Elements
+var samplingCounterElements = map[uint16]bool{
+	1: true, 2: true, 85: true, 86: true, //octetDeltaCount, packetDeltaCount, octetTotalCount, packetTotalCount
+	23: true, 24: true, 171: true, 172: true, //postOctetDeltaCount, postPacketDeltaCount, postOctetTotalCount, postPacketTotalCount
+	19: true, 20: true, 174: true, 175: true, //postMCastPacketDeltaCount, postMCastOctetDeltaCount, postMCastPacketTotalCount, postMCastOctetTotalCount
+	231: true, 232: true, 298: true, 299: true, //initiatorOctets, responderOctets, initiatorPackets, responderPackets
+	352: true, 353: true, //layer2OctetDeltaCount, layer2OctetTotalCount
+}
+
+// samplingScope tells what options data with sampling parameters applies to
+type samplingScope uint8
+
+const (
+	samplingScopeDomain samplingScope = iota
+	samplingScopeSampler
+	samplingScopeInterface
+)
+
+// samplingKey identifies the sampling parameters of an Observation Domain
+type samplingKey struct {
+	observationDomainID uint32
+	scope               samplingScope
+	id                  uint64 //samplerId or ingressInterface, 0 for the domain
+}
+
+// SamplingNormaliser multiplies the counters of sampled flow records by their sampling factor
+type SamplingNormaliser struct {
+	Selectors *PSAMPSelectors //The PSAMP Selectors, learned from the options data as well
+
+	factors    map[samplingKey]float64
+	normalised map[weak.Pointer[DataRecord]]bool
+
+	sync.Mutex
+}
+
+// NewSamplingNormaliser returns a normaliser that does not know any sampling parameters yet
+func NewSamplingNormaliser() *SamplingNormaliser {
+	return &SamplingNormaliser{
+		Selectors:  NewPSAMPSelectors(),
+		factors:    make(map[samplingKey]float64),
+		normalised: make(map[weak.Pointer[DataRecord]]bool),
+	}
+}
+
+// samplingFactor returns the sampling factor of the parameters in the fields of a record, or false if it has none
+func samplingFactor(datrec *DataRecord, fields map[uint16]FieldValue) (float64, bool) {
+	for _, elementid := range []uint16{samplerRandomIntervalElement, samplingIntervalElement} {
+		if fieldval, found := fields[elementid]; found {
+			if interval, ok := unsignedValue(fieldval); ok && interval > 0 {
+				return float64(interval), true
+			}
+		}
+	}
+	if fields[selectorAlgorithmElement] != nil {
+		if sel, err := NewSelector(datrec); err == nil {
+			if fraction, ok := sel.SelectedFraction(); ok && fraction > 0 {
+				return 1 / fraction, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// AddMessage learns the sampling parameters in the options data of the message
+func (sn *SamplingNormaliser) AddMessage(ipfixmsg *Message) error {
+	var err error
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			if datrec, ok := (*rec).(*DataRecord); ok {
+				if suberr := sn.AddRecord(ipfixmsg.ObservationDomainID, datrec); suberr != nil {
+					err = stackError(err, "Sub errors adding sampling options data.", suberr, 0)
+				}
+			}
+		}
+	}
+	return err
+}
+
+// AddRecord learns the sampling parameters of an options record of the Observation Domain. Other records are ignored.
+func (sn *SamplingNormaliser) AddRecord(observationdomainid uint32, datrec *DataRecord) error {
+	tmplrec, err := datrec.AssociatedTemplates.Get(datrec.TemplateID)
+	if err != nil || len(tmplrec.ScopeFieldSpecifiers) == 0 {
+		return err
+	}
+	if err := sn.Selectors.AddRecord(observationdomainid, datrec); err != nil {
+		return err
+	}
+	fields, _, err := psampFields(datrec)
+	if err != nil {
+		return err
+	}
+	if fields[selectorIDElement] != nil {
+		return nil //A PSAMP Selector, known by the Selectors
+	}
+	factor, found := samplingFactor(datrec, fields)
+	if !found {
+		return nil
+	}
+	key := samplingKey{observationDomainID: observationdomainid, scope: samplingScopeDomain}
+	if samplerid, found := fields[samplerIDElement]; found { //As a scope, or as a field of options data scoped on the system in NetFlow v9
+		key.scope = samplingScopeSampler
+		key.id, _ = unsignedValue(samplerid)
+	} else if scope := tmplrec.ScopeFieldSpecifiers[0]; scope.EnterpriseNumber == 0 && scope.InformationElementIdentifier == ingressInterfaceElement {
+		key.scope = samplingScopeInterface
+		key.id, _ = unsignedValue(fields[ingressInterfaceElement])
+	}
+	sn.Lock()
+	defer sn.Unlock()
+	sn.factors[key] = factor
+	return nil
+}
+
+// Factor returns the sampling factor of a data record of the Observation Domain, or false if its sampling parameters are not known
+func (sn *SamplingNormaliser) Factor(observationdomainid uint32, datrec *DataRecord) (float64, bool) {
+	fields, selectorids, err := psampFields(datrec)
+	if err != nil {
+		return 0, false
+	}
+	if factor, found := samplingFactor(datrec, fields); found {
+		return factor, true
+	}
+
+	if sequenceid, found := fields[selectionSequenceIDElement]; found {
+		id, _ := unsignedValue(sequenceid)
+		if factor, found := selectorsFactor(sn.Selectors.SequenceSelectors(observationdomainid, id)); found {
+			return factor, true
+		}
+	}
+	selectors := []*Selector{}
+	for _, id := range selectorids {
+		if sel, found := sn.Selectors.Selector(observationdomainid, id); found {
+			selectors = append(selectors, sel)
+		}
+	}
+	if factor, found := selectorsFactor(selectors); found {
+		return factor, true
+	}
+
+	sn.Lock()
+	defer sn.Unlock()
+	if samplerid, found := fields[samplerIDElement]; found {
+		id, _ := unsignedValue(samplerid)
+		if factor, found := sn.factors[samplingKey{observationDomainID: observationdomainid, scope: samplingScopeSampler, id: id}]; found {
+			return factor, true
+		}
+	}
+	if ingress, found := fields[ingressInterfaceElement]; found {
+		id, _ := unsignedValue(ingress)
+		if factor, found := sn.factors[samplingKey{observationDomainID: observationdomainid, scope: samplingScopeInterface, id: id}]; found {
+			return factor, true
+		}
+	}
+	factor, found := sn.factors[samplingKey{observationDomainID: observationdomainid, scope: samplingScopeDomain}]
+	return factor, found
+}
+
+// selectorsFactor returns the sampling factor of Selectors that are applied one after the other, or false if the fraction of one of them is not known
+func selectorsFactor(selectors []*Selector) (float64, bool) {
+	if len(selectors) == 0 {
+		return 0, false
+	}
+	fraction := 1.0
+	for _, sel := range selectors {
+		selfraction, ok := sel.SelectedFraction()
+		if !ok || selfraction <= 0 {
+			return 0, false
+		}
+		fraction *= selfraction
+	}
+	return 1 / fraction, true
+}
+
+// Normalise multiplies the octet and packet counters of a data record of the Observation Domain by its sampling factor.
+// It returns false if the record was normalised by the normaliser before or its sampling parameters are not known.
+// The counters are only changed when all of them can be scaled, a record that fails is left as it was.
+// Counters saturate at the largest value their field length can encode.
+func (sn *SamplingNormaliser) Normalise(observationdomainid uint32, datrec *DataRecord) (bool, error) {
+	key := weak.Make(datrec)
+	sn.Lock()
+	if sn.normalised[key] {
+		sn.Unlock()
+		return false, nil
+	}
+	sn.normalised[key] = true //Claimed, so the record is not scaled twice by concurrent calls
+	sn.Unlock()
+	normalised, err := sn.scale(observationdomainid, datrec)
+	sn.Lock()
+	defer sn.Unlock()
+	if !normalised {
+		delete(sn.normalised, key)
+		return false, err
+	}
+	runtime.AddCleanup(datrec, sn.forget, key)
+	return true, nil
+}
+
+// Normalised returns whether the record was normalised by the normaliser
+func (sn *SamplingNormaliser) Normalised(datrec *DataRecord) bool {
+	sn.Lock()
+	defer sn.Unlock()
+	return sn.normalised[weak.Make(datrec)]
+}
+
+// scale multiplies the counters of the record by its sampling factor, it returns false if the factor is not known or a counter can not be scaled
+func (sn *SamplingNormaliser) scale(observationdomainid uint32, datrec *DataRecord) (bool, error) {
+	factor, found := sn.Factor(observationdomainid, datrec)
+	if !found {
+		return false, nil
+	}
+	fsps, err := datrec.recordFields()
+	if err != nil {
+		return false, err
+	}
+	scaled := make(map[int]uint64)
+	for idx, fsp := range fsps {
+		if (fsp.EnterpriseNumber != 0 && fsp.EnterpriseNumber != ReversePEN) || !samplingCounterElements[fsp.InformationElementIdentifier] {
+			continue
+		}
+		value, err := scaledCounter(datrec.FieldValues[idx], factor, fsp.FieldLength)
+		if err != nil {
+			return false, err.InTemplate(datrec.TemplateID).AtField(idx)
+		}
+		scaled[idx] = value
+	}
+	for idx, value := range scaled {
+		setUnsigned(datrec.FieldValues[idx], value)
+	}
+	return true, nil
+}
+
+// forget removes a garbage collected record from the normalised records
+func (sn *SamplingNormaliser) forget(key weak.Pointer[DataRecord]) {
+	sn.Lock()
+	defer sn.Unlock()
+	delete(sn.normalised, key)
+}
+
+// scaledCounter returns an unsigned counter multiplied by factor, saturating at the maximum of its type and of its field length
+func scaledCounter(fieldval FieldValue, factor float64, fieldlength uint16) (uint64, *ProtocolError) {
+	value, ok := unsignedValue(fieldval)
+	if !ok {
+		return 0, NewCategoryError(ErrInvalidValue, fmt.Sprintf("Can not scale counter of type %T", fieldval), ErrCritical)
+	}
+	maximum := uint64(math.MaxUint64)
+	if fieldlength < 8 {
+		maximum = 1<<(8*fieldlength) - 1
+	}
+	if scaled := math.Round(float64(value) * factor); scaled < float64(maximum) {
+		return uint64(scaled), nil
+	}
+	return maximum, nil
+}
+
+// NormaliseMessage learns the sampling parameters in the options data of the message and normalises its other data records.
+// It returns the number of records that were normalised.
+func (sn *SamplingNormaliser) NormaliseMessage(ipfixmsg *Message) (int, error) {
+	err := sn.AddMessage(ipfixmsg)
+	normalised := 0
+	for _, set := range ipfixmsg.Sets {
+		if set.SetID < 256 {
+			continue
+		}
+		for _, rec := range set.Records {
+			datrec, ok := (*rec).(*DataRecord)
+			if !ok {
+				continue
+			}
+			if tmplrec, tmplerr := datrec.AssociatedTemplates.Get(datrec.TemplateID); tmplerr != nil || len(tmplrec.ScopeFieldSpecifiers) > 0 {
+				continue
+			}
+			done, suberr := sn.Normalise(ipfixmsg.ObservationDomainID, datrec)
+			if suberr != nil {
+				err = stackError(err, "Sub errors normalising records.", suberr, 0)
+			}
+			if done {
+				normalised++
+			}
+		}
+	}
+	return normalised, err
+}
@@ -0,0 +1,184 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+const (
+	samplingTestPrint = false
+)
+
+func TestSamplingMarker(t *testing.T) {
+	if samplingTestPrint {
+		fmt.Printf(testMarkerString, "Sampling")
+	}
+}
+
+// samplingTestFlow sets a template for the fields and returns a flow record of it with octetDeltaCount 1000 and packetDeltaCount 10
+func samplingTestFlow(templates *ActiveTemplates, templateid uint16, fields ...psampTestField) *DataRecord {
+	tmplrec, _ := NewTemplateRecord(templateid)
+	datrec, _ := NewDataRecord(templateid, templates)
+	fields = append([]psampTestField{{1, &FieldValueUnsigned64{value: 1000}}, {2, &FieldValueUnsigned64{value: 10}}}, fields...)
+	for _, field := range fields {
+		fsp, _ := NewFieldSpecifier(0, field.element, field.value.Len())
+		tmplrec.AddSpecifier(fsp)
+		datrec.FieldValues = append(datrec.FieldValues, field.value)
+	}
+	templates.Set(templateid, tmplrec)
+	return datrec
+}
+
+// samplingTestMessage returns the decoded message of the records
+func samplingTestMessage(t *testing.T, templates *ActiveTemplates, odid uint32, records ...*DataRecord) *Message {
+	builder := NewMessageBuilder(odid, templates, 0)
+	for _, datrec := range records {
+		if err := builder.AddRecord(datrec); err != nil {
+			t.Fatalf(errorPrefixMarker+"Error adding record: %v", err)
+		}
+	}
+	return messageBuilderTestDecode(t, builder.Flush(), maxMessageSize)[0]
+}
+
+func TestSamplingNormaliser(t *testing.T) {
+	templates := NewActiveTemplateList()
+	normaliser := NewSamplingNormaliser()
+	ipfixmsg := samplingTestMessage(t, templates, 9,
+		psampTestRecord(templates, 300, //Applies to the observation domain
+			psampTestField{149, &FieldValueUnsigned32{value: 9}},
+			psampTestField{34, &FieldValueUnsigned32{value: 100}}),
+		psampTestRecord(templates, 301, //NetFlow v9 sampler options, scoped on the system
+			psampTestField{149, &FieldValueUnsigned32{value: 9}},
+			psampTestField{48, &FieldValueUnsigned8{value: 3}},
+			psampTestField{49, &FieldValueUnsigned8{value: 2}},
+			psampTestField{50, &FieldValueUnsigned32{value: 1000}}),
+		psampTestRecord(templates, 302, //Applies to an interface
+			psampTestField{10, &FieldValueUnsigned32{value: 7}},
+			psampTestField{34, &FieldValueUnsigned32{value: 10}}),
+		psampTestRecord(templates, 303, //PSAMP Selector
+			psampTestField{302, &FieldValueUnsigned64{value: 1}},
+			psampTestField{304, &FieldValueUnsigned16{value: uint16(SelectorSystematicCount)}},
+			psampTestField{305, &FieldValueUnsigned32{value: 1}},
+			psampTestField{306, &FieldValueUnsigned32{value: 49}}),
+		samplingTestFlow(templates, 400, psampTestField{48, &FieldValueUnsigned8{value: 3}}),
+		samplingTestFlow(templates, 401, psampTestField{48, &FieldValueUnsigned8{value: 4}}),
+		samplingTestFlow(templates, 402, psampTestField{10, &FieldValueUnsigned32{value: 7}}),
+		samplingTestFlow(templates, 403, psampTestField{10, &FieldValueUnsigned32{value: 8}}),
+		samplingTestFlow(templates, 404, psampTestField{302, &FieldValueUnsigned64{value: 1}}),
+		samplingTestFlow(templates, 405, psampTestField{50, &FieldValueUnsigned32{value: 4}}),
+		samplingTestFlow(templates, 406, psampTestField{1, &FieldValueUnsigned64{value: 5}}),
+	)
+	normalised, err := normaliser.NormaliseMessage(ipfixmsg)
+	if err != nil || normalised != 7 {
+		t.Fatalf(errorPrefixMarker+"Expected 7 normalised records, but got %d (%v)", normalised, err)
+	}
+	for idx, factor := range []uint64{1000, 100, 10, 100, 50, 4, 100} {
+		datrec := (*ipfixmsg.Sets[idx+6].Records[0]).(*DataRecord)
+		if !normaliser.Normalised(datrec) || datrec.FieldValues[0].Value() != 1000*factor || datrec.FieldValues[1].Value() != 10*factor {
+			t.Errorf(errorPrefixMarker+"Template %d: expected factor %d, but got %v and %v", datrec.TemplateID, factor, datrec.FieldValues[0].Value(), datrec.FieldValues[1].Value())
+		}
+	}
+	if last := (*ipfixmsg.Sets[12].Records[0]).(*DataRecord); last.FieldValues[2].Value() != uint64(500) {
+		t.Errorf(errorPrefixMarker+"Every counter should be normalised, but got %v", last.FieldValues[2].Value())
+	}
+	if normalised, _ := normaliser.NormaliseMessage(ipfixmsg); normalised != 0 {
+		t.Errorf(errorPrefixMarker+"Records should only be normalised once, but %d were normalised again", normalised)
+	}
+
+	other := samplingTestFlow(templates, 400, psampTestField{48, &FieldValueUnsigned8{value: 3}})
+	if factor, found := normaliser.Factor(10, other); found {
+		t.Errorf(errorPrefixMarker+"Sampling parameters should be kept per observation domain, but got %f", factor)
+	}
+	if normalised, err := normaliser.Normalise(10, other); normalised || err != nil || normaliser.Normalised(other) {
+		t.Errorf(errorPrefixMarker+"Records without known sampling should be left alone (%v)", err)
+	}
+}
+
+func TestSamplingNormaliserCounters(t *testing.T) {
+	templates := NewActiveTemplateList()
+	normaliser := NewSamplingNormaliser()
+	tmplrec, _ := NewTemplateRecord(256)
+	for _, fsp := range []*FieldSpecifier{
+		{InformationElementIdentifier: 1, FieldLength: 8},
+		{E: true, InformationElementIdentifier: 2, FieldLength: 8, EnterpriseNumber: ReversePEN}, //reversePacketDeltaCount
+		{InformationElementIdentifier: 85, FieldLength: 8},
+		{InformationElementIdentifier: 3, FieldLength: 8}, //deltaFlowCount is not a packet counter
+		{InformationElementIdentifier: 34, FieldLength: 4},
+	} {
+		tmplrec.AddSpecifier(fsp)
+	}
+	templates.Set(256, tmplrec)
+	datrec, _ := NewDataRecord(256, templates)
+	datrec.FieldValues = []FieldValue{&FieldValueUnsigned64{value: 3}, &FieldValueUnsigned64{value: 5}, &FieldValueUnsigned64{value: math.MaxUint64 / 2}, &FieldValueUnsigned64{value: 1}, &FieldValueUnsigned32{value: 3}}
+	if normalised, err := normaliser.Normalise(1, datrec); !normalised || err != nil {
+		t.Fatalf(errorPrefixMarker+"Expected the record to be normalised (%v)", err)
+	}
+	for idx, expected := range []uint64{9, 15, math.MaxUint64, 1} {
+		if datrec.FieldValues[idx].Value() != expected {
+			t.Errorf(errorPrefixMarker+"Field %d: expected %d, but got %v", idx, expected, datrec.FieldValues[idx].Value())
+		}
+	}
+
+	if scaled, err := scaledCounter(&FieldValueUnsigned16{value: 1000}, 100, 2); scaled != math.MaxUint16 || err != nil {
+		t.Errorf(errorPrefixMarker+"Expected the counter to saturate, but got %d (%v)", scaled, err)
+	}
+	if _, err := scaledCounter(&FieldValueString{}, 100, 8); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf(errorPrefixMarker+"Expected an invalid value, but got %v", err)
+	}
+}
+
+func TestSamplingNormaliserReducedSize(t *testing.T) {
+	templates := NewActiveTemplateList()
+	normaliser := NewSamplingNormaliser()
+	tmplrec, _ := NewTemplateRecord(256)
+	for _, fsp := range []*FieldSpecifier{
+		{InformationElementIdentifier: 1, FieldLength: 4}, //Reduced-size octetDeltaCount
+		{InformationElementIdentifier: 2, FieldLength: 8},
+		{InformationElementIdentifier: 34, FieldLength: 4},
+	} {
+		tmplrec.AddSpecifier(fsp)
+	}
+	templates.Set(256, tmplrec)
+	datrec, _ := NewDataRecord(256, templates)
+	datrec.FieldValues = []FieldValue{&FieldValueUnsigned64{value: 3000000000}, &FieldValueUnsigned64{value: 5}, &FieldValueUnsigned32{value: 3}}
+	if normalised, err := normaliser.Normalise(1, datrec); !normalised || err != nil {
+		t.Fatalf(errorPrefixMarker+"Expected the record to be normalised (%v)", err)
+	}
+	if datrec.FieldValues[0].Value() != uint64(math.MaxUint32) || datrec.FieldValues[1].Value() != uint64(15) {
+		t.Errorf(errorPrefixMarker+"Expected the counter to saturate at its field length, but got %v", datrec.FieldValues[0].Value())
+	}
+	if _, err := datrec.MarshalBinary(); err != nil {
+		t.Errorf(errorPrefixMarker+"Error marshalling normalised record: %v", err)
+	}
+
+	failing, _ := NewDataRecord(256, templates)
+	failing.FieldValues = []FieldValue{&FieldValueUnsigned64{value: 7}, &FieldValueString{value: "5"}, &FieldValueUnsigned32{value: 3}}
+	if normalised, err := normaliser.Normalise(1, failing); normalised || !errors.Is(err, ErrInvalidValue) || normaliser.Normalised(failing) {
+		t.Errorf(errorPrefixMarker+"Expected a counter that is not a number to fail, but got %v", err)
+	}
+	failing.FieldValues[1] = &FieldValueUnsigned64{value: 5}
+	if normalised, err := normaliser.Normalise(1, failing); !normalised || err != nil || failing.FieldValues[0].Value() != uint64(21) {
+		t.Errorf(errorPrefixMarker+"Expected a failed record to be left as it was and normalised once, but got %v (%v)", failing.FieldValues[0].Value(), err)
+	}
+}
+
+func TestSamplingNormaliserConcurrent(t *testing.T) {
+	templates := NewActiveTemplateList()
+	normaliser := NewSamplingNormaliser()
+	datrec := samplingTestFlow(templates, 256, psampTestField{34, &FieldValueUnsigned32{value: 10}})
+	done := make(chan bool)
+	for range 8 {
+		go func() {
+			normaliser.Normalise(1, datrec)
+			done <- true
+		}()
+	}
+	for range 8 {
+		<-done
+	}
+	if datrec.FieldValues[0].Value() != uint64(10000) || !normaliser.Normalised(datrec) {
+		t.Errorf(errorPrefixMarker+"Expected the record to be normalised once, but got %v", datrec.FieldValues[0].Value())
+	}
+}
@@ -1,9 +1,167 @@
 package ipfix
 
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// Session holds the state of a single Transport Session between an Exporting Process and a Collecting Process
 type Session struct {
 
 	//AssociatedTemplates Templates points to the list of active templates. Without a template record a data record can not be encoded or decoded
 	AssociatedTemplates *ActiveTemplates
+
+	//Logger receives the diagnostics of the session, such as malformed messages, sequence gaps and template replacements. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	//DecodeOptions controls how malformed messages are handled by UnmarshalMessage, and the limits that apply
+	DecodeOptions DecodeOptions
+
+	//RegisterTypes registers the enterprise-specific Information Elements described by RFC 5610 Information Element Type Records as custom fields.
+	//Custom fields are shared by all sessions of the process, so only set it if the exporters can be trusted to agree on the types.
+	//No more than DecodeOptions.Limits.MaxCustomTypes elements are registered by types.
+	RegisterTypes bool
+
+	sequenceNumbers map[uint32]uint32 //Expected next Sequence Number per Observation Domain ID
+	sequenceLock    sync.Mutex
+}
+
+// NewSession returns a new session with an empty template list that logs to logger, which may be nil
+func NewSession(logger *slog.Logger) *Session {
+	session := &Session{
+		AssociatedTemplates: NewActiveTemplateList(),
+		Logger:              logger,
+		DecodeOptions:       DefaultDecodeOptions,
+		sequenceNumbers:     make(map[uint32]uint32),
+	}
+	session.AssociatedTemplates.SetLogger(logger)
+	return session
+}
+
+// logger returns the logger of the session, or a logger that discards everything if there is none
+func (session *Session) logger() *slog.Logger {
+	if session == nil || session.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return session.Logger
+}
+
+// UnmarshalMessage decodes an IPFIX Message using the templates of the session.
+// Malformed messages are logged as errors. Per Observation Domain the Sequence Number is checked against the number of Data Records seen so far and gaps are logged as warnings.
+// The number of Data Records is not known when a Data Set could not be decoded, like one of an unknown template: then the next message is not checked.
+// The message is returned even if there were errors, so the caller can decide whether to use the records that were decoded.
+func (session *Session) UnmarshalMessage(data []byte) (*Message, error) {
+	ipfixmsg, err := NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	if session.AssociatedTemplates == nil {
+		session.AssociatedTemplates = NewActiveTemplateList()
+	}
+	session.AssociatedTemplates.SetLogger(session.Logger) //The Logger may have been set after the session was created
+	ipfixmsg.AssociatedTemplates = session.AssociatedTemplates
+	err = ipfixmsg.UnmarshalBinaryWithOptions(data, session.DecodeOptions)
+	if session.RegisterTypes && session.registerTypes(ipfixmsg) > 0 { //The records were decoded before their types were known
+		ipfixmsg, _ = NewMessage()
+		ipfixmsg.AssociatedTemplates = session.AssociatedTemplates
+		err = ipfixmsg.UnmarshalBinaryWithOptions(data, session.DecodeOptions)
+	}
+	if err != nil {
+		session.logDecodeError(err, ipfixmsg)
+	}
+	if allRecordsDecoded(err) {
+		session.checkSequenceNumber(ipfixmsg)
+	} else if len(data) >= ipfixMessageHeaderLength { //The header may not have been decoded
+		session.forgetSequenceNumber(binary.BigEndian.Uint32(data[12:16]))
+	}
+	return ipfixmsg, err
+}
+
+// logDecodeError logs an error that occurred while decoding a message, with as much context as the error has
+func (session *Session) logDecodeError(err error, ipfixmsg *Message) {
+	attrs := []any{slog.Any("error", err), slog.Uint64("observation_domain_id", uint64(ipfixmsg.ObservationDomainID))}
+	var perr *ProtocolError
+	if errors.As(err, &perr) {
+		if perr.Offset >= 0 {
+			attrs = append(attrs, slog.Int("offset", perr.Offset))
+		}
+		if perr.SetID != 0 {
+			attrs = append(attrs, slog.Uint64("set_id", uint64(perr.SetID)))
+		}
+		if perr.TemplateID != 0 {
+			attrs = append(attrs, slog.Uint64("template_id", uint64(perr.TemplateID)))
+		}
+	}
+	if errors.Is(err, ErrTemplateNotFound) && (perr == nil || perr.Severity != ErrCritical) {
+		session.logger().Warn("IPFIX message has data for unknown templates", attrs...)
+		return
+	}
+	session.logger().Error("Malformed IPFIX message", attrs...)
+}
+
+// registerTypes registers the Information Element Type Records of the message, logs the ones that could not be registered
+// and returns the number of elements that were registered or changed
+func (session *Session) registerTypes(ipfixmsg *Message) int {
+	registered, err := RegisterInformationElementTypesWithLimits(ipfixmsg, session.DecodeOptions.Limits)
+	if err != nil {
+		session.logger().Warn("IPFIX Information Element types not registered", slog.Any("error", err), slog.Uint64("observation_domain_id", uint64(ipfixmsg.ObservationDomainID)))
+	}
+	if registered > 0 {
+		session.logger().Info("IPFIX Information Element types registered", slog.Int("count", registered), slog.Uint64("observation_domain_id", uint64(ipfixmsg.ObservationDomainID)))
+	}
+	return registered
+}
+
+// allRecordsDecoded returns whether a message decoded with err holds all its Data Records.
+// Only records with unknown Information Elements are still decoded, as octet arrays.
+func allRecordsDecoded(err error) bool {
+	if err == nil {
+		return true
+	}
+	perr, ok := err.(*ProtocolError)
+	if !ok || (perr.Category != nil && !errors.Is(perr.Category, ErrUnknownElement)) {
+		return false
+	}
+	for idx := range perr.SubError {
+		if !allRecordsDecoded(&perr.SubError[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetSequenceNumber forgets the expected Sequence Number of the Observation Domain, so the next message is not checked
+func (session *Session) forgetSequenceNumber(observationdomainid uint32) {
+	session.sequenceLock.Lock()
+	defer session.sequenceLock.Unlock()
+	delete(session.sequenceNumbers, observationdomainid)
+}
+
+// checkSequenceNumber compares the Sequence Number of the message with the expected one for its Observation Domain and logs gaps
+func (session *Session) checkSequenceNumber(ipfixmsg *Message) {
+	datacount := uint32(0)
+	for _, st := range ipfixmsg.Sets {
+		if st.SetID > 255 {
+			datacount += uint32(len(st.Records))
+		}
+	}
+	session.sequenceLock.Lock()
+	defer session.sequenceLock.Unlock()
+	if session.sequenceNumbers == nil {
+		session.sequenceNumbers = make(map[uint32]uint32)
+	}
+	expected, found := session.sequenceNumbers[ipfixmsg.ObservationDomainID]
+	if found && expected != ipfixmsg.SequenceNumber {
+		session.logger().Warn("IPFIX sequence number gap",
+			slog.Uint64("observation_domain_id", uint64(ipfixmsg.ObservationDomainID)),
+			slog.Uint64("expected", uint64(expected)),
+			slog.Uint64("received", uint64(ipfixmsg.SequenceNumber)),
+			slog.Int64("missing", int64(int32(ipfixmsg.SequenceNumber-expected))),
+		)
+	}
+	session.sequenceNumbers[ipfixmsg.ObservationDomainID] = ipfixmsg.SequenceNumber + datacount //Sequence numbers wrap around
 }
 
 /*
@@ -0,0 +1,107 @@
+package ipfix
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const (
+	sessionTestPrint = false
+)
+
+func TestSessionMarker(t *testing.T) {
+	if sessionTestPrint {
+		fmt.Printf(testMarkerString, "Session")
+	}
+}
+
+// sessionTestMessage returns an IPFIX message with the given sequence number and sets
+func sessionTestMessage(sequencenumber byte, sets ...[]byte) []byte {
+	msg := []byte{0, 10, 0, 0, 0x52, 0xdd, 0xa6, 0xec, 0, 0, 0, sequencenumber, 0, 0, 0, 1}
+	for _, st := range sets {
+		msg = append(msg, st...)
+	}
+	msg[3] = byte(len(msg))
+	return msg
+}
+
+func TestSessionLogging(t *testing.T) {
+	logbuf := &bytes.Buffer{}
+	session := NewSession(slog.New(slog.NewTextHandler(logbuf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	tmplset := []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 8, 0, 4}
+	datset := []byte{1, 0, 0, 8, 10, 0, 0, 1}
+
+	if _, err := session.UnmarshalMessage(sessionTestMessage(0, tmplset, datset)); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	if logbuf.Len() != 0 {
+		t.Errorf(errorPrefixMarker+"Nothing should have been logged, but got: %s", logbuf)
+	}
+
+	if _, err := session.UnmarshalMessage(sessionTestMessage(5, datset)); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	if !strings.Contains(logbuf.String(), "level=WARN") || !strings.Contains(logbuf.String(), "expected=1 received=5") {
+		t.Errorf(errorPrefixMarker+"Sequence gap should have been logged, but got: %s", logbuf)
+	}
+	logbuf.Reset()
+
+	if _, err := session.UnmarshalMessage(sessionTestMessage(6, tmplset)); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	if logbuf.Len() != 0 {
+		t.Errorf(errorPrefixMarker+"Identical template refresh should not have been logged, but got: %s", logbuf)
+	}
+
+	if _, err := session.UnmarshalMessage(sessionTestMessage(6, []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 12, 0, 4})); err != nil {
+		t.Fatalf(errorPrefixMarker+"Error unmarshalling message: %v", err)
+	}
+	if !strings.Contains(logbuf.String(), "level=INFO") || !strings.Contains(logbuf.String(), "template_id=256") {
+		t.Errorf(errorPrefixMarker+"Template replacement should have been logged, but got: %s", logbuf)
+	}
+	logbuf.Reset()
+
+	malformed := sessionTestMessage(6, datset)
+	malformed[3] = 40
+	if _, err := session.UnmarshalMessage(malformed); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for malformed message")
+	}
+	if !strings.Contains(logbuf.String(), "level=ERROR") || !strings.Contains(logbuf.String(), "offset=2") {
+		t.Errorf(errorPrefixMarker+"Malformed message should have been logged, but got: %s", logbuf)
+	}
+	if sessionTestPrint {
+		fmt.Print(logbuf)
+	}
+
+	quiet := NewSession(nil)
+	if _, err := quiet.UnmarshalMessage(malformed); err == nil {
+		t.Errorf(errorPrefixMarker + "Should have gotten error for malformed message without logger")
+	}
+
+	//The Data Records of unknown templates and of rejected messages are not counted, so the next message is not checked
+	logbuf.Reset()
+	gaps := NewSession(slog.New(slog.NewTextHandler(logbuf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	gaps.UnmarshalMessage(sessionTestMessage(0, []byte{2, 0, 0, 12, 10, 0, 0, 1, 10, 0, 0, 2}))
+	gaps.UnmarshalMessage(sessionTestMessage(2, tmplset, datset))
+	gaps.UnmarshalMessage(malformed)
+	gaps.UnmarshalMessage(sessionTestMessage(7, datset))
+	if strings.Contains(logbuf.String(), "sequence number gap") {
+		t.Errorf(errorPrefixMarker+"Records that were not decoded should not cause a sequence gap, but got: %s", logbuf)
+	}
+	gaps.UnmarshalMessage(sessionTestMessage(9, datset))
+	if !strings.Contains(logbuf.String(), "expected=8 received=9") {
+		t.Errorf(errorPrefixMarker+"Sequence gap should have been logged after the records were counted again, but got: %s", logbuf)
+	}
+
+	literal := &Session{}
+	literal.UnmarshalMessage(sessionTestMessage(0, tmplset))
+	literal.Logger = slog.New(slog.NewTextHandler(logbuf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logbuf.Reset()
+	literal.UnmarshalMessage(sessionTestMessage(0, []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 12, 0, 4}))
+	if !strings.Contains(logbuf.String(), "IPFIX template replaced") {
+		t.Errorf(errorPrefixMarker+"Template replacement should have been logged with a Logger set later, but got: %s", logbuf)
+	}
+}
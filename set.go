@@ -34,7 +34,7 @@ Because Template Sets are always 4-octet aligned by definition, padding is only
 // NewSet creates a new IPFIX Set with specified set ID
 func NewSet(setid uint16) (*Set, error) {
 	if setid < SetIDTemplate || (setid > SetIDOptionTemplate && setid < 256) {
-		return nil, NewError(fmt.Sprintf("Invalid value for Set ID: %d", setid), ErrCritical)
+		return nil, NewCategoryError(ErrInvalidSetID, fmt.Sprintf("Invalid value for Set ID: %d", setid), ErrCritical).InSet(setid)
 	}
 
 	return &Set{
@@ -56,7 +56,7 @@ func NewBlankSet() *Set {
 // AssociateTemplates sets the template to be used marshalling/unmarshalling this DataRecord
 func (ipfixset *Set) AssociateTemplates(at *ActiveTemplates) error {
 	if at == nil {
-		return NewError("Can not use nil as Template List", ErrCritical)
+		return NewCategoryError(ErrNoTemplates, "Can not use nil as Template List", ErrCritical)
 	}
 	ipfixset.AssociatedTemplates = at
 	return nil
@@ -91,29 +91,27 @@ func (ipfixset *Set) String() string {
 // AddRecord adds a new record to this set
 func (ipfixset *Set) AddRecord(rec Record) error {
 	if int(rec.Len())+int(ipfixset.Len()) > 65535 {
-		return NewError(fmt.Sprintf("Can not add record. Record size %d + Set Size %d > 65535", rec.Len(), ipfixset.Len()), ErrCritical)
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Can not add record. Record size %d + Set Size %d > 65535", rec.Len(), ipfixset.Len()), ErrCritical).InSet(ipfixset.SetID)
 	}
 	switch rec.(type) {
 	case *TemplateRecord:
 		switch ipfixset.SetID {
 		case SetIDTemplate:
 			if rec.(*TemplateRecord).ScopeFieldSpecifiers != nil {
-				return NewError("Can not add Option Template Record to Template Set", ErrCritical)
+				return NewCategoryError(ErrRecordTypeMismatch, "Can not add Option Template Record to Template Set", ErrCritical).InSet(ipfixset.SetID)
 			}
 
 		case SetIDOptionTemplate:
 			if rec.(*TemplateRecord).ScopeFieldSpecifiers == nil {
-				return NewError("Can not add Template Record to Scope Field Set", ErrCritical)
+				return NewCategoryError(ErrRecordTypeMismatch, "Can not add Template Record to Scope Field Set", ErrCritical).InSet(ipfixset.SetID)
 			}
 		default:
-			return NewError("Can not add Template Record to Data Set", ErrCritical)
+			return NewCategoryError(ErrRecordTypeMismatch, "Can not add Template Record to Data Set", ErrCritical).InSet(ipfixset.SetID)
 		}
 	case *DataRecord:
 		switch ipfixset.SetID {
 		case SetIDTemplate, SetIDOptionTemplate:
-			if rec.(*TemplateRecord).ScopeFieldSpecifiers == nil {
-				return NewError("Can not add Data Record to (Scope) Field Set", ErrCritical)
-			}
+			return NewCategoryError(ErrRecordTypeMismatch, "Can not add Data Record to (Scope) Field Set", ErrCritical).InSet(ipfixset.SetID)
 		}
 	}
 	ipfixset.Records = append(ipfixset.Records, &rec)
@@ -129,113 +127,151 @@ func (ipfixset *Set) Pad(paddingboundary uint16) {
 
 // MarshalBinary satisfies the encoding/BinaryMarshaler interface
 func (ipfixset *Set) MarshalBinary() (data []byte, err error) {
+	return ipfixset.AppendBinary(nil)
+}
+
+// AppendBinary satisfies the encoding/BinaryAppender interface, it appends the set to dst
+func (ipfixset *Set) AppendBinary(dst []byte) (data []byte, err error) {
 	//Set ID value identifies the Set.  A value of 2 is reserved for the Template Set.  A value of 3 is reserved for the Option Template Set.
 	//All other values from 4 to 255 are reserved for future use. Values above 255 are used for Data Sets.
 	if ipfixset.SetID > 3 && ipfixset.SetID < 256 && ipfixset.AssociatedTemplates == nil {
-		return nil, NewError(fmt.Sprintf("Need associated templates for Set ID %d", ipfixset.SetID), ErrCritical)
+		return nil, NewCategoryError(ErrNoTemplates, fmt.Sprintf("Need associated templates for Set ID %d", ipfixset.SetID), ErrCritical).InSet(ipfixset.SetID)
 	}
-	buf := new(bytes.Buffer) //should get from pool?
 
 	//   Each Set Header field is exported in network format.  The fields are defined as follows:
 	//   Set ID
 	//   Length
 	//      Total length of the Set, in octets, including the Set Header, all records, and the optional padding.
 	//      Because an individual Set MAY contain multiple records, the Length value MUST be used to determine the position of the next Set.
-	err = binary.Write(buf, binary.BigEndian, ipfixset.SetID)
-	if err != nil {
-		return nil, err
-	}
-
-	err = binary.Write(buf, binary.BigEndian, ipfixset.Len())
-	if err != nil {
-		return nil, err
-	}
-	data = buf.Bytes()
+	//      The length is filled in when the records are appended, so they are only walked once.
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint16(dst, ipfixset.SetID)
+	dst = append(dst, 0, 0)
 	for _, rec := range ipfixset.Records {
-		recdat, err := (*rec).MarshalBinary()
+		dst, err = appendBinary(dst, *rec)
 		if err != nil {
 			return nil, err
 		}
-		data = append(data, recdat...)
 	}
 	if ipfixset.Padding > 0 {
-		padlen := int(ipfixset.Len()) - len(data)
-		data = append(data, make([]byte, padlen, padlen)...)
+		for (len(dst)-start)%int(ipfixset.Padding) != 0 {
+			dst = append(dst, 0)
+		}
+	}
+	if len(dst)-start > 65535 {
+		return nil, NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid set length %d, must be <= 65535", len(dst)-start), ErrCritical).InSet(ipfixset.SetID)
 	}
-	return data, nil
+	binary.BigEndian.PutUint16(dst[start+2:start+4], uint16(len(dst)-start))
+	return dst, nil
 }
 
 // UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
+// It uses the DefaultDecodeOptions.
 func (ipfixset *Set) UnmarshalBinary(data []byte) error {
-	if data == nil || len(data) < 4 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+	return ipfixset.UnmarshalBinaryWithOptions(data, DefaultDecodeOptions)
+}
+
+// UnmarshalBinaryWithOptions decodes the Set in data, handling malformed input according to opts.
+// Records that were decoded before an error was found are kept in the Set.
+// An error with severity ErrCritical means the Set can not be used; an ErrFailure means the Set is usable but not everything could be decoded.
+func (ipfixset *Set) UnmarshalBinaryWithOptions(data []byte, opts DecodeOptions) error {
+	return ipfixset.unmarshal(data, opts, newDecodeState(opts.Limits))
+}
+
+// unmarshal decodes the Set in data, accounting for the decoded octets in state
+func (ipfixset *Set) unmarshal(data []byte, opts DecodeOptions, state *decodeState) error {
+	if data == nil || len(data) < ipfixSetHeaderLength {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical).AtOffset(0)
 	}
 
 	ipfixset.SetID = binary.BigEndian.Uint16(data[0:2])
-	recordlength := uint16(0)
-	hasvar := false
-	if ipfixset.SetID > 255 {
+	datalength := binary.BigEndian.Uint16(data[2:4])
+	if datalength < ipfixSetHeaderLength || int(datalength) > len(data) {
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Invalid set length %d, have %d bytes of data", datalength, len(data)), ErrCritical).AtOffset(2).InSet(ipfixset.SetID)
+	}
+	data = data[:datalength] //Records must not overrun the set
+
+	//Set ID value identifies the Set.  A value of 2 is reserved for the Template Set.  A value of 3 is reserved for the Option Template Set.
+	//All other values from 4 to 255 are reserved for future use. Values above 255 are used for Data Sets.
+	recordlength := 0 //The minimal length of a record, anything shorter at the end of the set is padding
+	var plan *decodePlan
+	var unknown error //Unknown Information Elements, reported once for the set
+	switch {
+	case ipfixset.SetID == SetIDTemplate, ipfixset.SetID == SetIDOptionTemplate:
+		recordlength = 4 //template header
+	case ipfixset.SetID > 255:
 		if ipfixset.AssociatedTemplates == nil {
-			return NewError(fmt.Sprintf("Must have associated templates to unmarshal set with ID %d", ipfixset.SetID), ErrCritical)
+			return NewCategoryError(ErrNoTemplates, fmt.Sprintf("Must have associated templates to unmarshal set with ID %d", ipfixset.SetID), ErrCritical).InSet(ipfixset.SetID)
 		}
-		ipfixsetTemplate, err := ipfixset.AssociatedTemplates.Get(ipfixset.SetID)
+		var err error
+		plan, err = state.plan(ipfixset.AssociatedTemplates, ipfixset.SetID) //Once per set, all records share the template
 		if err != nil {
-			return err
+			return setErrorContext(err, 0, ipfixset.SetID)
+		}
+		recordlength = plan.minLength
+		if unknown = plan.unknownElements(); unknown != nil {
+			unknown.(*ProtocolError).InSet(ipfixset.SetID)
 		}
-		for _, fsp := range ipfixsetTemplate.FieldSpecifiers {
-			if fsp.FieldLength != VariableLength {
-				recordlength += fsp.FieldLength
-			} else {
-				hasvar = true
-				recordlength += 2 //one byte for length, one for value
+		for _, field := range plan.fields {
+			if field.length == VariableLength {
+				recordlength++ //one byte for length, one for value
 			}
 		}
-	} else {
-		recordlength = 4 //template header
+	default:
+		return NewCategoryError(ErrInvalidSetID, fmt.Sprintf("Reserved set ID: %d", ipfixset.SetID), opts.malformed()).AtOffset(0).InSet(ipfixset.SetID)
 	}
-	datalength := binary.BigEndian.Uint16(data[2:4])
-	cursor := uint16(4)
 
-	for cursor < datalength { //We always need at least 4 bytes to determine Template ID and Field Count
-		if ((cursor + recordlength) > datalength) ||
-			((cursor+recordlength == datalength) && (bytes.Count(data[cursor:], []byte{0}) == int(recordlength))) { //Must be padding
-			return nil
+	cursor := ipfixSetHeaderLength
+	for cursor < len(data) {
+		if cursor+recordlength > len(data) || (ipfixset.SetID < 256 && bytes.Count(data[cursor:], []byte{0}) == len(data)-cursor) { //Must be padding, Template IDs are never 0
+			if bytes.Count(data[cursor:], []byte{0}) != len(data)-cursor {
+				return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Padding of %d octets is not all zeroes", len(data)-cursor), opts.malformed()).AtOffset(cursor).InSet(ipfixset.SetID)
+			}
+			return unknown
 		}
-		//Set ID value identifies the Set.  A value of 2 is reserved for the Template Set.  A value of 3 is reserved for the Option Template Set.
-		//All other values from 4 to 255 are reserved for future use. Values above 255 are used for Data Sets.
-		switch {
-		case ipfixset.SetID == SetIDTemplate, ipfixset.SetID == SetIDOptionTemplate: //We do the template or option template set
+		if limiterr := checkLimit("MaxRecordsPerSet", opts.Limits.MaxRecordsPerSet, len(ipfixset.Records)+1); limiterr != nil {
+			return limiterr.AtOffset(cursor).InSet(ipfixset.SetID)
+		}
+		if ipfixset.SetID < 256 { //We do the template or option template set
+			if limiterr := checkLimit("MaxFieldsPerTemplate", opts.Limits.MaxFieldsPerTemplate, int(binary.BigEndian.Uint16(data[cursor+2:cursor+4]))); limiterr != nil {
+				return limiterr.AtOffset(cursor + 2).InSet(ipfixset.SetID).InTemplate(binary.BigEndian.Uint16(data[cursor : cursor+2]))
+			}
 			tmprec := &TemplateRecord{}
 			if ipfixset.SetID == SetIDOptionTemplate {
 				tmprec.ScopeFieldSpecifiers = make([]*FieldSpecifier, 0, 0)
 			}
 			err := tmprec.UnmarshalBinary(data[cursor:])
 			if err != nil {
-				fmt.Println("A", err)
-				return err
+				return setErrorContext(err, cursor, ipfixset.SetID)
 			}
-			cursor += tmprec.Len()
+			cursor += int(tmprec.Len())
 			ipfixset.AddRecord(tmprec)
-		case ipfixset.SetID > 255: //We do a dataset
-			tmprec, err := NewDataRecord(ipfixset.SetID, ipfixset.AssociatedTemplates)
+		} else { //We do a dataset
+			tmprec := &DataRecord{TemplateID: ipfixset.SetID, AssociatedTemplates: ipfixset.AssociatedTemplates}
+			reclen, err := plan.decode(tmprec, data[cursor:], state)
 			if err != nil {
-				fmt.Println("B", err)
-				return err
+				return setErrorContext(err, cursor, ipfixset.SetID)
 			}
-			err = tmprec.UnmarshalBinary(data[cursor:])
-			if err != nil {
-				fmt.Println("C", err)
-				return err
-			}
-			if !hasvar {
-				cursor += recordlength
-			} else {
-				cursor += tmprec.Len()
+			if reclen == 0 {
+				return NewCategoryError(ErrMalformedLength, "Can not unmarshal records of length 0", ErrCritical).AtOffset(cursor).InSet(ipfixset.SetID).InTemplate(ipfixset.SetID)
 			}
-			ipfixset.AddRecord(tmprec)
-		default: //Invalid Template ID
-			return NewError(fmt.Sprintf("Invalid template ID: %d", ipfixset.SetID), ErrCritical)
+			cursor += reclen
+			var rec Record = tmprec //Not AddRecord: it came from this set so it fits, and computing the set length for each record is quadratic
+			ipfixset.Records = append(ipfixset.Records, &rec)
 		}
 	}
-	return nil
+	return unknown
+}
+
+// setErrorContext shifts the offsets of a record error so they are relative to the set and adds the set id
+// If the error does not know its offset, the start of the record is used.
+func setErrorContext(err error, cursor int, setid uint16) error {
+	if perr, ok := err.(*ProtocolError); ok {
+		if perr.Offset < 0 {
+			perr.Offset = 0
+		}
+		perr.shiftOffset(cursor)
+		perr.InSet(setid)
+	}
+	return err
 }
@@ -0,0 +1,288 @@
+package ipfix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+With TCP transport the IPFIX Messages are sent back to back on the stream, so the Message Length field is the only thing that frames them.
+If a single length field is wrong, every following message is read from the wrong position and the stream stays out of frame.
+RFC 7011, section 9.1 refers to [RFC5655], section 10.3, which describes how to recover: scan the stream for something that looks like a valid Message Header
+(version 10, a sane length and export time, followed by a valid Set Header) and continue from there.
+
+The TCPCollector can do this when Resynchronise is set. The octets that are skipped are counted, and a connection is dropped when an exporter keeps sending garbage.
+A malformed message whose Set Headers fill its length exactly is still in frame, so it is skipped by its length instead.
+
+*/
+
+const (
+	// DefaultMaxExportTimeSkew is the default for the maximum difference between the export time of a message and the local time, when resynchronising
+	DefaultMaxExportTimeSkew = 24 * time.Hour
+
+	// DefaultMaxSkippedBytes is the default for the maximum number of octets that may be skipped in a row before a connection is dropped
+	DefaultMaxSkippedBytes = 2 * 65535
+
+	// DefaultMaxResyncs is the default for the maximum number of times a single connection may be resynchronised before it is dropped
+	DefaultMaxResyncs = 16
+)
+
+// MessageHandler is called by the collector for every message that was decoded.
+// If err is not nil, it holds the non-fatal errors that occurred while decoding (for example, data for unknown templates).
+type MessageHandler func(session *Session, msg *Message, err error)
+
+// TCPCollectorStats holds the counters of a TCPCollector
+type TCPCollectorStats struct {
+	Connections        uint64 //Number of connections accepted
+	DroppedConnections uint64 //Number of connections dropped because they kept sending garbage
+	Messages           uint64 //Number of messages decoded
+	MalformedMessages  uint64 //Number of messages that were discarded because they were malformed
+	Resyncs            uint64 //Number of times a stream was resynchronised
+	BytesSkipped       uint64 //Number of octets skipped while resynchronising
+}
+
+// TCPCollector receives IPFIX Messages over TCP. Every connection is a Transport Session with its own templates.
+type TCPCollector struct {
+	Handler       MessageHandler //Called for every decoded message
+	Logger        *slog.Logger   //Receives the diagnostics of the collector and its sessions. If nil, nothing is logged.
+	DecodeOptions DecodeOptions  //Controls how malformed messages are handled, and the limits that apply
+
+	Resynchronise     bool          //Scan for the next valid message header when the stream is out of frame, instead of dropping the connection
+	MaxExportTimeSkew time.Duration //Maximum difference between export time and local time for a header to be plausible when resynchronising. 0 disables the check.
+	MaxSkippedBytes   int           //Maximum number of octets skipped in a row before the connection is dropped. 0 means no limit.
+	MaxResyncs        int           //Maximum number of resynchronisations per connection before the connection is dropped. 0 means no limit.
+
+	stats struct {
+		connections        atomic.Uint64
+		droppedConnections atomic.Uint64
+		messages           atomic.Uint64
+		malformedMessages  atomic.Uint64
+		resyncs            atomic.Uint64
+		bytesSkipped       atomic.Uint64
+	}
+	now func() time.Time //For testing
+}
+
+// NewTCPCollector returns a new collector that calls handler for every message, with the DefaultDecodeOptions, resynchronisation disabled and the default resynchronisation limits
+func NewTCPCollector(handler MessageHandler, logger *slog.Logger) *TCPCollector {
+	return &TCPCollector{
+		Handler:           handler,
+		Logger:            logger,
+		DecodeOptions:     DefaultDecodeOptions,
+		MaxExportTimeSkew: DefaultMaxExportTimeSkew,
+		MaxSkippedBytes:   DefaultMaxSkippedBytes,
+		MaxResyncs:        DefaultMaxResyncs,
+		now:               time.Now,
+	}
+}
+
+// Stats returns a snapshot of the counters of the collector
+func (collector *TCPCollector) Stats() TCPCollectorStats {
+	return TCPCollectorStats{
+		Connections:        collector.stats.connections.Load(),
+		DroppedConnections: collector.stats.droppedConnections.Load(),
+		Messages:           collector.stats.messages.Load(),
+		MalformedMessages:  collector.stats.malformedMessages.Load(),
+		Resyncs:            collector.stats.resyncs.Load(),
+		BytesSkipped:       collector.stats.bytesSkipped.Load(),
+	}
+}
+
+// Serve accepts connections on listener and serves each of them in its own goroutine, until the listener is closed.
+func (collector *TCPCollector) Serve(listener net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collector.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn reads messages from conn until it is closed or dropped. The connection is always closed when ServeConn returns.
+// It returns nil when the exporter closed the connection, and an error with category ErrOutOfFrame when the connection was dropped.
+func (collector *TCPCollector) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	collector.stats.connections.Add(1)
+	logger := collector.logger().With(slog.String("remote", conn.RemoteAddr().String()))
+	session := NewSession(logger)
+	session.DecodeOptions = collector.DecodeOptions
+
+	err := collector.serveStream(bufio.NewReaderSize(conn, 65535), session, logger)
+	if errors.Is(err, ErrOutOfFrame) {
+		collector.stats.droppedConnections.Add(1)
+		logger.Error("Dropping IPFIX connection", slog.Any("error", err))
+		return err
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// logger returns the logger of the collector, or a logger that discards everything if there is none
+func (collector *TCPCollector) logger() *slog.Logger {
+	if collector.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return collector.Logger
+}
+
+// serveStream reads, frames and decodes the messages on the stream until an error occurs
+func (collector *TCPCollector) serveStream(reader *bufio.Reader, session *Session, logger *slog.Logger) error {
+	skipped := 0 //Octets skipped since the last valid message
+	resyncs := 0
+	for {
+		msglength, reason, err := collector.nextFrame(reader)
+		if err != nil {
+			return err
+		}
+		var msg *Message
+		if reason == "" {
+			var data []byte
+			data, err = reader.Peek(msglength)
+			if err != nil {
+				return err
+			}
+			msg, err = session.UnmarshalMessage(data)
+			var perr *ProtocolError
+			if errors.As(err, &perr) && perr.Severity == ErrCritical {
+				collector.stats.malformedMessages.Add(1)
+				if !framed(data) {
+					reason = "malformed message"
+				} else { //Its sets fill it exactly, so the stream is still in frame and the message is skipped by its length
+					if _, err := reader.Discard(msglength); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		}
+		if reason != "" {
+			if !collector.Resynchronise {
+				if msg != nil { //The header was fine, so the stream is still in frame
+					if _, err := reader.Discard(msglength); err != nil {
+						return err
+					}
+					continue
+				}
+				return NewCategoryError(ErrOutOfFrame, fmt.Sprintf("Invalid message header: %s", reason), ErrCritical)
+			}
+			if skipped == 0 { //The length field may be what is wrong, so it can not be trusted to find the next message
+				resyncs++
+				collector.stats.resyncs.Add(1)
+				logger.Warn("IPFIX stream out of frame, resynchronising", slog.String("reason", reason))
+				if collector.MaxResyncs > 0 && resyncs > collector.MaxResyncs {
+					return NewCategoryError(ErrOutOfFrame, fmt.Sprintf("Resynchronised more than %d times", collector.MaxResyncs), ErrCritical)
+				}
+			}
+			if err := collector.skip(reader, &skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := reader.Discard(msglength); err != nil {
+			return err
+		}
+		if skipped > 0 {
+			logger.Info("IPFIX stream resynchronised", slog.Int("bytes_skipped", skipped))
+			skipped = 0
+		}
+		collector.stats.messages.Add(1)
+		if collector.Handler != nil {
+			collector.Handler(session, msg, err)
+		}
+	}
+}
+
+// nextFrame looks at the start of the stream and returns the length of the message that starts there,
+// or the reason why no message can start there
+func (collector *TCPCollector) nextFrame(reader *bufio.Reader) (int, string, error) {
+	header, err := reader.Peek(ipfixMessageHeaderLength)
+	if err != nil {
+		return 0, "", err
+	}
+	if reason := collector.implausibleHeader(header); reason != "" {
+		return 0, reason, nil
+	}
+	msglength := int(binary.BigEndian.Uint16(header[2:4]))
+	if collector.Resynchronise && msglength >= ipfixMessageHeaderLength+ipfixSetHeaderLength { //Check the first set header before waiting for a message that may not exist
+		header, err = reader.Peek(ipfixMessageHeaderLength + ipfixSetHeaderLength)
+		if err != nil {
+			return 0, "", err
+		}
+		setid := binary.BigEndian.Uint16(header[16:18])
+		setlength := int(binary.BigEndian.Uint16(header[18:20]))
+		if (setid != SetIDTemplate && setid != SetIDOptionTemplate && setid < 256) || setlength < ipfixSetHeaderLength || setlength > msglength-ipfixMessageHeaderLength {
+			return 0, fmt.Sprintf("set id %d, set length %d", setid, setlength), nil
+		}
+	}
+	return msglength, "", nil
+}
+
+// framed returns whether the set headers of the message fill it exactly, so its length can be trusted to find the next message
+func framed(data []byte) bool {
+	cursor := ipfixMessageHeaderLength
+	for cursor+ipfixSetHeaderLength <= len(data) {
+		setlength := int(binary.BigEndian.Uint16(data[cursor+2 : cursor+4]))
+		if setlength < ipfixSetHeaderLength {
+			return false
+		}
+		cursor += setlength
+	}
+	return cursor == len(data)
+}
+
+// skip discards a single octet of the stream and checks the limit on skipped octets
+func (collector *TCPCollector) skip(reader *bufio.Reader, skipped *int) error {
+	if _, err := reader.Discard(1); err != nil {
+		return err
+	}
+	*skipped++
+	collector.stats.bytesSkipped.Add(1)
+	if collector.MaxSkippedBytes > 0 && *skipped > collector.MaxSkippedBytes {
+		return NewCategoryError(ErrOutOfFrame, fmt.Sprintf("Skipped more than %d octets without finding a valid message", collector.MaxSkippedBytes), ErrCritical)
+	}
+	return nil
+}
+
+// implausibleHeader returns why the message header can not be the start of a message, or an empty string if it can.
+// The version and length are always checked. When resynchronising, the export time is checked against the local time as well.
+func (collector *TCPCollector) implausibleHeader(header []byte) string {
+	if version := binary.BigEndian.Uint16(header[0:2]); version != IPFIXVersion {
+		return fmt.Sprintf("version %d", version)
+	}
+	if msglength := binary.BigEndian.Uint16(header[2:4]); msglength < ipfixMessageHeaderLength {
+		return fmt.Sprintf("message length %d", msglength)
+	}
+	if collector.Resynchronise && collector.MaxExportTimeSkew > 0 {
+		exporttime := time.Unix(int64(binary.BigEndian.Uint32(header[4:8])), 0)
+		now := time.Now
+		if collector.now != nil {
+			now = collector.now
+		}
+		if skew := now().Sub(exporttime); skew > collector.MaxExportTimeSkew || skew < -collector.MaxExportTimeSkew {
+			return fmt.Sprintf("export time %s", exporttime.UTC())
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package ipfix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	tcpcollectorTestPrint = false
+)
+
+func TestTCPCollectorMarker(t *testing.T) {
+	if tcpcollectorTestPrint {
+		fmt.Printf(testMarkerString, "TCP Collector")
+	}
+}
+
+// tcpCollectorTestServe writes stream to a connection served by collector and returns the number of handled messages and the result of ServeConn
+func tcpCollectorTestServe(collector *TCPCollector, stream []byte) (int, error) {
+	handled := 0
+	collector.Handler = func(session *Session, msg *Message, err error) {
+		handled++
+	}
+	collector.now = func() time.Time { return time.Unix(0x52dda6ec, 0) }
+	server, client := net.Pipe()
+	go func() {
+		client.Write(stream)
+		client.Close()
+	}()
+	err := collector.ServeConn(server)
+	return handled, err
+}
+
+func TestTCPCollectorResynchronise(t *testing.T) {
+	tmplset := []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 8, 0, 4}
+	datset := []byte{1, 0, 0, 8, 10, 0, 0, 1}
+	msg := sessionTestMessage(0, tmplset, datset)
+
+	stream := append(append(append([]byte{}, msg...), 0xde, 0xad, 0, 10, 0xbe, 0xef, 0), msg...)
+	collector := NewTCPCollector(nil, nil)
+	collector.Resynchronise = true
+	handled, err := tcpCollectorTestServe(collector, stream)
+	if err != nil || handled != 2 {
+		t.Errorf(errorPrefixMarker+"Expected 2 messages around the garbage, but got %d (%v)", handled, err)
+	}
+	if stats := collector.Stats(); stats.BytesSkipped != 7 || stats.Resyncs != 1 || stats.Messages != 2 {
+		t.Errorf(errorPrefixMarker+"Wrong counters after garbage: %+v", stats)
+	}
+
+	badlength := append([]byte{}, msg...)
+	badlength[3] += 4 //Now includes the start of the next message
+	stream = append(append(badlength, msg...), msg...)
+	collector = NewTCPCollector(nil, nil)
+	collector.Resynchronise = true
+	handled, err = tcpCollectorTestServe(collector, stream)
+	if err != nil || handled != 2 {
+		t.Errorf(errorPrefixMarker+"Expected 2 messages after the bad length, but got %d (%v)", handled, err)
+	}
+	if stats := collector.Stats(); stats.BytesSkipped != uint64(len(msg)) || stats.MalformedMessages != 1 {
+		t.Errorf(errorPrefixMarker+"Wrong counters after bad length: %+v", stats)
+	}
+
+	badpadding := sessionTestMessage(1, []byte{1, 0, 0, 10, 10, 0, 0, 2, 0, 1}) //Malformed, but its set fills it exactly
+	stream = append(append(append([]byte{}, msg...), badpadding...), msg...)
+	collector = NewTCPCollector(nil, nil)
+	collector.Resynchronise = true
+	handled, err = tcpCollectorTestServe(collector, stream)
+	if err != nil || handled != 2 {
+		t.Errorf(errorPrefixMarker+"Expected 2 messages around the malformed message, but got %d (%v)", handled, err)
+	}
+	if stats := collector.Stats(); stats.BytesSkipped != 0 || stats.Resyncs != 0 || stats.MalformedMessages != 1 {
+		t.Errorf(errorPrefixMarker+"A malformed message that is in frame should be skipped by its length: %+v", stats)
+	}
+}
+
+func TestTCPCollectorDrop(t *testing.T) {
+	msg := sessionTestMessage(0, []byte{0, 2, 0, 12, 1, 0, 0, 1, 0, 8, 0, 4})
+	stream := append(append([]byte{}, msg...), 0xde, 0xad, 0xbe, 0xef)
+	stream = append(stream, msg...)
+
+	collector := NewTCPCollector(nil, nil)
+	handled, err := tcpCollectorTestServe(collector, stream)
+	if !errors.Is(err, ErrOutOfFrame) || handled != 1 {
+		t.Errorf(errorPrefixMarker+"Connection should have been dropped without resynchronisation after 1 message, but got %d (%v)", handled, err)
+	}
+	if stats := collector.Stats(); stats.DroppedConnections != 1 {
+		t.Errorf(errorPrefixMarker+"Dropped connection not counted: %+v", stats)
+	}
+
+	collector = NewTCPCollector(nil, nil)
+	collector.Resynchronise = true
+	collector.MaxSkippedBytes = 100
+	handled, err = tcpCollectorTestServe(collector, make([]byte, 1000))
+	if !errors.Is(err, ErrOutOfFrame) || handled != 0 {
+		t.Errorf(errorPrefixMarker+"Connection should have been dropped after too much garbage, but got %d (%v)", handled, err)
+	}
+	if stats := collector.Stats(); stats.BytesSkipped != 101 {
+		t.Errorf(errorPrefixMarker+"Wrong number of skipped bytes: %+v", stats)
+	}
+
+	collector = NewTCPCollector(nil, nil)
+	collector.Resynchronise = true
+	collector.MaxResyncs = 2
+	garbage := []byte{}
+	for cnt := 0; cnt < 4; cnt++ {
+		garbage = append(append(garbage, msg...), 0xff)
+	}
+	handled, err = tcpCollectorTestServe(collector, garbage)
+	if !errors.Is(err, ErrOutOfFrame) || handled != 3 {
+		t.Errorf(errorPrefixMarker+"Connection should have been dropped after too many resyncs, but got %d (%v)", handled, err)
+	}
+}
@@ -3,6 +3,7 @@ package ipfix
 import (
 	"encoding/binary"
 	"fmt"
+	"log/slog"
 	"reflect"
 	"strconv"
 	"strings"
@@ -66,6 +67,23 @@ func NewOptionsTemplateRecord(templateid uint16) (*TemplateRecord, error) {
 	return templaterecord, nil
 }
 
+// NewTemplateWithdrawalRecord returns a Template Withdrawal Record of the (Options) Template with the given templateid: a record without fields.
+// A templateid of 2 withdraws all Templates and a templateid of 3 all Options Templates, other ids must be in the range 256-65535.
+func NewTemplateWithdrawalRecord(templateid uint16, options bool) (*TemplateRecord, error) {
+	if templateid < 256 && templateid != SetIDTemplate && templateid != SetIDOptionTemplate {
+		return nil, fmt.Errorf("Invalid template id. Must be 2, 3 or >=256 but got %d", templateid)
+	}
+	if options {
+		return &TemplateRecord{TemplateID: templateid, ScopeFieldSpecifiers: make([]*FieldSpecifier, 0, 0)}, nil
+	}
+	return &TemplateRecord{TemplateID: templateid}, nil
+}
+
+// IsWithdrawal returns true if the template record is a Template Withdrawal Record, a record without fields (RFC 7011 section 8.1)
+func (tmplrec *TemplateRecord) IsWithdrawal() bool {
+	return len(tmplrec.ScopeFieldSpecifiers) == 0 && len(tmplrec.FieldSpecifiers) == 0
+}
+
 // IsOptionsTemplateRecord returns true if the template record is an Options Template Record
 func (tmplrec *TemplateRecord) IsOptionsTemplateRecord() bool {
 	return tmplrec.ScopeFieldSpecifiers == nil
@@ -87,17 +105,18 @@ func RegisterTemplateRecord(session *Session, templateid uint16, val interface{}
 	if value.Type().Kind() != reflect.Struct {
 		return nil, NewError("Can not create template record from a single value. Must use struct.", ErrCritical)
 	}
+	logger := session.logger()
 	for i := 0; i < value.NumField(); i++ { // iterates through every struct type field
-		_, err := getFieldSpecifierFromValue(value.Field(i), strings.Split(value.Type().Field(i).Tag.Get("ipfix"), ","))
+		_, err := getFieldSpecifierFromValue(logger, value.Field(i), strings.Split(value.Type().Field(i).Tag.Get("ipfix"), ","))
 		if err != nil {
-			fmt.Println(err)
+			logger.Warn("Can not map struct field to field specifier", slog.String("field", value.Type().Field(i).Name), slog.Any("error", err))
 		}
 	}
 
 	return templaterecord, nil
 }
 
-func getFieldSpecifierFromValue(value reflect.Value, tags []string) (*FieldSpecifier, error) {
+func getFieldSpecifierFromValue(logger *slog.Logger, value reflect.Value, tags []string) (*FieldSpecifier, error) {
 	enterpriseid := -1
 	fieldid := -1
 	fieldlen := -1
@@ -107,7 +126,7 @@ func getFieldSpecifierFromValue(value reflect.Value, tags []string) (*FieldSpeci
 	issubtemplatemultilist := false
 	var fieldtype FieldValue
 
-	fmt.Println(">>>", value.Type().Kind(), tags)
+	logger.Debug("Mapping struct field", slog.String("kind", value.Type().Kind().String()), slog.Any("tags", tags))
 	for _, tag := range tags {
 		elm := strings.SplitN(tag, ":", 2)
 		if len(elm) == 2 {
@@ -152,37 +171,34 @@ func getFieldSpecifierFromValue(value reflect.Value, tags []string) (*FieldSpeci
 			}
 
 		} else {
-			fmt.Println("FLAG:", elm)
+			logger.Debug("Ignoring tag without value", slog.String("tag", tag))
 		}
 	}
 
 	if value.Type().Kind() == reflect.Slice { //Basically, just a basiclist of a singular field type
-		fmt.Println("slice")
-		fmt.Println("Basiclist")
+		logger.Debug("Mapping slice to basiclist")
 	}
 
 	if value.Type().Kind() == reflect.Struct { //This returns a whole different structure of template record
-		fmt.Println("JAJA")
 		if !issubtemplatelist && !issubtemplatemultilist {
-			fmt.Println("OOOK!")
 			return nil, NewError("Must map a struct to either 'subtemplatelist' or 'subtemplatemultilist'", ErrCritical)
 		}
-		fmt.Println("Okido")
+		logger.Debug("Mapping struct to subtemplate", slog.Int("subtemplate_id", subtemplateid))
 		for i := 0; i < value.NumField(); i++ { // iterates through every struct type field
-			getFieldSpecifierFromValue(value.Field(i), strings.Split(value.Type().Field(i).Tag.Get("ipfix"), ","))
+			getFieldSpecifierFromValue(logger, value.Field(i), strings.Split(value.Type().Field(i).Tag.Get("ipfix"), ","))
 		}
 	}
 
 	isdefined, iscustom := fieldInstanceExists(uint32(enterpriseid), uint16(fieldid))
 	if !isdefined || iscustom {
 		if iscustom {
-			fmt.Println("Unregistering: ", enterpriseid, fieldid, fieldlen, fielddesc, fmt.Sprintf("%#v", fieldtype), subtemplateid)
+			logger.Debug("Unregistering custom field", slog.Int("enterprise_id", enterpriseid), slog.Int("field_id", fieldid))
 			unregerr := UnregisterCustomField(uint32(enterpriseid), uint16(fieldid))
 			if unregerr != nil {
 				return nil, unregerr
 			}
 		}
-		fmt.Println("Registering: ", enterpriseid, fieldid, fieldlen, fielddesc, fmt.Sprintf("%#v", fieldtype))
+		logger.Debug("Registering custom field", slog.Int("enterprise_id", enterpriseid), slog.Int("field_id", fieldid), slog.Int("field_length", fieldlen), slog.String("description", fielddesc))
 
 		regerr := RegisterCustomField(uint32(enterpriseid), uint16(fieldid), uint16(fieldlen), fielddesc, fieldtype)
 		if regerr != nil {
@@ -221,6 +237,16 @@ func (tmplrec *TemplateRecord) String() string {
 	return retstring
 }
 
+// allFieldSpecifiers returns the Scope Field Specifiers followed by the Field Specifiers, which is the order in which the values appear in a Data Record
+func (tmplrec *TemplateRecord) allFieldSpecifiers() []*FieldSpecifier {
+	if len(tmplrec.ScopeFieldSpecifiers) == 0 {
+		return tmplrec.FieldSpecifiers
+	}
+	fsps := make([]*FieldSpecifier, 0, len(tmplrec.ScopeFieldSpecifiers)+len(tmplrec.FieldSpecifiers))
+	fsps = append(fsps, tmplrec.ScopeFieldSpecifiers...)
+	return append(fsps, tmplrec.FieldSpecifiers...)
+}
+
 //AddScopeSpecifier adds a Scope Field Specifier to the record
 func (tmplrec *TemplateRecord) AddScopeSpecifier(fsp *FieldSpecifier) (*TemplateRecord, error) {
 	if tmplrec.ScopeFieldSpecifiers == nil {
@@ -239,6 +265,9 @@ func (tmplrec *TemplateRecord) AddSpecifier(fsp *FieldSpecifier) *TemplateRecord
 // Len returns the size in octets of the template record
 func (tmplrec *TemplateRecord) Len() uint16 {
 	reclen := uint16(4) //basic header is 4 bytes
+	if tmplrec.IsWithdrawal() {
+		return reclen //A withdrawal has no scope field count, also in an Options Template Set
+	}
 	if tmplrec.ScopeFieldSpecifiers != nil {
 		reclen += 2 //we need some space in the header for the count of the scope fields
 		for _, rec := range tmplrec.ScopeFieldSpecifiers {
@@ -267,107 +296,88 @@ func (tmplrec *TemplateRecord) Len() uint16 {
 
 // MarshalBinary satisfies the encoding/BinaryMarshaler interface
 func (tmplrec *TemplateRecord) MarshalBinary() (data []byte, err error) {
-	if len(tmplrec.FieldSpecifiers) < 1 {
-		return nil, NewError("Can not marshal record, must have at least one Field Specifier", ErrCritical)
-	}
+	return tmplrec.AppendBinary(make([]byte, 0, tmplrec.Len()))
+}
 
-	marshalValue := make([]byte, 0, 0)
-	marshalTemplateID, err := marshalBinarySingleValue(tmplrec.TemplateID)
-	if err != nil {
-		return nil, err
+// AppendBinary satisfies the encoding/BinaryAppender interface, it appends the record to dst
+func (tmplrec *TemplateRecord) AppendBinary(dst []byte) (data []byte, err error) {
+	if tmplrec.IsWithdrawal() {
+		dst = binary.BigEndian.AppendUint16(dst, tmplrec.TemplateID)
+		return binary.BigEndian.AppendUint16(dst, 0), nil
 	}
-	marshalValue = append(marshalValue, marshalTemplateID...)
-
-	marshalFieldCount, err := marshalBinarySingleValue(uint16(uint16(len(tmplrec.FieldSpecifiers)) + uint16(len(tmplrec.ScopeFieldSpecifiers))))
-	if err != nil {
-		return nil, err
+	if len(tmplrec.FieldSpecifiers) < 1 {
+		return nil, NewError("Can not marshal record, must have at least one Field Specifier", ErrCritical)
 	}
-	marshalValue = append(marshalValue, marshalFieldCount...)
 
+	dst = binary.BigEndian.AppendUint16(dst, tmplrec.TemplateID)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(uint16(len(tmplrec.FieldSpecifiers))+uint16(len(tmplrec.ScopeFieldSpecifiers))))
 	if tmplrec.ScopeFieldSpecifiers != nil {
-		marshalScopeFieldCount, err := marshalBinarySingleValue(uint16(len(tmplrec.ScopeFieldSpecifiers)))
-		if err != nil {
-			return nil, err
-		}
-		marshalValue = append(marshalValue, marshalScopeFieldCount...)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(tmplrec.ScopeFieldSpecifiers)))
 		for _, listitem := range tmplrec.ScopeFieldSpecifiers {
-			itemdata, err := listitem.MarshalBinary()
+			dst, err = listitem.AppendBinary(dst)
 			if err != nil {
 				return nil, err
 			}
-			marshalValue = append(marshalValue, itemdata...)
 		}
 	}
 	for _, listitem := range tmplrec.FieldSpecifiers {
-		itemdata, err := listitem.MarshalBinary()
+		dst, err = listitem.AppendBinary(dst)
 		if err != nil {
 			return nil, err
 		}
-		marshalValue = append(marshalValue, itemdata...)
 	}
 
-	return marshalValue, nil
+	return dst, nil
 }
 
 // UnmarshalBinary satisfies the encoding/BinaryUnmarshaler interface
 func (tmplrec *TemplateRecord) UnmarshalBinary(data []byte) (err error) {
-	if data == nil || len(data) < 6 {
-		return NewError(fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
+	headerlength := 4
+	if len(data) >= headerlength && binary.BigEndian.Uint16(data[2:4]) == 0 { //A withdrawal, which has no scope field count
+		tmplrec.TemplateID = binary.BigEndian.Uint16(data[0:2])
+		return nil
+	}
+	if tmplrec.ScopeFieldSpecifiers != nil {
+		headerlength = 6
+	}
+	if data == nil || len(data) < headerlength {
+		return NewCategoryError(ErrInsufficientData, fmt.Sprintf("Can not unmarshal, invalid data. %#v", data), ErrCritical)
 	}
 	tmplrec.TemplateID = binary.BigEndian.Uint16(data[0:2])
 	totalFieldCount := binary.BigEndian.Uint16(data[2:4])
 	scopeFieldCount := uint16(0)
-	cursor := uint16(4)
 	if tmplrec.ScopeFieldSpecifiers != nil {
 		scopeFieldCount = binary.BigEndian.Uint16(data[4:6])
-		cursor = 6
 	}
-	for cnt := uint16(0); cnt < scopeFieldCount; cnt++ {
-		scopeField := &FieldSpecifier{}
-		if (data[cursor] & 128) != 0 {
-			suberr := scopeField.UnmarshalBinary(data[cursor : cursor+8])
-			if suberr != nil {
-				if err == nil {
-					err = NewError("Sub errors unmarshalling record.", ErrFailure)
-				}
-				err.(*ProtocolError).Stack(suberr)
-			}
-			cursor += uint16(8)
-		} else {
-			suberr := scopeField.UnmarshalBinary(data[cursor : cursor+4])
-			if suberr != nil {
-				if err == nil {
-					err = NewError("Sub errors unmarshalling record.", ErrFailure)
-				}
-				err.(*ProtocolError).Stack(suberr)
-			}
-			cursor += uint16(4)
-		}
-		tmplrec.ScopeFieldSpecifiers = append(tmplrec.ScopeFieldSpecifiers, scopeField)
+	if scopeFieldCount > totalFieldCount {
+		return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Scope field count %d is greater than field count %d", scopeFieldCount, totalFieldCount), ErrCritical).AtOffset(4).InTemplate(tmplrec.TemplateID)
 	}
-	for cnt := uint16(0); cnt < (totalFieldCount - scopeFieldCount); cnt++ {
+	cursor := headerlength
+	for cnt := uint16(0); cnt < totalFieldCount; cnt++ {
+		fsplength := 4
+		if cursor < len(data) && (data[cursor]&128) != 0 {
+			fsplength = 8
+		}
+		if cursor+fsplength > len(data) {
+			return NewCategoryError(ErrMalformedLength, fmt.Sprintf("Insufficient data to decode field specifier %d of %d. Needed %d, but have %d", cnt, totalFieldCount, fsplength, len(data)-cursor), ErrCritical).AtOffset(cursor).InTemplate(tmplrec.TemplateID).AtField(int(cnt))
+		}
 		fieldSpecifier := &FieldSpecifier{}
-		if (data[cursor] & 128) != 0 {
-			suberr := fieldSpecifier.UnmarshalBinary(data[cursor : cursor+8])
-			if suberr != nil {
-				if err == nil {
-					err = NewError("Sub errors unmarshalling record.", ErrFailure)
-				}
-				err.(*ProtocolError).Stack(suberr)
+		suberr := fieldSpecifier.UnmarshalBinary(data[cursor : cursor+fsplength])
+		if suberr != nil {
+			if err == nil {
+				err = NewError("Sub errors unmarshalling record.", ErrFailure)
 			}
-
-			cursor += uint16(8)
+			err.(*ProtocolError).Stack(suberr)
+		}
+		cursor += fsplength
+		if cnt < scopeFieldCount {
+			tmplrec.ScopeFieldSpecifiers = append(tmplrec.ScopeFieldSpecifiers, fieldSpecifier)
 		} else {
-			suberr := fieldSpecifier.UnmarshalBinary(data[cursor : cursor+4])
-			if suberr != nil {
-				if err == nil {
-					err = NewError("Sub errors unmarshalling record.", ErrFailure)
-				}
-				err.(*ProtocolError).Stack(suberr)
-			}
-			cursor += uint16(4)
+			tmplrec.FieldSpecifiers = append(tmplrec.FieldSpecifiers, fieldSpecifier)
 		}
-		tmplrec.FieldSpecifiers = append(tmplrec.FieldSpecifiers, fieldSpecifier)
+	}
+	if err != nil {
+		err.(*ProtocolError).InTemplate(tmplrec.TemplateID)
 	}
 	return err
 }